@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// currentSource 回傳目前對外 IP 的 CIDR，allow-ip 與 create --allow-my-ip 沒有指定來源時使用
+func (c *Commander) currentSource(ctx context.Context) (string, error) {
+	ip, err := c.ipChecker.PublicIP(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to detect your public IP: %v", err)
+	}
+	return parseSource(ip)
+}
+
+// AllowedIPs 列出 proxy 連接埠開放的來源
+func (c *Commander) AllowedIPs(name string) error {
+	record, found, err := c.recordManager.FindByName(name)
+	if err != nil {
+		return fmt.Errorf("error loading records: %v", err)
+	}
+	if !found {
+		return fmt.Errorf("proxy not found: %s", name)
+	}
+	if len(record.AllowedIPs) == 0 {
+		fmt.Printf(tr("Proxy %s accepts connections from any IP.\n"), name)
+		return nil
+	}
+	for _, source := range record.AllowedIPs {
+		fmt.Println(source)
+	}
+	return nil
+}
+
+// AllowIP 修改 proxy 連接埠開放的來源並套用到雲端防火牆與主機的 UFW
+// 清單變成空的時恢復對所有來源開放，不再使用的防火牆規則由 gc 清除
+func (c *Commander) AllowIP(ctx context.Context, name string, add, remove []string) error {
+	for i, raw := range add {
+		source, err := parseSource(raw)
+		if err != nil {
+			return err
+		}
+		add[i] = source
+	}
+	for i, raw := range remove {
+		source, err := parseSource(raw)
+		if err != nil {
+			return err
+		}
+		remove[i] = source
+	}
+
+	previous, found, err := c.recordManager.FindByName(name)
+	if err != nil {
+		return fmt.Errorf("error loading records: %v", err)
+	}
+	if !found {
+		return fmt.Errorf("proxy not found: %s", name)
+	}
+	if !previous.Ready() {
+		return fmt.Errorf("proxy %s is not deployed yet, run `auto_proxy resume --name %s` first", name, name)
+	}
+	record := previous
+	record.AllowedIPs = slices.DeleteFunc(slices.Clone(previous.AllowedIPs), func(s string) bool { return slices.Contains(remove, s) })
+	for _, source := range add {
+		if !slices.Contains(record.AllowedIPs, source) {
+			record.AllowedIPs = append(record.AllowedIPs, source)
+		}
+	}
+	if slices.Equal(previous.AllowedIPs, record.AllowedIPs) {
+		fmt.Printf(tr("Allowed sources of proxy %s unchanged.\n"), name)
+		return nil
+	}
+	// 套用成功後才寫入紀錄，失敗時重新執行同樣的指令即可
+	if err := c.applyFirewall(ctx, previous, record); err != nil {
+		return err
+	}
+	c.logger.Printf("Allowed sources of proxy %s set to %v", record.Name, record.AllowedIPs)
+	_, err = c.recordManager.UpdateRecord(name, func(r *ProxyRecord) error {
+		r.AllowedIPs = record.AllowedIPs
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error saving records: %v", err)
+	}
+	if len(record.AllowedIPs) == 0 {
+		fmt.Printf(tr("Proxy %s now accepts connections from any IP.\n"), name)
+		return nil
+	}
+	fmt.Printf(tr("Proxy %s now accepts connections only from: %s\n"), name, strings.Join(record.AllowedIPs, ", "))
+	return nil
+}
+
+// applyFirewall 先建立新的雲端防火牆規則再切換 instance 的網路標記，最後重新套用主機的設定
+// allow-ip 與 user 指令修改紀錄前以這個函式套用，previous 為修改前的紀錄
+func (c *Commander) applyFirewall(ctx context.Context, previous, record ProxyRecord) error {
+	if err := c.preflight(); err != nil {
+		return err
+	}
+	firewall, err := record.Firewall()
+	if err != nil {
+		return err
+	}
+	if record.Managed() {
+		if err := c.provider.EnsureFirewall(ctx, firewall); err != nil {
+			return fmt.Errorf("error updating firewall rules: %v", err)
+		}
+		old, err := previous.Firewall()
+		if err != nil {
+			return err
+		}
+		if old.Tag() != firewall.Tag() {
+			if err := c.provider.SetNetworkTags(ctx, record.Zone, record.InstanceID, []string{firewall.Tag()}); err != nil {
+				return fmt.Errorf("error updating network tags: %v", err)
+			}
+		}
+	}
+	target, err := c.deployTarget(record)
+	if err != nil {
+		return err
+	}
+	if err := c.deployer.Deploy(target); err != nil {
+		c.logger.Printf("Error applying changes to %s: %v", record.Name, err)
+		return fmt.Errorf("error updating %s: %v", record.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// 標記為 audit 的指令會修改雲端或紀錄，執行結果寫入稽核記錄
+const annotationAudit = "audit"
+
+// audited 將指令標記為需要稽核
+func audited(cmd *cobra.Command) *cobra.Command {
+	if cmd.Annotations == nil {
+		cmd.Annotations = make(map[string]string)
+	}
+	cmd.Annotations[annotationAudit] = "true"
+	return cmd
+}
+
+// AuditEntry 稽核記錄中的一筆操作
+type AuditEntry struct {
+	Time     time.Time         `json:"time" yaml:"time"`
+	User     string            `json:"user" yaml:"user"`
+	Host     string            `json:"host" yaml:"host"`
+	Command  string            `json:"command" yaml:"command"`
+	Args     []string          `json:"args,omitempty" yaml:"args,omitempty"`
+	Flags    map[string]string `json:"flags,omitempty" yaml:"flags,omitempty"`
+	Outcome  string            `json:"outcome" yaml:"outcome"`
+	Error    string            `json:"error,omitempty" yaml:"error,omitempty"`
+	Duration string            `json:"duration" yaml:"duration"`
+}
+
+// 操作的結果
+const (
+	AuditSucceeded = "succeeded"
+	AuditFailed    = "failed"
+)
+
+// parameters 以命令列的形式列出參數，旗標依名稱排序
+func (e AuditEntry) parameters() string {
+	parts := append([]string(nil), e.Args...)
+	names := make([]string, 0, len(e.Flags))
+	for name := range e.Flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("--%s=%s", name, e.Flags[name]))
+	}
+	return strings.Join(parts, " ")
+}
+
+// AuditLog 只附加寫入的稽核記錄，每行一筆 JSON，多人共用同一個帳號時可以查出誰做了什麼
+type AuditLog struct {
+	path string
+}
+
+func (l *AuditLog) Append(entry AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit entry: %w", err)
+	}
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return f.Close()
+}
+
+// Read 讀取所有記錄，舊的在前
+func (l *AuditLog) Read() ([]AuditEntry, error) {
+	f, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer f.Close()
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("failed to parse audit log line %d: %w", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	return entries, nil
+}
+
+// auditUser 回傳執行指令的本機使用者
+func auditUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return os.Getenv("USERNAME")
+}
+
+// auditCommands 包裝標記為 audit 的指令，執行後把參數與結果寫入稽核記錄，dry run 不記錄
+func (a *cliApp) auditCommands(cmd *cobra.Command) {
+	for _, sub := range cmd.Commands() {
+		a.auditCommands(sub)
+	}
+	if cmd.Annotations[annotationAudit] != "true" || cmd.RunE == nil {
+		return
+	}
+	run := cmd.RunE
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		started := time.Now()
+		err := run(cmd, args)
+		if a.dryRun || a.commander == nil || a.commander.audit == nil {
+			return err
+		}
+		entry := AuditEntry{
+			Time:     started,
+			User:     auditUser(),
+			Command:  strings.TrimPrefix(cmd.CommandPath(), cmd.Root().Name()+" "),
+			Args:     args,
+			Outcome:  AuditSucceeded,
+			Duration: time.Since(started).Round(time.Second).String(),
+		}
+		entry.Host, _ = os.Hostname()
+		cmd.Flags().Visit(func(f *pflag.Flag) {
+			if entry.Flags == nil {
+				entry.Flags = make(map[string]string)
+			}
+			entry.Flags[f.Name] = f.Value.String()
+		})
+		if err != nil {
+			entry.Outcome, entry.Error = AuditFailed, err.Error()
+		}
+		// 稽核記錄寫入失敗不影響指令本身的結果
+		if auditErr := a.commander.audit.Append(entry); auditErr != nil {
+			a.logger.Printf("Error writing audit log: %v", auditErr)
+		}
+		return err
+	}
+}
+
+// AuditHistory 列出稽核記錄，name 不為空時只列出參數包含這個 proxy 的操作，limit 為最多列出的筆數
+func (c *Commander) AuditHistory(name string, limit int) error {
+	entries, err := c.audit.Read()
+	if err != nil {
+		return err
+	}
+	if name != "" {
+		entries = slices.DeleteFunc(entries, func(e AuditEntry) bool {
+			return !slices.Contains(e.Args, name) && e.Flags["name"] != name
+		})
+	}
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	if len(entries) == 0 && !c.machineOutput() {
+		fmt.Println(tr("No operations recorded."))
+		return nil
+	}
+	return c.render(entries, func(w io.Writer) {
+		fmt.Fprintln(w, "TIME\tUSER\tCOMMAND\tOUTCOME\tDURATION\tPARAMETERS")
+		for _, e := range entries {
+			outcome := e.Outcome
+			if e.Error != "" {
+				outcome += ": " + e.Error
+			}
+			fmt.Fprintf(w, "%s\t%s@%s\t%s\t%s\t%s\t%s\n", e.Time.In(appLocation).Format(time.RFC3339), e.User, e.Host, e.Command, outcome, e.Duration, e.parameters())
+		}
+	})
+}
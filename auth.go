@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Authenticator 驗證訂閱伺服器收到的請求，回傳 nil 表示通過
+type Authenticator interface {
+	Authenticate(r *http.Request) error
+}
+
+// 支援的驗證方式，可以同時啟用多種，任一種通過即可
+const (
+	AuthToken  = "token"
+	AuthBasic  = "basic"
+	AuthSigned = "signed"
+)
+
+// NewAuthenticator 依 schemes 建立驗證器，各方式的密鑰從環境變數或 OS keyring 讀取；schemes 為空時不驗證
+func NewAuthenticator(schemes []string) (Authenticator, error) {
+	var auths anyAuth
+	for _, scheme := range schemes {
+		switch scheme {
+		case AuthToken:
+			token := secretEnv("AUTO_PROXY_SERVE_TOKEN")
+			if token == "" {
+				return nil, fmt.Errorf("AUTO_PROXY_SERVE_TOKEN is required for token auth, set it or store it with `auto_proxy secret set`")
+			}
+			auths = append(auths, &TokenAuth{token: token})
+		case AuthBasic:
+			user, password := os.Getenv("AUTO_PROXY_SERVE_USER"), secretEnv("AUTO_PROXY_SERVE_PASSWORD")
+			if user == "" || password == "" {
+				return nil, fmt.Errorf("AUTO_PROXY_SERVE_USER and AUTO_PROXY_SERVE_PASSWORD are required for basic auth, the password can be stored with `auto_proxy secret set`")
+			}
+			auths = append(auths, &BasicAuth{user: user, password: password})
+		case AuthSigned:
+			signer, err := NewURLSignerFromEnv()
+			if err != nil {
+				return nil, err
+			}
+			auths = append(auths, signer)
+		default:
+			return nil, fmt.Errorf("unsupported auth scheme: %s (supported: token, basic, signed)", scheme)
+		}
+	}
+	return auths, nil
+}
+
+type anyAuth []Authenticator
+
+func (a anyAuth) Authenticate(r *http.Request) error {
+	if len(a) == 0 {
+		return nil
+	}
+	var errs []string
+	for _, auth := range a {
+		err := auth.Authenticate(r)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err.Error())
+	}
+	return fmt.Errorf("unauthorized: %s", strings.Join(errs, "; "))
+}
+
+// TokenAuth 固定的 token，放在 Authorization: Bearer 標頭或 token 參數
+type TokenAuth struct {
+	token string
+}
+
+func (a *TokenAuth) Authenticate(r *http.Request) error {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		token = r.URL.Query().Get("token")
+	}
+	if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+		return fmt.Errorf("invalid token")
+	}
+	return nil
+}
+
+type BasicAuth struct {
+	user     string
+	password string
+}
+
+func (a *BasicAuth) Authenticate(r *http.Request) error {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return fmt.Errorf("missing basic auth credentials")
+	}
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(a.user)) == 1
+	passwordOK := subtle.ConstantTimeCompare([]byte(password), []byte(a.password)) == 1
+	if !userOK || !passwordOK {
+		return fmt.Errorf("invalid basic auth credentials")
+	}
+	return nil
+}
+
+// URLSigner 產生與驗證有期限的簽章網址，適合直接放進客戶端 App 的訂閱連結
+// 更換 AUTO_PROXY_SERVE_SIGNING_KEY 會讓所有已發出的連結失效
+type URLSigner struct {
+	key []byte
+}
+
+func NewURLSignerFromEnv() (*URLSigner, error) {
+	key := secretEnv("AUTO_PROXY_SERVE_SIGNING_KEY")
+	if key == "" {
+		return nil, fmt.Errorf("AUTO_PROXY_SERVE_SIGNING_KEY is required for signed URLs, set it or store it with `auto_proxy secret set`")
+	}
+	return &URLSigner{key: []byte(key)}, nil
+}
+
+// Sign 在 rawURL 加上 expires 與 sig 參數
+func (s *URLSigner) Sign(rawURL string, expires time.Time) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL %s: %v", rawURL, err)
+	}
+	query := u.Query()
+	query.Del("sig")
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	query.Set("sig", s.signature(u.Path, query))
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+func (s *URLSigner) Authenticate(r *http.Request) error {
+	query := r.URL.Query()
+	sig := query.Get("sig")
+	if sig == "" {
+		return fmt.Errorf("missing signature")
+	}
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid expires")
+	}
+	if now().After(time.Unix(expires, 0)) {
+		return fmt.Errorf("signed URL expired")
+	}
+	if !hmac.Equal([]byte(sig), []byte(s.signature(r.URL.Path, query))) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+// signature 對路徑與 sig 以外的參數做 HMAC，Encode 會依 key 排序所以順序不影響結果
+func (s *URLSigner) signature(path string, query url.Values) string {
+	signed := url.Values{}
+	for k, v := range query {
+		if k != "sig" {
+			signed[k] = v
+		}
+	}
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(path + "?" + signed.Encode()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// RetryPolicy 雲端操作的重試策略，第 n 次重試前等待 BaseDelay * 2^(n-1)
+// MaxWait 限制單一操作花在重試與等待完成的總時間，0 表示不限制
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxWait     time.Duration
+}
+
+var defaultRetryPolicy = RetryPolicy{MaxAttempts: 5, BaseDelay: time.Second}
+
+// errMaxWait 操作超過 --max-wait 時回傳的錯誤
+var errMaxWait = errors.New("exceeded --max-wait")
+
+// bound 回傳套用 MaxWait 的 context，超過時間後 cause 為 errMaxWait
+func (p RetryPolicy) bound(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p.MaxWait <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeoutCause(ctx, p.MaxWait, fmt.Errorf("%w (%v)", errMaxWait, p.MaxWait))
+}
+
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	return p.BaseDelay << uint(attempt)
+}
+
+// backoff 顯示重試次數並倒數到下一次重試，ctx 結束時提前回傳原因
+func (p RetryPolicy) backoff(ctx context.Context, desc string, attempt int, err error) error {
+	wait := p.delay(attempt)
+	// 等待時間超過剩下的 MaxWait 時不再重試
+	if deadline, ok := ctx.Deadline(); ok && p.MaxWait > 0 && time.Until(deadline) < wait {
+		return fmt.Errorf("%s: %w (%v), last error: %v", desc, errMaxWait, p.MaxWait, err)
+	}
+	chatf(tr("%s failed (attempt %d/%d): %v\n"), desc, attempt+1, p.MaxAttempts, err)
+	if waitErr := countdown(ctx, wait); waitErr != nil {
+		return fmt.Errorf("%s: %w, last error: %v", desc, waitErr, err)
+	}
+	return nil
+}
+
+// countdown 等待 d，在終端機上每秒更新剩下的秒數，其他情況只印一行
+func countdown(ctx context.Context, d time.Duration) error {
+	if logLevel < LevelNormal {
+		return sleepContext(ctx, d)
+	}
+	// 狀態列已經顯示經過時間，不另外倒數
+	if !term.IsTerminal(int(os.Stdout.Fd())) || (status != nil && status.active()) {
+		chatf(tr("Retrying in %v...\n"), d)
+		return sleepContext(ctx, d)
+	}
+	deadline := time.Now().Add(d)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		remaining := time.Until(deadline).Round(time.Second)
+		if remaining <= 0 {
+			fmt.Print("\r\033[K")
+			return nil
+		}
+		fmt.Printf("\r\033[K"+tr("Retrying in %v..."), remaining)
+		select {
+		case <-ctx.Done():
+			fmt.Println()
+			return context.Cause(ctx)
+		case <-ticker.C:
+		}
+	}
+}
+
+// sleepContext 等待 d 或直到 ctx 結束
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// supportedBakeProtocols 目前可以預先安裝到映像檔的協定
+var supportedBakeProtocols = []string{"shadowsocks"}
+
+// Bake 建立暫時的 instance 部署 proxy 後做成映像檔，並複製到指定的 regions，最後清除暫時資源
+func (c *Commander) Bake(ctx context.Context, zone, machineType, protocol, imageName string, locations []string) error {
+	if err := c.preflight(); err != nil {
+		return err
+	}
+	supported := false
+	for _, p := range supportedBakeProtocols {
+		if p == protocol {
+			supported = true
+		}
+	}
+	if !supported {
+		return fmt.Errorf("unsupported protocol: %s", protocol)
+	}
+	if machineType == "" {
+		machineType = c.provider.RecommendedType()
+	}
+	arch := c.provider.MachineArch(machineType)
+	stamp := time.Now().Format("20060102-150405")
+	if imageName == "" {
+		imageName = fmt.Sprintf("proxy-image-%s-%s-%s", protocol, arch, stamp)
+	}
+
+	name := "proxy-bake-" + stamp
+	fmt.Printf(tr("Creating build instance %s in %s...\n"), name, zone)
+	// 暫時的 instance 沒有紀錄，清除完成前中斷時由日誌在下次執行時刪除
+	done, err := c.journal.Begin(JournalInsertInstance, "", zone, name)
+	if err != nil {
+		return err
+	}
+	instanceID, info, err := c.provider.CreateInstance(ctx, InstanceSpec{Name: name, Zone: zone, MachineType: machineType, Arch: arch, Metadata: c.metadata.Merge(nil)})
+	if err != nil {
+		return fmt.Errorf("error creating build instance: %v", err)
+	}
+
+	diskID := info.DiskID
+	defer func() {
+		if err := c.provider.DeleteInstance(ctx, zone, instanceID); err != nil && !errors.Is(err, ErrInstanceNotFound) {
+			c.logger.Printf("Error deleting build instance %s: %v", instanceID, err)
+			fmt.Printf(tr("Failed to delete build instance %s, please delete it manually\n"), instanceID)
+			return
+		}
+		if diskID != "" {
+			if err := c.provider.DeleteDisk(ctx, zone, diskID); err != nil && !errors.Is(err, ErrDiskNotFound) {
+				c.logger.Printf("Error deleting build disk %s: %v", diskID, err)
+				fmt.Printf(tr("Failed to delete build disk %s, please delete it manually\n"), diskID)
+				return
+			}
+		}
+		done()
+	}()
+
+	// 映像檔內的 Shadowsocks 設定使用設定檔的預設值，由映像檔建立的 proxy 部署時會換成各自的密碼
+	endpoint := c.config.Shadowsocks
+	password, err := c.newProxyPassword()
+	if err != nil {
+		return err
+	}
+	target := DeployTarget{IP: info.IP, Arch: arch, Port: endpoint.Port, Method: endpoint.Method, Password: password}
+	if err := c.deployer.Deploy(target); err != nil {
+		c.logger.Printf("Error deploying build instance %s: %v", name, err)
+		return fmt.Errorf("error deploying build instance: %v", err)
+	}
+
+	spec := ImageSpec{Name: imageName, Zone: zone, DiskID: diskID, Locations: locations, Protocol: protocol, Arch: arch}
+	if err := c.provider.CreateImage(ctx, spec); err != nil {
+		return fmt.Errorf("error creating image: %v", err)
+	}
+	fmt.Printf(tr("Image %s baked.\n"), imageName)
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"google.golang.org/api/bigquery/v2"
+)
+
+// billingTablePattern Cloud Billing 匯出的 BigQuery 資料表，project.dataset.table
+var billingTablePattern = regexp.MustCompile(`^[A-Za-z0-9_:-]+\.[A-Za-z0-9_]+\.[A-Za-z0-9_]+$`)
+
+// billingQuery 依資源的 id label 加總 project 中的費用，credits 為負值，例如免費方案與續用折扣
+// 名稱取最後一筆帳單上的 name label，rename 前後的費用都歸屬到同一台 proxy
+const billingQuery = "SELECT l.value, " +
+	"ARRAY_AGG((SELECT n.value FROM UNNEST(b.labels) n WHERE n.key = @name_label) IGNORE NULLS ORDER BY b.usage_start_time DESC LIMIT 1)[SAFE_OFFSET(0)], " +
+	"b.currency, SUM(b.cost) + SUM(IFNULL((SELECT SUM(c.amount) FROM UNNEST(b.credits) c), 0)) " +
+	"FROM `%s` AS b, UNNEST(b.labels) AS l WHERE l.key = @id_label AND b.project.id = @project GROUP BY l.value, b.currency"
+
+// BilledCosts 從匯出到 BigQuery 的帳單資料加總目前 project 中帶有 idLabel 的資源的實際費用，key 為 idLabel 的值
+// 匯出的資料涵蓋整個帳單帳戶，通常比實際使用晚幾個小時
+func (g *GCPProvider) BilledCosts(ctx context.Context, table, idLabel, nameLabel string) (map[string]BilledCost, error) {
+	if !billingTablePattern.MatchString(table) {
+		return nil, fmt.Errorf("invalid billing export table %q: use project.dataset.table", table)
+	}
+	service, err := bigquery.NewService(ctx, g.clientOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create BigQuery client: %v", err)
+	}
+	useLegacySQL := false
+	request := &bigquery.QueryRequest{
+		Query:        fmt.Sprintf(billingQuery, table),
+		UseLegacySql: &useLegacySQL,
+		TimeoutMs:    30000,
+		QueryParameters: []*bigquery.QueryParameter{
+			stringParameter("id_label", idLabel),
+			stringParameter("name_label", nameLabel),
+			stringParameter("project", g.project),
+		},
+	}
+	debugf("bigquery.jobs.query %s", table)
+	response, err := service.Jobs.Query(g.project, request).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query billing export: %v", err)
+	}
+	rows, pageToken, complete := response.Rows, response.PageToken, response.JobComplete
+	// 查詢超過 TimeoutMs 或結果有多頁時以 getQueryResults 繼續取得
+	for !complete || pageToken != "" {
+		job := response.JobReference
+		call := service.Jobs.GetQueryResults(job.ProjectId, job.JobId).Location(job.Location).TimeoutMs(30000).Context(ctx)
+		if complete {
+			call = call.PageToken(pageToken)
+		}
+		page, err := call.Do()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get billing export results: %v", err)
+		}
+		if !page.JobComplete {
+			continue
+		}
+		rows, pageToken, complete = append(rows, page.Rows...), page.PageToken, true
+	}
+
+	costs := make(map[string]BilledCost)
+	for _, row := range rows {
+		if len(row.F) != 4 {
+			continue
+		}
+		id, _ := row.F[0].V.(string)
+		name, _ := row.F[1].V.(string)
+		currency, _ := row.F[2].V.(string)
+		text, _ := row.F[3].V.(string)
+		amount, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cost %q in billing export: %v", text, err)
+		}
+		cost := costs[id]
+		cost.Amount += amount
+		cost.Currency = currency
+		if cost.Name == "" {
+			cost.Name = name
+		}
+		costs[id] = cost
+	}
+	return costs, nil
+}
+
+func stringParameter(name, value string) *bigquery.QueryParameter {
+	return &bigquery.QueryParameter{
+		Name:           name,
+		ParameterType:  &bigquery.QueryParameterType{Type: "STRING"},
+		ParameterValue: &bigquery.QueryParameterValue{Value: value},
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !auto_proxyd
+
+package main
+
+import "github.com/spf13/cobra"
+
+// binaryCommand 回傳這個執行檔的根指令，`-tags auto_proxyd` 時改為 daemon
+func (a *cliApp) binaryCommand() *cobra.Command {
+	return a.rootCommand()
+}
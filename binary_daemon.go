@@ -0,0 +1,9 @@
+//go:build auto_proxyd
+
+package main
+
+import "github.com/spf13/cobra"
+
+func (a *cliApp) binaryCommand() *cobra.Command {
+	return a.daemonCommand()
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+type createResult struct {
+	Name string
+	Err  error
+}
+
+// createJob 一台要建立的 proxy，分散建立時每台的 zone 可能不同
+type createJob struct {
+	Name string
+	Plan createPlan
+}
+
+// takenNames 回傳已經使用的名稱，rename 過的 proxy 在 GCE 上仍使用原本的 instance 名稱，也要避開
+func takenNames(records []ProxyRecord) map[string]bool {
+	taken := make(map[string]bool, len(records))
+	for _, r := range records {
+		taken[r.Name] = true
+		if r.InstanceID != "" {
+			taken[r.InstanceID] = true
+		}
+	}
+	return taken
+}
+
+// proxyName 產生 proxy-<zone>-<隨機後綴> 的名稱，同一個 zone 建立多台或其他電腦建立的 instance 都不會重複
+func proxyName(zone string, taken map[string]bool) string {
+	const letters = "abcdefghijklmnopqrstuvwxyz0123456789"
+	for {
+		suffix := make([]byte, 4)
+		for i := range suffix {
+			suffix[i] = letters[rand.Intn(len(letters))]
+		}
+		name := fmt.Sprintf("proxy-%s-%s", strings.ReplaceAll(zone, "-", ""), suffix)
+		if !taken[name] {
+			return name
+		}
+	}
+}
+
+// bulkJobs 依 zone 命名，編號在整批中不重複，也會跳過 taken 中已經有紀錄的名稱
+func bulkJobs(plan createPlan, zones []string, taken map[string]bool) []createJob {
+	jobs := make([]createJob, len(zones))
+	n := 0
+	for i, zone := range zones {
+		p := plan
+		p.Zone = zone
+		var name string
+		for {
+			n++
+			name = fmt.Sprintf("proxy-%s-%d", strings.ReplaceAll(zone, "-", ""), n)
+			if !taken[name] {
+				break
+			}
+		}
+		jobs[i] = createJob{Name: name, Plan: p}
+	}
+	return jobs
+}
+
+// spreadZones 為 count 台 proxy 挑選 zone，已經有越多 proxy 的 zone 權重越低，
+// 讓同一個 region 的 proxy 分散到不同 zone，拿到不同網段的 IP
+func spreadZones(zones []string, existing map[string]int, count int) []string {
+	used := make(map[string]int, len(zones))
+	for _, zone := range zones {
+		used[zone] = existing[zone]
+	}
+	picked := make([]string, 0, count)
+	for range count {
+		weights := make([]float64, len(zones))
+		total := 0.0
+		for i, zone := range zones {
+			weights[i] = 1 / float64(1+used[zone])
+			total += weights[i]
+		}
+		r := rand.Float64() * total
+		choice := zones[len(zones)-1]
+		for i, w := range weights {
+			if r < w {
+				choice = zones[i]
+				break
+			}
+			r -= w
+		}
+		used[choice]++
+		picked = append(picked, choice)
+	}
+	return picked
+}
+
+// createMany 以 worker pool 併發建立多個 proxy，全部結束後彙整每一台的結果
+// jitter 大於 0 時每台開始建立前隨機延遲，錯開建立時間
+func (c *Commander) createMany(ctx context.Context, jobs []createJob, parallel int, jitter time.Duration) error {
+	if parallel < 1 {
+		parallel = 1
+	}
+	queue := make(chan createJob)
+	results := make(chan createResult, len(jobs))
+
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				if jitter > 0 {
+					delay := time.Duration(rand.Int63n(int64(jitter)))
+					if !c.machineOutput() {
+						printAbove(tr("[%s] Starting in %v...\n"), job.Name, delay.Round(time.Second))
+					}
+					if err := sleepContext(ctx, delay); err != nil {
+						results <- createResult{Name: job.Name, Err: err}
+						continue
+					}
+				}
+				if !c.machineOutput() {
+					printAbove(tr("[%s] Creating...\n"), job.Name)
+				}
+				err := c.provision(ctx, job.Plan, job.Name)
+				if err != nil {
+					c.logger.Printf("Error creating proxy %s: %v", job.Name, err)
+				}
+				results <- createResult{Name: job.Name, Err: err}
+			}
+		}()
+	}
+	var created []string
+	for _, job := range jobs {
+		created = append(created, job.Name)
+		queue <- job
+	}
+	close(queue)
+	wg.Wait()
+	close(results)
+
+	errs := make(map[string]error)
+	for r := range results {
+		if r.Err != nil {
+			errs[r.Name] = r.Err
+		}
+	}
+	if !c.machineOutput() {
+		fmt.Println(tr("Summary:"))
+	}
+	if err := c.renderCreated(created, errs); err != nil {
+		return err
+	}
+	if failed := len(errs); failed > 0 {
+		return fmt.Errorf("%d of %d proxies failed", failed, len(jobs))
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// serve --mtls 使用的憑證都放在資料目錄的 ca 子目錄，ca init 建立 CA 與伺服器憑證，ca issue 發給用戶端
+const (
+	caCertFile     = "ca.pem"
+	caKeyFile      = "ca-key.pem"
+	serverCertFile = "server.pem"
+	serverKeyFile  = "server-key.pem"
+)
+
+// 憑證的有效期限，伺服器憑證到期前以 ca server 重新簽發，CA 不變時用戶端憑證不受影響
+const (
+	caValidity     = 10 * 365 * 24 * time.Hour
+	serverValidity = 825 * 24 * time.Hour
+)
+
+// caDirectory 回傳資料目錄下存放 CA 的目錄
+func caDirectory(cfg *Config) (string, error) {
+	dir, err := cfg.dataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ca"), nil
+}
+
+// CAInit 建立管理 serve 連線的 CA 與伺服器憑證，已經有 CA 時需要 force，重建後之前發出的用戶端憑證都會失效
+func CAInit(dir string, hosts []string, force bool) error {
+	if _, err := os.Stat(filepath.Join(dir, caCertFile)); err == nil && !force {
+		return fmt.Errorf("a CA already exists in %s, use --force to replace it and invalidate all client certificates", dir)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %v", dir, err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate CA key: %v", err)
+	}
+	template, err := certTemplate("auto_proxy CA", caValidity)
+	if err != nil {
+		return err
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("failed to create CA certificate: %v", err)
+	}
+	if err := writeCertPair(filepath.Join(dir, caCertFile), filepath.Join(dir, caKeyFile), der, key); err != nil {
+		return err
+	}
+	fmt.Printf(tr("Created CA in %s.\n"), dir)
+	return CAServer(dir, hosts)
+}
+
+// CAServer 以 CA 簽發 serve 使用的伺服器憑證，hosts 為空時包含 localhost 與本機名稱
+func CAServer(dir string, hosts []string) error {
+	if len(hosts) == 0 {
+		hosts = []string{"localhost", "127.0.0.1", "::1"}
+		if name, err := os.Hostname(); err == nil {
+			hosts = append(hosts, name)
+		}
+	}
+	template, err := certTemplate(hosts[0], serverValidity)
+	if err != nil {
+		return err
+	}
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	if err := issueCert(dir, template, filepath.Join(dir, serverCertFile), filepath.Join(dir, serverKeyFile)); err != nil {
+		return err
+	}
+	fmt.Printf(tr("Issued server certificate for %v, valid until %s.\n"), hosts, template.NotAfter.Format(time.DateOnly))
+	return nil
+}
+
+// CAIssue 簽發用戶端憑證，寫到 out 目錄的 <name>.pem 與 <name>-key.pem
+func CAIssue(dir, name, out string, validity time.Duration) error {
+	if name == "" {
+		return fmt.Errorf("client name is required")
+	}
+	if validity <= 0 {
+		return fmt.Errorf("--days must be positive")
+	}
+	template, err := certTemplate(name, validity)
+	if err != nil {
+		return err
+	}
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	certPath, keyPath := filepath.Join(out, name+".pem"), filepath.Join(out, name+"-key.pem")
+	if err := issueCert(dir, template, certPath, keyPath); err != nil {
+		return err
+	}
+	fmt.Printf(tr("Issued client certificate %s (key %s), valid until %s.\n"), certPath, keyPath, template.NotAfter.Format(time.DateOnly))
+	fmt.Println(tr("Connect with:"))
+	fmt.Printf("  curl --cacert %s --cert %s --key %s 'https://<host>/subscription?key=<access key>'\n", filepath.Join(dir, caCertFile), certPath, keyPath)
+	return nil
+}
+
+// mtlsConfig 回傳要求用戶端出示 CA 簽發的憑證的 TLS 設定
+func mtlsConfig(dir string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dir, serverCertFile), filepath.Join(dir, serverKeyFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no server certificate in %s, run `auto_proxy ca init` first", dir)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server certificate: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, caCertFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("invalid CA certificate in %s", dir)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+func certTemplate(commonName string, validity time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %v", err)
+	}
+	// 容許簽發端與使用端的時鐘有些微差距
+	notBefore := now().Add(-5 * time.Minute)
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"auto_proxy"}},
+		NotBefore:    notBefore,
+		NotAfter:     notBefore.Add(validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}, nil
+}
+
+// issueCert 以 dir 中的 CA 簽發 template，產生新的金鑰
+func issueCert(dir string, template *x509.Certificate, certPath, keyPath string) error {
+	ca, err := tls.LoadX509KeyPair(filepath.Join(dir, caCertFile), filepath.Join(dir, caKeyFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("no CA in %s, run `auto_proxy ca init` first", dir)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load CA: %v", err)
+	}
+	caCert, err := x509.ParseCertificate(ca.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse CA certificate: %v", err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate key: %v", err)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, ca.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("failed to sign certificate: %v", err)
+	}
+	return writeCertPair(certPath, keyPath, der, key)
+}
+
+// writeCertPair 寫入 PEM 格式的憑證與私鑰，私鑰只有自己可以讀取
+func writeCertPair(certPath, keyPath string, der []byte, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to encode key: %v", err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %v", keyPath, err)
+	}
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", certPath, err)
+	}
+	return nil
+}
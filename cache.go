@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DiskCache 將目錄、價格等不常變動的資料快取在磁碟上
+// 過期的資料仍會先回傳，並在背景更新；抓取失敗時也會退回舊資料，讓離線時仍可使用
+type DiskCache struct {
+	dir    string
+	ttl    time.Duration
+	logger *log.Logger
+	wg     sync.WaitGroup
+}
+
+type cacheEntry[T any] struct {
+	Fetched time.Time `json:"fetched"`
+	Data    T         `json:"data"`
+}
+
+func NewDiskCache(dir string, ttl time.Duration, logger *log.Logger) *DiskCache {
+	return &DiskCache{dir: dir, ttl: ttl, logger: logger}
+}
+
+// Wait 等待背景更新完成，程式結束前呼叫
+func (c *DiskCache) Wait() {
+	c.wg.Wait()
+}
+
+func (c *DiskCache) path(key string) string {
+	return filepath.Join(c.dir, strings.NewReplacer("/", "_", ":", "_").Replace(key)+".json")
+}
+
+func cachedFetch[T any](c *DiskCache, key string, fetch func() (T, error)) (T, error) {
+	var entry cacheEntry[T]
+	data, err := os.ReadFile(c.path(key))
+	cached := err == nil && json.Unmarshal(data, &entry) == nil
+
+	if cached && time.Since(entry.Fetched) < c.ttl {
+		return entry.Data, nil
+	}
+	if cached {
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			if _, err := refreshCache(c, key, fetch); err != nil {
+				c.logger.Printf("Background refresh of %s failed: %v", key, err)
+			}
+		}()
+		return entry.Data, nil
+	}
+	return refreshCache(c, key, fetch)
+}
+
+func refreshCache[T any](c *DiskCache, key string, fetch func() (T, error)) (T, error) {
+	value, err := fetch()
+	if err != nil {
+		return value, err
+	}
+	data, err := json.Marshal(cacheEntry[T]{Fetched: time.Now(), Data: value})
+	if err != nil {
+		return value, fmt.Errorf("failed to marshal cache: %w", err)
+	}
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return value, fmt.Errorf("failed to create cache dir: %w", err)
+	}
+	// 先寫到暫存檔再改名，背景更新時讀取端不會讀到寫一半的檔案
+	tmp := c.path(key) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return value, fmt.Errorf("failed to write cache: %w", err)
+	}
+	if err := os.Rename(tmp, c.path(key)); err != nil {
+		return value, fmt.Errorf("failed to write cache: %w", err)
+	}
+	return value, nil
+}
+
+// CachingProvider 對 region、zone、machine type 等目錄資料做讀穿式快取
+type CachingProvider struct {
+	CloudProvider
+	cache  *DiskCache
+	prefix string
+}
+
+func NewCachingProvider(provider CloudProvider, cache *DiskCache, prefix string) *CachingProvider {
+	return &CachingProvider{CloudProvider: provider, cache: cache, prefix: prefix}
+}
+
+func (p *CachingProvider) ListRegions(ctx context.Context) ([]string, error) {
+	return cachedFetch(p.cache, p.prefix+"-regions", func() ([]string, error) {
+		return p.CloudProvider.ListRegions(ctx)
+	})
+}
+
+func (p *CachingProvider) ListZones(ctx context.Context, region string) ([]string, error) {
+	return cachedFetch(p.cache, p.prefix+"-zones-"+region, func() ([]string, error) {
+		return p.CloudProvider.ListZones(ctx, region)
+	})
+}
+
+func (p *CachingProvider) MachinePrices(ctx context.Context, zone string) (map[string]MachinePrice, error) {
+	return cachedFetch(p.cache, p.prefix+"-machine-prices-"+zone, func() (map[string]MachinePrice, error) {
+		return p.CloudProvider.MachinePrices(ctx, zone)
+	})
+}
+
+func (p *CachingProvider) ListMachineTypes(ctx context.Context, zone string) ([]string, error) {
+	return cachedFetch(p.cache, p.prefix+"-machine-types-"+zone, func() ([]string, error) {
+		return p.CloudProvider.ListMachineTypes(ctx, zone)
+	})
+}
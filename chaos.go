@@ -0,0 +1,177 @@
+//go:build chaos
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 使用 `go build -tags chaos` 編譯後，透過 AUTO_PROXY_CHAOS 注入錯誤，例如：
+//
+//	AUTO_PROXY_CHAOS="provider=0.3,ssh=0.5,partial=0.2,seed=42"
+//
+// provider: 雲端 API 呼叫失敗的機率
+// ssh:      部署時 SSH 逾時的機率
+// partial:  instance 已建立但回報失敗的機率
+type chaosConfig struct {
+	provider float64
+	ssh      float64
+	partial  float64
+	rnd      *rand.Rand
+}
+
+func loadChaosConfig() (*chaosConfig, error) {
+	cfg := &chaosConfig{rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	spec := os.Getenv("AUTO_PROXY_CHAOS")
+	if spec == "" {
+		return cfg, nil
+	}
+	for _, part := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid AUTO_PROXY_CHAOS entry: %s", part)
+		}
+		if key == "seed" {
+			seed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid chaos seed: %v", err)
+			}
+			cfg.rnd = rand.New(rand.NewSource(seed))
+			continue
+		}
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chaos rate %s: %v", key, err)
+		}
+		switch key {
+		case "provider":
+			cfg.provider = rate
+		case "ssh":
+			cfg.ssh = rate
+		case "partial":
+			cfg.partial = rate
+		default:
+			return nil, fmt.Errorf("unknown chaos key: %s", key)
+		}
+	}
+	return cfg, nil
+}
+
+func (c *chaosConfig) hit(rate float64) bool {
+	return rate > 0 && c.rnd.Float64() < rate
+}
+
+func wrapChaos(provider CloudProvider, deployer ProxyDeployer) (CloudProvider, ProxyDeployer, error) {
+	cfg, err := loadChaosConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	fmt.Println(tr("CHAOS MODE ENABLED: failures will be injected"))
+	return &chaosProvider{CloudProvider: provider, cfg: cfg}, &chaosDeployer{ProxyDeployer: deployer, cfg: cfg}, nil
+}
+
+type chaosProvider struct {
+	CloudProvider
+	cfg *chaosConfig
+}
+
+func (p *chaosProvider) fail(op string) error {
+	if p.cfg.hit(p.cfg.provider) {
+		return fmt.Errorf("chaos: injected provider error in %s", op)
+	}
+	return nil
+}
+
+func (p *chaosProvider) CreateInstance(ctx context.Context, spec InstanceSpec) (string, InstanceInfo, error) {
+	if err := p.fail("CreateInstance"); err != nil {
+		return "", InstanceInfo{}, err
+	}
+	instanceID, info, err := p.CloudProvider.CreateInstance(ctx, spec)
+	if err == nil && p.cfg.hit(p.cfg.partial) {
+		// instance 已經建立，但呼叫端只看到錯誤
+		return "", InstanceInfo{}, fmt.Errorf("chaos: injected partial failure after creating %s", instanceID)
+	}
+	return instanceID, info, err
+}
+
+func (p *chaosProvider) DeleteInstance(ctx context.Context, zone, instanceID string) error {
+	if err := p.fail("DeleteInstance"); err != nil {
+		return err
+	}
+	return p.CloudProvider.DeleteInstance(ctx, zone, instanceID)
+}
+
+func (p *chaosProvider) StopInstance(ctx context.Context, zone, instanceID string) error {
+	if err := p.fail("StopInstance"); err != nil {
+		return err
+	}
+	return p.CloudProvider.StopInstance(ctx, zone, instanceID)
+}
+
+func (p *chaosProvider) StartInstance(ctx context.Context, zone, instanceID string) error {
+	if err := p.fail("StartInstance"); err != nil {
+		return err
+	}
+	return p.CloudProvider.StartInstance(ctx, zone, instanceID)
+}
+
+func (p *chaosProvider) DeleteDisk(ctx context.Context, zone, diskID string) error {
+	if err := p.fail("DeleteDisk"); err != nil {
+		return err
+	}
+	return p.CloudProvider.DeleteDisk(ctx, zone, diskID)
+}
+
+func (p *chaosProvider) GetInstanceInfo(ctx context.Context, zone, instanceID string) (InstanceInfo, error) {
+	if err := p.fail("GetInstanceInfo"); err != nil {
+		return InstanceInfo{}, err
+	}
+	return p.CloudProvider.GetInstanceInfo(ctx, zone, instanceID)
+}
+
+func (p *chaosProvider) ListInstances(ctx context.Context, label string) ([]CloudInstance, error) {
+	if err := p.fail("ListInstances"); err != nil {
+		return nil, err
+	}
+	return p.CloudProvider.ListInstances(ctx, label)
+}
+
+func (p *chaosProvider) SetNetworkTags(ctx context.Context, zone, instanceID string, tags []string) error {
+	if err := p.fail("SetNetworkTags"); err != nil {
+		return err
+	}
+	return p.CloudProvider.SetNetworkTags(ctx, zone, instanceID, tags)
+}
+
+func (p *chaosProvider) DeleteResource(ctx context.Context, resource CloudResource) error {
+	if err := p.fail("DeleteResource"); err != nil {
+		return err
+	}
+	return p.CloudProvider.DeleteResource(ctx, resource)
+}
+
+type chaosDeployer struct {
+	ProxyDeployer
+	cfg *chaosConfig
+}
+
+func (d *chaosDeployer) Deploy(target DeployTarget) error {
+	if d.cfg.hit(d.cfg.ssh) {
+		time.Sleep(5 * time.Second)
+		return fmt.Errorf("chaos: ssh: connect to host %s port 22: Connection timed out", target.IP)
+	}
+	return d.ProxyDeployer.Deploy(target)
+}
+
+func (d *chaosDeployer) Preflight() error {
+	if checker, ok := d.ProxyDeployer.(PreflightChecker); ok {
+		return checker.Preflight()
+	}
+	return nil
+}
@@ -0,0 +1,8 @@
+//go:build !chaos
+
+package main
+
+// wrapChaos 只有在 `-tags chaos` 編譯時才會注入錯誤
+func wrapChaos(provider CloudProvider, deployer ProxyDeployer) (CloudProvider, ProxyDeployer, error) {
+	return provider, deployer, nil
+}
@@ -0,0 +1,1173 @@
+package main
+
+import (
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// cliApp 持有指令執行時需要的 Commander，--help 等不需要雲端連線的情況不會建立
+type cliApp struct {
+	logger    *log.Logger
+	output    string
+	lang      string
+	profile   string
+	maxWait   time.Duration
+	dryRun    bool
+	secrets   bool // --show-secrets
+	quiet     bool
+	verbose   int
+	config    *Config
+	commander *Commander
+	cleanup   func()
+}
+
+func (a *cliApp) setup(cmd *cobra.Command, args []string) error {
+	// 設定檔有誤時 config 指令仍然要能執行，錯誤留到需要雲端連線時才回報
+	cfg, cfgErr := loadConfig()
+	lang := a.lang
+	if lang == "" && os.Getenv("AUTO_PROXY_LANG") == "" && cfgErr == nil {
+		lang = cfg.Lang
+	}
+	if err := setLanguage(lang); err != nil {
+		return err
+	}
+	if err := setLogLevel(a.quiet, a.verbose); err != nil {
+		return err
+	}
+	// help、shell completion 與自帶環境的指令不需要雲端連線
+	if cmd.Annotations[annotationStandalone] == "true" || cmd.Name() == "help" || cmd.Name() == cobra.ShellCompRequestCmd || cmd.Name() == cobra.ShellCompNoDescRequestCmd ||
+		(cmd.HasParent() && cmd.Parent().Name() == "completion") {
+		return nil
+	}
+	// 先檢查必填參數，避免在建立雲端連線之後才發現少了參數
+	if err := cmd.ValidateRequiredFlags(); err != nil {
+		return err
+	}
+	if cfgErr != nil {
+		return cfgErr
+	}
+	profile := a.profile
+	if profile == "" {
+		profile = os.Getenv("AUTO_PROXY_PROFILE")
+	}
+	if err := cfg.useProfile(profile); err != nil {
+		return err
+	}
+	a.config = cfg
+	// dry run 不搬移舊的狀態檔
+	dataDir, err := cfg.prepareDataDir(!a.dryRun)
+	if err != nil {
+		return err
+	}
+	logger, closeLog, err := openLogger(cfg.LogFile)
+	if err != nil {
+		return err
+	}
+	a.logger = logger
+	commander, cleanup, err := newCommanderFromConfig(a.logger, cfg, commanderOptions{Output: a.output, MaxWait: a.maxWait, DryRun: a.dryRun, DataDir: dataDir, ShowSecrets: a.secrets})
+	if err != nil {
+		closeLog()
+		return err
+	}
+	a.commander = commander
+	a.cleanup = func() {
+		cleanup()
+		closeLog()
+	}
+	// 修改雲端資源的指令執行前，先處理上次被中斷的操作
+	if cmd.Annotations[annotationAudit] == "true" {
+		commander.recoverJournal(cmd.Context())
+	}
+	return nil
+}
+
+func (a *cliApp) close() {
+	if a.cleanup != nil {
+		a.cleanup()
+	}
+}
+
+func (a *cliApp) rootCommand() *cobra.Command {
+	root := &cobra.Command{
+		Use:               "auto_proxy",
+		Short:             "Create and manage Shadowsocks proxies on GCP",
+		SilenceUsage:      true,
+		PersistentPreRunE: a.setup,
+	}
+	root.PersistentFlags().StringVarP(&a.output, "output", "o", OutputTable, "Output format: table, json or yaml")
+	root.PersistentFlags().StringVar(&a.lang, "lang", "", "Language of messages: en or zh-TW (default from AUTO_PROXY_LANG or the system locale)")
+	root.PersistentFlags().StringVar(&a.profile, "profile", "", "Use the GCP project of this profile from the config; records of other projects are not listed or changed (default AUTO_PROXY_PROFILE)")
+	root.PersistentFlags().DurationVar(&a.maxWait, "max-wait", 0, "Maximum time to spend retrying and waiting on any single cloud operation, e.g. 5m (default no limit)")
+	root.PersistentFlags().BoolVarP(&a.quiet, "quiet", "q", false, "Only print results; errors are still written to the log file")
+	root.PersistentFlags().CountVarP(&a.verbose, "verbose", "v", "Show more detail: -v adds Ansible output, -vv also logs every cloud API call")
+	root.PersistentFlags().BoolVar(&a.dryRun, "dry-run", false, "Print the cloud API calls, firewall changes and deployment steps without executing them")
+	root.PersistentFlags().BoolVar(&a.secrets, "show-secrets", false, "Include passwords and share URIs in the json and yaml output of create, which hides them by default")
+	root.AddCommand(
+		audited(a.createCommand()),
+		audited(a.deleteCommand()),
+		audited(a.noteCommand()),
+		audited(a.renameCommand()),
+		a.listCommand(),
+		a.searchCommand(),
+		a.statusCommand(),
+		audited(a.syncCommand()),
+		audited(a.pruneCommand()),
+		audited(a.gcCommand()),
+		audited(a.rotateCommand()),
+		a.allowIPCommand(),
+		a.firewallCommand(),
+		a.userCommand(),
+		a.connectCommand(),
+		a.sshCommand(),
+		a.costCommand(),
+		audited(a.reapCommand()),
+		audited(a.stopCommand()),
+		audited(a.startCommand()),
+		a.shareCommand(),
+		a.bestCommand(),
+		audited(a.recommendCommand()),
+		audited(a.rolloutCommand()),
+		audited(a.resumeCommand()),
+		a.inviteCommand(),
+		a.imageCommand(),
+		audited(a.bakeCommand()),
+		a.ipCommand(),
+		audited(a.importCommand()),
+		a.graphCommand(),
+		a.exportCommand(),
+		a.stateCommand(),
+		a.historyCommand(),
+		a.configCommand(),
+		a.secretCommand(),
+		a.caCommand(),
+		a.versionCommand(),
+	)
+	// 常駐的訂閱伺服器已移到 auto_proxyd，保留 serve 讓既有的部署可以繼續運作
+	serve := a.serveCommand()
+	serve.Deprecated = "use auto_proxyd serve instead (build it with `go build -tags auto_proxyd -o auto_proxyd .`)"
+	root.AddCommand(serve)
+	for _, extra := range extraCommands {
+		root.AddCommand(extra(a))
+	}
+	a.auditCommands(root)
+	return root
+}
+
+// extraCommands 依 build tag 額外加入的指令，例如 `-tags fakegce` 的 selftest
+var extraCommands []func(a *cliApp) *cobra.Command
+
+// 標記為 standalone 的指令自行準備執行環境，不需要完整的設定檔
+const annotationStandalone = "standalone"
+
+func (a *cliApp) createCommand() *cobra.Command {
+	var opts CreateOptions
+	cmd := &cobra.Command{
+		Use:   "create",
+		Short: "Create proxies interactively",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.commander.Create(cmd.Context(), opts)
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&opts.Name, "name", "", "Name of the proxy, lowercase letters, digits and hyphens (default proxy-<zone>-<random suffix>)")
+	flags.IntVar(&opts.Count, "count", 1, "Number of identical proxies to create, named proxy-<zone>-<n> with numbers not used by existing records")
+	flags.IntVar(&opts.Parallel, "parallel", 4, "Number of proxies to create concurrently when --count is greater than 1")
+	flags.BoolVar(&opts.Spread, "spread", false, "Spread proxies across the zones of the region, favoring zones with fewer proxies")
+	flags.DurationVar(&opts.Jitter, "jitter", 0, "Random delay of up to this long before each proxy is created, e.g. 2m")
+	flags.BoolVar(&opts.Last, "last", false, "Repeat the previous create with the same platform, region, zone, machine type and image, without prompts")
+	flags.BoolVar(&opts.Latency, "latency", false, "Measure latency to each region first and list the fastest regions first")
+	flags.StringArrayVar(&opts.Notes, "note", nil, "Note to keep with the proxy, e.g. do-not-delete or \"shared-with-team: used by CI\" (repeatable)")
+	flags.StringArrayVar(&opts.Tags, "tag", nil, "Tag the proxy with key=value, also applied as a GCP label, e.g. owner=alice (repeatable)")
+	flags.StringVar(&opts.SSHUser, "ssh-user", "", "SSH user used to provision the proxy (default ANSIBLE_SSH_USER)")
+	flags.StringVar(&opts.SSHKeyPath, "ssh-key", "", "SSH private key used to provision the proxy (default ANSIBLE_SSH_KEY_PATH)")
+	flags.IntVar(&opts.SSHPort, "ssh-port", 0, "Move sshd to this port when the proxy boots, kept for later redeploys (default ssh.port from the config, 22)")
+	flags.BoolVar(&opts.Private, "private", false, "Create the proxy without an external IP")
+	flags.StringVar(&opts.JumpHost, "jump-host", "", "Deploy through a bastion host, as user@host[:port]")
+	flags.BoolVar(&opts.IAP, "iap", false, "Deploy through a GCP Identity-Aware Proxy tunnel (requires gcloud)")
+	flags.BoolVar(&opts.Fast, "fast", false, "Fast boot: minimal OS image with preseeded apt config (default OS "+fastBootOS+")")
+	flags.StringVar(&opts.OS, "os", "", "OS image of the proxy: "+strings.Join(supportedOSImages(), ", ")+" (default ubuntu-2204)")
+	flags.BoolVar(&opts.Randomize, "randomize", false, "Pick a random high port and AEAD cipher for each proxy instead of the configured ones")
+	flags.BoolVar(&opts.AllowMyIP, "allow-my-ip", false, "Only allow connections to the proxy port from your current public IP, change it later with allow-ip")
+	flags.IntVar(&opts.RateLimit, "rate-limit", 0, "Drop new connections to the proxy beyond this many per source per minute (default shadowsocks.rate_limit from the config, 0 for none)")
+	flags.DurationVar(&opts.TTL, "ttl", 0, "Delete the proxy this long after it is created, e.g. 6h, when reap or serve --reap-interval runs (default never)")
+	flags.BoolVar(&opts.EndOfDay, "until-end-of-day", false, "Delete the proxy at the end of the day in the configured timezone, when reap or serve --reap-interval runs")
+	flags.Float64Var(&opts.MaxCost, "max-cost", 0, "Abort if the estimated monthly cost of the new proxies exceeds this amount, in the billing currency")
+	flags.BoolVarP(&opts.Yes, "yes", "y", false, "Create without asking for confirmation after the wizard")
+	flags.BoolVar(&opts.Knock, "knock", false, "Keep SSH closed until a random port knock sequence is sent, as `auto_proxy ssh` and deploys do (needs a public IP)")
+	cmd.MarkFlagsMutuallyExclusive("ttl", "until-end-of-day")
+	return cmd
+}
+
+func (a *cliApp) deleteCommand() *cobra.Command {
+	var name string
+	var force, yes bool
+	var filter DeleteFilter
+	cmd := &cobra.Command{
+		Use:   "delete",
+		Short: "Delete proxies and their cloud resources, choosing from a list when --name is omitted",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !filter.empty() {
+				return a.commander.DeleteMatching(cmd.Context(), filter, force, yes)
+			}
+			return a.commander.Delete(cmd.Context(), name, force, yes)
+		},
+	}
+	cmd.Flags().StringVar(&name, "name", "", "Name of the proxy to delete (default choose interactively)")
+	cmd.Flags().BoolVar(&force, "force", false, "Delete the proxy even if a note such as do-not-delete protects it")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Delete without asking for confirmation")
+	cmd.Flags().BoolVar(&filter.All, "all", false, "Delete all proxies")
+	cmd.Flags().StringVar(&filter.Region, "region", "", "Delete all proxies in this region, e.g. asia-east1")
+	cmd.Flags().DurationVar(&filter.OlderThan, "older-than", 0, "Delete all proxies created longer ago than this, e.g. 24h")
+	cmd.Flags().StringArrayVar(&filter.Tags, "tag", nil, "Delete all proxies with this tag, as key=value or key (repeatable, all must match)")
+	cmd.MarkFlagsMutuallyExclusive("name", "all")
+	cmd.MarkFlagsMutuallyExclusive("name", "region")
+	cmd.MarkFlagsMutuallyExclusive("name", "older-than")
+	cmd.MarkFlagsMutuallyExclusive("name", "tag")
+	cmd.MarkFlagsMutuallyExclusive("all", "tag")
+	cmd.MarkFlagsMutuallyExclusive("all", "region")
+	cmd.MarkFlagsMutuallyExclusive("all", "older-than")
+	return cmd
+}
+
+func (a *cliApp) noteCommand() *cobra.Command {
+	var name string
+	var add, remove []string
+	cmd := &cobra.Command{
+		Use:   "note",
+		Short: "Add, remove or show notes on a proxy",
+		Long:  "Notes are shown before a proxy is deleted. Notes starting with do-not-delete, shared-with-team or protected make delete require --force.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.commander.Note(name, add, remove)
+		},
+	}
+	cmd.Flags().StringVar(&name, "name", "", "Name of the proxy")
+	cmd.Flags().StringArrayVar(&add, "add", nil, "Note to add (repeatable)")
+	cmd.Flags().StringArrayVar(&remove, "remove", nil, "Note to remove (repeatable)")
+	cmd.MarkFlagRequired("name")
+	return cmd
+}
+
+func (a *cliApp) renameCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "rename <old> <new>",
+		Short: "Give a proxy a new name",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.commander.Rename(cmd.Context(), args[0], args[1])
+		},
+	}
+}
+
+func (a *cliApp) listCommand() *cobra.Command {
+	var opts ListOptions
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List proxies",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.commander.List(opts)
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&opts.Region, "region", "", "Only list proxies whose region starts with this, e.g. asia or asia-east1")
+	flags.StringVar(&opts.Provider, "provider", "", "Only list proxies of this cloud provider, e.g. gcp")
+	flags.StringVar(&opts.Protocol, "protocol", "", "Only list proxies serving this protocol, e.g. shadowsocks")
+	flags.StringVar(&opts.Status, "status", "", "Only list proxies with this status: creating, provisioning, active, unhealthy, stopped, deleting or failed")
+	flags.StringArrayVar(&opts.Tags, "tag", nil, "Only list proxies with this tag, as key=value or key (repeatable, all must match)")
+	flags.StringVar(&opts.Sort, "sort", "", "Sort by "+strings.Join(listSortKeys, ", ")+" (created lists the newest first)")
+	return cmd
+}
+
+func (a *cliApp) searchCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "search <query>",
+		Short: "Find proxies whose name, IP, region, location or tags contain the query",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.commander.Search(args[0])
+		},
+	}
+}
+
+func (a *cliApp) statusCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "status [name]",
+		Short: "Show the live cloud state of proxies and flag changed IPs",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := ""
+			if len(args) > 0 {
+				name = args[0]
+			}
+			return a.commander.Status(cmd.Context(), name)
+		},
+	}
+}
+
+func (a *cliApp) syncCommand() *cobra.Command {
+	var opts SyncOptions
+	cmd := &cobra.Command{
+		Use:   "sync",
+		Short: "Compare proxy records with the instances in the cloud and repair drift",
+		Long: "Lists the instances labeled " + managedLabel + " in the cloud and reports records whose instance is gone, changed IPs, " +
+			"instances created before labeling and labeled instances without a record.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.commander.Sync(cmd.Context(), opts)
+		},
+	}
+	cmd.Flags().BoolVar(&opts.Fix, "fix", false, "Remove records of missing instances, update changed IPs and label older instances")
+	cmd.Flags().BoolVar(&opts.Adopt, "adopt", false, "Add records for unmanaged instances as provisioning, then run resume to redeploy them")
+	return cmd
+}
+
+func (a *cliApp) pruneCommand() *cobra.Command {
+	var yes bool
+	cmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Remove records whose instance no longer exists in the cloud",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.commander.Prune(cmd.Context(), yes)
+		},
+	}
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Remove the records without asking for confirmation")
+	cmd.Flags().BoolVar(&yes, "force", false, "Same as --yes")
+	return cmd
+}
+
+func (a *cliApp) gcCommand() *cobra.Command {
+	var yes bool
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Delete orphaned disks, static IPs and firewall rules no proxy uses anymore",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.commander.GC(cmd.Context(), yes)
+		},
+	}
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Delete the resources without asking for confirmation")
+	return cmd
+}
+
+func (a *cliApp) rotateCommand() *cobra.Command {
+	var name string
+	cmd := &cobra.Command{
+		Use:   "rotate",
+		Short: "Generate a new password for a proxy, deploy it and print the new client config",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.commander.Rotate(cmd.Context(), name)
+		},
+	}
+	cmd.Flags().StringVar(&name, "name", "", "Name of the proxy whose credentials to rotate")
+	cmd.MarkFlagRequired("name")
+	return cmd
+}
+
+func (a *cliApp) allowIPCommand() *cobra.Command {
+	var name string
+	cmd := &cobra.Command{
+		Use:   "allow-ip",
+		Short: "Manage which IPs may connect to a proxy, applied to the cloud firewall and UFW",
+	}
+	cmd.PersistentFlags().StringVar(&name, "name", "", "Name of the proxy")
+	cmd.MarkPersistentFlagRequired("name")
+	cmd.AddCommand(audited(&cobra.Command{
+		Use:   "add [ip|cidr]...",
+		Short: "Allow connections from these sources, your current public IP when none is given",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				source, err := a.commander.currentSource(cmd.Context())
+				if err != nil {
+					return err
+				}
+				args = []string{source}
+			}
+			return a.commander.AllowIP(cmd.Context(), name, args, nil)
+		},
+	}))
+	cmd.AddCommand(audited(&cobra.Command{
+		Use:   "remove <ip|cidr>...",
+		Short: "Stop allowing these sources, the proxy accepts any IP again once the list is empty",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.commander.AllowIP(cmd.Context(), name, nil, args)
+		},
+	}))
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List the sources allowed to connect to the proxy",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.commander.AllowedIPs(name)
+		},
+	})
+	return cmd
+}
+
+func (a *cliApp) firewallCommand() *cobra.Command {
+	var name string
+	cmd := &cobra.Command{
+		Use:   "firewall",
+		Short: "Manage extra ports a proxy opens in the cloud firewall and UFW",
+	}
+	cmd.PersistentFlags().StringVar(&name, "name", "", "Name of the proxy")
+	cmd.MarkPersistentFlagRequired("name")
+	var rule FirewallRule
+	add := &cobra.Command{
+		Use:   "add <port>[/tcp|udp]",
+		Short: "Open a port on the proxy, to any IP unless --source is given",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			port, protocol, err := parsePortSpec(args[0])
+			if err != nil {
+				return err
+			}
+			rule.Port, rule.Protocol = port, protocol
+			return a.commander.AddFirewallRule(cmd.Context(), name, rule)
+		},
+	}
+	add.Flags().StringSliceVar(&rule.Sources, "source", nil, "Only allow this IP or CIDR, can be repeated (default any)")
+	add.Flags().StringVar(&rule.Comment, "comment", "", "Description shown in firewall list and the UFW rule names")
+	add.Flags().BoolVar(&rule.Limit, "limit", false, "Use UFW's rate limit, denying sources with more than 6 connections in 30 seconds")
+	cmd.AddCommand(audited(add))
+	cmd.AddCommand(audited(&cobra.Command{
+		Use:   "remove <port>[/tcp|udp]",
+		Short: "Close a port opened with firewall add",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			port, protocol, err := parsePortSpec(args[0])
+			if err != nil {
+				return err
+			}
+			return a.commander.RemoveFirewallRule(cmd.Context(), name, port, protocol)
+		},
+	}))
+	cmd.AddCommand(audited(&cobra.Command{
+		Use:   "rate-limit <connections per minute>",
+		Short: "Drop new connections to the proxy and user ports beyond this many per source per minute, 0 to remove the limit",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			limit, err := strconv.Atoi(args[0])
+			if err != nil {
+				return err
+			}
+			return a.commander.SetRateLimit(cmd.Context(), name, limit)
+		},
+	}))
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List every rule of the proxy, including its own ports",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.commander.FirewallRules(name)
+		},
+	})
+	return cmd
+}
+
+func (a *cliApp) userCommand() *cobra.Command {
+	var name string
+	cmd := &cobra.Command{
+		Use:   "user",
+		Short: "Manage additional Shadowsocks users of a proxy, each with its own port and password",
+	}
+	// expire 不指定 --name 時處理所有 proxy，其他子指令由 Commander 要求 --name
+	cmd.PersistentFlags().StringVar(&name, "name", "", "Name of the proxy (required except for expire)")
+	var ttl time.Duration
+	add := &cobra.Command{
+		Use:   "add <user>",
+		Short: "Add a user with a new port and password and print its client parameters",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.commander.AddUser(cmd.Context(), name, args[0], ttl)
+		},
+	}
+	add.Flags().DurationVar(&ttl, "ttl", 0, "Remove the user automatically after this long, e.g. 72h (default never)")
+	cmd.AddCommand(audited(add))
+	cmd.AddCommand(audited(&cobra.Command{
+		Use:   "remove <user>",
+		Short: "Remove a user, stopping its server and closing its port",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.commander.RemoveUser(cmd.Context(), name, args[0])
+		},
+	}))
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List the users of the proxy and their share URIs",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.commander.Users(name)
+		},
+	})
+	cmd.AddCommand(audited(&cobra.Command{
+		Use:   "expire",
+		Short: "Remove users whose --ttl has passed from the servers, on every proxy unless --name is given",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.commander.ExpireUsers(cmd.Context(), name)
+		},
+	}))
+	return cmd
+}
+
+func (a *cliApp) connectCommand() *cobra.Command {
+	var name string
+	var qr bool
+	cmd := &cobra.Command{
+		Use:   "connect",
+		Short: "Print the client parameters and config snippets of a proxy",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.commander.Connect(name, qr)
+		},
+	}
+	cmd.Flags().StringVar(&name, "name", "", "Name of the proxy to connect to")
+	cmd.Flags().BoolVar(&qr, "qr", false, "Also render the ss:// URI as a QR code for mobile clients")
+	cmd.MarkFlagRequired("name")
+	return cmd
+}
+
+func (a *cliApp) costCommand() *cobra.Command {
+	var billingTable string
+	cmd := &cobra.Command{
+		Use:   "cost",
+		Short: "Estimate what each proxy has cost since it was created",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.commander.Cost(cmd.Context(), billingTable)
+		},
+	}
+	cmd.Flags().StringVar(&billingTable, "billing-table", "", "Also show actual costs from a Cloud Billing export in BigQuery, as project.dataset.table (only proxies created with the "+billingLabel+" label)")
+	return cmd
+}
+
+func (a *cliApp) reapCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "reap",
+		Short: "Delete proxies whose create --ttl has passed, e.g. from cron",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.commander.Reap(cmd.Context())
+		},
+	}
+}
+
+func (a *cliApp) stopCommand() *cobra.Command {
+	var name string
+	cmd := &cobra.Command{
+		Use:   "stop",
+		Short: "Stop a proxy's instance to pause billing for CPU and memory, keeping its disk and configuration",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.commander.Stop(cmd.Context(), name)
+		},
+	}
+	cmd.Flags().StringVar(&name, "name", "", "Name of the proxy to stop")
+	cmd.MarkFlagRequired("name")
+	return cmd
+}
+
+func (a *cliApp) startCommand() *cobra.Command {
+	var name string
+	cmd := &cobra.Command{
+		Use:   "start",
+		Short: "Start a stopped proxy, updating the recorded IP if it changed",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.commander.Start(cmd.Context(), name)
+		},
+	}
+	cmd.Flags().StringVar(&name, "name", "", "Name of the proxy to start")
+	cmd.MarkFlagRequired("name")
+	return cmd
+}
+
+func (a *cliApp) sshCommand() *cobra.Command {
+	var name string
+	cmd := &cobra.Command{
+		Use:   "ssh [-- command...]",
+		Short: "Open an SSH session to a proxy with the deployment key, knocking first if it was created with --knock",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.commander.SSH(name, args)
+		},
+	}
+	cmd.Flags().StringVar(&name, "name", "", "Name of the proxy")
+	cmd.MarkFlagRequired("name")
+	return cmd
+}
+
+func (a *cliApp) shareCommand() *cobra.Command {
+	var name string
+	var qr bool
+	cmd := &cobra.Command{
+		Use:   "share",
+		Short: "Print ss:// share URIs of active proxies",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.commander.Share(name, qr)
+		},
+	}
+	cmd.Flags().StringVar(&name, "name", "", "Only print the URI of this proxy")
+	cmd.Flags().BoolVar(&qr, "qr", false, "Also render each URI as a QR code for mobile clients")
+	return cmd
+}
+
+func (a *cliApp) bestCommand() *cobra.Command {
+	var regions bool
+	cmd := &cobra.Command{
+		Use:   "best",
+		Short: "Rank proxies or regions by latency",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.commander.Best(cmd.Context(), regions)
+		},
+	}
+	cmd.Flags().BoolVar(&regions, "regions", false, "Rank regions instead of existing proxies")
+	return cmd
+}
+
+func (a *cliApp) recommendCommand() *cobra.Command {
+	var opts RecommendOptions
+	cmd := &cobra.Command{
+		Use:   "recommend",
+		Short: "Recommend the best region, zone and machine type by latency, free tier and price",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.commander.Recommend(cmd.Context(), opts)
+		},
+	}
+	flags := cmd.Flags()
+	flags.IntVar(&opts.Candidates, "candidates", 5, "Number of fastest regions to compare")
+	flags.DurationVar(&opts.LatencySlack, "latency-slack", 20*time.Millisecond, "Regions at most this much slower than the fastest are compared by free tier and price")
+	flags.BoolVar(&opts.Apply, "apply", false, "Create a proxy with the recommended placement without prompting")
+	flags.IntVar(&opts.Create.Count, "count", 1, "Number of proxies to create with --apply")
+	flags.IntVar(&opts.Create.Parallel, "parallel", 4, "Number of proxies to create concurrently when --count is greater than 1")
+	flags.StringVar(&opts.Create.OS, "os", "", "OS image of the proxy created with --apply: "+strings.Join(supportedOSImages(), ", ")+" (default ubuntu-2204)")
+	flags.StringArrayVar(&opts.Create.Notes, "note", nil, "Note to keep with the created proxy (repeatable)")
+	flags.StringArrayVar(&opts.Create.Tags, "tag", nil, "Tag the created proxy with key=value, also applied as a GCP label (repeatable)")
+	flags.BoolVar(&opts.Create.Randomize, "randomize", false, "Pick a random high port and AEAD cipher for the created proxies")
+	flags.BoolVar(&opts.Create.AllowMyIP, "allow-my-ip", false, "Only allow connections to the created proxies from your current public IP")
+	return cmd
+}
+
+func (a *cliApp) rolloutCommand() *cobra.Command {
+	var canary int
+	cmd := &cobra.Command{
+		Use:   "rollout",
+		Short: "Redeploy the proxy configuration to every managed proxy",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.commander.Rollout(cmd.Context(), canary)
+		},
+	}
+	cmd.Flags().IntVar(&canary, "canary", 1, "Number of proxies to deploy and verify before the rest")
+	return cmd
+}
+
+func (a *cliApp) resumeCommand() *cobra.Command {
+	var name string
+	cmd := &cobra.Command{
+		Use:   "resume",
+		Short: "Retry the deployment of a provisioning or failed proxy",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.commander.Resume(cmd.Context(), name)
+		},
+	}
+	cmd.Flags().StringVar(&name, "name", "", "Name of the proxy to resume")
+	cmd.MarkFlagRequired("name")
+	return cmd
+}
+
+func (a *cliApp) inviteCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "invite",
+		Short: "Manage invite codes for the subscription server",
+	}
+
+	var scope []string
+	var quota int
+	var ttl time.Duration
+	create := &cobra.Command{
+		Use:   "create",
+		Short: "Create an invite code",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.commander.InviteCreate(scope, quota, ttl)
+		},
+	}
+	create.Flags().StringSliceVar(&scope, "scope", nil, "Comma-separated proxy names the invite can access (default all)")
+	create.Flags().IntVar(&quota, "quota", 1, "Number of times the invite can be redeemed")
+	create.Flags().DurationVar(&ttl, "ttl", 72*time.Hour, "How long the invite and its access keys stay valid")
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List invite codes",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.commander.InviteList()
+		},
+	}
+
+	var code string
+	revoke := &cobra.Command{
+		Use:   "revoke",
+		Short: "Revoke an invite code and its access keys",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.commander.InviteRevoke(code)
+		},
+	}
+	revoke.Flags().StringVar(&code, "code", "", "Invite code to revoke")
+	revoke.MarkFlagRequired("code")
+
+	var key, baseURL string
+	var linkTTL time.Duration
+	link := &cobra.Command{
+		Use:   "link",
+		Short: "Print a signed, time-limited subscription URL for an access key",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.commander.InviteLink(key, baseURL, linkTTL)
+		},
+	}
+	link.Flags().StringVar(&key, "key", "", "Access key to create the link for")
+	link.Flags().StringVar(&baseURL, "base-url", "", "Public URL of the subscription server, e.g. https://sub.example.com")
+	link.Flags().DurationVar(&linkTTL, "ttl", 24*time.Hour, "How long the link stays valid")
+	link.MarkFlagRequired("key")
+	link.MarkFlagRequired("base-url")
+
+	cmd.AddCommand(audited(create), list, audited(revoke), link)
+	return cmd
+}
+
+func (a *cliApp) imageCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "image",
+		Short: "Manage prebaked proxy images",
+	}
+
+	var proxy, buildImage string
+	build := &cobra.Command{
+		Use:   "build",
+		Short: "Build an image from an existing proxy",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.commander.ImageBuild(cmd.Context(), proxy, buildImage)
+		},
+	}
+	build.Flags().StringVar(&proxy, "name", "", "Name of the proxy to build the image from")
+	build.Flags().StringVar(&buildImage, "image", "", "Name of the image")
+	build.MarkFlagRequired("name")
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List prebaked images",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.commander.ImageList(cmd.Context())
+		},
+	}
+
+	var deleteImage string
+	del := &cobra.Command{
+		Use:   "delete",
+		Short: "Delete a prebaked image",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.commander.ImageDelete(cmd.Context(), deleteImage)
+		},
+	}
+	del.Flags().StringVar(&deleteImage, "image", "", "Name of the image")
+	del.MarkFlagRequired("image")
+
+	cmd.AddCommand(audited(build), list, audited(del))
+	return cmd
+}
+
+func (a *cliApp) bakeCommand() *cobra.Command {
+	var zone, machineType, protocol, image string
+	var locations []string
+	cmd := &cobra.Command{
+		Use:   "bake",
+		Short: "Build a prebaked image on a temporary instance",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.commander.Bake(cmd.Context(), zone, machineType, protocol, image, locations)
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&zone, "zone", "", "Zone to run the build instance in")
+	flags.StringVar(&machineType, "type", "", "Machine type of the build instance (default recommended type)")
+	flags.StringVar(&protocol, "protocol", "shadowsocks", "Proxy protocol to preinstall")
+	flags.StringVar(&image, "image", "", "Name of the image")
+	flags.StringSliceVar(&locations, "locations", nil, "Comma-separated regions or multi-regions to store the image in, e.g. asia,us")
+	cmd.MarkFlagRequired("zone")
+	return cmd
+}
+
+func (a *cliApp) ipCommand() *cobra.Command {
+	var name string
+	cmd := &cobra.Command{
+		Use:   "ip",
+		Short: "Show the public IP of this machine and optionally a proxy's exit IP",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.commander.IP(cmd.Context(), name)
+		},
+	}
+	cmd.Flags().StringVar(&name, "name", "", "Also show the exit IP of this proxy")
+	return cmd
+}
+
+func (a *cliApp) importCommand() *cobra.Command {
+	var file string
+	var skipCheck bool
+	cmd := &cobra.Command{
+		Use:   "import",
+		Short: "Import servers from Outline, Clash or ss:// subscriptions",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.commander.Import(cmd.Context(), file, skipCheck)
+		},
+	}
+	cmd.Flags().StringVar(&file, "file", "", "Outline Manager export, Clash config or ss:// subscription to import")
+	cmd.Flags().BoolVar(&skipCheck, "skip-check", false, "Import servers without checking that they are reachable")
+	cmd.MarkFlagRequired("file")
+	return cmd
+}
+
+func (a *cliApp) graphCommand() *cobra.Command {
+	var format string
+	cmd := &cobra.Command{
+		Use:   "graph",
+		Short: "Export the fleet topology as a graph",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.commander.Graph(format)
+		},
+	}
+	cmd.Flags().StringVar(&format, "format", "dot", "Output format: dot or mermaid")
+	return cmd
+}
+
+func (a *cliApp) exportCommand() *cobra.Command {
+	var format, policy string
+	var tags []string
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export a client config with all active proxies and routing rules",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.commander.Export(format, policy, tags)
+		},
+	}
+	cmd.Flags().StringArrayVar(&tags, "tag", nil, "Only export proxies with this tag, as key=value or key (repeatable, all must match)")
+	cmd.Flags().StringVar(&format, "format", "clash", "Client config format: clash, sing-box, surge or quantumult-x")
+	cmd.Flags().StringVar(&policy, "policy", "default", "Egress policy: default (CN direct), none, or a JSON policy file")
+	return cmd
+}
+
+func (a *cliApp) stateCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "state",
+		Short: "Manage the local state",
+	}
+	var rotateTokens bool
+	rekey := &cobra.Command{
+		Use:   "rekey",
+		Short: "Re-encrypt stored secrets under a new passphrase, or a new random key when no passphrase is set",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.commander.StateRekey(rotateTokens)
+		},
+	}
+	rekey.Flags().BoolVar(&rotateTokens, "rotate-tokens", false, "Also replace the serve token, password and signing key stored in the OS keyring and revoke all invites and access keys")
+	cmd.AddCommand(audited(rekey))
+	cmd.AddCommand(&cobra.Command{
+		Use:   "export",
+		Short: "Write the config, proxy records and invites as a JSON backup to stdout",
+		Long:  "Write the config, proxy records and invites as a JSON backup to stdout, e.g. auto_proxy state export > backup.json.\nPasswords are decrypted so the backup can be imported on another machine; keep the file safe.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.commander.StateExport(os.Stdout)
+		},
+	})
+	var replace bool
+	importCmd := &cobra.Command{
+		Use:         "import <file|->",
+		Short:       "Restore a backup written by state export",
+		Long:        "Restore a backup written by state export. Existing config and records with the same name are kept unless --replace is given.",
+		Args:        cobra.ExactArgs(1),
+		Annotations: map[string]string{annotationStandalone: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return StateImport(args[0], replace, a.dryRun)
+		},
+	}
+	importCmd.Flags().BoolVar(&replace, "replace", false, "Overwrite the existing config, records and invites with the backup")
+	cmd.AddCommand(importCmd)
+	cmd.AddCommand(&cobra.Command{
+		Use:   "history [name]",
+		Short: "Show how proxy records changed over time (sqlite storage only)",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := ""
+			if len(args) == 1 {
+				name = args[0]
+			}
+			return a.commander.StateHistory(name)
+		},
+	})
+	return cmd
+}
+
+func (a *cliApp) historyCommand() *cobra.Command {
+	var limit int
+	cmd := &cobra.Command{
+		Use:   "history [name]",
+		Short: "Show the audit log of operations, optionally only those on one proxy",
+		Long:  "Every create, delete, rename, resume, rollout, sync, prune, import, invite, image and rekey run is recorded with its time, user, parameters and outcome.",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := ""
+			if len(args) == 1 {
+				name = args[0]
+			}
+			return a.commander.AuditHistory(name, limit)
+		},
+	}
+	cmd.Flags().IntVar(&limit, "limit", 50, "Show at most this many of the latest operations (0 for all)")
+	return cmd
+}
+
+func (a *cliApp) configCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Manage ~/.auto_proxy/config.yaml",
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:         "init",
+		Short:       "Create or update the config file interactively",
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{annotationStandalone: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return ConfigInit()
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:         "get [key]",
+		Short:       "Print a config value, or the whole config without a key",
+		Args:        cobra.MaximumNArgs(1),
+		Annotations: map[string]string{annotationStandalone: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key := ""
+			if len(args) == 1 {
+				key = args[0]
+			}
+			return ConfigGet(key)
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:         "set <key> <value>",
+		Short:       "Set a config value, e.g. `config set defaults.region asia-east1`",
+		Args:        cobra.ExactArgs(2),
+		Annotations: map[string]string{annotationStandalone: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return ConfigSet(args[0], args[1])
+		},
+	})
+	return cmd
+}
+
+func (a *cliApp) secretCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "secret",
+		Short: "Keep passphrases, serve credentials and the GCP key in the OS keyring instead of environment variables and files",
+	}
+	var fromFile string
+	set := &cobra.Command{
+		Use:         "set <name>",
+		Short:       "Store a secret in the OS keyring, read from the terminal, stdin or --from-file",
+		Long:        "Store a secret in the OS keyring, read from the terminal, stdin or --from-file.\nNames: " + strings.Join(keyringEnvSecrets, ", ") + ", or gcp-credentials[:<name>] with --from-file <key.json> used by gcp.credentials keyring[:<name>].\nEnvironment variables still take precedence over the keyring.",
+		Args:        cobra.ExactArgs(1),
+		Annotations: map[string]string{annotationStandalone: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return SecretSet(args[0], fromFile)
+		},
+	}
+	set.Flags().StringVar(&fromFile, "from-file", "", "Read the secret from this file, e.g. a service account key")
+	cmd.AddCommand(set)
+	cmd.AddCommand(&cobra.Command{
+		Use:         "delete <name>",
+		Short:       "Delete a secret from the OS keyring",
+		Args:        cobra.ExactArgs(1),
+		Annotations: map[string]string{annotationStandalone: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return SecretDelete(args[0])
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:         "list",
+		Short:       "Show where each secret is read from, without printing values",
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{annotationStandalone: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			if err := cfg.useProfile(a.profile); err != nil {
+				return err
+			}
+			return SecretList(cfg.GCP.Credentials)
+		},
+	})
+	return cmd
+}
+
+func (a *cliApp) serveCommand() *cobra.Command {
+	var addr string
+	var auth []string
+	var mtls bool
+	var expireInterval, reapInterval time.Duration
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Run the invite and subscription server",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			caDir := ""
+			if mtls {
+				var err error
+				if caDir, err = caDirectory(a.config); err != nil {
+					return err
+				}
+			}
+			return a.commander.Serve(cmd.Context(), addr, auth, caDir, expireInterval, reapInterval)
+		},
+	}
+	cmd.Flags().StringVar(&addr, "addr", ":8080", "Address for the subscription server to listen on")
+	cmd.Flags().StringSliceVar(&auth, "auth", nil, "Comma-separated auth schemes any of which grants access: token, basic, signed (default none)")
+	cmd.Flags().BoolVar(&mtls, "mtls", false, "Serve HTTPS and require a client certificate issued by `ca issue`, in addition to --auth")
+	cmd.Flags().DurationVar(&expireInterval, "expire-interval", 0, "Remove proxy users whose --ttl has passed this often, e.g. 10m (default off)")
+	cmd.Flags().DurationVar(&reapInterval, "reap-interval", 0, "Delete proxies whose create --ttl has passed this often, e.g. 10m (default off)")
+	return cmd
+}
+
+func (a *cliApp) caCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ca",
+		Short: "Manage the certificate authority that protects serve --mtls",
+	}
+	// CA 放在資料目錄下，與 serve 讀取紀錄的位置相同
+	caDir := func() (string, error) {
+		cfg, err := loadConfig()
+		if err != nil {
+			return "", err
+		}
+		return caDirectory(cfg)
+	}
+	var hosts []string
+	var force bool
+	initCmd := &cobra.Command{
+		Use:         "init",
+		Short:       "Create the CA and the server certificate used by serve --mtls",
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{annotationStandalone: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir, err := caDir()
+			if err != nil {
+				return err
+			}
+			return CAInit(dir, hosts, force)
+		},
+	}
+	initCmd.Flags().StringSliceVar(&hosts, "host", nil, "Host names and IPs clients use to reach serve (default localhost and this host name)")
+	initCmd.Flags().BoolVar(&force, "force", false, "Replace an existing CA, invalidating every client certificate it issued")
+	cmd.AddCommand(initCmd)
+
+	var serverHosts []string
+	serverCmd := &cobra.Command{
+		Use:         "server",
+		Short:       "Issue a new server certificate, e.g. before it expires or when the host name changes",
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{annotationStandalone: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir, err := caDir()
+			if err != nil {
+				return err
+			}
+			return CAServer(dir, serverHosts)
+		},
+	}
+	serverCmd.Flags().StringSliceVar(&serverHosts, "host", nil, "Host names and IPs clients use to reach serve (default localhost and this host name)")
+	cmd.AddCommand(serverCmd)
+
+	var out string
+	var days int
+	issueCmd := &cobra.Command{
+		Use:         "issue <client>",
+		Short:       "Issue a client certificate for remote access to serve --mtls",
+		Args:        cobra.ExactArgs(1),
+		Annotations: map[string]string{annotationStandalone: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir, err := caDir()
+			if err != nil {
+				return err
+			}
+			return CAIssue(dir, args[0], out, time.Duration(days)*24*time.Hour)
+		},
+	}
+	issueCmd.Flags().StringVar(&out, "out", ".", "Directory to write <client>.pem and <client>-key.pem to")
+	issueCmd.Flags().IntVar(&days, "days", 365, "Days the client certificate stays valid")
+	cmd.AddCommand(issueCmd)
+	return cmd
+}
+
+// 舊版使用 Go flag 的 -name 寫法，轉成 --name 讓既有的腳本可以繼續使用
+// 只轉換有註冊為長參數的名稱，-vv 這類疊在一起的短參數保持不變
+var legacyFlag = regexp.MustCompile(`^-([a-z][a-z-]+)(=.*)?$`)
+
+func normalizeLegacyFlags(root *cobra.Command, args []string) []string {
+	long := longFlagNames(root)
+	normalized := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "--" {
+			copy(normalized[i:], args[i:])
+			break
+		}
+		if m := legacyFlag.FindStringSubmatch(arg); m != nil && long[m[1]] {
+			arg = "-" + arg
+		}
+		normalized[i] = arg
+	}
+	return normalized
+}
+
+// longFlagNames 回傳 cmd 與所有子指令註冊的長參數名稱
+func longFlagNames(cmd *cobra.Command) map[string]bool {
+	names := make(map[string]bool)
+	var walk func(*cobra.Command)
+	walk = func(c *cobra.Command) {
+		add := func(f *pflag.Flag) { names[f.Name] = true }
+		c.Flags().VisitAll(add)
+		c.PersistentFlags().VisitAll(add)
+		for _, sub := range c.Commands() {
+			walk(sub)
+		}
+	}
+	walk(cmd)
+	return names
+}
@@ -1,6 +1,9 @@
 package main
 
-import "context"
+import (
+	"context"
+	"errors"
+)
 
 // CloudProvider 定義雲服務提供者的抽象接口
 
@@ -9,13 +12,105 @@ type CloudProvider interface {
 	ListZones(ctx context.Context, region string) ([]string, error)
 	ListMachineTypes(ctx context.Context, zone string) ([]string, error)
 	RecommendedType() string
-	CreateInstance(ctx context.Context, name, zone, machineType string) (string, string, error) // 返回 instanceID 和 ip
+	FreeTier(region, machineType string) bool                                            // 是否符合免費方案的條件
+	MachineArch(machineType string) string                                               // 回傳 ArchAMD64 或 ArchARM64
+	CreateInstance(ctx context.Context, spec InstanceSpec) (string, InstanceInfo, error) // 返回 instanceID 和 ip、開機磁碟
 	DeleteInstance(ctx context.Context, zone, instanceID string) error
+	StopInstance(ctx context.Context, zone, instanceID string) error  // 停止計費的 CPU 與記憶體，保留開機磁碟
+	StartInstance(ctx context.Context, zone, instanceID string) error // 啟動後以 GetInstanceInfo 取得新的 IP
 	DeleteDisk(ctx context.Context, zone, diskID string) error
 	GetInstanceInfo(ctx context.Context, zone, instanceID string) (InstanceInfo, error)
+	CreateImage(ctx context.Context, spec ImageSpec) error
+	ListImages(ctx context.Context, arch string) ([]string, error)
+	DeleteImage(ctx context.Context, name string) error
+	TunnelCommand(zone, instanceID string) (string, error)                                            // 回傳經由 provider 通道 (例如 IAP) 連線 SSH 的 ProxyCommand
+	EnsureFirewall(ctx context.Context, template FirewallTemplate) error                              // 依 template 建立或更新雲端防火牆規則
+	MachinePrices(ctx context.Context, zone string) (map[string]MachinePrice, error)                  // 預估的機器類型價格，key 為機器類型
+	BilledCosts(ctx context.Context, table, idLabel, nameLabel string) (map[string]BilledCost, error) // 帳單匯出資料中目前 project 帶有 idLabel 的資源的實際費用，key 為 idLabel 的值
+	SetInstanceLabels(ctx context.Context, zone, instanceID string, labels map[string]string) error   // 合併到 instance 既有的 labels
+	SetNetworkTags(ctx context.Context, zone, instanceID string, tags []string) error                 // 取代 instance 上 auto_proxy 的網路標記，保留其他標記
+	ListInstances(ctx context.Context, label string) ([]CloudInstance, error)                         // 列出所有 zone 中帶有 label 的 instance
+	ListResources(ctx context.Context, label string) ([]CloudResource, error)                         // 列出 auto_proxy 建立的磁碟、靜態 IP 與防火牆規則
+	DeleteResource(ctx context.Context, resource CloudResource) error
+	MissingPermissions(ctx context.Context, permissions []string) ([]string, error) // 回傳目前憑證在專案中沒有的 IAM 權限，不會修改任何資源
+}
+
+// ImageSpec 從磁碟建立映像檔的參數，Locations 為空時由 provider 決定存放位置
+type ImageSpec struct {
+	Name      string
+	Zone      string
+	DiskID    string
+	Locations []string
+	Protocol  string
+	Arch      string
+}
+
+// CPU 架構
+const (
+	ArchAMD64 = "amd64"
+	ArchARM64 = "arm64"
+)
+
+// InstanceSpec 建立 instance 需要的參數，Image 為空時使用 provider 預設的映像檔
+type InstanceSpec struct {
+	Name        string
+	Zone        string
+	MachineType string
+	Image       string
+	Arch        string
+	Metadata    map[string]string
+	PrivateOnly bool     // 不配置外部 IP，回傳內部 IP
+	NetworkTags []string // 雲端防火牆規則以網路標記選擇 instance
+	Labels      map[string]string
+}
+
+// MachinePrice 機器類型的預估價格，只包含 CPU 與記憶體
+type MachinePrice struct {
+	Hourly   float64 `json:"hourly"`
+	Currency string  `json:"currency"`
+}
+
+// BilledCost 帳單中實際產生的費用，已扣除折扣與免費額度
+type BilledCost struct {
+	Name     string  `json:"name,omitempty"` // 最後一筆帳單上的 proxy 名稱
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency"`
 }
 
 type InstanceInfo struct {
-	IP         string
+	IP     string
 	DiskID string
-}
\ No newline at end of file
+	Status string // provider 回報的狀態，例如 RUNNING、STOPPED
+}
+
+// CloudInstance 雲端上實際存在的 instance
+type CloudInstance struct {
+	Name   string
+	Zone   string
+	IP     string
+	Status string
+	Labels map[string]string
+	Tags   []string // 網路標記
+}
+
+// ListResources 回傳的資源種類
+const (
+	ResourceDisk     = "disk"
+	ResourceAddress  = "address"
+	ResourceFirewall = "firewall"
+)
+
+// CloudResource instance 以外由 auto_proxy 建立的雲端資源，gc 以此找出沒有被使用的資源
+type CloudResource struct {
+	Kind     string
+	Name     string
+	Location string // 磁碟的 zone 或靜態 IP 的 region，防火牆規則為空
+	InUse    bool   // 磁碟已掛載或靜態 IP 已配置，防火牆規則由呼叫端以網路標記判斷
+	Tag      string // 防火牆規則的目標網路標記
+}
+
+// ErrInstanceNotFound instance 已經不存在於雲端
+var ErrInstanceNotFound = errors.New("instance not found")
+
+// ErrDiskNotFound 磁碟已經不存在於雲端
+var ErrDiskNotFound = errors.New("disk not found")
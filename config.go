@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Config ~/.auto_proxy/config.yaml 的內容，密碼短語與訂閱伺服器的密鑰仍然只從環境變數讀取，不寫進設定檔
+type Config struct {
+	GCP GCPConfig `yaml:"gcp"`
+	// Profiles 其他 GCP 專案的連線設定，以 --profile 或 AUTO_PROXY_PROFILE 選擇，空白的欄位沿用 gcp 的值
+	Profiles map[string]GCPConfig `yaml:"profiles,omitempty"`
+	// Defaults 精靈中預先選好的選項
+	Defaults struct {
+		Region      string `yaml:"region"`
+		Zone        string `yaml:"zone"`
+		MachineType string `yaml:"machine_type"`
+		OS          string `yaml:"os"`
+	} `yaml:"defaults"`
+	SSH struct {
+		User    string `yaml:"user"`
+		KeyPath string `yaml:"key_path"`
+		Port    int    `yaml:"port"` // 新建 proxy 的 sshd 連接埠，建立時記錄在紀錄中
+	} `yaml:"ssh"`
+	// Shadowsocks 新建 proxy 使用的連線參數，建立後記錄在各自的紀錄中，修改不影響既有的 proxy
+	Shadowsocks struct {
+		Port     int    `yaml:"port"`
+		Method   string `yaml:"method"`
+		Password string `yaml:"password"` // 空值時每台 proxy 產生隨機密碼
+		// RateLimit 每個來源每分鐘可以建立的新連線數，0 表示不限制，建立後以 firewall rate-limit 修改
+		RateLimit int `yaml:"rate_limit"`
+	} `yaml:"shadowsocks"`
+	ProbeTargetsFile string `yaml:"probe_targets_file"`
+	MetadataFile     string `yaml:"metadata_file"`
+	HooksDir         string `yaml:"hooks_dir"`
+	Timezone         string `yaml:"timezone"` // IANA 名稱，例如 Asia/Taipei
+	Events           string `yaml:"events"`   // 部署進度格式：text 或 json
+	Lang             string `yaml:"lang"`
+	LogFile          string `yaml:"log_file"` // 錯誤與除錯記錄，空值表示不寫入檔案
+	Storage          string `yaml:"storage"`  // 紀錄的儲存方式：json、sqlite 或 gcs
+	// StorageBucket storage 為 gcs 時存放紀錄的 bucket，可以加上路徑前綴，例如 my-bucket/auto_proxy
+	StorageBucket string `yaml:"storage_bucket"`
+	// DataDir 紀錄、邀請與記錄檔所在的目錄，空值為 os.UserConfigDir()/auto_proxy，其他檔案的相對路徑以此為準
+	DataDir string `yaml:"data_dir"`
+	// Secrets 新建 proxy 的密碼存放位置，外部服務時紀錄只保留參照
+	// vault 的位址與 token 取自 VAULT_ADDR 與 VAULT_TOKEN
+	Secrets struct {
+		Backend    string `yaml:"backend"`     // records、keyring、gcp-secret-manager 或 vault
+		VaultMount string `yaml:"vault_mount"` // Vault KV v2 的掛載點
+	} `yaml:"secrets"`
+	// Hardening 部署時額外的主機強化步驟，例如 hardening.fail2ban、hardening.disable_password_auth
+	Hardening HardeningConfig `yaml:"hardening"`
+
+	profile string // 目前使用的 profile，空值表示 gcp
+}
+
+// GCPConfig 一個 GCP 專案的連線設定
+type GCPConfig struct {
+	ProjectID   string `yaml:"project_id"`
+	Credentials string `yaml:"credentials"` // 服務帳戶金鑰檔的路徑，keyring 或 keyring:<name> 表示存在 OS keyring，空白時使用 ADC
+}
+
+// useProfile 以 profile 的連線設定取代 gcp，name 為空時不變
+func (c *Config) useProfile(name string) error {
+	if name == "" {
+		return nil
+	}
+	profile, ok := c.Profiles[name]
+	if !ok {
+		names := make([]string, 0, len(c.Profiles))
+		for n := range c.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown profile %q (configured: %s)", name, strings.Join(names, ", "))
+	}
+	if profile.ProjectID != "" {
+		c.GCP.ProjectID = profile.ProjectID
+	}
+	if profile.Credentials != "" {
+		c.GCP.Credentials = profile.Credentials
+	}
+	c.profile = name
+	return nil
+}
+
+func defaultConfig() *Config {
+	cfg := &Config{}
+	cfg.Shadowsocks.Port = shadowsocksPort
+	cfg.Shadowsocks.Method = shadowsocksMethod
+	cfg.SSH.Port = defaultSSHPort
+	cfg.Secrets.Backend = SecretsRecords
+	cfg.Secrets.VaultMount = "secret"
+	cfg.ProbeTargetsFile = "probe_targets.json"
+	cfg.MetadataFile = "instance_metadata.json"
+	cfg.LogFile = "proxy_error.log"
+	cfg.Storage = StorageJSON
+	return cfg
+}
+
+// configPath 回傳設定檔位置，可以用 AUTO_PROXY_CONFIG 指定其他檔案
+func configPath() (string, error) {
+	if path := os.Getenv("AUTO_PROXY_CONFIG"); path != "" {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate home directory: %v", err)
+	}
+	return filepath.Join(home, ".auto_proxy", "config.yaml"), nil
+}
+
+// LoadConfig 讀取設定檔，檔案不存在時回傳預設值
+func LoadConfig(path string) (*Config, error) {
+	cfg := defaultConfig()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %v", err)
+	}
+	if cfg, err = decodeConfig(data); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	if password := cfg.Shadowsocks.Password; password != shadowsocksPassword {
+		registerSecret(password)
+	}
+	return cfg, nil
+}
+
+func (c *Config) Save(path string) error {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create config directory: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write config: %v", err)
+	}
+	return nil
+}
+
+// validate 檢查建立雲端連線與部署需要的設定
+func (c *Config) validate() error {
+	required := []struct{ key, value string }{
+		{"gcp.project_id", c.GCP.ProjectID},
+		{"ssh.user", c.SSH.User},
+		{"ssh.key_path", c.SSH.KeyPath},
+	}
+	var missing []string
+	for _, r := range required {
+		if r.value == "" {
+			missing = append(missing, r.key)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%s not set, run `auto_proxy config init` or `auto_proxy config set <key> <value>`", strings.Join(missing, ", "))
+	}
+	if c.Shadowsocks.Port <= 0 || c.Shadowsocks.Port > 65535 {
+		return fmt.Errorf("invalid shadowsocks.port: %d", c.Shadowsocks.Port)
+	}
+	if c.Shadowsocks.RateLimit < 0 {
+		return fmt.Errorf("invalid shadowsocks.rate_limit: %d", c.Shadowsocks.RateLimit)
+	}
+	if c.SSH.Port <= 0 || c.SSH.Port > 65535 {
+		return fmt.Errorf("invalid ssh.port: %d", c.SSH.Port)
+	}
+	return nil
+}
+
+// Get 以 gcp.project_id 這類以點分隔的 key 讀取設定值
+func (c *Config) Get(key string) (string, error) {
+	tree, err := c.tree()
+	if err != nil {
+		return "", err
+	}
+	node := any(tree)
+	for _, part := range strings.Split(key, ".") {
+		m, ok := node.(map[string]any)
+		if !ok {
+			return "", fmt.Errorf("unknown config key: %s", key)
+		}
+		if node, ok = m[part]; !ok {
+			return "", fmt.Errorf("unknown config key: %s", key)
+		}
+	}
+	if _, ok := node.(map[string]any); ok {
+		data, err := yaml.Marshal(node)
+		return strings.TrimSpace(string(data)), err
+	}
+	if node == nil {
+		return "", nil
+	}
+	return fmt.Sprint(node), nil
+}
+
+// Set 設定一個值，value 依欄位型別解析，未知的 key 會回傳錯誤，
+// profiles.<名稱>.<欄位> 的 profile 不存在時會新增
+func (c *Config) Set(key, value string) error {
+	known := key
+	if parts := strings.Split(key, "."); len(parts) == 3 && parts[0] == "profiles" {
+		known = "gcp." + parts[2]
+	}
+	if _, err := c.Get(known); err != nil {
+		return fmt.Errorf("unknown config key: %s", key)
+	}
+	tree, err := c.tree()
+	if err != nil {
+		return err
+	}
+	parts := strings.Split(key, ".")
+	m := tree
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := m[part].(map[string]any)
+		if !ok {
+			next = make(map[string]any)
+			m[part] = next
+		}
+		m = next
+	}
+	// 值依 YAML 解析成數字或布林，欄位是字串時改用原始字串
+	var typed any
+	if err := yaml.Unmarshal([]byte(value), &typed); err != nil || typed == nil {
+		typed = value
+	}
+	var updated *Config
+	for _, v := range []any{typed, value} {
+		m[parts[len(parts)-1]] = v
+		data, err := yaml.Marshal(tree)
+		if err != nil {
+			return err
+		}
+		if updated, err = decodeConfig(data); err == nil {
+			break
+		}
+	}
+	if updated == nil {
+		return fmt.Errorf("invalid value for %s", key)
+	}
+	*c = *updated
+	return nil
+}
+
+// tree 將設定轉成巢狀 map，用於以 key 讀寫
+func (c *Config) tree() (map[string]any, error) {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	tree := make(map[string]any)
+	if err := yaml.Unmarshal(data, &tree); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// decodeConfig 解析 YAML 並拒絕未知的欄位，沒有出現的欄位保留預設值
+func decodeConfig(data []byte) (*Config, error) {
+	cfg := defaultConfig()
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadConfig 讀取預設位置的設定檔
+func loadConfig() (*Config, error) {
+	path, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+	return LoadConfig(path)
+}
+
+// ConfigInit 互動建立設定檔，已有設定檔時以現有的值作為預設
+// 沒有設定檔但目前目錄有舊版的 .env 時，從 .env 帶入設定
+func ConfigInit() error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		if env, err := godotenv.Read(".env"); err == nil {
+			fmt.Println(tr("Importing settings from .env"))
+			cfg.GCP.ProjectID = env["GOOGLE_PROJECT_ID"]
+			cfg.GCP.Credentials = env["GOOGLE_APPLICATION_CREDENTIALS"]
+			cfg.SSH.User = env["ANSIBLE_SSH_USER"]
+			cfg.SSH.KeyPath = env["ANSIBLE_SSH_KEY_PATH"]
+			if file := env["PROBE_TARGETS_FILE"]; file != "" {
+				cfg.ProbeTargetsFile = file
+			}
+			if file := env["AUTO_PROXY_METADATA_FILE"]; file != "" {
+				cfg.MetadataFile = file
+			}
+			cfg.HooksDir = env["AUTO_PROXY_HOOKS_DIR"]
+			cfg.Timezone = env["AUTO_PROXY_TIMEZONE"]
+			cfg.Events = env["AUTO_PROXY_EVENTS"]
+			cfg.Lang = env["AUTO_PROXY_LANG"]
+		}
+	}
+
+	questions := []*survey.Question{
+		{Name: "project", Prompt: &survey.Input{Message: tr("GCP project ID:"), Default: cfg.GCP.ProjectID}, Validate: survey.Required},
+		{Name: "credentials", Prompt: &survey.Input{Message: tr("Path of the service account key file:"), Default: cfg.GCP.Credentials, Help: tr("Leave empty to use gcloud application default credentials")}},
+		{Name: "user", Prompt: &survey.Input{Message: tr("SSH user for deployment:"), Default: cfg.SSH.User}, Validate: survey.Required},
+		{Name: "key", Prompt: &survey.Input{Message: tr("Path of the SSH private key:"), Default: cfg.SSH.KeyPath}, Validate: survey.Required},
+		{Name: "region", Prompt: &survey.Input{Message: tr("Default region (optional):"), Default: cfg.Defaults.Region}},
+	}
+	answers := struct {
+		Project     string `survey:"project"`
+		Credentials string `survey:"credentials"`
+		User        string `survey:"user"`
+		Key         string `survey:"key"`
+		Region      string `survey:"region"`
+	}{}
+	if err := survey.Ask(questions, &answers); err != nil {
+		return err
+	}
+	cfg.GCP.ProjectID, cfg.GCP.Credentials = answers.Project, answers.Credentials
+	cfg.SSH.User, cfg.SSH.KeyPath = answers.User, answers.Key
+	cfg.Defaults.Region = answers.Region
+	if err := cfg.Save(path); err != nil {
+		return err
+	}
+	fmt.Printf(tr("Saved %s, use `auto_proxy config set` to change other settings.\n"), path)
+	return nil
+}
+
+// ConfigGet 輸出一個設定值，key 為空時輸出整份設定
+func ConfigGet(key string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if key == "" {
+		data, err := yaml.Marshal(cfg)
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(data))
+		return nil
+	}
+	value, err := cfg.Get(key)
+	if err != nil {
+		return err
+	}
+	fmt.Println(value)
+	return nil
+}
+
+func ConfigSet(key, value string) error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		return err
+	}
+	if err := cfg.Set(key, value); err != nil {
+		return err
+	}
+	return cfg.Save(path)
+}
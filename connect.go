@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// connectView 客戶端連線需要的完整參數
+type connectView struct {
+	Name     string `json:"name" yaml:"name"`
+	Protocol string `json:"protocol" yaml:"protocol"`
+	Host     string `json:"host" yaml:"host"`
+	Port     int    `json:"port" yaml:"port"`
+	Password string `json:"password" yaml:"password"`
+	Method   string `json:"method" yaml:"method"`
+	ShareURI string `json:"share_uri" yaml:"share_uri"`
+}
+
+// Connect 印出 proxy 的連線參數與可以直接貼到客戶端的設定片段，qr 為 true 時附上分享連結的 QR code
+func (c *Commander) Connect(name string, qr bool) error {
+	records, err := c.recordManager.Load()
+	if err != nil {
+		return fmt.Errorf("error loading records: %v", err)
+	}
+	for _, r := range records {
+		if r.Name != name || r.Type != "instance" {
+			continue
+		}
+		if !r.Ready() {
+			return fmt.Errorf("proxy %s is not deployed yet, run `auto_proxy resume --name %s` first", name, name)
+		}
+		port, method, password := r.Endpoint()
+		view := connectView{Name: r.Name, Protocol: r.ProxyProtocol(), Host: r.IP, Port: port, Password: password, Method: method, ShareURI: r.ShareURI()}
+		if c.machineOutput() {
+			return c.render(view, nil)
+		}
+		if err := writeConnectInfo(os.Stdout, view); err != nil {
+			return err
+		}
+		if qr {
+			code, err := terminalQR(view.ShareURI)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("\n%s", code)
+		}
+		return nil
+	}
+	return fmt.Errorf("proxy not found: %s", name)
+}
+
+func writeConnectInfo(w io.Writer, v connectView) error {
+	fmt.Fprintf(w, tr("Proxy %s\n - Protocol: Shadowsocks\n - Host: %s\n - Port: %d\n - Password: %s\n - Encryption: %s\n"), v.Name, v.Host, v.Port, v.Password, v.Method)
+
+	fmt.Fprintf(w, tr(" - Share URI: %s\n"), v.ShareURI)
+
+	// shadowsocks-libev / shadowsocks-rust 的 ss-local 設定檔
+	ssLocal, err := json.MarshalIndent(struct {
+		Server     string `json:"server"`
+		ServerPort int    `json:"server_port"`
+		Password   string `json:"password"`
+		Method     string `json:"method"`
+		LocalPort  int    `json:"local_port"`
+	}{v.Host, v.Port, v.Password, v.Method, 1080}, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\n# ss-local (config.json)\n%s\n", ssLocal)
+
+	type clashProxy struct {
+		Name     string `yaml:"name"`
+		Type     string `yaml:"type"`
+		Server   string `yaml:"server"`
+		Port     int    `yaml:"port"`
+		Cipher   string `yaml:"cipher"`
+		Password string `yaml:"password"`
+		UDP      bool   `yaml:"udp"`
+	}
+	clash, err := yaml.Marshal([]clashProxy{{v.Name, "ss", v.Host, v.Port, v.Method, v.Password, true}})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\n# Clash (proxies:)\n%s", clash)
+
+	singBox, err := json.MarshalIndent(struct {
+		Type       string `json:"type"`
+		Tag        string `json:"tag"`
+		Server     string `json:"server"`
+		ServerPort int    `json:"server_port"`
+		Method     string `json:"method"`
+		Password   string `json:"password"`
+	}{"shadowsocks", v.Name, v.Host, v.Port, v.Method, v.Password}, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\n# sing-box (outbounds:)\n%s\n", singBox)
+	return nil
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"time"
+)
+
+// 預估費用時磁碟、外部 IP 與流量以 GCP 美國 region 的牌價與固定的用量估算，只在計價貨幣為美元時加入
+const (
+	bootDiskGB          = 10    // 映像檔預設的開機磁碟大小
+	diskMonthlyPerGB    = 0.04  // pd-standard 每 GB 每月
+	externalIPHourly    = 0.005 // 使用中的外部 IPv4 每小時
+	egressGBPerMonth    = 10    // 假設每台 proxy 每月的對外流量
+	egressPricePerGB    = 0.12  // 對外流量每 GB
+	defaultCostCurrency = "USD"
+)
+
+// CostEstimate 一台 proxy 每小時的預估費用，依項目分開
+type CostEstimate struct {
+	Machine  float64 `json:"machine" yaml:"machine"`
+	Disk     float64 `json:"disk" yaml:"disk"`
+	IP       float64 `json:"ip" yaml:"ip"`
+	Egress   float64 `json:"egress" yaml:"egress"`
+	Currency string  `json:"currency" yaml:"currency"`
+	// FreeTier 機器符合免費方案，機器費用以 0 計算，每個帳號只有一台
+	FreeTier bool `json:"free_tier,omitempty" yaml:"free_tier,omitempty"`
+}
+
+// Total 回傳所有項目加總的價格
+func (e CostEstimate) Total() MachinePrice {
+	return MachinePrice{Hourly: e.Machine + e.Disk + e.IP + e.Egress, Currency: e.Currency}
+}
+
+// estimateCost 估算在 zone 建立一台 machineType 的 proxy 的費用，billing API 無法使用或沒有這個機器類型的價格時回傳錯誤
+func (c *Commander) estimateCost(ctx context.Context, region, zone, machineType string, privateOnly bool) (CostEstimate, error) {
+	prices, err := c.provider.MachinePrices(ctx, zone)
+	if err != nil {
+		return CostEstimate{}, fmt.Errorf("failed to load machine type prices: %v", err)
+	}
+	price, ok := prices[machineType]
+	if !ok {
+		return CostEstimate{}, fmt.Errorf("no price found for machine type %s in %s", machineType, zone)
+	}
+	estimate := CostEstimate{Machine: price.Hourly, Currency: price.Currency}
+	if c.provider.FreeTier(region, machineType) {
+		estimate.Machine, estimate.FreeTier = 0, true
+	}
+	if estimate.Currency == "" {
+		estimate.Currency = defaultCostCurrency
+	}
+	if estimate.Currency != defaultCostCurrency {
+		return estimate, nil
+	}
+	estimate.Disk = bootDiskGB * diskMonthlyPerGB / hoursPerMonth
+	estimate.Egress = egressGBPerMonth * egressPricePerGB / hoursPerMonth
+	if !privateOnly {
+		estimate.IP = externalIPHourly
+	}
+	return estimate, nil
+}
+
+// checkCost 建立前顯示 count 台 proxy 的預估費用，maxCost 大於 0 時預估月費超過就中止
+// 無法估算時只提出警告，但設定了 maxCost 時無法確認費用，同樣中止
+func (c *Commander) checkCost(ctx context.Context, plan createPlan, count int, maxCost float64) error {
+	estimate, err := c.estimateCost(ctx, plan.Region, plan.Zone, plan.MachineType, plan.PrivateOnly)
+	if err != nil {
+		if maxCost > 0 {
+			return fmt.Errorf("cannot check --max-cost: %v", err)
+		}
+		c.logger.Printf("Warning: %v", err)
+		return nil
+	}
+	total := estimate.Total()
+	if count > 1 {
+		total.Hourly *= float64(count)
+	}
+	chatf(tr("Estimated cost: %s\n"), total)
+	if count > 1 {
+		chatf(tr("The estimate covers all %d proxies.\n"), count)
+	}
+	if estimate.Currency != defaultCostCurrency {
+		chatf(tr("Only the machine is included for prices in %s.\n"), estimate.Currency)
+	} else if plan.PrivateOnly {
+		chatf(tr("Includes the machine, a %d GB disk and %d GB of egress a month.\n"), bootDiskGB, egressGBPerMonth)
+	} else {
+		chatf(tr("Includes the machine, a %d GB disk, the external IP and %d GB of egress a month.\n"), bootDiskGB, egressGBPerMonth)
+	}
+	if estimate.FreeTier {
+		chatf(tr("%s qualifies for the free tier, only one per account is free.\n"), plan.MachineType)
+	}
+	if maxCost > 0 && total.Monthly() > maxCost {
+		return fmt.Errorf("estimated cost %.2f %s/mo exceeds --max-cost %.2f", total.Monthly(), total.Currency, maxCost)
+	}
+	return nil
+}
+
+// costView 一台 proxy 到目前為止的費用，Estimated 以建立後一直維持目前的狀態估算，Billed 來自帳單匯出資料
+type costView struct {
+	Name        string     `json:"name" yaml:"name"`
+	Status      string     `json:"status" yaml:"status"`
+	Zone        string     `json:"zone,omitempty" yaml:"zone,omitempty"`
+	MachineType string     `json:"machine_type,omitempty" yaml:"machine_type,omitempty"`
+	CreatedAt   *time.Time `json:"created_at,omitempty" yaml:"created_at,omitempty"`
+	Hours       float64    `json:"hours,omitempty" yaml:"hours,omitempty"`
+	Hourly      *float64   `json:"hourly,omitempty" yaml:"hourly,omitempty"`
+	Estimated   *float64   `json:"estimated,omitempty" yaml:"estimated,omitempty"`
+	Billed      *float64   `json:"billed,omitempty" yaml:"billed,omitempty"`
+	Currency    string     `json:"currency,omitempty" yaml:"currency,omitempty"`
+}
+
+// Cost 估算每台 proxy 從建立到現在累積的費用，billingTable 不為空時一併列出帳單匯出資料中的實際費用
+// 帳單以建立時的 billingLabel 對應紀錄，紀錄中沒有的 ID 是已經刪除的 proxy，只有實際費用
+func (c *Commander) Cost(ctx context.Context, billingTable string) error {
+	records, err := c.recordManager.Load()
+	if err != nil {
+		return fmt.Errorf("error loading records: %v", err)
+	}
+	var billed map[string]BilledCost
+	if billingTable != "" {
+		if billed, err = c.provider.BilledCosts(ctx, billingTable, billingLabel, nameLabel); err != nil {
+			return err
+		}
+	}
+	at := now()
+	stopped := false
+	estimates := make(map[string]CostEstimate)
+	views := make([]costView, 0, len(records))
+	for _, r := range records {
+		if r.Type != "instance" || !r.Managed() {
+			continue
+		}
+		view := costView{Name: r.Name, Status: r.Lifecycle(), Zone: r.Zone, MachineType: r.MachineType}
+		if !r.CreatedAt.IsZero() && r.MachineType != "" {
+			view.CreatedAt = &r.CreatedAt
+			view.Hours = at.Sub(r.CreatedAt).Hours()
+			key := r.Zone + "/" + r.MachineType
+			estimate, ok := estimates[key]
+			if !ok {
+				if estimate, err = c.estimateCost(ctx, r.Region, r.Zone, r.MachineType, r.PrivateOnly); err != nil {
+					c.logger.Printf("Warning: %v", err)
+				}
+				estimates[key] = estimate
+			}
+			if estimate.Currency != "" {
+				hourly := estimate.Total().Hourly
+				// 停止的 instance 不收機器與流量的費用，只計算磁碟與 IP
+				if view.Status == StatusStopped {
+					hourly = estimate.Disk + estimate.IP
+					stopped = true
+				}
+				estimated := hourly * view.Hours
+				view.Hourly, view.Estimated, view.Currency = &hourly, &estimated, estimate.Currency
+			}
+		}
+		if cost, ok := billed[r.BillingID]; ok && r.BillingID != "" {
+			view.Billed, view.Currency = &cost.Amount, cost.Currency
+			delete(billed, r.BillingID)
+		}
+		views = append(views, view)
+	}
+	for _, id := range slices.Sorted(maps.Keys(billed)) {
+		cost := billed[id]
+		name := cost.Name
+		if name == "" {
+			name = id
+		}
+		views = append(views, costView{Name: name, Status: "deleted", Billed: &cost.Amount, Currency: cost.Currency})
+	}
+	if len(views) == 0 && !c.machineOutput() {
+		fmt.Println(tr("No proxies found."))
+		return nil
+	}
+
+	estimatedTotal, billedTotal := make(map[string]float64), make(map[string]float64)
+	err = c.render(views, func(w io.Writer) {
+		header := "NAME\tSTATUS\tTYPE\tZONE\tHOURS\tHOURLY\tESTIMATED"
+		if billingTable != "" {
+			header += "\tBILLED"
+		}
+		fmt.Fprintln(w, header)
+		for _, v := range views {
+			hours, hourly, estimated, billedCost := "-", "-", "-", "-"
+			if v.CreatedAt != nil {
+				hours = fmt.Sprintf("%.0f", v.Hours)
+			}
+			if v.Estimated != nil {
+				hourly = fmt.Sprintf("%.4f", *v.Hourly)
+				estimated = fmt.Sprintf("%.2f %s", *v.Estimated, v.Currency)
+				estimatedTotal[v.Currency] += *v.Estimated
+			}
+			if v.Billed != nil {
+				billedCost = fmt.Sprintf("%.2f %s", *v.Billed, v.Currency)
+				billedTotal[v.Currency] += *v.Billed
+			}
+			line := fmt.Sprintf("%s\t%s\t%s\t%s\t%s\t%s\t%s", v.Name, v.Status, v.MachineType, v.Zone, hours, hourly, estimated)
+			if billingTable != "" {
+				line += "\t" + billedCost
+			}
+			fmt.Fprintln(w, line)
+		}
+	})
+	if err != nil || c.machineOutput() {
+		return err
+	}
+	for _, currency := range slices.Sorted(maps.Keys(estimatedTotal)) {
+		fmt.Printf(tr("Estimated total: %.2f %s\n"), estimatedTotal[currency], currency)
+	}
+	for _, currency := range slices.Sorted(maps.Keys(billedTotal)) {
+		fmt.Printf(tr("Billed total: %.2f %s\n"), billedTotal[currency], currency)
+	}
+	fmt.Println(tr("Estimates assume each proxy has been running since it was created."))
+	if stopped {
+		fmt.Println(tr("Stopped proxies only include the disk and IP, as if they had been stopped since they were created."))
+	}
+	return nil
+}
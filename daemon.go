@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// auto_proxyd 常駐執行的訂閱伺服器，與 auto_proxy 共用同一份程式碼，以 `-tags auto_proxyd` 編譯：
+//
+//	go build -tags auto_proxyd -o auto_proxyd .
+const daemonBinaryName = "auto_proxyd"
+
+func (a *cliApp) daemonCommand() *cobra.Command {
+	root := &cobra.Command{
+		Use:               daemonBinaryName,
+		Short:             "Run the auto_proxy subscription server as a long-running service",
+		SilenceUsage:      true,
+		PersistentPreRunE: a.setup,
+	}
+	root.PersistentFlags().StringVar(&a.lang, "lang", "", "Language of messages: en or zh-TW (default from AUTO_PROXY_LANG or the system locale)")
+	root.PersistentFlags().CountVarP(&a.verbose, "verbose", "v", "Show more detail: -vv also logs every cloud API call")
+	a.output = OutputTable
+	root.AddCommand(a.serveCommand(), a.installServiceCommand(), a.caCommand(), a.versionCommand())
+	return root
+}
+
+// serviceUnit systemd unit 的參數，WorkingDir 為紀錄所在的資料目錄，也作為服務的 AUTO_PROXY_DATA_DIR
+type serviceUnit struct {
+	User       string
+	WorkingDir string
+	ConfigPath string
+	ExecStart  string
+}
+
+var serviceUnitTemplate = template.Must(template.New("unit").Parse(`[Unit]
+Description=auto_proxy subscription server
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=simple
+User={{.User}}
+WorkingDirectory={{.WorkingDir}}
+Environment=AUTO_PROXY_CONFIG={{.ConfigPath}}
+Environment=AUTO_PROXY_DATA_DIR={{.WorkingDir}}
+ExecStart={{.ExecStart}}
+Restart=on-failure
+RestartSec=5
+NoNewPrivileges=true
+PrivateTmp=true
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+func writeServiceUnit(w io.Writer, unit serviceUnit) error {
+	return serviceUnitTemplate.Execute(w, unit)
+}
+
+func (a *cliApp) installServiceCommand() *cobra.Command {
+	var addr, unitPath, userName, workingDir string
+	var auth []string
+	var printOnly, mtls bool
+	var expireInterval, reapInterval time.Duration
+	cmd := &cobra.Command{
+		Use:         "install-service",
+		Short:       "Generate a systemd unit that runs the subscription server",
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{annotationStandalone: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			executable, err := os.Executable()
+			if err != nil {
+				return fmt.Errorf("failed to locate %s executable: %v", daemonBinaryName, err)
+			}
+			if userName == "" {
+				current, err := user.Current()
+				if err != nil {
+					return fmt.Errorf("failed to get current user: %v", err)
+				}
+				userName = current.Username
+			}
+			// 服務以其他使用者執行時資料目錄不同，預設沿用目前使用者的資料目錄
+			if workingDir == "" {
+				cfg, err := loadConfig()
+				if err != nil {
+					return err
+				}
+				if workingDir, err = cfg.dataDir(); err != nil {
+					return err
+				}
+			}
+			if workingDir, err = filepath.Abs(workingDir); err != nil {
+				return fmt.Errorf("invalid working directory: %v", err)
+			}
+			// 服務以其他使用者執行時家目錄不同，明確指定設定檔的位置
+			configFile, err := configPath()
+			if err != nil {
+				return err
+			}
+			if configFile, err = filepath.Abs(configFile); err != nil {
+				return fmt.Errorf("invalid config path: %v", err)
+			}
+			if _, err := os.Stat(configFile); err != nil {
+				return fmt.Errorf("no config found at %s, run `auto_proxy config init` first", configFile)
+			}
+
+			execStart := []string{executable, "serve", "--addr", addr}
+			if len(auth) > 0 {
+				execStart = append(execStart, "--auth", strings.Join(auth, ","))
+			}
+			if mtls {
+				execStart = append(execStart, "--mtls")
+			}
+			if expireInterval > 0 {
+				execStart = append(execStart, "--expire-interval", expireInterval.String())
+			}
+			if reapInterval > 0 {
+				execStart = append(execStart, "--reap-interval", reapInterval.String())
+			}
+			unit := serviceUnit{User: userName, WorkingDir: workingDir, ConfigPath: configFile, ExecStart: strings.Join(execStart, " ")}
+			if printOnly {
+				return writeServiceUnit(os.Stdout, unit)
+			}
+
+			file, err := os.OpenFile(unitPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+			if err != nil {
+				return fmt.Errorf("failed to write %s (try sudo or --print): %v", unitPath, err)
+			}
+			defer file.Close()
+			if err := writeServiceUnit(file, unit); err != nil {
+				return fmt.Errorf("failed to write %s: %v", unitPath, err)
+			}
+			service := strings.TrimSuffix(filepath.Base(unitPath), ".service")
+			fmt.Printf(tr("Wrote %s. Start it with:\n  systemctl daemon-reload && systemctl enable --now %s\n"), unitPath, service)
+			return nil
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&addr, "addr", ":8080", "Address for the subscription server to listen on")
+	flags.StringSliceVar(&auth, "auth", nil, "Comma-separated auth schemes passed to serve")
+	flags.StringVar(&userName, "user", "", "User to run the service as (default current user)")
+	flags.StringVar(&workingDir, "dir", "", "Data directory containing the records (default the data directory of the current user)")
+	flags.StringVar(&unitPath, "unit", "/etc/systemd/system/"+daemonBinaryName+".service", "Path of the unit file to write")
+	flags.BoolVar(&printOnly, "print", false, "Print the unit to stdout instead of writing it")
+	flags.BoolVar(&mtls, "mtls", false, "Pass --mtls to serve, run `ca init` in the service's data directory first")
+	flags.DurationVar(&expireInterval, "expire-interval", 0, "Passed to serve to remove expired proxy users periodically")
+	flags.DurationVar(&reapInterval, "reap-interval", 0, "Passed to serve to delete expired proxies periodically")
+	return cmd
+}
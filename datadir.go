@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// legacyStateFiles 舊版寫在目前目錄的狀態檔，第一次使用資料目錄時搬過去
+var legacyStateFiles = []string{"proxy_records.json", "proxy_records.db", "invites.json", "probe_targets.json", "instance_metadata.json"}
+
+// dataDir 回傳存放紀錄、邀請與記錄檔的目錄，依 AUTO_PROXY_DATA_DIR、設定檔的 data_dir、
+// os.UserConfigDir()/auto_proxy 的順序決定
+func (c *Config) dataDir() (string, error) {
+	dir := os.Getenv("AUTO_PROXY_DATA_DIR")
+	if dir == "" {
+		dir = c.DataDir
+	}
+	if dir == "" {
+		base, err := os.UserConfigDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to locate config directory, set data_dir: %v", err)
+		}
+		dir = filepath.Join(base, "auto_proxy")
+	}
+	return filepath.Abs(dir)
+}
+
+// dataPath 相對路徑放在資料目錄下，絕對路徑與空值維持不變
+func dataPath(dir, name string) string {
+	if name == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(dir, name)
+}
+
+// prepareDataDir 建立資料目錄、把設定檔中的相對路徑改為資料目錄下的路徑，migrate 時搬移舊版留在目前目錄的狀態檔
+func (c *Config) prepareDataDir(migrate bool) (string, error) {
+	dir, err := c.dataDir()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create data directory: %v", err)
+	}
+	c.LogFile = dataPath(dir, c.LogFile)
+	c.ProbeTargetsFile = dataPath(dir, c.ProbeTargetsFile)
+	c.MetadataFile = dataPath(dir, c.MetadataFile)
+	if migrate {
+		migrateLegacyState(dir)
+	}
+	return dir, nil
+}
+
+// migrateLegacyState 資料目錄中還沒有的狀態檔才搬移，已經有的保留資料目錄的版本並提醒
+// 訊息寫到 stderr，不影響 -o json 的輸出
+func migrateLegacyState(dir string) {
+	notef := func(format string, args ...any) {
+		if logLevel >= LevelNormal {
+			fmt.Fprintf(os.Stderr, tr(format), args...)
+		}
+	}
+	cwd, err := os.Getwd()
+	if err != nil || cwd == dir {
+		return
+	}
+	for _, name := range legacyStateFiles {
+		from, to := filepath.Join(cwd, name), filepath.Join(dir, name)
+		if _, err := os.Stat(from); err != nil {
+			continue
+		}
+		if _, err := os.Stat(to); err == nil {
+			notef("Ignoring %s, %s is used instead\n", from, to)
+			continue
+		}
+		if err := os.Rename(from, to); err != nil {
+			notef("Failed to move %s to %s, move it manually: %v\n", from, to, err)
+			continue
+		}
+		notef("Moved %s to %s\n", from, to)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// dryRunIP 模擬建立的 instance 使用的位址 (TEST-NET-1)，不會連到真的主機
+const dryRunIP = "192.0.2.1"
+
+// wrapDryRun 讓會修改雲端或主機的操作只印出將要執行的內容，查詢類的操作照常執行
+func wrapDryRun(provider CloudProvider, deployer ProxyDeployer) (CloudProvider, ProxyDeployer) {
+	fmt.Println(tr("DRY RUN: nothing will be created, changed or deleted"))
+	return &dryRunProvider{CloudProvider: provider, created: make(map[string]bool)}, &dryRunDeployer{ProxyDeployer: deployer}
+}
+
+func dryRunf(format string, args ...any) {
+	fmt.Printf("[dry-run] "+tr(format), args...)
+}
+
+type dryRunProvider struct {
+	CloudProvider
+	created map[string]bool
+}
+
+func (p *dryRunProvider) CreateInstance(ctx context.Context, spec InstanceSpec) (string, InstanceInfo, error) {
+	dryRunf("Would create instance %s in %s: machine type %s, image %s\n", spec.Name, spec.Zone, spec.MachineType, spec.Image)
+	if spec.PrivateOnly {
+		dryRunf("  without an external IP\n")
+	}
+	if len(spec.NetworkTags) > 0 {
+		dryRunf("  network tags: %s\n", strings.Join(spec.NetworkTags, ", "))
+	}
+	if len(spec.Labels) > 0 {
+		dryRunf("  labels: %v\n", spec.Labels)
+	}
+	if len(spec.Metadata) > 0 {
+		keys := make([]string, 0, len(spec.Metadata))
+		for k := range spec.Metadata {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		dryRunf("  metadata: %s\n", strings.Join(keys, ", "))
+	}
+	p.created[spec.Name] = true
+	return spec.Name, InstanceInfo{IP: dryRunIP, DiskID: spec.Name, Status: "RUNNING"}, nil
+}
+
+func (p *dryRunProvider) DeleteInstance(ctx context.Context, zone, instanceID string) error {
+	dryRunf("Would delete instance %s in %s\n", instanceID, zone)
+	return nil
+}
+
+func (p *dryRunProvider) DeleteDisk(ctx context.Context, zone, diskID string) error {
+	dryRunf("Would delete disk %s in %s\n", diskID, zone)
+	return nil
+}
+
+func (p *dryRunProvider) StopInstance(ctx context.Context, zone, instanceID string) error {
+	dryRunf("Would stop instance %s in %s\n", instanceID, zone)
+	return nil
+}
+
+func (p *dryRunProvider) StartInstance(ctx context.Context, zone, instanceID string) error {
+	dryRunf("Would start instance %s in %s\n", instanceID, zone)
+	return nil
+}
+
+// GetInstanceInfo 模擬建立的 instance 不存在於雲端，回傳假的資訊
+func (p *dryRunProvider) GetInstanceInfo(ctx context.Context, zone, instanceID string) (InstanceInfo, error) {
+	if p.created[instanceID] {
+		return InstanceInfo{IP: dryRunIP, DiskID: instanceID, Status: "RUNNING"}, nil
+	}
+	return p.CloudProvider.GetInstanceInfo(ctx, zone, instanceID)
+}
+
+func (p *dryRunProvider) CreateImage(ctx context.Context, spec ImageSpec) error {
+	dryRunf("Would create image %s from disk %s in %s\n", spec.Name, spec.DiskID, spec.Zone)
+	return nil
+}
+
+func (p *dryRunProvider) DeleteImage(ctx context.Context, name string) error {
+	dryRunf("Would delete image %s\n", name)
+	return nil
+}
+
+func (p *dryRunProvider) EnsureFirewall(ctx context.Context, template FirewallTemplate) error {
+	dryRunf("Would ensure firewall rules for network tag %s:\n", template.Tag())
+	for _, rule := range template.Rules {
+		sources := "0.0.0.0/0"
+		if len(rule.Sources) > 0 {
+			sources = strings.Join(rule.Sources, ", ")
+		}
+		dryRunf("  allow %d/%s from %s (%s)\n", rule.Port, rule.Protocol, sources, rule.Comment)
+	}
+	return nil
+}
+
+func (p *dryRunProvider) DeleteResource(ctx context.Context, resource CloudResource) error {
+	dryRunf("Would delete %s %s\n", resource.Kind, resource.Name)
+	return nil
+}
+
+func (p *dryRunProvider) SetNetworkTags(ctx context.Context, zone, instanceID string, tags []string) error {
+	dryRunf("Would set network tags on instance %s: %s\n", instanceID, strings.Join(tags, ", "))
+	return nil
+}
+
+func (p *dryRunProvider) SetInstanceLabels(ctx context.Context, zone, instanceID string, labels map[string]string) error {
+	dryRunf("Would set labels on instance %s: %v\n", instanceID, labels)
+	return nil
+}
+
+// playbookRenderer 可以在不連線的情況下產生部署內容的 deployer
+type playbookRenderer interface {
+	inventory(target DeployTarget) string
+	playbook(target DeployTarget) (string, error)
+}
+
+type dryRunDeployer struct {
+	ProxyDeployer
+}
+
+// Deploy 印出會寫入的 inventory、playbook 與 ansible-playbook 指令
+func (d *dryRunDeployer) Deploy(target DeployTarget) error {
+	renderer, ok := d.ProxyDeployer.(playbookRenderer)
+	if !ok {
+		dryRunf("Would deploy the proxy to %s\n", target.IP)
+		return nil
+	}
+	playbook, err := renderer.playbook(target)
+	if err != nil {
+		return err
+	}
+	arch := target.Arch
+	if arch == "" {
+		arch = ArchAMD64
+	}
+	inventoryPath, playbookPath := filepath.Join("<workdir>", "inventory.ini"), filepath.Join("<workdir>", "playbook.yml")
+	// 密碼檔的內容不印出
+	args, err := playbookArgs(target, inventoryPath, playbookPath, filepath.Join("<workdir>", "secrets.json"), arch, nil)
+	if err != nil {
+		return err
+	}
+	dryRunf("Would deploy the proxy to %s with:\n", target.IP)
+	fmt.Printf("--- %s\n%s\n--- %s\n%s\n--- command\nansible-playbook %s\n", inventoryPath, renderer.inventory(target), playbookPath, playbook, strings.Join(args, " "))
+	return nil
+}
+
+func (d *dryRunDeployer) Preflight() error {
+	if checker, ok := d.ProxyDeployer.(PreflightChecker); ok {
+		return checker.Preflight()
+	}
+	return nil
+}
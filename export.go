@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EgressPolicy 匯出客戶端設定時附帶的分流建議，符合 Direct* 的流量直連，其餘依 Final 決定
+type EgressPolicy struct {
+	DirectCountries []string `json:"direct_countries"` // GeoIP / GeoSite 國碼，例如 CN
+	DirectASNs      []int    `json:"direct_asns"`
+	DirectDomains   []string `json:"direct_domains"` // 網域後綴
+	ProxyDomains    []string `json:"proxy_domains"`  // 優先於直連規則，一律走 proxy
+	Final           string   `json:"final"`          // proxy 或 direct，預設 proxy
+}
+
+// 內建的分流範本，--policy 也可以指定 JSON 檔
+var egressPolicies = map[string]EgressPolicy{
+	"default": {DirectCountries: []string{"CN"}, Final: "proxy"},
+	"none":    {Final: "proxy"},
+}
+
+func LoadEgressPolicy(name string) (EgressPolicy, error) {
+	if policy, ok := egressPolicies[name]; ok {
+		return policy, nil
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return EgressPolicy{}, fmt.Errorf("failed to read egress policy: %w", err)
+	}
+	var policy EgressPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return EgressPolicy{}, fmt.Errorf("failed to unmarshal egress policy: %w", err)
+	}
+	if policy.Final == "" {
+		policy.Final = "proxy"
+	}
+	if policy.Final != "proxy" && policy.Final != "direct" {
+		return EgressPolicy{}, fmt.Errorf("invalid final outbound %q in egress policy (expected proxy or direct)", policy.Final)
+	}
+	return policy, nil
+}
+
+// exportedProxy 匯出到客戶端設定的 Shadowsocks 伺服器
+type exportedProxy struct {
+	Name     string
+	Server   string
+	Port     int
+	Method   string
+	Password string
+}
+
+func exportableProxies(records []ProxyRecord, tags tagFilter) []exportedProxy {
+	var proxies []exportedProxy
+	for _, r := range records {
+		if r.Type != "instance" || !r.Ready() || r.IP == "" || r.ProxyProtocol() != ProtocolShadowsocks || !tags.match(r.Tags) {
+			continue
+		}
+		port, method, password := r.Endpoint()
+		proxies = append(proxies, exportedProxy{Name: r.Name, Server: r.IP, Port: port, Method: method, Password: password})
+	}
+	return proxies
+}
+
+// Export 輸出可以直接匯入客戶端的設定檔，依 policy 加上分流規則，tags 不為空時只輸出符合的 proxy
+func (c *Commander) Export(format, policyName string, tags []string) error {
+	policy, err := LoadEgressPolicy(policyName)
+	if err != nil {
+		return err
+	}
+	records, err := c.recordManager.Load()
+	if err != nil {
+		return fmt.Errorf("error loading records: %v", err)
+	}
+	proxies := exportableProxies(records, tagFilter(tags))
+	if len(proxies) == 0 {
+		return fmt.Errorf("no active proxies to export")
+	}
+	switch format {
+	case "clash":
+		return writeClashConfig(os.Stdout, proxies, policy)
+	case "sing-box":
+		return writeSingBoxConfig(os.Stdout, proxies, policy)
+	case "surge":
+		return writeSurgeConfig(os.Stdout, proxies, policy)
+	case "quantumult-x":
+		return writeQuantumultXConfig(os.Stdout, proxies, policy)
+	default:
+		return fmt.Errorf("unsupported export format: %s (supported: clash, sing-box, surge, quantumult-x)", format)
+	}
+}
+
+// writeClashConfig 輸出 Clash.Meta (mihomo) 設定，GEOSITE 與 IP-ASN 規則需要 Meta 核心
+func writeClashConfig(w io.Writer, proxies []exportedProxy, policy EgressPolicy) error {
+	type clashProxy struct {
+		Name     string `yaml:"name"`
+		Type     string `yaml:"type"`
+		Server   string `yaml:"server"`
+		Port     int    `yaml:"port"`
+		Cipher   string `yaml:"cipher"`
+		Password string `yaml:"password"`
+		UDP      bool   `yaml:"udp"`
+	}
+	type clashGroup struct {
+		Name    string   `yaml:"name"`
+		Type    string   `yaml:"type"`
+		Proxies []string `yaml:"proxies"`
+	}
+	var config struct {
+		Proxies     []clashProxy `yaml:"proxies"`
+		ProxyGroups []clashGroup `yaml:"proxy-groups"`
+		Rules       []string     `yaml:"rules"`
+	}
+	group := clashGroup{Name: "Proxy", Type: "select"}
+	for _, p := range proxies {
+		config.Proxies = append(config.Proxies, clashProxy{Name: p.Name, Type: "ss", Server: p.Server, Port: p.Port, Cipher: p.Method, Password: p.Password, UDP: true})
+		group.Proxies = append(group.Proxies, p.Name)
+	}
+	config.ProxyGroups = []clashGroup{group}
+
+	for _, domain := range policy.ProxyDomains {
+		config.Rules = append(config.Rules, "DOMAIN-SUFFIX,"+domain+",Proxy")
+	}
+	for _, domain := range policy.DirectDomains {
+		config.Rules = append(config.Rules, "DOMAIN-SUFFIX,"+domain+",DIRECT")
+	}
+	for _, country := range policy.DirectCountries {
+		config.Rules = append(config.Rules, "GEOSITE,"+strings.ToLower(country)+",DIRECT")
+	}
+	config.Rules = append(config.Rules, "GEOIP,LAN,DIRECT,no-resolve")
+	for _, country := range policy.DirectCountries {
+		config.Rules = append(config.Rules, "GEOIP,"+strings.ToUpper(country)+",DIRECT")
+	}
+	for _, asn := range policy.DirectASNs {
+		config.Rules = append(config.Rules, fmt.Sprintf("IP-ASN,%d,DIRECT", asn))
+	}
+	final := "Proxy"
+	if policy.Final == "direct" {
+		final = "DIRECT"
+	}
+	config.Rules = append(config.Rules, "MATCH,"+final)
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	defer encoder.Close()
+	return encoder.Encode(config)
+}
+
+// sing-box 的國家分流使用 SagerNet 維護的 rule-set，sing-box 沒有 ASN 規則，DirectASNs 會被略過
+const (
+	singBoxGeositeURL = "https://raw.githubusercontent.com/SagerNet/sing-geosite/rule-set/geosite-%s.srs"
+	singBoxGeoIPURL   = "https://raw.githubusercontent.com/SagerNet/sing-geoip/rule-set/geoip-%s.srs"
+)
+
+func writeSingBoxConfig(w io.Writer, proxies []exportedProxy, policy EgressPolicy) error {
+	type object = map[string]any
+	var names []string
+	var outbounds []object
+	for _, p := range proxies {
+		names = append(names, p.Name)
+		outbounds = append(outbounds, object{
+			"type":        "shadowsocks",
+			"tag":         p.Name,
+			"server":      p.Server,
+			"server_port": p.Port,
+			"method":      p.Method,
+			"password":    p.Password,
+		})
+	}
+	outbounds = append([]object{{"type": "selector", "tag": "proxy", "outbounds": names}}, outbounds...)
+	outbounds = append(outbounds, object{"type": "direct", "tag": "direct"})
+
+	rules := []object{}
+	if len(policy.ProxyDomains) > 0 {
+		rules = append(rules, object{"domain_suffix": policy.ProxyDomains, "outbound": "proxy"})
+	}
+	rules = append(rules, object{"ip_is_private": true, "outbound": "direct"})
+	if len(policy.DirectDomains) > 0 {
+		rules = append(rules, object{"domain_suffix": policy.DirectDomains, "outbound": "direct"})
+	}
+	ruleSets := []object{}
+	var tags []string
+	for _, country := range policy.DirectCountries {
+		country = strings.ToLower(country)
+		for _, set := range []struct{ tag, url string }{
+			{"geosite-" + country, fmt.Sprintf(singBoxGeositeURL, country)},
+			{"geoip-" + country, fmt.Sprintf(singBoxGeoIPURL, country)},
+		} {
+			ruleSets = append(ruleSets, object{"type": "remote", "tag": set.tag, "format": "binary", "url": set.url, "download_detour": "proxy"})
+			tags = append(tags, set.tag)
+		}
+	}
+	if len(tags) > 0 {
+		rules = append(rules, object{"rule_set": tags, "outbound": "direct"})
+	}
+
+	config := object{
+		"outbounds": outbounds,
+		"route": object{
+			"rule_set": ruleSets,
+			"rules":    rules,
+			"final":    policy.Final,
+		},
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(config)
+}
+
+// writeSurgeConfig 輸出 Surge 的 [Proxy]、[Proxy Group] 與 [Rule] 區段，Surge 沒有 GeoSite，國家只以 GEOIP 分流
+func writeSurgeConfig(w io.Writer, proxies []exportedProxy, policy EgressPolicy) error {
+	var names []string
+	fmt.Fprintln(w, "[Proxy]")
+	for _, p := range proxies {
+		names = append(names, p.Name)
+		fmt.Fprintf(w, "%s = ss, %s, %d, encrypt-method=%s, password=%s, udp-relay=true\n", p.Name, p.Server, p.Port, p.Method, p.Password)
+	}
+	fmt.Fprintf(w, "\n[Proxy Group]\nProxy = select, %s\n", strings.Join(names, ", "))
+
+	fmt.Fprintln(w, "\n[Rule]")
+	for _, domain := range policy.ProxyDomains {
+		fmt.Fprintf(w, "DOMAIN-SUFFIX,%s,Proxy\n", domain)
+	}
+	for _, domain := range policy.DirectDomains {
+		fmt.Fprintf(w, "DOMAIN-SUFFIX,%s,DIRECT\n", domain)
+	}
+	fmt.Fprintln(w, "IP-CIDR,192.168.0.0/16,DIRECT,no-resolve")
+	fmt.Fprintln(w, "IP-CIDR,10.0.0.0/8,DIRECT,no-resolve")
+	fmt.Fprintln(w, "IP-CIDR,172.16.0.0/12,DIRECT,no-resolve")
+	for _, country := range policy.DirectCountries {
+		fmt.Fprintf(w, "GEOIP,%s,DIRECT\n", strings.ToUpper(country))
+	}
+	for _, asn := range policy.DirectASNs {
+		fmt.Fprintf(w, "IP-ASN,%d,DIRECT\n", asn)
+	}
+	final := "Proxy"
+	if policy.Final == "direct" {
+		final = "DIRECT"
+	}
+	fmt.Fprintf(w, "FINAL,%s\n", final)
+	return nil
+}
+
+// writeQuantumultXConfig 輸出 Quantumult X 的 [server_local]、[policy] 與 [filter_local] 區段，DirectASNs 會被略過
+func writeQuantumultXConfig(w io.Writer, proxies []exportedProxy, policy EgressPolicy) error {
+	var names []string
+	fmt.Fprintln(w, "[server_local]")
+	for _, p := range proxies {
+		names = append(names, p.Name)
+		fmt.Fprintf(w, "shadowsocks=%s:%d, method=%s, password=%s, udp-relay=true, tag=%s\n", p.Server, p.Port, p.Method, p.Password, p.Name)
+	}
+	fmt.Fprintf(w, "\n[policy]\nstatic=Proxy, %s\n", strings.Join(names, ", "))
+
+	fmt.Fprintln(w, "\n[filter_local]")
+	for _, domain := range policy.ProxyDomains {
+		fmt.Fprintf(w, "host-suffix, %s, Proxy\n", domain)
+	}
+	for _, domain := range policy.DirectDomains {
+		fmt.Fprintf(w, "host-suffix, %s, direct\n", domain)
+	}
+	fmt.Fprintln(w, "ip-cidr, 192.168.0.0/16, direct")
+	fmt.Fprintln(w, "ip-cidr, 10.0.0.0/8, direct")
+	fmt.Fprintln(w, "ip-cidr, 172.16.0.0/12, direct")
+	for _, country := range policy.DirectCountries {
+		fmt.Fprintf(w, "geoip, %s, direct\n", strings.ToLower(country))
+	}
+	final := "Proxy"
+	if policy.Final == "direct" {
+		final = "direct"
+	}
+	fmt.Fprintf(w, "final, %s\n", final)
+	return nil
+}
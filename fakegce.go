@@ -0,0 +1,459 @@
+//go:build fakegce
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/option"
+)
+
+// 使用 `go run -tags fakegce . selftest` 以本機的假 Compute API 跑過 GCPProvider 的
+// 建立、刪除、重試與 operation 輪詢流程，重構 provider 時不需要真的 GCP 專案
+func init() {
+	extraCommands = append(extraCommands, func(a *cliApp) *cobra.Command {
+		return &cobra.Command{
+			Use:         "selftest",
+			Short:       "Run the GCP provider against a local fake Compute API",
+			Args:        cobra.NoArgs,
+			Annotations: map[string]string{annotationStandalone: "true"},
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return runSelfTest(cmd.Context())
+			},
+		}
+	})
+}
+
+// FakeGCE 只實作 GCPProvider 會用到的 Compute API，operation 需要輪詢 pollsPerOp 次才會完成
+type FakeGCE struct {
+	mu         sync.Mutex
+	instances  map[string]*compute.Instance
+	operations map[string]int // 剩下幾次輪詢才會 DONE
+	failures   map[string][]int
+	pollsPerOp int
+	nextOp     int
+	nextIP     int // start 後配置的新外部 IP
+	calls      map[string]int
+}
+
+func NewFakeGCE() *FakeGCE {
+	return &FakeGCE{
+		instances:  make(map[string]*compute.Instance),
+		operations: make(map[string]int),
+		failures:   make(map[string][]int),
+		calls:      make(map[string]int),
+		pollsPerOp: 1,
+	}
+}
+
+// FailNext 讓接下來的 method 呼叫依序回傳這些 HTTP 狀態碼，method 例如 "instances.insert"
+func (f *FakeGCE) FailNext(method string, codes ...int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures[method] = append(f.failures[method], codes...)
+}
+
+func (f *FakeGCE) Calls(method string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[method]
+}
+
+func (f *FakeGCE) Handler() http.Handler {
+	mux := http.NewServeMux()
+	const zonePath = "/compute/v1/projects/{project}/zones/{zone}"
+	mux.HandleFunc("GET /compute/v1/projects/{project}/regions", f.handle("regions.list", f.listRegions))
+	mux.HandleFunc("GET /compute/v1/projects/{project}/zones", f.handle("zones.list", f.listZones))
+	mux.HandleFunc("GET /compute/v1/projects/{project}/aggregated/instances", f.handle("instances.aggregatedList", f.listInstances))
+	mux.HandleFunc("GET /compute/v1/projects/{project}/aggregated/disks", f.handle("disks.aggregatedList", f.listDisks))
+	mux.HandleFunc("GET /compute/v1/projects/{project}/aggregated/addresses", f.handle("addresses.aggregatedList", f.listAddresses))
+	mux.HandleFunc("GET /compute/v1/projects/{project}/global/firewalls", f.handle("firewalls.list", f.listFirewalls))
+	mux.HandleFunc("GET "+zonePath+"/machineTypes", f.handle("machineTypes.list", f.listMachineTypes))
+	mux.HandleFunc("POST "+zonePath+"/instances", f.handle("instances.insert", f.insertInstance))
+	mux.HandleFunc("GET "+zonePath+"/instances/{name}", f.handle("instances.get", f.getInstance))
+	mux.HandleFunc("DELETE "+zonePath+"/instances/{name}", f.handle("instances.delete", f.deleteInstance))
+	mux.HandleFunc("POST "+zonePath+"/instances/{name}/stop", f.handle("instances.stop", f.stopInstance))
+	mux.HandleFunc("POST "+zonePath+"/instances/{name}/start", f.handle("instances.start", f.startInstance))
+	mux.HandleFunc("DELETE "+zonePath+"/disks/{name}", f.handle("disks.delete", f.deleteDisk))
+	mux.HandleFunc("GET "+zonePath+"/operations/{name}", f.handle("zoneOperations.get", f.getOperation))
+	return mux
+}
+
+type fakeHandler func(r *http.Request) (any, int, error)
+
+// handle 記錄呼叫次數並套用 FailNext 設定的錯誤，錯誤以 Compute API 的格式回傳
+func (f *FakeGCE) handle(method string, h fakeHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.calls[method]++
+		var injected int
+		if codes := f.failures[method]; len(codes) > 0 {
+			injected, f.failures[method] = codes[0], codes[1:]
+		}
+		f.mu.Unlock()
+
+		var body any
+		code := http.StatusOK
+		var err error
+		if injected != 0 {
+			code, err = injected, fmt.Errorf("injected failure")
+		} else {
+			body, code, err = h(r)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			w.WriteHeader(code)
+			json.NewEncoder(w).Encode(map[string]any{"error": map[string]any{"code": code, "message": err.Error()}})
+			return
+		}
+		json.NewEncoder(w).Encode(body)
+	}
+}
+
+func (f *FakeGCE) listRegions(r *http.Request) (any, int, error) {
+	return &compute.RegionList{Items: []*compute.Region{{Name: "asia-east1"}, {Name: "us-west1"}}}, http.StatusOK, nil
+}
+
+func (f *FakeGCE) listZones(r *http.Request) (any, int, error) {
+	return &compute.ZoneList{Items: []*compute.Zone{{Name: "asia-east1-a"}, {Name: "asia-east1-b"}, {Name: "us-west1-a"}}}, http.StatusOK, nil
+}
+
+func (f *FakeGCE) listMachineTypes(r *http.Request) (any, int, error) {
+	return &compute.MachineTypeList{Items: []*compute.MachineType{{Name: "e2-micro"}, {Name: "t2a-standard-1"}}}, http.StatusOK, nil
+}
+
+func (f *FakeGCE) newOperation() *compute.Operation {
+	f.nextOp++
+	name := fmt.Sprintf("operation-%d", f.nextOp)
+	f.operations[name] = f.pollsPerOp
+	return &compute.Operation{Name: name, Status: "RUNNING"}
+}
+
+func (f *FakeGCE) insertInstance(r *http.Request) (any, int, error) {
+	var instance compute.Instance
+	if err := json.NewDecoder(r.Body).Decode(&instance); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.instances[instance.Name]; ok {
+		return nil, http.StatusConflict, fmt.Errorf("instance %s already exists", instance.Name)
+	}
+	zone := r.PathValue("zone")
+	instance.Zone = fmt.Sprintf("projects/%s/zones/%s", r.PathValue("project"), zone)
+	instance.Status = "RUNNING"
+	boot := &compute.AttachedDisk{Boot: true, Source: fmt.Sprintf("projects/%s/zones/%s/disks/%s", r.PathValue("project"), zone, instance.Name)}
+	if len(instance.Disks) > 0 {
+		boot.InitializeParams = instance.Disks[0].InitializeParams
+	}
+	instance.Disks = []*compute.AttachedDisk{boot}
+	for i, nic := range instance.NetworkInterfaces {
+		nic.NetworkIP = fmt.Sprintf("10.0.0.%d", len(f.instances)+2)
+		for _, ac := range nic.AccessConfigs {
+			ac.NatIP = fmt.Sprintf("203.0.113.%d", len(f.instances)+i+10)
+		}
+	}
+	f.instances[instance.Name] = &instance
+	return f.newOperation(), http.StatusOK, nil
+}
+
+func (f *FakeGCE) getInstance(r *http.Request) (any, int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	instance, ok := f.instances[r.PathValue("name")]
+	if !ok {
+		return nil, http.StatusNotFound, fmt.Errorf("instance %s not found", r.PathValue("name"))
+	}
+	return instance, http.StatusOK, nil
+}
+
+// listInstances 只支援 ListInstances 使用的 labels.KEY:* 篩選
+func (f *FakeGCE) listInstances(r *http.Request) (any, int, error) {
+	filter := r.URL.Query().Get("filter")
+	label, ok := strings.CutSuffix(strings.TrimPrefix(filter, "labels."), ":*")
+	if filter != "" && !ok {
+		return nil, http.StatusBadRequest, fmt.Errorf("unsupported filter: %s", filter)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	items := make(map[string]compute.InstancesScopedList)
+	for _, instance := range f.instances {
+		if _, ok := instance.Labels[label]; filter != "" && !ok {
+			continue
+		}
+		scope := "zones/" + instance.Zone[strings.LastIndex(instance.Zone, "/")+1:]
+		list := items[scope]
+		list.Instances = append(list.Instances, instance)
+		items[scope] = list
+	}
+	return &compute.InstanceAggregatedList{Items: items}, http.StatusOK, nil
+}
+
+func (f *FakeGCE) deleteInstance(r *http.Request) (any, int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := r.PathValue("name")
+	if _, ok := f.instances[name]; !ok {
+		return nil, http.StatusNotFound, fmt.Errorf("instance %s not found", name)
+	}
+	delete(f.instances, name)
+	return f.newOperation(), http.StatusOK, nil
+}
+
+// stopInstance 與真的 GCE 一樣釋放臨時的外部 IP
+func (f *FakeGCE) stopInstance(r *http.Request) (any, int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	instance, ok := f.instances[r.PathValue("name")]
+	if !ok {
+		return nil, http.StatusNotFound, fmt.Errorf("instance %s not found", r.PathValue("name"))
+	}
+	instance.Status = "TERMINATED"
+	for _, nic := range instance.NetworkInterfaces {
+		for _, ac := range nic.AccessConfigs {
+			ac.NatIP = ""
+		}
+	}
+	return f.newOperation(), http.StatusOK, nil
+}
+
+// startInstance 配置新的外部 IP，和停止前的 IP 不同
+func (f *FakeGCE) startInstance(r *http.Request) (any, int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	instance, ok := f.instances[r.PathValue("name")]
+	if !ok {
+		return nil, http.StatusNotFound, fmt.Errorf("instance %s not found", r.PathValue("name"))
+	}
+	instance.Status = "RUNNING"
+	for _, nic := range instance.NetworkInterfaces {
+		for _, ac := range nic.AccessConfigs {
+			f.nextIP++
+			ac.NatIP = fmt.Sprintf("198.51.100.%d", f.nextIP)
+		}
+	}
+	return f.newOperation(), http.StatusOK, nil
+}
+
+func (f *FakeGCE) deleteDisk(r *http.Request) (any, int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.newOperation(), http.StatusOK, nil
+}
+
+// listDisks 只有 instance 的開機磁碟，labels 沿用建立時的 InitializeParams
+func (f *FakeGCE) listDisks(r *http.Request) (any, int, error) {
+	filter := r.URL.Query().Get("filter")
+	label, _ := strings.CutSuffix(strings.TrimPrefix(filter, "labels."), ":*")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	items := make(map[string]compute.DisksScopedList)
+	for _, instance := range f.instances {
+		var labels map[string]string
+		if len(instance.Disks) > 0 && instance.Disks[0].InitializeParams != nil {
+			labels = instance.Disks[0].InitializeParams.Labels
+		}
+		if _, ok := labels[label]; filter != "" && !ok {
+			continue
+		}
+		scope := "zones/" + instance.Zone[strings.LastIndex(instance.Zone, "/")+1:]
+		list := items[scope]
+		list.Disks = append(list.Disks, &compute.Disk{Name: instance.Name, Zone: instance.Zone, Labels: labels, Users: []string{instance.Name}})
+		items[scope] = list
+	}
+	return &compute.DiskAggregatedList{Items: items}, http.StatusOK, nil
+}
+
+func (f *FakeGCE) listAddresses(r *http.Request) (any, int, error) {
+	return &compute.AddressAggregatedList{}, http.StatusOK, nil
+}
+
+func (f *FakeGCE) listFirewalls(r *http.Request) (any, int, error) {
+	return &compute.FirewallList{}, http.StatusOK, nil
+}
+
+func (f *FakeGCE) getOperation(r *http.Request) (any, int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := r.PathValue("name")
+	remaining, ok := f.operations[name]
+	if !ok {
+		return nil, http.StatusNotFound, fmt.Errorf("operation %s not found", name)
+	}
+	if remaining > 0 {
+		f.operations[name] = remaining - 1
+		return &compute.Operation{Name: name, Status: "RUNNING"}, http.StatusOK, nil
+	}
+	return &compute.Operation{Name: name, Status: "DONE"}, http.StatusOK, nil
+}
+
+// runSelfTest 依序執行各個情境，全部跑完後回報失敗的情境
+func runSelfTest(ctx context.Context) error {
+	fake := NewFakeGCE()
+	server := httptest.NewServer(fake.Handler())
+	defer server.Close()
+	provider, err := newGCPProvider("selftest", option.WithEndpoint(server.URL+"/compute/v1/"), option.WithoutAuthentication())
+	if err != nil {
+		return fmt.Errorf("failed to create provider: %v", err)
+	}
+
+	zone := "asia-east1-a"
+	var ip, diskID string
+	steps := []struct {
+		name string
+		run  func() error
+	}{
+		{"list zones of a region", func() error {
+			zones, err := provider.ListZones(ctx, "asia-east1")
+			if err != nil {
+				return err
+			}
+			if len(zones) != 2 {
+				return fmt.Errorf("expected 2 zones, got %v", zones)
+			}
+			return nil
+		}},
+		{"create retries 5xx and polls the operation", func() error {
+			fake.FailNext("instances.insert", http.StatusServiceUnavailable, http.StatusInternalServerError)
+			_, created, err := provider.CreateInstance(ctx, InstanceSpec{Name: "proxy-selftest", Zone: zone, MachineType: "e2-micro"})
+			if err != nil {
+				return err
+			}
+			if calls := fake.Calls("instances.insert"); calls != 3 {
+				return fmt.Errorf("expected 3 insert calls, got %d", calls)
+			}
+			if created.IP == "" || created.DiskID != "proxy-selftest" {
+				return fmt.Errorf("unexpected info: %+v", created)
+			}
+			ip = created.IP
+			return nil
+		}},
+		{"create fails fast on 4xx", func() error {
+			fake.FailNext("instances.insert", http.StatusBadRequest)
+			if _, _, err := provider.CreateInstance(ctx, InstanceSpec{Name: "proxy-bad", Zone: zone, MachineType: "e2-micro"}); err == nil {
+				return fmt.Errorf("expected an error")
+			}
+			return nil
+		}},
+		{"max wait bounds retries", func() error {
+			defer provider.SetMaxWait(0)
+			provider.SetMaxWait(1500 * time.Millisecond)
+			// 第二次失敗後需要等 2 秒，超過剩下的時間
+			fake.FailNext("instances.insert", http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+			_, _, err := provider.CreateInstance(ctx, InstanceSpec{Name: "proxy-slow", Zone: zone, MachineType: "e2-micro"})
+			if !errors.Is(err, errMaxWait) {
+				return fmt.Errorf("expected errMaxWait, got %v", err)
+			}
+			return nil
+		}},
+		{"private instance returns the internal IP", func() error {
+			_, private, err := provider.CreateInstance(ctx, InstanceSpec{Name: "proxy-private", Zone: zone, MachineType: "e2-micro", PrivateOnly: true})
+			if err != nil {
+				return err
+			}
+			if !strings.HasPrefix(private.IP, "10.") {
+				return fmt.Errorf("expected an internal IP, got %s", private.IP)
+			}
+			return nil
+		}},
+		{"list instances by label", func() error {
+			if _, _, err := provider.CreateInstance(ctx, InstanceSpec{Name: "proxy-labeled", Zone: "us-west1-a", MachineType: "e2-micro", Labels: map[string]string{managedLabel: "true"}}); err != nil {
+				return err
+			}
+			instances, err := provider.ListInstances(ctx, managedLabel)
+			if err != nil {
+				return err
+			}
+			if len(instances) != 1 || instances[0].Name != "proxy-labeled" || instances[0].Zone != "us-west1-a" || instances[0].IP == "" {
+				return fmt.Errorf("unexpected instances: %+v", instances)
+			}
+			return nil
+		}},
+		{"list resources reports attached boot disks by label", func() error {
+			resources, err := provider.ListResources(ctx, managedLabel)
+			if err != nil {
+				return err
+			}
+			if len(resources) != 1 || resources[0].Kind != ResourceDisk || resources[0].Name != "proxy-labeled" || !resources[0].InUse {
+				return fmt.Errorf("unexpected resources: %+v", resources)
+			}
+			return nil
+		}},
+		{"get instance info", func() error {
+			info, err := provider.GetInstanceInfo(ctx, zone, "proxy-selftest")
+			if err != nil {
+				return err
+			}
+			if info.IP != ip || info.Status != "RUNNING" || info.DiskID != "proxy-selftest" {
+				return fmt.Errorf("unexpected info: %+v", info)
+			}
+			diskID = info.DiskID
+			return nil
+		}},
+		{"stop and start assigns a new external IP", func() error {
+			fake.FailNext("instances.start", http.StatusServiceUnavailable)
+			if err := provider.StopInstance(ctx, zone, "proxy-selftest"); err != nil {
+				return err
+			}
+			if info, err := provider.GetInstanceInfo(ctx, zone, "proxy-selftest"); err != nil || info.Status != "TERMINATED" {
+				return fmt.Errorf("expected a stopped instance, got %+v, %v", info, err)
+			}
+			if err := provider.StartInstance(ctx, zone, "proxy-selftest"); err != nil {
+				return err
+			}
+			info, err := provider.GetInstanceInfo(ctx, zone, "proxy-selftest")
+			if err != nil {
+				return err
+			}
+			if info.Status != "RUNNING" || info.IP == "" || info.IP == ip {
+				return fmt.Errorf("unexpected info after start: %+v", info)
+			}
+			return nil
+		}},
+		{"delete retries 5xx", func() error {
+			fake.FailNext("instances.delete", http.StatusServiceUnavailable)
+			if err := provider.DeleteInstance(ctx, zone, "proxy-selftest"); err != nil {
+				return err
+			}
+			return provider.DeleteDisk(ctx, zone, diskID)
+		}},
+		{"deleted instance is reported as not found", func() error {
+			_, err := provider.GetInstanceInfo(ctx, zone, "proxy-selftest")
+			if !errors.Is(err, ErrInstanceNotFound) {
+				return fmt.Errorf("expected ErrInstanceNotFound, got %v", err)
+			}
+			return nil
+		}},
+		{"deleting a missing instance is reported as not found", func() error {
+			err := provider.DeleteInstance(ctx, zone, "proxy-selftest")
+			if !errors.Is(err, ErrInstanceNotFound) {
+				return fmt.Errorf("expected ErrInstanceNotFound, got %v", err)
+			}
+			return nil
+		}},
+	}
+
+	failed := 0
+	for _, step := range steps {
+		if err := step.run(); err != nil {
+			failed++
+			fmt.Printf("FAIL %s: %v\n", step.name, err)
+			continue
+		}
+		fmt.Printf("PASS %s\n", step.name)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d self tests failed", failed, len(steps))
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package main
+
+// fastBootOS 快速開機模式預設使用的作業系統
+const fastBootOS = "ubuntu-2204-minimal"
+
+// aptPreseed 不下載翻譯檔與建議套件，並讓下載失敗時自動重試，部署時也會寫入
+const aptPreseed = `Acquire::Languages "none";
+APT::Install-Recommends "false";
+APT::Install-Suggests "false";
+Acquire::Retries "3";`
+
+// fastBootUserData 讓 cloud-init 開機時不更新套件，並預先寫入 apt 設定，SSH 可以更早連線
+var fastBootUserData = `#cloud-config
+package_update: false
+package_upgrade: false
+write_files:
+  - path: /etc/apt/apt.conf.d/90auto-proxy
+    content: |
+` + indent(aptPreseed, 6) + `
+`
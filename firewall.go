@@ -0,0 +1,252 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"path"
+	"slices"
+	"strings"
+)
+
+// FirewallRule 需要開放的一個連接埠，Sources 為空時不限制來源
+type FirewallRule struct {
+	Port     int      `json:"port" yaml:"port"`
+	Protocol string   `json:"protocol" yaml:"protocol"`                   // tcp 或 udp
+	Sources  []string `json:"sources,omitempty" yaml:"sources,omitempty"` // 允許的來源 CIDR
+	Comment  string   `json:"comment,omitempty" yaml:"comment,omitempty"`
+	// Limit 主機的 UFW 以 limit 取代 allow，同一個來源 30 秒內連線超過 6 次時拒絕，雲端防火牆照常開放
+	Limit bool `json:"limit,omitempty" yaml:"limit,omitempty"`
+}
+
+// defaultSSHPort sshd 預設的連接埠，紀錄沒有 SSHPort 時使用
+const defaultSSHPort = 22
+
+// FirewallTemplate 一種 proxy 協定在某個連接埠上需要開放的規則
+// 雲端防火牆與主機上的 UFW 都由同一份 template 產生，新增協定時只需要在這裡加上連接埠
+type FirewallTemplate struct {
+	Protocol string
+	Port     int
+	SSHPort  int
+	Rules    []FirewallRule
+	// Scope 不為空時規則只套用到一台 proxy，限制來源或有額外使用者的 proxy 不能和其他 proxy 共用網路標記
+	Scope string
+	// UserPorts 多使用者模式下各使用者的連接埠，與 Port 同樣依 allowed 限制來源
+	UserPorts []int
+	// KnockPorts 不為空時主機的 UFW 不開放 SSH，knockd 看到這些連接埠的 knock 後才對來源開放
+	// 雲端防火牆需要放行 knock 封包，UFW 不需要
+	KnockPorts []int
+	// CustomRules 以 firewall 指令加入的規則，同時包含在 Rules 中
+	CustomRules []FirewallRule
+}
+
+// firewallTemplates 依 proxy 的連接埠產生 template，連接埠可以在設定檔中修改
+var firewallTemplates = map[string]func(port, sshPort int) FirewallTemplate{
+	"shadowsocks": func(port, sshPort int) FirewallTemplate {
+		return FirewallTemplate{
+			Protocol: "shadowsocks",
+			Port:     port,
+			SSHPort:  sshPort,
+			Rules: []FirewallRule{
+				{Port: sshPort, Protocol: "tcp", Comment: "SSH"},
+				{Port: port, Protocol: "tcp", Comment: "Shadowsocks"},
+				{Port: port, Protocol: "udp", Comment: "Shadowsocks UDP relay"},
+			},
+		}
+	},
+}
+
+// firewallTemplate 回傳協定的 template，sshPort 為 0 時使用預設的連接埠
+func firewallTemplate(protocol string, port, sshPort int) (FirewallTemplate, error) {
+	template, ok := firewallTemplates[protocol]
+	if !ok {
+		return FirewallTemplate{}, fmt.Errorf("no firewall template for protocol: %s", protocol)
+	}
+	if sshPort == 0 {
+		sshPort = defaultSSHPort
+	}
+	return template(port, sshPort), nil
+}
+
+// Tag 套用這個 template 的 instance 的網路標記，雲端防火牆規則以此為目標
+// 不同連接埠的 proxy 使用不同的標記，避免開放其他 proxy 用不到的連接埠
+func (t FirewallTemplate) Tag() string {
+	tag := fmt.Sprintf("auto-proxy-%s-%d", t.Protocol, t.Port)
+	if t.SSHPort != 0 && t.SSHPort != defaultSSHPort {
+		tag += fmt.Sprintf("-ssh%d", t.SSHPort)
+	}
+	if t.Scope != "" {
+		tag += "-" + t.Scope
+	}
+	return tag
+}
+
+// proxyFirewall 回傳一台 proxy 使用的 template，allowed 不為空時 proxy 的連接埠只開放給這些來源
+// scope 以 instance 名稱的雜湊產生，改名後不變，也不會超過網路標記的長度限制
+// 有自訂規則時 scope 也包含規則，規則改變後換成新的網路標記，不再使用的雲端規則由 gc 清除
+func proxyFirewall(protocol string, port, sshPort int, userPorts, knockPorts []int, custom []FirewallRule, allowed []string, instanceID string) (FirewallTemplate, error) {
+	template, err := firewallTemplate(protocol, port, sshPort)
+	if err != nil || (len(allowed) == 0 && len(userPorts) == 0 && len(knockPorts) == 0 && len(custom) == 0) {
+		return template, err
+	}
+	template.UserPorts = userPorts
+	template.KnockPorts = knockPorts
+	template.CustomRules = custom
+	key := instanceID
+	if len(custom) > 0 {
+		key += "\n" + template.customMarker()
+	}
+	sum := sha256.Sum256([]byte(key))
+	template.Scope = hex.EncodeToString(sum[:4])
+	rules := make([]FirewallRule, 0, len(template.Rules)+2*len(userPorts)+len(knockPorts)+len(custom))
+	for _, rule := range template.Rules {
+		rules = append(rules, rule)
+		if rule.Port != port {
+			continue
+		}
+		for _, userPort := range userPorts {
+			rules = append(rules, FirewallRule{Port: userPort, Protocol: rule.Protocol, Comment: rule.Comment + " user"})
+		}
+	}
+	for i, rule := range rules {
+		if template.restricted(rule) {
+			rules[i].Sources = allowed
+		}
+	}
+	for _, knockPort := range knockPorts {
+		rules = append(rules, FirewallRule{Port: knockPort, Protocol: "tcp", Comment: "SSH knock"})
+	}
+	template.Rules = append(rules, custom...)
+	return template, nil
+}
+
+// restricted 回傳規則是否為 proxy 的連接埠，這些規則依 allowed 限制來源
+func (t FirewallTemplate) restricted(rule FirewallRule) bool {
+	return rule.Port == t.Port || slices.Contains(t.UserPorts, rule.Port)
+}
+
+// hostRule 回傳規則是否需要在主機的 UFW 開放，knock 模式下 SSH 由 knockd 開放，knock 封包不需要開放
+func (t FirewallTemplate) hostRule(rule FirewallRule) bool {
+	if len(t.KnockPorts) == 0 {
+		return true
+	}
+	return rule.Port != t.SSHPort && !slices.Contains(t.KnockPorts, rule.Port)
+}
+
+// customRulesFile 記錄主機上目前的自訂規則，每行 action,port,protocol,source，重新部署時據此移除不再存在的規則
+const customRulesFile = "/etc/auto-proxy/custom-rules"
+
+// customMarker 回傳寫入 customRulesFile 的內容，沒有自訂規則時為空字串
+func (t FirewallTemplate) customMarker() string {
+	var b strings.Builder
+	for _, rule := range t.CustomRules {
+		sources := rule.Sources
+		if len(sources) == 0 {
+			sources = []string{"any"}
+		}
+		for _, source := range sources {
+			fmt.Fprintf(&b, "%s,%d,%s,%s\n", rule.action(), rule.Port, rule.Protocol, source)
+		}
+	}
+	return b.String()
+}
+
+// action 回傳規則在 UFW 使用的動作
+func (r FirewallRule) action() string {
+	if r.Limit {
+		return "limit"
+	}
+	return "allow"
+}
+
+// parseSource 將 IP 或 CIDR 轉成 CIDR，單一 IP 視為 /32 或 /128
+func parseSource(s string) (string, error) {
+	if prefix, err := netip.ParsePrefix(s); err == nil {
+		return prefix.Masked().String(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid IP or CIDR: %s", s)
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()).String(), nil
+}
+
+// ufwSourcesFile 記錄主機上 proxy 連接埠目前開放的來源，重新部署時據此移除不再允許的來源
+const ufwSourcesFile = "/etc/auto-proxy/allowed-sources"
+
+// ufwSources 回傳寫入 ufwSourcesFile 的內容，沒有限制來源時為 any
+func (t FirewallTemplate) ufwSources() string {
+	for _, rule := range t.Rules {
+		if rule.Port == t.Port && len(rule.Sources) > 0 {
+			return strings.Join(rule.Sources, "\n") + "\n"
+		}
+	}
+	return "any\n"
+}
+
+// ufwTasks 產生 playbook 中設定 UFW 的 tasks，縮排對齊 tasks 清單
+func (t FirewallTemplate) ufwTasks() string {
+	var b strings.Builder
+	b.WriteString("    - name: Configure UFW\n      block:\n")
+	// 先移除不在目前清單中的來源，沒有紀錄檔的主機是限制來源之前部署的，proxy 連接埠對所有來源開放
+	sources := t.ufwSources()
+	b.WriteString("        - name: Remove sources no longer allowed\n          shell: |\n")
+	fmt.Fprintf(&b, "            for source in $(cat %s 2>/dev/null || echo any); do\n", ufwSourcesFile)
+	fmt.Fprintf(&b, "              case \" %s \" in *\" $source \"*) continue ;; esac\n", strings.Join(strings.Fields(sources), " "))
+	for _, rule := range t.Rules {
+		if t.restricted(rule) {
+			fmt.Fprintf(&b, "              ufw delete allow from \"$source\" to any port %d proto %s || true\n", rule.Port, rule.Protocol)
+		}
+	}
+	b.WriteString("            done\n")
+	custom := t.customMarker()
+	b.WriteString("        - name: Remove custom rules no longer configured\n          shell: |\n")
+	fmt.Fprintf(&b, "            for entry in $(cat %s 2>/dev/null); do\n", customRulesFile)
+	fmt.Fprintf(&b, "              case \" %s \" in *\" $entry \"*) continue ;; esac\n", strings.Join(strings.Fields(custom), " "))
+	b.WriteString("              set -- $(echo \"$entry\" | tr , ' ')\n")
+	b.WriteString("              ufw delete \"$1\" from \"$4\" to any port \"$2\" proto \"$3\" || true\n")
+	b.WriteString("            done\n")
+	if len(t.KnockPorts) > 0 {
+		// 之前開放給所有來源的 SSH 規則，knockd 加入的規則限定來源，不受影響
+		fmt.Fprintf(&b, "        - name: Close SSH until knocked\n          ufw:\n            rule: allow\n            port: '%d'\n            proto: tcp\n            delete: yes\n", t.SSHPort)
+	}
+	for _, rule := range t.Rules {
+		if !t.hostRule(rule) {
+			continue
+		}
+		sources := rule.Sources
+		if len(sources) == 0 {
+			sources = []string{"any"}
+		}
+		for _, source := range sources {
+			fmt.Fprintf(&b, "        - name: Allow %s (%d/%s from %s)\n", rule.Comment, rule.Port, rule.Protocol, source)
+			fmt.Fprintf(&b, "          ufw:\n            rule: %s\n            port: '%d'\n            proto: %s\n            from_ip: %s\n", rule.action(), rule.Port, rule.Protocol, source)
+		}
+	}
+	fmt.Fprintf(&b, "        - name: Create auto_proxy state directory\n          file:\n            path: %s\n            state: directory\n            mode: '0755'\n", path.Dir(ufwSourcesFile))
+	fmt.Fprintf(&b, "        - name: Record allowed sources\n          copy:\n            content: |\n%s\n            dest: %s\n", indent(strings.TrimSuffix(sources, "\n"), 14), ufwSourcesFile)
+	b.WriteString("        - name: Record custom rules\n          copy:\n")
+	if custom != "" {
+		b.WriteString("            content: |\n" + indent(strings.TrimSuffix(custom, "\n"), 14) + "\n")
+	} else {
+		b.WriteString("            content: \"\"\n")
+	}
+	b.WriteString("            dest: " + customRulesFile + "\n")
+	b.WriteString("        - name: Enable UFW\n          ufw:\n            state: enabled\n")
+	b.WriteString("      tags: [config]\n")
+	return b.String()
+}
+
+// sourceGroups 依來源把規則分組，同一組的連接埠可以放在同一條雲端防火牆規則
+func (t FirewallTemplate) sourceGroups() (keys []string, groups map[string][]FirewallRule) {
+	groups = make(map[string][]FirewallRule)
+	for _, rule := range t.Rules {
+		key := strings.Join(rule.Sources, ",")
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], rule)
+	}
+	return keys, groups
+}
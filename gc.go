@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/AlecAivazis/survey/v2"
+)
+
+// GC 刪除沒有被任何 proxy 使用的雲端資源：沒有掛載的開機磁碟、沒有配置的靜態 IP，
+// 以及沒有紀錄或 instance 使用其網路標記的防火牆規則。刪除失敗留下的 disk 紀錄也一併清除
+// yes 時不詢問直接刪除
+func (c *Commander) GC(ctx context.Context, yes bool) error {
+	records, err := c.recordManager.Load()
+	if err != nil {
+		return fmt.Errorf("error loading records: %v", err)
+	}
+	var instances []CloudInstance
+	var resources []CloudResource
+	err = withSpinner(tr("Listing cloud resources"), func() error {
+		if instances, err = c.provider.ListInstances(ctx, managedLabel); err != nil {
+			return err
+		}
+		resources, err = c.provider.ListResources(ctx, managedLabel)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error listing resources: %v", err)
+	}
+	orphans := findOrphans(records, instances, resources)
+	if len(orphans) == 0 {
+		fmt.Println(tr("No orphaned resources found."))
+		return nil
+	}
+
+	fmt.Println(tr("The following resources are not used by any proxy:"))
+	for _, o := range orphans {
+		if o.Location == "" {
+			fmt.Printf(" - %s %s\n", o.Kind, o.Name)
+			continue
+		}
+		fmt.Printf(" - %s %s (%s)\n", o.Kind, o.Name, o.Location)
+	}
+	if c.dryRun {
+		dryRunf("Would delete %d resources\n", len(orphans))
+		return nil
+	}
+	if !yes {
+		confirmed := false
+		if err := survey.AskOne(&survey.Confirm{Message: fmt.Sprintf(tr("Delete %d resources?"), len(orphans))}, &confirmed); err != nil {
+			return fmt.Errorf("confirmation failed (use --yes to skip it): %v", err)
+		}
+		if !confirmed {
+			fmt.Println(tr("Cancelled."))
+			return nil
+		}
+	}
+
+	deleted, failed := 0, 0
+	var deletedDisks []CloudResource
+	for _, o := range orphans {
+		err := withSpinner(fmt.Sprintf(tr("Deleting %s %s"), o.Kind, o.Name), func() error {
+			return c.provider.DeleteResource(ctx, o)
+		})
+		if o.Kind == ResourceDisk && errors.Is(err, ErrDiskNotFound) {
+			err = nil
+		}
+		if err != nil {
+			c.logger.Printf("Error deleting %s %s: %v", o.Kind, o.Name, err)
+			fmt.Printf(tr("Failed to delete %s %s: %v\n"), o.Kind, o.Name, err)
+			failed++
+			continue
+		}
+		c.logger.Printf("Deleted orphaned %s %s", o.Kind, o.Name)
+		if o.Kind == ResourceDisk {
+			deletedDisks = append(deletedDisks, o)
+		}
+		deleted++
+	}
+
+	if len(deletedDisks) > 0 {
+		err = c.recordManager.Update(func(records []ProxyRecord) ([]ProxyRecord, error) {
+			kept := records[:0]
+			for _, r := range records {
+				if r.Type == "disk" && containsResource(deletedDisks, CloudResource{Kind: ResourceDisk, Name: r.InstanceID, Location: r.Zone}) {
+					continue
+				}
+				kept = append(kept, r)
+			}
+			return kept, nil
+		})
+		if err != nil {
+			return fmt.Errorf("error saving records: %v", err)
+		}
+	}
+	fmt.Printf(tr("Deleted %d resources.\n"), deleted)
+	if failed > 0 {
+		return fmt.Errorf("failed to delete %d resources", failed)
+	}
+	return nil
+}
+
+// releaseFirewall 刪除 proxy 被刪除後不再有紀錄或 instance 使用的防火牆規則
+// 同時刪除共用同一個網路標記的多台 proxy 時可能都還看得到彼此的紀錄，留下的規則由 gc 清除
+func (c *Commander) releaseFirewall(ctx context.Context, deleted ProxyRecord) {
+	template, err := deleted.Firewall()
+	if err != nil {
+		return
+	}
+	records, err := c.recordManager.Load()
+	if err != nil {
+		c.logger.Printf("Error loading records: %v", err)
+		return
+	}
+	instances, err := c.provider.ListInstances(ctx, managedLabel)
+	if err != nil {
+		c.logger.Printf("Error listing instances: %v", err)
+		return
+	}
+	resources, err := c.provider.ListResources(ctx, managedLabel)
+	if err != nil {
+		c.logger.Printf("Error listing firewall rules: %v", err)
+		return
+	}
+	resources = slices.DeleteFunc(resources, func(r CloudResource) bool {
+		return r.Kind != ResourceFirewall || r.Tag != template.Tag()
+	})
+	for _, rule := range findOrphans(records, instances, resources) {
+		if rule.Kind != ResourceFirewall {
+			continue
+		}
+		if err := c.provider.DeleteResource(ctx, rule); err != nil {
+			c.logger.Printf("Error deleting firewall rule %s: %v", rule.Name, err)
+			fmt.Printf(tr("Failed to delete firewall rule %s, run `auto_proxy gc` to retry\n"), rule.Name)
+			continue
+		}
+		chatf(tr("Firewall rule %s deleted\n"), rule.Name)
+	}
+}
+
+// findOrphans 從 resources 中挑出沒有被使用的資源，加上 disk 紀錄追蹤的磁碟
+// 舊版建立的磁碟沒有 label，只能從 disk 紀錄找到
+func findOrphans(records []ProxyRecord, instances []CloudInstance, resources []CloudResource) []CloudResource {
+	tags := make(map[string]bool)
+	for _, instance := range instances {
+		for _, tag := range instance.Tags {
+			tags[tag] = true
+		}
+	}
+	for _, r := range records {
+		if r.Type != "instance" || !r.Managed() {
+			continue
+		}
+		if template, err := r.Firewall(); err == nil {
+			tags[template.Tag()] = true
+		}
+	}
+
+	var orphans []CloudResource
+	for _, res := range resources {
+		switch res.Kind {
+		case ResourceFirewall:
+			// 沒有目標標記的規則套用到所有 instance，不視為沒有使用
+			if res.Tag == "" || tags[res.Tag] {
+				continue
+			}
+		default:
+			if res.InUse {
+				continue
+			}
+		}
+		orphans = append(orphans, res)
+	}
+	for _, r := range records {
+		if r.Type != "disk" {
+			continue
+		}
+		disk := CloudResource{Kind: ResourceDisk, Name: r.InstanceID, Location: r.Zone}
+		// 帶有 label 的磁碟已經依是否掛載處理過
+		if !containsResource(resources, disk) {
+			orphans = append(orphans, disk)
+		}
+	}
+	return orphans
+}
+
+func containsResource(resources []CloudResource, target CloudResource) bool {
+	for _, r := range resources {
+		if r.Kind == target.Kind && r.Name == target.Name && r.Location == target.Location {
+			return true
+		}
+	}
+	return false
+}
@@ -3,6 +3,10 @@ package main
 import (
 	"context"
 	"fmt"
+	"os/exec"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -11,63 +15,45 @@ import (
 	"google.golang.org/api/option"
 )
 
-var gcp_locations = map[string]string{
-    "africa-south1": "約翰尼斯堡",
-    "asia-east1": "台灣",
-    "asia-east2": "香港",
-    "asia-northeast1": "東京",
-    "asia-northeast2": "大阪",
-    "asia-northeast3": "首爾",
-    "asia-south1": "孟買",
-    "asia-south2": "德里",
-    "asia-southeast1": "新加坡",
-    "asia-southeast2": "雅加達",
-    "australia-southeast1": "雪梨",
-    "australia-southeast2": "墨爾本",
-    "europe-central2": "華沙",
-    "europe-north1": "芬蘭",
-    "europe-north2": "斯德哥爾摩",
-    "europe-southwest1": "馬德里",
-    "europe-west1": "比利時",
-    "europe-west10": "柏林",
-    "europe-west12": "杜林",
-    "europe-west2": "倫敦",
-    "europe-west3": "法蘭克福",
-    "europe-west4": "荷蘭",
-    "europe-west6": "蘇黎世",
-    "europe-west8": "米蘭",
-    "europe-west9": "巴黎",
-    "me-central1": "杜哈",
-    "me-central2": "達曼",
-    "me-west1": "特拉維夫",
-    "northamerica-northeast1": "蒙特婁",
-    "northamerica-northeast2": "多倫多",
-    "northamerica-south1": "墨西哥",
-    "southamerica-east1": "聖保羅",
-    "southamerica-west1": "聖地牙哥",
-    "us-central1": "愛荷華州",
-    "us-east1": "南卡羅來納州",
-    "us-east4": "北維吉尼亞州",
-    "us-east5": "哥倫布",
-    "us-south1": "達拉斯",
-    "us-west1": "奧勒岡州",
-    "us-west2": "洛杉磯",
-    "us-west3": "鹽湖城",
-    "us-west4": "拉斯維加斯",
-}
-
 type GCPProvider struct {
 	service *compute.Service
 	project string
+	retry   RetryPolicy
+	// clientOpts 建立其他 Google API (例如 Cloud Billing) 的 client 時沿用相同的憑證
+	clientOpts []option.ClientOption
 }
 
+// NewGCPProvider credsPath 為空時使用 Application Default Credentials，
+// 也就是 GOOGLE_APPLICATION_CREDENTIALS、gcloud auth application-default login 或 GCE 的服務帳戶
 func NewGCPProvider(project string, credsPath string) (*GCPProvider, error) {
+	if credsPath == "" {
+		provider, err := newGCPProvider(project)
+		if err != nil {
+			return nil, fmt.Errorf("no GCP credentials found, run `gcloud auth application-default login` or set gcp.credentials: %v", err)
+		}
+		return provider, nil
+	}
+	credentials, err := gcpCredentialsOption(credsPath)
+	if err != nil {
+		return nil, err
+	}
+	return newGCPProvider(project, credentials)
+}
+
+// newGCPProvider 讓測試工具可以指向假的 Compute API 端點
+func newGCPProvider(project string, opts ...option.ClientOption) (*GCPProvider, error) {
 	ctx := context.Background()
-	svc, err := compute.NewService(ctx, option.WithCredentialsFile(credsPath))
+	opts = append(opts, option.WithUserAgent(userAgent()))
+	svc, err := compute.NewService(ctx, opts...)
 	if err != nil {
 		return nil, err
 	}
-	return &GCPProvider{service: svc, project: project}, nil
+	return &GCPProvider{service: svc, project: project, retry: defaultRetryPolicy, clientOpts: opts}, nil
+}
+
+// SetMaxWait 限制單一雲端操作花在重試與等待完成的總時間，0 表示不限制
+func (g *GCPProvider) SetMaxWait(d time.Duration) {
+	g.retry.MaxWait = d
 }
 
 func (g *GCPProvider) ListRegions(ctx context.Context) ([]string, error) {
@@ -112,15 +98,93 @@ func (g *GCPProvider) RecommendedType() string {
 	return "e2-micro"
 }
 
-func (g *GCPProvider) CreateInstance(ctx context.Context, name, zone, machineType string) (string, string, error) {
+// gcpFreeTierRegions Always Free 方案的 e2-micro 只適用於這些 region，每個帳號一台
+var gcpFreeTierRegions = []string{"us-west1", "us-central1", "us-east1"}
+
+func (g *GCPProvider) FreeTier(region, machineType string) bool {
+	return machineType == "e2-micro" && slices.Contains(gcpFreeTierRegions, region)
+}
+
+const defaultOSImage = "ubuntu-2204"
+
+// osImages 可以選擇的作業系統映像檔，依 CPU 架構區分
+var osImages = map[string]map[string]string{
+	"ubuntu-2204": {
+		ArchAMD64: "projects/ubuntu-os-cloud/global/images/family/ubuntu-2204-lts",
+		ArchARM64: "projects/ubuntu-os-cloud/global/images/family/ubuntu-2204-lts-arm64",
+	},
+	"ubuntu-2404": {
+		ArchAMD64: "projects/ubuntu-os-cloud/global/images/family/ubuntu-2404-lts-amd64",
+		ArchARM64: "projects/ubuntu-os-cloud/global/images/family/ubuntu-2404-lts-arm64",
+	},
+	// minimal 映像檔少了大部分預載套件，開機與安裝都比較快
+	"ubuntu-2204-minimal": {
+		ArchAMD64: "projects/ubuntu-os-cloud/global/images/family/ubuntu-minimal-2204-lts",
+		ArchARM64: "projects/ubuntu-os-cloud/global/images/family/ubuntu-minimal-2204-lts-arm64",
+	},
+	"ubuntu-2404-minimal": {
+		ArchAMD64: "projects/ubuntu-os-cloud/global/images/family/ubuntu-minimal-2404-lts-amd64",
+		ArchARM64: "projects/ubuntu-os-cloud/global/images/family/ubuntu-minimal-2404-lts-arm64",
+	},
+	"debian-12": {
+		ArchAMD64: "projects/debian-cloud/global/images/family/debian-12",
+		ArchARM64: "projects/debian-cloud/global/images/family/debian-12-arm64",
+	},
+}
+
+func supportedOSImages() []string {
+	names := make([]string, 0, len(osImages))
+	for name := range osImages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolveOSImage 回傳作業系統在指定架構下的映像檔路徑
+func resolveOSImage(name, arch string) (string, bool) {
+	images, ok := osImages[name]
+	if !ok {
+		return "", false
+	}
+	if arch == "" {
+		arch = ArchAMD64
+	}
+	image, ok := images[arch]
+	return image, ok
+}
+
+// armMachinePrefixes GCP 上使用 ARM (Ampere / Axion) CPU 的機器系列
+var armMachinePrefixes = []string{"t2a-", "c4a-"}
+
+func (g *GCPProvider) MachineArch(machineType string) string {
+	for _, prefix := range armMachinePrefixes {
+		if strings.HasPrefix(machineType, prefix) {
+			return ArchARM64
+		}
+	}
+	return ArchAMD64
+}
+
+func (g *GCPProvider) CreateInstance(ctx context.Context, spec InstanceSpec) (string, InstanceInfo, error) {
+	name, zone, machineType := spec.Name, spec.Zone, spec.MachineType
+	// Image 可以是完整的映像檔路徑，或是專案內預先安裝好的映像檔名稱
+	sourceImage, _ := resolveOSImage(defaultOSImage, g.MachineArch(machineType))
+	if strings.Contains(spec.Image, "/") {
+		sourceImage = spec.Image
+	} else if spec.Image != "" {
+		sourceImage = fmt.Sprintf("projects/%s/global/images/%s", g.project, spec.Image)
+	}
 	instance := &compute.Instance{
 		Name: name,
 		MachineType: fmt.Sprintf("zones/%s/machineTypes/%s", zone, machineType),
 		Disks: []*compute.AttachedDisk{
 			{
 				Boot: true,
+				// 開機磁碟帶有和 instance 相同的 labels，gc 以此找出留下來的磁碟
 				InitializeParams: &compute.AttachedDiskInitializeParams{
-					SourceImage: "projects/ubuntu-os-cloud/global/images/family/ubuntu-2204-lts",
+					SourceImage: sourceImage,
+					Labels:      spec.Labels,
 				},
 			},
 		},
@@ -130,129 +194,558 @@ func (g *GCPProvider) CreateInstance(ctx context.Context, name, zone, machineTyp
 			},
 		},
 	}
+	if spec.PrivateOnly {
+		instance.NetworkInterfaces[0].AccessConfigs = nil
+	}
+	if len(spec.NetworkTags) > 0 {
+		instance.Tags = &compute.Tags{Items: spec.NetworkTags}
+	}
+	if len(spec.Labels) > 0 {
+		instance.Labels = spec.Labels
+	}
+	if len(spec.Metadata) > 0 {
+		instance.Metadata = &compute.Metadata{}
+		for k, v := range spec.Metadata {
+			value := v
+			instance.Metadata.Items = append(instance.Metadata.Items, &compute.MetadataItems{Key: k, Value: &value})
+		}
+	}
 
-	maxRetries := 5
-	for attempt := range maxRetries {
-		op, err := g.service.Instances.Insert(g.project, zone, instance).Do()
+	ctx, cancel := g.retry.bound(ctx)
+	defer cancel()
+	for attempt := 0; ; attempt++ {
+		debugf("compute.instances.insert %s/%s", zone, name)
+		op, err := g.service.Instances.Insert(g.project, zone, instance).Context(ctx).Do()
 		if err == nil {
-			for {
-				operation, err := g.service.ZoneOperations.Get(g.project, zone, op.Name).Context(ctx).Do()
-				if err != nil {
-					return "", "", fmt.Errorf("failed to check operation status: %v", err)
-				}
-				if operation.Status == "DONE" {
-					if operation.Error != nil {
-						return "", "", fmt.Errorf("operation failed: %v", operation.Error)
-					}
-					break
-				}
-				fmt.Printf("Waiting for instance creation (%s)...\n", operation.Status)
-				time.Sleep(2 * time.Second)
+			if err := g.waitZoneOperation(ctx, zone, op.Name, tr("instance creation")); err != nil {
+				return "", InstanceInfo{}, err
 			}
-
 			instanceInfo, err := g.service.Instances.Get(g.project, zone, name).Context(ctx).Do()
 			if err != nil {
-				return "", "", fmt.Errorf("failed to get instance info: %v", err)
+				return "", InstanceInfo{}, fmt.Errorf("failed to get instance info: %v", err)
 			}
-			ip := instanceInfo.NetworkInterfaces[0].AccessConfigs[0].NatIP
-			return name, ip, nil
+			return name, toInstanceInfo(instanceInfo), nil
 		}
-
-		if gerr, ok := err.(*googleapi.Error); ok && gerr.Code >= 500 {
-			wait := time.Duration(1<<uint(attempt)) * time.Second
-			fmt.Printf("Create retryable error: (%d/%d): %v, waiting %v\n", attempt+1, maxRetries, err, wait)
-			time.Sleep(wait)
-			continue
+		if !retryableError(err) {
+			return "", InstanceInfo{}, fmt.Errorf("non-retryable error: %v", err)
+		}
+		if attempt+1 >= g.retry.MaxAttempts {
+			return "", InstanceInfo{}, fmt.Errorf("failed to create instance after %d attempts: %v", attempt+1, err)
+		}
+		if err := g.retry.backoff(ctx, tr("Create instance"), attempt, err); err != nil {
+			return "", InstanceInfo{}, err
 		}
-		return "", "", fmt.Errorf("non-retryable error: %v", err)
 	}
-	return "", "", fmt.Errorf("failed to create instance after %d retries", maxRetries)
 }
 
 func (g *GCPProvider) DeleteInstance(ctx context.Context, zone, instanceID string) error {
-	fmt.Printf("Attempting to delete instance %s in zone %s\n", instanceID, zone)
-	maxRetries := 5
-	for attempt := range maxRetries {
+	chatf(tr("Attempting to delete instance %s in zone %s\n"), instanceID, zone)
+	ctx, cancel := g.retry.bound(ctx)
+	defer cancel()
+	for attempt := 0; ; attempt++ {
+		debugf("compute.instances.delete %s/%s", zone, instanceID)
 		op, err := g.service.Instances.Delete(g.project, zone, instanceID).Context(ctx).Do()
 		if err == nil {
-			for {
-				operation, err := g.service.ZoneOperations.Get(g.project, zone, op.Name).Context(ctx).Do()
-				if err != nil {
-					return fmt.Errorf("failed to check delete operation status: %v", err)
-				}
-				if operation.Status == "DONE" {
-					if operation.Error != nil {
-						return fmt.Errorf("delete operation failed: %v", operation.Error)
-					}
-					fmt.Printf("Instance %s deleted successfully\n", instanceID)
-					return nil
-				}
-				fmt.Printf("Waiting for instance deletion (%s)...\n", operation.Status)
-				time.Sleep(2 * time.Second)
+			if err := g.waitZoneOperation(ctx, zone, op.Name, tr("instance deletion")); err != nil {
+				return err
 			}
+			chatf(tr("Instance %s deleted successfully\n"), instanceID)
+			return nil
+		}
+		if gerr, ok := err.(*googleapi.Error); ok && gerr.Code == 404 {
+			return fmt.Errorf("instance %s: %w", instanceID, ErrInstanceNotFound)
+		}
+		if !retryableError(err) {
+			return fmt.Errorf("non-retryable error: %v", err)
+		}
+		if attempt+1 >= g.retry.MaxAttempts {
+			return fmt.Errorf("failed to delete instance after %d attempts: %v", attempt+1, err)
 		}
+		if err := g.retry.backoff(ctx, tr("Delete instance"), attempt, err); err != nil {
+			return err
+		}
+	}
+}
 
-		if gerr, ok := err.(*googleapi.Error); ok && gerr.Code >= 500 {
-			wait := time.Duration(1<<uint(attempt)) * time.Second
-			fmt.Printf("Delete retryable error (%d/%d): %v, waiting %v, waiting %v\n", attempt+1, maxRetries, err, wait, wait)
-			time.Sleep(wait)
-			continue
+// StopInstance 停止 instance，開機磁碟保留，臨時的外部 IP 會被釋放
+func (g *GCPProvider) StopInstance(ctx context.Context, zone, instanceID string) error {
+	return g.setPower(ctx, zone, instanceID, func(ctx context.Context) (*compute.Operation, error) {
+		debugf("compute.instances.stop %s/%s", zone, instanceID)
+		return g.service.Instances.Stop(g.project, zone, instanceID).Context(ctx).Do()
+	}, tr("instance stop"))
+}
+
+// StartInstance 啟動已經停止的 instance，外部 IP 可能和停止前不同
+func (g *GCPProvider) StartInstance(ctx context.Context, zone, instanceID string) error {
+	return g.setPower(ctx, zone, instanceID, func(ctx context.Context) (*compute.Operation, error) {
+		debugf("compute.instances.start %s/%s", zone, instanceID)
+		return g.service.Instances.Start(g.project, zone, instanceID).Context(ctx).Do()
+	}, tr("instance start"))
+}
+
+// setPower 呼叫 stop 或 start 並等待 operation 完成，暫時性的錯誤會重試
+func (g *GCPProvider) setPower(ctx context.Context, zone, instanceID string, call func(context.Context) (*compute.Operation, error), what string) error {
+	ctx, cancel := g.retry.bound(ctx)
+	defer cancel()
+	for attempt := 0; ; attempt++ {
+		op, err := call(ctx)
+		if err == nil {
+			return g.waitZoneOperation(ctx, zone, op.Name, what)
+		}
+		if gerr, ok := err.(*googleapi.Error); ok && gerr.Code == 404 {
+			return fmt.Errorf("instance %s: %w", instanceID, ErrInstanceNotFound)
+		}
+		if !retryableError(err) {
+			return fmt.Errorf("non-retryable error: %v", err)
+		}
+		if attempt+1 >= g.retry.MaxAttempts {
+			return fmt.Errorf("%s failed after %d attempts: %v", what, attempt+1, err)
+		}
+		if err := g.retry.backoff(ctx, what, attempt, err); err != nil {
+			return err
 		}
-		return fmt.Errorf("non-retryable error: %v", err)
 	}
-	return fmt.Errorf("failed to delete instance after %d retries", maxRetries)
 }
 
 func (g *GCPProvider) DeleteDisk(ctx context.Context, zone, diskID string) error {
-	fmt.Printf("attempting to delete disk %s in zone %s\n", diskID, zone)
-	maxRetries := 5
-	for attempt := range maxRetries {
+	chatf(tr("attempting to delete disk %s in zone %s\n"), diskID, zone)
+	ctx, cancel := g.retry.bound(ctx)
+	defer cancel()
+	for attempt := 0; ; attempt++ {
+		debugf("compute.disks.delete %s/%s", zone, diskID)
 		op, err := g.service.Disks.Delete(g.project, zone, diskID).Context(ctx).Do()
 		if err == nil {
-			for {
-				operation, err := g.service.ZoneOperations.Get(g.project, zone, op.Name).Context(ctx).Do()
-				if err != nil {
-					return fmt.Errorf("failed to check disk delete operation status: %v", err)
-				}
-				if operation.Status == "DONE" {
-					if operation.Error != nil {
-						return fmt.Errorf("disk delete operation failed: %v", operation.Error)
-					}
-					fmt.Printf("Disk %s deleted successfully\n", diskID)
-					return nil 
-				}
-				fmt.Printf("Waiting for disk deletion (%s)...\n", operation.Status)
-				time.Sleep(2 * time.Second)
+			if err := g.waitZoneOperation(ctx, zone, op.Name, tr("disk deletion")); err != nil {
+				return err
+			}
+			chatf(tr("Disk %s deleted successfully\n"), diskID)
+			return nil
+		}
+		if gerr, ok := err.(*googleapi.Error); ok && gerr.Code == 404 {
+			return fmt.Errorf("disk %s: %w", diskID, ErrDiskNotFound)
+		}
+		if !retryableError(err) {
+			return fmt.Errorf("non-retryable error deleting disk: %v", err)
+		}
+		if attempt+1 >= g.retry.MaxAttempts {
+			return fmt.Errorf("failed to delete disk after %d attempts: %v", attempt+1, err)
+		}
+		if err := g.retry.backoff(ctx, tr("Delete disk"), attempt, err); err != nil {
+			return err
+		}
+	}
+}
+
+// retryableError 回傳是否為 GCP 暫時性的錯誤
+func retryableError(err error) bool {
+	gerr, ok := err.(*googleapi.Error)
+	return ok && gerr.Code >= 500
+}
+
+// waitZoneOperation 等待 zone operation 完成，ctx 套用 --max-wait 時超過時間會提前結束
+func (g *GCPProvider) waitZoneOperation(ctx context.Context, zone, opName, desc string) error {
+	for {
+		debugf("compute.zoneOperations.get %s/%s", zone, opName)
+		operation, err := g.service.ZoneOperations.Get(g.project, zone, opName).Context(ctx).Do()
+		if err != nil {
+			if cause := context.Cause(ctx); cause != nil {
+				return fmt.Errorf("waiting for %s: %w", desc, cause)
+			}
+			return fmt.Errorf("failed to check %s operation status: %v", desc, err)
+		}
+		if operation.Status == "DONE" {
+			if operation.Error != nil {
+				return fmt.Errorf("%s operation failed: %v", desc, operation.Error)
+			}
+			return nil
+		}
+		fmt.Fprintf(chatter(LevelVerbose), tr("Waiting for %s (%s)...\n"), desc, operation.Status)
+		if err := sleepContext(ctx, 2*time.Second); err != nil {
+			return fmt.Errorf("waiting for %s: %w", desc, err)
+		}
+	}
+}
+
+// instanceIP 回傳外部 IP，沒有外部 IP 的機器回傳內部 IP
+func instanceIP(instance *compute.Instance) string {
+	if len(instance.NetworkInterfaces) == 0 {
+		return ""
+	}
+	nic := instance.NetworkInterfaces[0]
+	if len(nic.AccessConfigs) > 0 && nic.AccessConfigs[0].NatIP != "" {
+		return nic.AccessConfigs[0].NatIP
+	}
+	return nic.NetworkIP
+}
+
+// EnsureFirewall 建立或更新 template 對應的防火牆規則，規則以網路標記套用到 instance
+// 來源不同的連接埠分成多條規則，第一條規則名稱與標記相同
+func (g *GCPProvider) EnsureFirewall(ctx context.Context, template FirewallTemplate) error {
+	keys, groups := template.sourceGroups()
+	for i, key := range keys {
+		name := template.Tag()
+		if i > 0 {
+			name = fmt.Sprintf("%s-%d", name, i)
+		}
+		firewall := &compute.Firewall{
+			Name:         name,
+			Description:  fmt.Sprintf("Managed by auto_proxy for %s proxies", template.Protocol),
+			Network:      "global/networks/default",
+			Direction:    "INGRESS",
+			TargetTags:   []string{template.Tag()},
+			SourceRanges: []string{"0.0.0.0/0"},
+		}
+		if key != "" {
+			firewall.SourceRanges = strings.Split(key, ",")
+		}
+		ports := make(map[string][]string)
+		var protocols []string
+		for _, rule := range groups[key] {
+			if _, ok := ports[rule.Protocol]; !ok {
+				protocols = append(protocols, rule.Protocol)
+			}
+			ports[rule.Protocol] = append(ports[rule.Protocol], strconv.Itoa(rule.Port))
+		}
+		for _, protocol := range protocols {
+			firewall.Allowed = append(firewall.Allowed, &compute.FirewallAllowed{IPProtocol: protocol, Ports: ports[protocol]})
+		}
+
+		var op *compute.Operation
+		debugf("compute.firewalls.get %s", name)
+		existing, err := g.service.Firewalls.Get(g.project, name).Context(ctx).Do()
+		if gerr, ok := err.(*googleapi.Error); ok && gerr.Code == 404 {
+			op, err = g.service.Firewalls.Insert(g.project, firewall).Context(ctx).Do()
+			// 同時建立多台 proxy 時其他 goroutine 可能已經建立
+			if gerr, ok := err.(*googleapi.Error); ok && gerr.Code == 409 {
+				continue
+			}
+		} else if err == nil {
+			if sameFirewall(existing, firewall) {
+				continue
+			}
+			op, err = g.service.Firewalls.Update(g.project, name, firewall).Context(ctx).Do()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to ensure firewall rule %s: %v", name, err)
+		}
+		if err := g.waitGlobalOperation(ctx, op.Name, tr("firewall update")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sameFirewall(a, b *compute.Firewall) bool {
+	describe := func(f *compute.Firewall) string {
+		var allowed []string
+		for _, rule := range f.Allowed {
+			allowed = append(allowed, rule.IPProtocol+":"+strings.Join(rule.Ports, ","))
+		}
+		return fmt.Sprint(allowed, f.SourceRanges, f.TargetTags)
+	}
+	return describe(a) == describe(b)
+}
+
+// SetInstanceLabels 以 instance 目前的 label fingerprint 更新 labels，保留其他既有的 labels
+func (g *GCPProvider) SetInstanceLabels(ctx context.Context, zone, instanceID string, labels map[string]string) error {
+	ctx, cancel := g.retry.bound(ctx)
+	defer cancel()
+	instance, err := g.service.Instances.Get(g.project, zone, instanceID).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to get instance: %v", err)
+	}
+	merged := make(map[string]string)
+	for k, v := range instance.Labels {
+		merged[k] = v
+	}
+	for k, v := range labels {
+		merged[k] = v
+	}
+	req := &compute.InstancesSetLabelsRequest{Labels: merged, LabelFingerprint: instance.LabelFingerprint}
+	debugf("compute.instances.setLabels %s/%s %v", zone, instanceID, merged)
+	op, err := g.service.Instances.SetLabels(g.project, zone, instanceID, req).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to set labels: %v", err)
+	}
+	return g.waitZoneOperation(ctx, zone, op.Name, tr("label update"))
+}
+
+// SetNetworkTags 以 instance 目前的 tags fingerprint 更新網路標記，只取代 auto-proxy- 開頭的標記
+func (g *GCPProvider) SetNetworkTags(ctx context.Context, zone, instanceID string, tags []string) error {
+	ctx, cancel := g.retry.bound(ctx)
+	defer cancel()
+	instance, err := g.service.Instances.Get(g.project, zone, instanceID).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to get instance: %v", err)
+	}
+	items := slices.Clone(tags)
+	fingerprint := ""
+	if instance.Tags != nil {
+		fingerprint = instance.Tags.Fingerprint
+		for _, tag := range instance.Tags.Items {
+			if !strings.HasPrefix(tag, reservedTagPrefix) {
+				items = append(items, tag)
+			}
+		}
+	}
+	debugf("compute.instances.setTags %s/%s %v", zone, instanceID, items)
+	op, err := g.service.Instances.SetTags(g.project, zone, instanceID, &compute.Tags{Items: items, Fingerprint: fingerprint}).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to set network tags: %v", err)
+	}
+	return g.waitZoneOperation(ctx, zone, op.Name, tr("network tag update"))
+}
+
+// ListInstances 以 aggregated list 一次列出所有 zone 中帶有 label 的 instance
+func (g *GCPProvider) ListInstances(ctx context.Context, label string) ([]CloudInstance, error) {
+	filter := fmt.Sprintf("labels.%s:*", label)
+	debugf("compute.instances.aggregatedList %s", filter)
+	var instances []CloudInstance
+	err := g.service.Instances.AggregatedList(g.project).Filter(filter).Pages(ctx, func(page *compute.InstanceAggregatedList) error {
+		for _, scoped := range page.Items {
+			for _, instance := range scoped.Instances {
+				instances = append(instances, CloudInstance{
+					Name:   instance.Name,
+					Zone:   instance.Zone[strings.LastIndex(instance.Zone, "/")+1:],
+					IP:     instanceIP(instance),
+					Status: instance.Status,
+					Labels: instance.Labels,
+					Tags:   networkTags(instance),
+				})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list instances: %v", err)
+	}
+	return instances, nil
+}
+
+func networkTags(instance *compute.Instance) []string {
+	if instance.Tags == nil {
+		return nil
+	}
+	return instance.Tags.Items
+}
+
+// ListResources 列出帶有 label 的磁碟與靜態 IP，以及 auto_proxy 建立的防火牆規則
+// 防火牆規則沒有 label，以名稱前綴與 EnsureFirewall 寫入的說明辨識
+func (g *GCPProvider) ListResources(ctx context.Context, label string) ([]CloudResource, error) {
+	filter := fmt.Sprintf("labels.%s:*", label)
+	var resources []CloudResource
+	debugf("compute.disks.aggregatedList %s", filter)
+	err := g.service.Disks.AggregatedList(g.project).Filter(filter).Pages(ctx, func(page *compute.DiskAggregatedList) error {
+		for _, scoped := range page.Items {
+			for _, disk := range scoped.Disks {
+				resources = append(resources, CloudResource{
+					Kind:     ResourceDisk,
+					Name:     disk.Name,
+					Location: disk.Zone[strings.LastIndex(disk.Zone, "/")+1:],
+					InUse:    len(disk.Users) > 0,
+				})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list disks: %v", err)
+	}
+	debugf("compute.addresses.aggregatedList %s", filter)
+	err = g.service.Addresses.AggregatedList(g.project).Filter(filter).Pages(ctx, func(page *compute.AddressAggregatedList) error {
+		for _, scoped := range page.Items {
+			for _, address := range scoped.Addresses {
+				resources = append(resources, CloudResource{
+					Kind:     ResourceAddress,
+					Name:     address.Name,
+					Location: address.Region[strings.LastIndex(address.Region, "/")+1:],
+					InUse:    address.Status != "RESERVED",
+				})
 			}
-		}	
-		if gerr, ok := err.(*googleapi.Error); ok && gerr.Code >= 500 {
-			wait := time.Duration(1<<uint(attempt)) * time.Second
-			fmt.Printf("Disk delete retryable error (%d/%d): %v, waiting %v, waiting %v\n", attempt+1, maxRetries, err, wait, wait)
-			time.Sleep(wait)
-			continue
 		}
-		return fmt.Errorf("non-retryable error deleteing disk: %v", err)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list addresses: %v", err)
+	}
+	debugf("compute.firewalls.list")
+	err = g.service.Firewalls.List(g.project).Pages(ctx, func(page *compute.FirewallList) error {
+		for _, firewall := range page.Items {
+			if !strings.HasPrefix(firewall.Name, reservedTagPrefix) || !strings.HasPrefix(firewall.Description, "Managed by auto_proxy") {
+				continue
+			}
+			resource := CloudResource{Kind: ResourceFirewall, Name: firewall.Name}
+			if len(firewall.TargetTags) > 0 {
+				resource.Tag = firewall.TargetTags[0]
+			}
+			resources = append(resources, resource)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list firewall rules: %v", err)
 	}
-	return fmt.Errorf("failed to delete disk after %d retries", maxRetries)
+	return resources, nil
+}
+
+// DeleteResource 刪除 ListResources 回傳的資源，磁碟沿用 DeleteDisk 的重試
+func (g *GCPProvider) DeleteResource(ctx context.Context, resource CloudResource) error {
+	switch resource.Kind {
+	case ResourceDisk:
+		return g.DeleteDisk(ctx, resource.Location, resource.Name)
+	case ResourceAddress:
+		debugf("compute.addresses.delete %s/%s", resource.Location, resource.Name)
+		op, err := g.service.Addresses.Delete(g.project, resource.Location, resource.Name).Context(ctx).Do()
+		if err != nil {
+			return fmt.Errorf("failed to delete address %s: %v", resource.Name, err)
+		}
+		return g.waitRegionOperation(ctx, resource.Location, op.Name, tr("address deletion"))
+	case ResourceFirewall:
+		debugf("compute.firewalls.delete %s", resource.Name)
+		op, err := g.service.Firewalls.Delete(g.project, resource.Name).Context(ctx).Do()
+		if err != nil {
+			return fmt.Errorf("failed to delete firewall rule %s: %v", resource.Name, err)
+		}
+		return g.waitGlobalOperation(ctx, op.Name, tr("firewall deletion"))
+	}
+	return fmt.Errorf("unknown resource kind %q", resource.Kind)
+}
+
+// TunnelCommand 回傳透過 Identity-Aware Proxy 連線 SSH 的 ProxyCommand，需要安裝 gcloud
+// 連接埠由 ssh 以 %p 帶入，sshd 不在 22 時也能連線
+func (g *GCPProvider) TunnelCommand(zone, instanceID string) (string, error) {
+	if _, err := exec.LookPath("gcloud"); err != nil {
+		return "", fmt.Errorf("gcloud is required for IAP tunnels: %v", err)
+	}
+	return fmt.Sprintf("gcloud compute start-iap-tunnel %s %%p --listen-on-stdin --zone=%s --project=%s --verbosity=warning", instanceID, zone, g.project), nil
 }
 
 func (g *GCPProvider) GetInstanceInfo(ctx context.Context, zone, instanceID string) (InstanceInfo, error) {
     instance, err := g.service.Instances.Get(g.project, zone, instanceID).Context(ctx).Do()
+    if gerr, ok := err.(*googleapi.Error); ok && gerr.Code == 404 {
+        return InstanceInfo{}, fmt.Errorf("instance %s: %w", instanceID, ErrInstanceNotFound)
+    }
     if err != nil {
         return InstanceInfo{}, fmt.Errorf("failed to get instance info: %v", err)
     }
 
-    var info InstanceInfo
-    info.IP = instance.NetworkInterfaces[0].AccessConfigs[0].NatIP
-    for _, disk := range instance.Disks {
-        if disk.Boot {
-            parts := strings.Split(disk.Source, "/")
-            info.DiskID = parts[len(parts)-1]
-            break
-        }
-    }
+    info := toInstanceInfo(instance)
     if info.DiskID == "" {
         return InstanceInfo{}, fmt.Errorf("no boot disk found for instance %s", instanceID)
     }
     return info, nil
-}
\ No newline at end of file
+}
+
+func toInstanceInfo(instance *compute.Instance) InstanceInfo {
+	info := InstanceInfo{IP: instanceIP(instance), Status: instance.Status}
+	for _, disk := range instance.Disks {
+		if disk.Boot {
+			parts := strings.Split(disk.Source, "/")
+			info.DiskID = parts[len(parts)-1]
+			break
+		}
+	}
+	return info
+}
+
+// 預先安裝好 proxy 的映像檔都帶有這個 label，方便列出
+const (
+	imageLabel     = "auto-proxy-image"
+	imageArchLabel = "auto-proxy-arch"
+)
+
+func (g *GCPProvider) CreateImage(ctx context.Context, spec ImageSpec) error {
+	labels := map[string]string{imageLabel: "true", imageArchLabel: spec.Arch}
+	if spec.Arch == "" {
+		labels[imageArchLabel] = ArchAMD64
+	}
+	if spec.Protocol != "" {
+		labels["auto-proxy-protocol"] = spec.Protocol
+	}
+	image := &compute.Image{
+		Name:             spec.Name,
+		Architecture:     map[string]string{ArchAMD64: "X86_64", ArchARM64: "ARM64"}[labels[imageArchLabel]],
+		SourceDisk:       fmt.Sprintf("zones/%s/disks/%s", spec.Zone, spec.DiskID),
+		StorageLocations: spec.Locations,
+		Labels:           labels,
+	}
+	debugf("compute.images.insert %s", image.Name)
+	op, err := g.service.Images.Insert(g.project, image).ForceCreate(true).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to create image: %v", err)
+	}
+	return g.waitGlobalOperation(ctx, op.Name, tr("image creation"))
+}
+
+// ListImages 列出預先安裝好的映像檔，arch 為空時列出全部；沒有架構 label 的舊映像檔視為 amd64
+func (g *GCPProvider) ListImages(ctx context.Context, arch string) ([]string, error) {
+	req := g.service.Images.List(g.project).Filter(fmt.Sprintf("labels.%s=true", imageLabel))
+	var images []string
+	err := req.Pages(ctx, func(page *compute.ImageList) error {
+		for _, image := range page.Items {
+			imageArch := image.Labels[imageArchLabel]
+			if imageArch == "" {
+				imageArch = ArchAMD64
+			}
+			if arch != "" && imageArch != arch {
+				continue
+			}
+			images = append(images, image.Name)
+		}
+		return nil
+	})
+	return images, err
+}
+
+func (g *GCPProvider) DeleteImage(ctx context.Context, name string) error {
+	debugf("compute.images.delete %s", name)
+	op, err := g.service.Images.Delete(g.project, name).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to delete image: %v", err)
+	}
+	return g.waitGlobalOperation(ctx, op.Name, tr("image deletion"))
+}
+
+func (g *GCPProvider) waitGlobalOperation(ctx context.Context, opName, desc string) error {
+	ctx, cancel := g.retry.bound(ctx)
+	defer cancel()
+	for {
+		debugf("compute.globalOperations.get %s", opName)
+		operation, err := g.service.GlobalOperations.Get(g.project, opName).Context(ctx).Do()
+		if err != nil {
+			return fmt.Errorf("failed to check %s operation status: %v", desc, err)
+		}
+		if operation.Status == "DONE" {
+			if operation.Error != nil {
+				return fmt.Errorf("%s operation failed: %v", desc, operation.Error)
+			}
+			return nil
+		}
+		fmt.Fprintf(chatter(LevelVerbose), tr("Waiting for %s (%s)...\n"), desc, operation.Status)
+		if err := sleepContext(ctx, 2*time.Second); err != nil {
+			return fmt.Errorf("waiting for %s: %w", desc, err)
+		}
+	}
+}
+
+func (g *GCPProvider) waitRegionOperation(ctx context.Context, region, opName, desc string) error {
+	ctx, cancel := g.retry.bound(ctx)
+	defer cancel()
+	for {
+		debugf("compute.regionOperations.get %s/%s", region, opName)
+		operation, err := g.service.RegionOperations.Get(g.project, region, opName).Context(ctx).Do()
+		if err != nil {
+			return fmt.Errorf("failed to check %s operation status: %v", desc, err)
+		}
+		if operation.Status == "DONE" {
+			if operation.Error != nil {
+				return fmt.Errorf("%s operation failed: %v", desc, operation.Error)
+			}
+			return nil
+		}
+		fmt.Fprintf(chatter(LevelVerbose), tr("Waiting for %s (%s)...\n"), desc, operation.Status)
+		if err := sleepContext(ctx, 2*time.Second); err != nil {
+			return fmt.Errorf("waiting for %s: %w", desc, err)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// graphNode 拓撲圖中的節點
+type graphNode struct {
+	ID    string
+	Label string
+	Kind  string // provider, region, proxy, invite
+}
+
+type graphEdge struct {
+	From, To string
+}
+
+type fleetGraph struct {
+	nodes []graphNode
+	edges []graphEdge
+	seen  map[string]bool
+}
+
+func (g *fleetGraph) addNode(id, label, kind string) {
+	if g.seen[id] {
+		return
+	}
+	g.seen[id] = true
+	g.nodes = append(g.nodes, graphNode{ID: id, Label: label, Kind: kind})
+}
+
+// buildFleetGraph 由 provider → region → proxy 組成樹狀結構，邀請碼再連到可存取的 proxy
+func buildFleetGraph(records []ProxyRecord, invites []Invite) *fleetGraph {
+	g := &fleetGraph{seen: make(map[string]bool)}
+	sorted := append([]ProxyRecord(nil), records...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	var proxies []string
+	for _, r := range sorted {
+		if r.Type != "instance" {
+			continue
+		}
+		providerID := graphID("provider", r.Provider)
+		g.addNode(providerID, r.Provider, "provider")
+		parent := providerID
+		if r.Region != "" {
+			regionID := graphID("region", r.Provider+"-"+r.Region)
+			label := r.Region
+			if location := r.DisplayLocation(); location != r.Region {
+				label = fmt.Sprintf("%s (%s)", r.Region, location)
+			}
+			g.addNode(regionID, label, "region")
+			if !g.seen["edge:"+providerID+regionID] {
+				g.seen["edge:"+providerID+regionID] = true
+				g.edges = append(g.edges, graphEdge{From: providerID, To: regionID})
+			}
+			parent = regionID
+		}
+		proxyID := graphID("proxy", r.Name)
+		label := r.Name
+		if r.IP != "" {
+			label = fmt.Sprintf("%s\\n%s", r.Name, r.IP)
+		}
+		g.addNode(proxyID, label, "proxy")
+		g.edges = append(g.edges, graphEdge{From: parent, To: proxyID})
+		proxies = append(proxies, r.Name)
+	}
+
+	for _, i := range invites {
+		if i.Revoked || now().After(i.ExpiresAt) {
+			continue
+		}
+		inviteID := graphID("invite", i.Code)
+		g.addNode(inviteID, fmt.Sprintf("invite %s\\n%d/%d redeemed", i.Code, i.Redeemed, i.Quota), "invite")
+		key := AccessKey{Scope: i.Scope}
+		for _, name := range proxies {
+			if key.Allows(name) {
+				g.edges = append(g.edges, graphEdge{From: inviteID, To: graphID("proxy", name)})
+			}
+		}
+	}
+	return g
+}
+
+func graphID(kind, name string) string {
+	return kind + "_" + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func (g *fleetGraph) writeDot(w io.Writer) {
+	shapes := map[string]string{"provider": "cloud", "region": "folder", "proxy": "box", "invite": "note"}
+	fmt.Fprintln(w, "digraph fleet {")
+	fmt.Fprintln(w, "  rankdir=LR;")
+	for _, n := range g.nodes {
+		fmt.Fprintf(w, "  %s [label=\"%s\", shape=%s];\n", n.ID, strings.ReplaceAll(n.Label, "\"", "'"), shapes[n.Kind])
+	}
+	for _, e := range g.edges {
+		fmt.Fprintf(w, "  %s -> %s;\n", e.From, e.To)
+	}
+	fmt.Fprintln(w, "}")
+}
+
+func (g *fleetGraph) writeMermaid(w io.Writer) {
+	shapes := map[string][2]string{"provider": {"((", "))"}, "region": {"[/", "/]"}, "proxy": {"[", "]"}, "invite": {">", "]"}}
+	fmt.Fprintln(w, "graph LR")
+	for _, n := range g.nodes {
+		s := shapes[n.Kind]
+		label := strings.ReplaceAll(strings.ReplaceAll(n.Label, "\\n", "<br/>"), "\"", "'")
+		fmt.Fprintf(w, "  %s%s\"%s\"%s\n", n.ID, s[0], label, s[1])
+	}
+	for _, e := range g.edges {
+		fmt.Fprintf(w, "  %s --> %s\n", e.From, e.To)
+	}
+}
+
+// Graph 將目前的 proxy 拓撲輸出成 Graphviz dot 或 Mermaid 圖
+func (c *Commander) Graph(format string) error {
+	records, err := c.recordManager.Load()
+	if err != nil {
+		return fmt.Errorf("error loading records: %v", err)
+	}
+	invites, err := c.invites.List()
+	if err != nil {
+		return fmt.Errorf("error loading invites: %v", err)
+	}
+	g := buildFleetGraph(records, invites)
+	switch format {
+	case "dot":
+		g.writeDot(os.Stdout)
+	case "mermaid":
+		g.writeMermaid(os.Stdout)
+	default:
+		return fmt.Errorf("unsupported graph format: %s (supported: dot, mermaid)", format)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// HardeningConfig 部署時額外的主機強化步驟，預設都不啟用，修改後以 rollout 套用到既有的 proxy
+// 停用已經套用的步驟不會移除套件或還原設定
+type HardeningConfig struct {
+	Fail2ban           bool `yaml:"fail2ban"`            // 以 fail2ban 封鎖多次 SSH 登入失敗的來源
+	UnattendedUpgrades bool `yaml:"unattended_upgrades"` // 每天自動安裝安全性更新，不會自動重新開機
+	// DisablePasswordAuth 與 DisableRootLogin 以 sshd 的 drop-in 設定關閉密碼登入與 root 登入
+	DisablePasswordAuth bool `yaml:"disable_password_auth"`
+	DisableRootLogin    bool `yaml:"disable_root_login"`
+}
+
+// hardeningFile 記錄主機上已經套用的步驟，探測部署狀態時比對，設定改變時重新套用
+const hardeningFile = "/etc/auto-proxy/hardening"
+
+// hardeningStep 一個強化步驟的 tasks 與 handlers，tasks 縮排對齊 block 內的清單
+type hardeningStep struct {
+	name     string
+	tasks    string
+	handlers string
+}
+
+var fail2banStep = hardeningStep{
+	name: "fail2ban",
+	tasks: `        - name: Install fail2ban
+          apt:
+            name: [fail2ban, python3-systemd]
+            state: present
+        - name: Configure fail2ban for sshd
+          copy:
+            content: |
+              [sshd]
+              enabled = true
+              backend = systemd
+              maxretry = 5
+              findtime = 10m
+              bantime = 1h
+            dest: /etc/fail2ban/jail.d/auto-proxy.conf
+          notify: Restart fail2ban
+        - name: Ensure fail2ban is enabled and started
+          systemd:
+            name: fail2ban
+            enabled: yes
+            state: started
+`,
+	handlers: `    - name: Restart fail2ban
+      systemd:
+        name: fail2ban
+        state: restarted
+`,
+}
+
+// unattendedUpgradesStep 只允許 Ubuntu 與 Debian 的安全性更新來源，覆寫映像檔預設的來源清單
+var unattendedUpgradesStep = hardeningStep{
+	name: "unattended-upgrades",
+	tasks: `        - name: Install unattended-upgrades
+          apt:
+            name: unattended-upgrades
+            state: present
+        - name: Limit unattended-upgrades to security updates
+          copy:
+            content: |
+              #clear Unattended-Upgrade::Allowed-Origins;
+              #clear Unattended-Upgrade::Origins-Pattern;
+              Unattended-Upgrade::Origins-Pattern {
+                "origin=Ubuntu,archive=${distro_codename}-security";
+                "origin=Debian,codename=${distro_codename}-security,label=Debian-Security";
+              };
+              Unattended-Upgrade::Automatic-Reboot "false";
+            dest: /etc/apt/apt.conf.d/52auto-proxy-unattended-upgrades
+        - name: Enable daily unattended upgrades
+          copy:
+            content: |
+              APT::Periodic::Update-Package-Lists "1";
+              APT::Periodic::Unattended-Upgrade "1";
+            dest: /etc/apt/apt.conf.d/20auto-upgrades
+`,
+}
+
+// sshdDropIn 產生寫入 sshd_config.d 的 task，檔名排在映像檔的設定之前，sshd 採用先讀到的值
+func sshdDropIn(name, file, content string) string {
+	return fmt.Sprintf(`        - name: %s
+          copy:
+            content: |
+%s
+            dest: /etc/ssh/sshd_config.d/%s
+            validate: /usr/sbin/sshd -t -f %%s
+          notify: Restart sshd
+`, name, indent(content, 14), file)
+}
+
+var passwordAuthStep = hardeningStep{
+	name:  "ssh-no-password",
+	tasks: sshdDropIn("Disable SSH password authentication", "10-auto-proxy-password.conf", "PasswordAuthentication no\nKbdInteractiveAuthentication no"),
+}
+
+var rootLoginStep = hardeningStep{
+	name:  "ssh-no-root-login",
+	tasks: sshdDropIn("Disable SSH root login", "10-auto-proxy-root-login.conf", "PermitRootLogin no"),
+}
+
+// sshdPortFile 移動 sshd 連接埠的 drop-in，開機腳本與 playbook 寫入相同的內容
+const sshdPortFile = "10-auto-proxy-port.conf"
+
+// sshdHandler 重新啟動 sshd，Ubuntu 24.04 以 ssh.socket 監聽，需要重新產生 socket 才會換連接埠
+// 已經建立的連線不受影響，Ansible 可以繼續執行
+const sshdHandler = `    - name: Restart sshd
+      shell: |
+        systemctl daemon-reload
+        if systemctl is-active --quiet ssh.socket; then systemctl restart ssh.socket; else systemctl restart ssh; fi
+`
+
+// sshPortTasks 產生把 sshd 移到 port 的 task，預設連接埠時不修改主機的設定
+func sshPortTasks(port int) string {
+	if port == 0 || port == defaultSSHPort {
+		return ""
+	}
+	return "    - name: Configure sshd port\n      block:\n" +
+		sshdDropIn("Move sshd to port "+fmt.Sprint(port), sshdPortFile, fmt.Sprintf("Port %d", port)) +
+		"      tags: [config]\n"
+}
+
+// sshPortStartupScript 產生開機時移動 sshd 連接埠的腳本，第一次部署前 sshd 就已經在新的連接埠上
+// 雲端防火牆只開放新的連接埠，不能等 playbook 再移動
+func sshPortStartupScript(port int) string {
+	if port == 0 || port == defaultSSHPort {
+		return ""
+	}
+	return fmt.Sprintf(`#!/bin/bash
+conf=/etc/ssh/sshd_config.d/%s
+if [ "$(cat $conf 2>/dev/null)" != "Port %d" ]; then
+  mkdir -p /etc/ssh/sshd_config.d
+  echo "Port %d" > $conf
+  systemctl daemon-reload
+  if systemctl is-active --quiet ssh.socket; then systemctl restart ssh.socket; else systemctl restart ssh; fi
+fi`, sshdPortFile, port, port)
+}
+
+func (h HardeningConfig) steps() []hardeningStep {
+	var steps []hardeningStep
+	if h.Fail2ban {
+		steps = append(steps, fail2banStep)
+	}
+	if h.UnattendedUpgrades {
+		steps = append(steps, unattendedUpgradesStep)
+	}
+	if h.DisablePasswordAuth {
+		steps = append(steps, passwordAuthStep)
+	}
+	if h.DisableRootLogin {
+		steps = append(steps, rootLoginStep)
+	}
+	return steps
+}
+
+// marker 回傳寫入 hardeningFile 的內容，每行一個步驟，沒有啟用任何步驟時為空字串
+func (h HardeningConfig) marker() string {
+	var b strings.Builder
+	for _, step := range h.steps() {
+		b.WriteString(step.name + "\n")
+	}
+	return b.String()
+}
+
+// tasks 產生 playbook 中強化主機的 tasks，需要在建立 /etc/auto-proxy 的 UFW tasks 之後
+func (h HardeningConfig) tasks() string {
+	var b strings.Builder
+	b.WriteString("    - name: Harden the host\n      block:\n")
+	for _, step := range h.steps() {
+		b.WriteString(step.tasks)
+	}
+	b.WriteString("        - name: Record hardening steps\n          copy:\n")
+	if marker := h.marker(); marker != "" {
+		b.WriteString("            content: |\n" + indent(strings.TrimSuffix(marker, "\n"), 14) + "\n")
+	} else {
+		b.WriteString("            content: \"\"\n")
+	}
+	b.WriteString("            dest: " + hardeningFile + "\n")
+	b.WriteString("      tags: [config]\n")
+	return b.String()
+}
+
+func (h HardeningConfig) handlers() string {
+	var b strings.Builder
+	for _, step := range h.steps() {
+		b.WriteString(step.handlers)
+	}
+	return b.String()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+)
+
+// 支援的 hook，檔案放在 hooks 目錄下，例如 ~/.auto_proxy/hooks/post-create.sh
+const (
+	HookPostCreate = "post-create"
+	HookPostDelete = "post-delete"
+)
+
+// hooksDir 回傳 hook 腳本所在的目錄，可以用設定檔的 hooks_dir 覆寫
+func hooksDir(configured string) string {
+	if configured != "" {
+		return configured
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".auto_proxy", "hooks")
+}
+
+// runHook 執行使用者定義的 hook，proxy 的資訊透過環境變數傳入
+// hook 不存在時直接略過，執行失敗只記錄不影響主要流程
+func (c *Commander) runHook(hook string, record ProxyRecord) {
+	dir := hooksDir(c.config.HooksDir)
+	if dir == "" {
+		return
+	}
+	path := filepath.Join(dir, hook+".sh")
+	if _, err := os.Stat(path); err != nil {
+		return
+	}
+	if c.dryRun {
+		dryRunf("Would run hook %s\n", path)
+		return
+	}
+
+	port, method, password := record.Endpoint()
+	cmd := exec.Command(path)
+	cmd.Env = append(os.Environ(),
+		"AUTO_PROXY_HOOK="+hook,
+		"AUTO_PROXY_NAME="+record.Name,
+		"AUTO_PROXY_IP="+record.IP,
+		"AUTO_PROXY_PORT="+strconv.Itoa(port),
+		"AUTO_PROXY_METHOD="+method,
+		"AUTO_PROXY_PASSWORD="+password,
+		"AUTO_PROXY_PROVIDER="+record.Provider,
+		"AUTO_PROXY_REGION="+record.Region,
+		"AUTO_PROXY_ZONE="+record.Zone,
+		"AUTO_PROXY_LOCATION="+record.Location,
+	)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		c.logger.Printf("Hook %s failed for %s: %v", hook, record.Name, err)
+		fmt.Printf(tr("Warning: %s hook failed: %v\n"), hook, err)
+	}
+}
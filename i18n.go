@@ -0,0 +1,316 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// 介面語言，英文訊息本身就是訊息目錄的 key，沒有翻譯時直接顯示英文
+const (
+	LangEnglish            = "en"
+	LangTraditionalChinese = "zh-TW"
+)
+
+var currentLang = LangEnglish
+
+// setLanguage 設定介面語言，lang 為空時依 AUTO_PROXY_LANG 與系統 locale 判斷
+func setLanguage(lang string) error {
+	if lang == "" {
+		lang = detectLanguage()
+	}
+	switch strings.ToLower(strings.ReplaceAll(lang, "_", "-")) {
+	case "en", "en-us", "en-gb":
+		currentLang = LangEnglish
+	case "zh", "zh-tw", "zh-hant", "zh-hk":
+		currentLang = LangTraditionalChinese
+	default:
+		return fmt.Errorf("unsupported language: %s (supported: en, zh-TW)", lang)
+	}
+	return nil
+}
+
+func detectLanguage() string {
+	if lang := os.Getenv("AUTO_PROXY_LANG"); lang != "" {
+		return lang
+	}
+	for _, key := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		locale := os.Getenv(key)
+		if locale == "" {
+			continue
+		}
+		// 例如 zh_TW.UTF-8，只有繁體中文翻譯，其他中文 locale 也使用繁體中文
+		if strings.HasPrefix(locale, "zh") {
+			return LangTraditionalChinese
+		}
+		return LangEnglish
+	}
+	return LangEnglish
+}
+
+// tr 回傳訊息在目前語言的翻譯
+func tr(message string) string {
+	if translated, ok := messageCatalog[currentLang][message]; ok {
+		return translated
+	}
+	return message
+}
+
+var messageCatalog = map[string]map[string]string{
+	LangTraditionalChinese: {
+		// create
+		"Choose a cloud platform:":             "選擇雲端平台：",
+		"Choose a region:":                     "選擇地區：",
+		"Choose a zone:":                       "選擇可用區：",
+		"Choose a machine type:":               "選擇機器類型：",
+		"(recommended)":                        "(推薦)",
+		"Measuring latency to %d regions...\n": "正在測量到 %d 個地區的延遲...\n",
+		"yes":                                  "是",
+		"\nRecommended: %s (%s), zone %s, %s\nRun with --apply to create it.\n": "\n推薦：%s (%s)，可用區 %s，%s\n加上 --apply 即可建立。\n",
+		"Creating a proxy in %s (%s), zone %s, %s\n":                            "正在 %s (%s) 的可用區 %s 建立 %s 的 proxy\n",
+		"%s (unreachable)": "%s (無法連線)",
+		"Type to filter, e.g. \"asia\" or \"e2 med\"": "輸入文字篩選，例如「asia」或「e2 med」",
+		"Choose an image:":                "選擇映像檔：",
+		"Proxy %s ready in %v\n":          "Proxy %s 已就緒，耗時 %v\n",
+		"Proxy %s is already deployed.\n": "Proxy %s 已經部署完成。\n",
+		"[%s] Creating...\n":              "[%s] 建立中...\n",
+		"[%s] Starting in %v...\n":        "[%s] %v 後開始建立...\n",
+		"Summary:":                        "結果：",
+		" - Share URI: %s\n":              " - 分享連結：%s\n",
+		"Shadowsocks proxy created at: %s:%d\n - Protocol: Shadowsocks\n - Password: %s\n - Encryption: %s\n": "Shadowsocks proxy 已建立：%s:%d\n - 協定：Shadowsocks\n - 密碼：%s\n - 加密方式：%s\n",
+		"Create a reusable image from this proxy to speed up future creates?":                                 "要從這台 proxy 建立映像檔，加快之後的建立速度嗎？",
+
+		// delete
+		"Proxy not found: %s\n":                                             "找不到 proxy：%s\n",
+		"[%s] Deleting instance":                                            "[%s] 正在刪除 instance",
+		"[%s] Deleting disk":                                                "[%s] 正在刪除磁碟",
+		"Found boot disk: %s for instance %s\n":                             "找到 instance %[2]s 的開機磁碟：%[1]s\n",
+		"Failed to delete disk %s\n":                                        "刪除磁碟 %s 失敗\n",
+		"Proxy %s deleted.\n":                                               "已刪除 proxy %s。\n",
+		"Record of external proxy %s removed.\n":                            "已移除外部 proxy %s 的紀錄。\n",
+		"!!! Notes on proxy %s:\n":                                          "!!! proxy %s 的備註：\n",
+		"Proxy %s has no notes.\n":                                          "Proxy %s 沒有備註。\n",
+		"Choose proxies to delete:":                                         "選擇要刪除的 proxy：",
+		"No proxies selected.":                                              "沒有選擇任何 proxy。",
+		"Failed to delete %s: %v\n":                                         "刪除 %s 失敗：%v\n",
+		"Skipped %d proxies without a creation time.\n":                     "略過 %d 台沒有建立時間的 proxy。\n",
+		"No proxies match.":                                                 "沒有符合條件的 proxy。",
+		"The following will be deleted:":                                    "將會刪除以下資源：",
+		" - %s (%s, %s): record only, the external server is not touched\n": " - %s (%s, %s)：只移除紀錄，不會動到外部伺服器\n",
+		" - %s (%s, %s): instance %s in %s, its boot disk and the record\n": " - %s (%s, %s)：%[5]s 的 instance %[4]s、開機磁碟與紀錄\n",
+		"Delete %d proxies?":                                                "要刪除 %d 台 proxy 嗎？",
+		"Cancelled.":                                                        "已取消。",
+
+		// rename
+		"Warning: failed to update the instance label: %v\n": "警告：更新 instance 的 label 失敗：%v\n",
+		"Proxy %s renamed to %s.\n":                          "已將 proxy %s 重新命名為 %s。\n",
+
+		// list / status / best
+		"IP changed":             "IP 已變更",
+		"No proxies found.":      "沒有任何 proxy。",
+		"%s (%s): unreachable\n": "%s (%s)：無法連線\n",
+
+		// connect
+		"Proxy %s\n - Protocol: Shadowsocks\n - Host: %s\n - Port: %d\n - Password: %s\n - Encryption: %s\n": "Proxy %s\n - 協定：Shadowsocks\n - 主機：%s\n - 連接埠：%d\n - 密碼：%s\n - 加密方式：%s\n",
+
+		// GCP 操作進度
+		"Attempting to delete instance %s in zone %s\n": "正在刪除 %[2]s 的 instance %[1]s\n",
+		"Instance %s deleted successfully\n":            "已刪除 instance %s\n",
+		"attempting to delete disk %s in zone %s\n":     "正在刪除 %[2]s 的磁碟 %[1]s\n",
+		"Disk %s deleted successfully\n":                "已刪除磁碟 %s\n",
+		"Waiting for %s (%s)...\n":                      "等待 %s (%s)...\n",
+		"instance creation":                             "instance 建立",
+		"instance stop":                                 "instance 停止",
+		"instance start":                                "instance 啟動",
+		"instance deletion":                             "instance 刪除",
+		"disk deletion":                                 "磁碟刪除",
+		"label update":                                  "label 更新",
+		"image creation":                                "映像檔建立",
+		"image deletion":                                "映像檔刪除",
+		"firewall update":                               "防火牆規則更新",
+		"Create instance":                               "建立 instance",
+		"Delete instance":                               "刪除 instance",
+		"Delete disk":                                   "刪除磁碟",
+		"%s failed (attempt %d/%d): %v\n":               "%s失敗（第 %d/%d 次）：%v\n",
+		"done (%v)":                                     "完成 (%v)",
+		"failed after %v":                               "%v 後失敗",
+		"Retrying in %v...\n":                           "%v 後重試...\n",
+		"Retrying in %v...":                             "%v 後重試...",
+
+		// image / bake
+		"Image %s created.\n":                   "已建立映像檔 %s。\n",
+		"No images found.":                      "沒有任何映像檔。",
+		"Image %s deleted.\n":                   "已刪除映像檔 %s。\n",
+		"Creating build instance %s in %s...\n": "正在 %[2]s 建立建置用的 instance %[1]s...\n",
+		"Failed to delete build instance %s, please delete it manually\n": "刪除建置用的 instance %s 失敗，請手動刪除\n",
+		"Failed to delete build disk %s, please delete it manually\n":     "刪除建置用的磁碟 %s 失敗，請手動刪除\n",
+		"Image %s baked.\n": "映像檔 %s 建置完成。\n",
+
+		// rollout
+		"Rolling out to %d canary proxies...\n":                   "先部署到 %d 台 canary proxy...\n",
+		"Rollout completed.":                                      "部署完成。",
+		"Canary passed, rolling out to remaining %d proxies...\n": "Canary 通過，部署到其餘 %d 台 proxy...\n",
+		"Deploying to %s (%s)...\n":                               "正在部署到 %s (%s)...\n",
+		"Proxy %s has no public IP, skipping health check.\n":     "Proxy %s 沒有外部 IP，略過健康檢查。\n",
+		"Proxy %s is healthy.\n":                                  "Proxy %s 運作正常。\n",
+
+		// import / invite / ip
+		"No Shadowsocks servers found in import file.":                   "匯入檔中沒有 Shadowsocks 伺服器。",
+		"Skipping %s (%s:%d): unreachable\n":                             "略過 %s (%s:%d)：無法連線\n",
+		"Imported %d servers, updated %d existing records.\n":            "已匯入 %d 台伺服器，更新 %d 筆既有紀錄。\n",
+		"Invite code: %s (quota: %d, expires: %s)\n":                     "邀請碼：%s（可兌換 %d 次，到期：%s）\n",
+		"No invites found.":                                              "沒有任何邀請碼。",
+		"Code: %s, Scope: %s, Redeemed: %d/%d, Expires: %s, State: %s\n": "邀請碼：%s，範圍：%s，已兌換：%d/%d，到期：%s，狀態：%s\n",
+		"Invite %s revoked.\n":                                           "已撤銷邀請碼 %s。\n",
+		"Expires: %s\n":                                                  "到期：%s\n",
+		"Public IP: %s\n":                                                "本機外部 IP：%s\n",
+		"Exit IP of %s: %s\n":                                            "%s 的出口 IP：%s\n",
+
+		// 其他
+		"Warning: %s hook failed: %v\n":                                                      "警告：%s hook 執行失敗：%v\n",
+		"CHAOS MODE ENABLED: failures will be injected":                                      "已啟用混沌模式：會注入錯誤",
+		"Wrote %s. Start it with:\n  systemctl daemon-reload && systemctl enable --now %s\n": "已寫入 %s，使用以下指令啟動：\n  systemctl daemon-reload && systemctl enable --now %s\n",
+		"Ignoring %s, %s is used instead\n":                                                  "忽略 %s，改用 %s\n",
+		"Failed to move %s to %s, move it manually: %v\n":                                    "無法將 %s 搬到 %s，請手動搬移：%v\n",
+		"Moved %s to %s\n":               "已將 %s 搬到 %s\n",
+		"Listing cloud instances":        "列出雲端上的 instance",
+		"Local records match the cloud.": "本機紀錄與雲端一致。",
+		"Run `auto_proxy sync --fix` to update the records, and --adopt to add unmanaged instances.": "執行 `auto_proxy sync --fix` 更新紀錄，加上 --adopt 將沒有紀錄的 instance 加入紀錄。",
+		"Warning: failed to check %s: %v\n": "警告：無法檢查 %s：%v\n",
+		"failed: %v":                        "失敗：%v",
+		"labeled":                           "已加上 label",
+		"record removed":                    "已移除紀錄",
+		"IP updated":                        "已更新 IP",
+		"adopted as provisioning":           "已加入為 provisioning",
+		"Would update record %s: %s\n":      "將會更新紀錄 %s：%s\n",
+		"No operations recorded.":           "沒有操作紀錄。",
+		"Warning: the backup contains proxy passwords in plain text, keep it safe.":                   "警告：備份中的 proxy 密碼為明文，請妥善保管。",
+		"Restored config to %s, check that gcp.credentials and ssh.key_path exist on this machine.\n": "已還原設定檔 %s，請確認 gcp.credentials 與 ssh.key_path 在這台電腦上存在。\n",
+		"Kept the existing config %s, use --replace to overwrite it.\n":                               "保留既有的設定檔 %s，使用 --replace 以備份覆蓋。\n",
+		"Restored %d records, skipped %d that already exist (use --replace to overwrite).\n":          "已還原 %d 筆紀錄，略過 %d 筆已經存在的紀錄（使用 --replace 覆蓋）。\n",
+		"Restored %d records.\n":                                         "已還原 %d 筆紀錄。\n",
+		"Restored %d invites.\n":                                         "已還原 %d 個邀請碼。\n",
+		"No dead records found.":                                         "沒有失效的紀錄。",
+		"The following records have no instance in the cloud:":           "以下紀錄在雲端上已經沒有 instance：",
+		"Remove %d records?":                                             "移除 %d 筆紀錄？",
+		"Removed %d records.\n":                                          "已移除 %d 筆紀錄。\n",
+		"Proxy %s had no instance, record removed.\n":                    "Proxy %s 沒有 instance，已移除紀錄。\n",
+		"Warning: failed to recover the interrupted %s of %s: %v\n":      "警告：無法處理上次中斷的 %s (%s)：%v\n",
+		"Deleting instance %s left behind by an interrupted operation\n": "刪除上次中斷的操作留下的 instance %s\n",
+		"Recovered proxy %s from an interrupted create, run `auto_proxy resume --name %s` to deploy it\n": "已找回上次建立到一半的 proxy %s，執行 `auto_proxy resume --name %s` 完成部署\n",
+		"No proxies match %q.\n":                             "沒有符合 %q 的 proxy。\n",
+		"Listing cloud resources":                            "正在列出雲端資源",
+		"No orphaned resources found.":                       "沒有找到未使用的資源。",
+		"The following resources are not used by any proxy:": "以下資源沒有被任何 proxy 使用：",
+		"Delete %d resources?":                               "要刪除 %d 項資源嗎？",
+		"Deleting %s %s":                                     "正在刪除 %s %s",
+		"Failed to delete %s %s: %v\n":                       "刪除 %s %s 失敗：%v\n",
+		"Deleted %d resources.\n":                            "已刪除 %d 項資源。\n",
+		"Would delete %d resources\n":                        "將會刪除 %d 項資源\n",
+		"Would delete %s %s\n":                               "將會刪除 %s %s\n",
+		"address deletion":                                   "刪除靜態 IP",
+		"firewall deletion":                                  "刪除防火牆規則",
+		"Deploying new credentials to %s (%s)...\n":          "正在將新的憑證部署到 %s (%s)...\n",
+		"Warning: proxy %s did not pass the health check after rotating credentials.\n": "警告：proxy %s 更換憑證後沒有通過健康檢查。\n",
+		"Credentials of proxy %s rotated, update your clients:\n\n":                     "proxy %s 的憑證已更換，請更新客戶端設定：\n\n",
+		"Proxy %s accepts connections from any IP.\n":                                   "proxy %s 接受任何 IP 的連線。\n",
+		"Allowed sources of proxy %s unchanged.\n":                                      "proxy %s 允許的來源沒有變更。\n",
+		"Proxy %s now accepts connections from any IP.\n":                               "proxy %s 現在接受任何 IP 的連線。\n",
+		"Proxy %s now accepts connections only from: %s\n":                              "proxy %s 現在只接受以下來源的連線：%s\n",
+		"Would set network tags on instance %s: %s\n":                                   "將會設定 instance %s 的網路標記：%s\n",
+		"network tag update": "更新網路標記",
+		"Failed to delete firewall rule %s, run `auto_proxy gc` to retry\n": "刪除防火牆規則 %s 失敗，請執行 `auto_proxy gc` 重試\n",
+		"Firewall rule %s deleted\n":                                        "防火牆規則 %s 已刪除\n",
+		"Failed to delete the stored password %s, delete it manually\n":     "無法刪除儲存的密碼 %s，請手動刪除\n",
+		"Value of %s: ":                  "%s 的值：",
+		"%s stored in the OS keyring.\n": "%s 已存入 OS keyring。\n",
+		"Run `auto_proxy config set gcp.credentials %s` to use it, then delete %s.\n":    "執行 `auto_proxy config set gcp.credentials %s` 開始使用，然後刪除 %s。\n",
+		"%s deleted from the OS keyring.\n":                                              "已從 OS keyring 刪除 %s。\n",
+		"not set":                                                                        "未設定",
+		"environment":                                                                    "環境變數",
+		"OS keyring":                                                                     "OS keyring",
+		"Proxy %s has no additional users.\n":                                            "proxy %s 沒有其他使用者。\n",
+		"Adding user %s to proxy %s on port %d...\n":                                     "正在新增使用者 %s 到 proxy %s，連接埠 %d...\n",
+		"User %s added, share these parameters with them:\n\n":                           "已新增使用者 %s，請把以下參數提供給對方：\n\n",
+		"User %s removed from proxy %s.\n":                                               "已將使用者 %s 從 proxy %s 移除。\n",
+		"Leave empty to use gcloud application default credentials":                      "留白則使用 gcloud 的應用程式預設憑證",
+		"Created CA in %s.\n":                                                            "已在 %s 建立 CA。\n",
+		"Issued server certificate for %v, valid until %s.\n":                            "已簽發 %v 的伺服器憑證，有效期限至 %s。\n",
+		"Issued client certificate %s (key %s), valid until %s.\n":                       "已簽發用戶端憑證 %s（金鑰 %s），有效期限至 %s。\n",
+		"Connect with:":                                                                  "連線方式：",
+		"\nThe user expires at %s.\n":                                                    "\n這個使用者將於 %s 到期。\n",
+		"Removed expired user %s from proxy %s.\n":                                       "已將到期的使用者 %s 從 proxy %s 移除。\n",
+		"No expired users.":                                                              "沒有到期的使用者。",
+		"Opened %d/%s on proxy %s.\n":                                                    "已在 proxy %[3]s 開放 %[1]d/%[2]s。\n",
+		"Closed %d/%s on proxy %s.\n":                                                    "已在 proxy %[3]s 關閉 %[1]d/%[2]s。\n",
+		"Rate limit of proxy %s unchanged.\n":                                            "proxy %s 的連線數限制沒有變更。\n",
+		"Proxy %s no longer limits new connections.\n":                                   "proxy %s 不再限制新連線數。\n",
+		"Rate limit of proxy %s set to %d/minute per source.\n":                          "已將 proxy %s 的連線數限制設為每個來源每分鐘 %d 次。\n",
+		"No history found.":                                                              "沒有變更紀錄。",
+		"Replaced %s, links signed with the old key no longer work.\n":                   "已更換 %s，以舊金鑰簽署的連結已失效。\n",
+		"Replaced %s, give clients the new value: %s\n":                                  "已更換 %s，請將新的值提供給客戶端：%s\n",
+		"%s comes from the environment, replace it there.\n":                             "%s 來自環境變數，請在環境變數中更換。\n",
+		"Revoked %d invites and %d access keys, create new invites for your users.\n":    "已作廢 %d 個邀請碼與 %d 把存取金鑰，請為使用者建立新的邀請碼。\n",
+		"Restart serve to use the new secrets.":                                          "請重新啟動 serve 以使用新的密鑰。",
+		"New passphrase:":                                                                "新的密碼短語：",
+		"Confirm new passphrase:":                                                        "再次輸入新的密碼短語：",
+		"Re-encrypted %d secrets with a new key stored in %s.\n":                         "已以新的金鑰重新加密 %d 筆機密資料，金鑰存放在 %s。\n",
+		"Would re-encrypt secrets with a new key stored in %s\n":                         "將會以新的金鑰重新加密機密資料，金鑰存放在 %s\n",
+		"Re-encrypted %d secrets. Update AUTO_PROXY_PASSPHRASE to the new passphrase.\n": "已重新加密 %d 筆機密資料，請將 AUTO_PROXY_PASSPHRASE 更新為新的密碼短語。\n",
+
+		// dry run
+		"DRY RUN: nothing will be created, changed or deleted":        "試跑模式：不會建立、修改或刪除任何資源",
+		"  allow %d/%s from %s (%s)\n":                                "  允許來自 %[3]s 的 %[1]d/%[2]s（%[4]s）\n",
+		"  metadata: %s\n":                                            "  metadata：%s\n",
+		"  network tags: %s\n":                                        "  網路標記：%s\n",
+		"  without an external IP\n":                                  "  不配置外部 IP\n",
+		"Would create image %s from disk %s in %s\n":                  "將會從 %[3]s 的磁碟 %[2]s 建立映像檔 %[1]s\n",
+		"Would create instance %s in %s: machine type %s, image %s\n": "將會在 %[2]s 建立 instance %[1]s：機器類型 %[3]s，映像檔 %[4]s\n",
+		"Would delete disk %s in %s\n":                                "將會刪除 %[2]s 的磁碟 %[1]s\n",
+		"Would delete image %s\n":                                     "將會刪除映像檔 %s\n",
+		"Would delete instance %s in %s\n":                            "將會刪除 %[2]s 的 instance %[1]s\n",
+		"Would deploy the proxy to %s with:\n":                        "將會以下列內容部署 proxy 到 %s：\n",
+		"Would deploy the proxy to %s\n":                              "將會部署 proxy 到 %s\n",
+		"Would ensure firewall rules for network tag %s:\n":           "將會確保網路標記 %s 的防火牆規則：\n",
+		"Would rename %s to %s in invite scopes\n":                    "將會把邀請碼範圍中的 %s 改為 %s\n",
+		"Would run hook %s\n":                                         "將會執行 hook %s\n",
+		"Would start instance %s in %s\n":                             "將會啟動 %[2]s 的 instance %[1]s\n",
+		"Would stop instance %s in %s\n":                              "將會停止 %[2]s 的 instance %[1]s\n",
+		"Would set labels on instance %s: %v\n":                       "將會設定 instance %s 的 labels：%v\n",
+
+		// config
+		"Importing settings from .env":                                      "從 .env 匯入設定",
+		"GCP project ID:":                                                   "GCP 專案 ID：",
+		"Path of the service account key file:":                             "服務帳戶金鑰檔的路徑：",
+		"SSH user for deployment:":                                          "部署使用的 SSH 使用者：",
+		"Path of the SSH private key:":                                      "SSH 私鑰的路徑：",
+		"Default region (optional):":                                        "預設區域（選填）：",
+		"Saved %s, use `auto_proxy config set` to change other settings.\n": "已儲存 %s，其他設定可以使用 `auto_proxy config set` 修改。\n",
+
+		// stop / start
+		"Proxy %s stopped, start it again with `auto_proxy start`.\n":          "proxy %s 已停止，可以用 `auto_proxy start` 再次啟動。\n",
+		"Warning: proxy %s did not pass the health check after starting.\n":    "警告：proxy %s 啟動後沒有通過健康檢查。\n",
+		"Proxy %s started with a new IP %s (was %s), update your clients:\n\n": "proxy %s 已啟動，IP 由 %[3]s 變更為 %[2]s，請更新客戶端：\n\n",
+		"Stopping proxy %s...\n":    "正在停止 proxy %s...\n",
+		"Starting proxy %s...\n":    "正在啟動 proxy %s...\n",
+		"Proxy %s started at %s.\n": "proxy %s 已在 %s 啟動。\n",
+
+		// ttl
+		"It expires at %s and will be deleted by `auto_proxy reap`.\n":            "將在 %s 到期，由 `auto_proxy reap` 刪除。\n",
+		"Proxy %s expired but is protected by note %q, delete it with --force.\n": "proxy %s 已到期，但受到備註 %q 保護，請使用 --force 刪除。\n",
+		"No expired proxies.": "沒有到期的 proxy。",
+
+		// cost
+		"Includes the machine, a %d GB disk, the external IP and %d GB of egress a month.\n":                 "包含機器、%d GB 的磁碟、外部 IP 與每月 %d GB 的流量。\n",
+		"%s qualifies for the free tier, only one per account is free.\n":                                    "%s 符合免費方案，每個帳號只有一台免費。\n",
+		"Includes the machine, a %d GB disk and %d GB of egress a month.\n":                                  "包含機器、%d GB 的磁碟與每月 %d GB 的流量。\n",
+		"Only the machine is included for prices in %s.\n":                                                   "以 %s 計價時只包含機器的費用。\n",
+		"The estimate covers all %d proxies.\n":                                                              "預估費用為全部 %d 台 proxy 的總和。\n",
+		"Estimated cost: %s\n":                                                                               "預估費用：%s\n",
+		"Estimates assume each proxy has been running since it was created.":                                 "預估費用假設每台 proxy 建立後一直在執行。",
+		"Stopped proxies only include the disk and IP, as if they had been stopped since they were created.": "停止的 proxy 只計算磁碟與 IP，假設建立後一直是停止的。",
+		"Estimated total: %.2f %s\n":                                                                         "預估總計：%.2f %s\n",
+		"Billed total: %.2f %s\n":                                                                            "帳單總計：%.2f %s\n",
+		"Create %d proxies?":                                                                                 "要建立 %d 台 proxy 嗎？",
+	},
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/AlecAivazis/survey/v2"
+)
+
+const freshInstallOption = "Fresh install (Ubuntu + Ansible)"
+
+// chooseImage 有預先安裝好的映像檔時讓使用者選擇，回傳空字串代表全新安裝
+func (c *Commander) chooseImage(ctx context.Context, arch string) (string, error) {
+	images, err := c.provider.ListImages(ctx, arch)
+	if err != nil {
+		return "", fmt.Errorf("error listing images: %v", err)
+	}
+	if len(images) == 0 {
+		return "", nil
+	}
+	var selected string
+	survey.AskOne(&survey.Select{Message: tr("Choose an image:"), Options: append(images, freshInstallOption), Default: images[0]}, &selected)
+	if selected == freshInstallOption {
+		return "", nil
+	}
+	return selected, nil
+}
+
+// activatePrebaked 映像檔已經包含設定好的 proxy，只需要確認服務已啟動
+func (c *Commander) activatePrebaked(ctx context.Context, record ProxyRecord) error {
+	name, ip := record.Name, record.IP
+	if record.PrivateOnly {
+		// 沒有外部 IP 時無法從這裡檢查服務埠
+		report(c.reporter, name, ip, StageVerify, EventProgress, "proxy has no public IP, skipping port check")
+		return c.markDeployed(name, ip, true)
+	}
+	report(c.reporter, name, ip, StageVerify, EventStarted, "")
+	if err := c.checkHealth(ctx, record); err != nil {
+		report(c.reporter, name, ip, StageVerify, EventFailed, err.Error())
+		c.logger.Printf("Prebaked proxy %s is not reachable: %v", name, err)
+		c.setStatus(name, StatusFailed)
+		return fmt.Errorf("prebaked proxy not reachable: %v (run `auto_proxy resume --name %s` to deploy with Ansible)", err, name)
+	}
+	report(c.reporter, name, ip, StageVerify, EventSucceeded, "")
+	return c.markDeployed(name, ip, true)
+}
+
+// offerImageBuild 第一次成功部署後詢問是否要建立映像檔供之後使用
+func (c *Commander) offerImageBuild(ctx context.Context, name string) {
+	images, err := c.provider.ListImages(ctx, "")
+	if err != nil || len(images) > 0 {
+		return
+	}
+	build := false
+	survey.AskOne(&survey.Confirm{Message: tr("Create a reusable image from this proxy to speed up future creates?")}, &build)
+	if !build {
+		return
+	}
+	if err := c.ImageBuild(ctx, name, ""); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func (c *Commander) ImageBuild(ctx context.Context, name, imageName string) error {
+	records, err := c.recordManager.Load()
+	if err != nil {
+		return fmt.Errorf("error loading records: %v", err)
+	}
+	var record *ProxyRecord
+	for i, r := range records {
+		if r.Name == name && r.Type == "instance" {
+			record = &records[i]
+			break
+		}
+	}
+	if record == nil {
+		fmt.Printf(tr("Proxy not found: %s\n"), name)
+		return nil
+	}
+	if !record.Managed() {
+		return fmt.Errorf("proxy %s was imported and is not managed by auto_proxy", name)
+	}
+	if !record.Ready() {
+		return fmt.Errorf("proxy %s is %s, not deployed", name, record.Lifecycle())
+	}
+
+	info, err := c.provider.GetInstanceInfo(ctx, record.Zone, record.InstanceID)
+	if err != nil {
+		return fmt.Errorf("error getting instance info: %v", err)
+	}
+	if imageName == "" {
+		imageName = fmt.Sprintf("proxy-image-%s", time.Now().Format("20060102-150405"))
+	}
+	spec := ImageSpec{Name: imageName, Zone: record.Zone, DiskID: info.DiskID, Protocol: "shadowsocks", Arch: record.Arch}
+	if err := c.provider.CreateImage(ctx, spec); err != nil {
+		return fmt.Errorf("error creating image: %v", err)
+	}
+	fmt.Printf(tr("Image %s created.\n"), imageName)
+	return nil
+}
+
+func (c *Commander) ImageList(ctx context.Context) error {
+	images, err := c.provider.ListImages(ctx, "")
+	if err != nil {
+		return fmt.Errorf("error listing images: %v", err)
+	}
+	if len(images) == 0 {
+		fmt.Println(tr("No images found."))
+		return nil
+	}
+	for _, image := range images {
+		fmt.Println(image)
+	}
+	return nil
+}
+
+func (c *Commander) ImageDelete(ctx context.Context, name string) error {
+	if err := c.provider.DeleteImage(ctx, name); err != nil {
+		return fmt.Errorf("error deleting image: %v", err)
+	}
+	fmt.Printf(tr("Image %s deleted.\n"), name)
+	return nil
+}
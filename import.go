@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// importedServer 從其他管理工具匯出檔解析出的 Shadowsocks 伺服器
+type importedServer struct {
+	Name     string
+	Server   string
+	Port     int
+	Method   string
+	Password string
+}
+
+// parseImport 依內容自動判斷格式：Outline Manager 的 access keys JSON、Clash 設定或 ss:// 訂閱
+func parseImport(data []byte) ([]importedServer, error) {
+	text := strings.TrimSpace(string(data))
+	if strings.HasPrefix(text, "{") {
+		return parseOutlineExport(data)
+	}
+	if strings.Contains(text, "proxies:") {
+		return parseClashConfig(data)
+	}
+	if !strings.Contains(text, "://") {
+		// 訂閱內容通常整份做 base64
+		decoded, err := decodeBase64(text)
+		if err != nil {
+			return nil, fmt.Errorf("unrecognized import format")
+		}
+		text = string(decoded)
+	}
+	return parseSSURIs(text)
+}
+
+func parseOutlineExport(data []byte) ([]importedServer, error) {
+	var export struct {
+		AccessKeys []struct {
+			Name      string `json:"name"`
+			AccessURL string `json:"accessUrl"`
+		} `json:"accessKeys"`
+	}
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, fmt.Errorf("failed to parse Outline export: %v", err)
+	}
+	var servers []importedServer
+	for _, key := range export.AccessKeys {
+		server, err := parseSSURI(key.AccessURL)
+		if err != nil {
+			return nil, err
+		}
+		if key.Name != "" {
+			server.Name = key.Name
+		}
+		servers = append(servers, server)
+	}
+	return servers, nil
+}
+
+func parseClashConfig(data []byte) ([]importedServer, error) {
+	var config struct {
+		Proxies []struct {
+			Name     string `yaml:"name"`
+			Type     string `yaml:"type"`
+			Server   string `yaml:"server"`
+			Port     int    `yaml:"port"`
+			Cipher   string `yaml:"cipher"`
+			Password string `yaml:"password"`
+		} `yaml:"proxies"`
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse Clash config: %v", err)
+	}
+	var servers []importedServer
+	for _, p := range config.Proxies {
+		if p.Type != "ss" {
+			continue
+		}
+		servers = append(servers, importedServer{Name: p.Name, Server: p.Server, Port: p.Port, Method: p.Cipher, Password: p.Password})
+	}
+	return servers, nil
+}
+
+func parseSSURIs(text string) ([]importedServer, error) {
+	var servers []importedServer
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "ss://") {
+			continue
+		}
+		server, err := parseSSURI(line)
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, server)
+	}
+	return servers, nil
+}
+
+// parseSSURI 支援 SIP002 (ss://base64(method:password)@host:port#tag) 與舊格式 (ss://base64(method:password@host:port)#tag)
+func parseSSURI(uri string) (importedServer, error) {
+	rest, ok := strings.CutPrefix(uri, "ss://")
+	if !ok {
+		return importedServer{}, fmt.Errorf("not a ss:// URI: %s", uri)
+	}
+	var server importedServer
+	if i := strings.Index(rest, "#"); i >= 0 {
+		server.Name, _ = url.PathUnescape(rest[i+1:])
+		rest = rest[:i]
+	}
+	rest, _, _ = strings.Cut(rest, "?")
+	rest = strings.TrimSuffix(rest, "/")
+
+	if !strings.Contains(rest, "@") {
+		decoded, err := decodeBase64(rest)
+		if err != nil {
+			return importedServer{}, fmt.Errorf("invalid ss:// URI: %s", uri)
+		}
+		rest = string(decoded)
+	}
+	at := strings.LastIndex(rest, "@")
+	if at < 0 {
+		return importedServer{}, fmt.Errorf("invalid ss:// URI: %s", uri)
+	}
+	userinfo, hostport := rest[:at], rest[at+1:]
+	if decoded, err := decodeBase64(userinfo); err == nil && strings.Contains(string(decoded), ":") {
+		userinfo = string(decoded)
+	} else if unescaped, err := url.PathUnescape(userinfo); err == nil {
+		userinfo = unescaped
+	}
+	method, password, ok := strings.Cut(userinfo, ":")
+	if !ok {
+		return importedServer{}, fmt.Errorf("invalid ss:// credentials: %s", uri)
+	}
+	host, portStr, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return importedServer{}, fmt.Errorf("invalid ss:// host: %s", uri)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return importedServer{}, fmt.Errorf("invalid ss:// port: %s", uri)
+	}
+	server.Server, server.Port, server.Method, server.Password = host, port, method, password
+	return server, nil
+}
+
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if data, err := enc.DecodeString(s); err == nil {
+			return data, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid base64")
+}
+
+// Import 匯入其他工具的匯出檔，可以連線的伺服器會被納入管理
+// 已經有紀錄的 IP 只更新連線參數；skipCheck 為 true 時不檢查連線
+func (c *Commander) Import(ctx context.Context, filePath string, skipCheck bool) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("error reading import file: %v", err)
+	}
+	servers, err := parseImport(data)
+	if err != nil {
+		return fmt.Errorf("error parsing import file: %v", err)
+	}
+	if len(servers) == 0 {
+		fmt.Println(tr("No Shadowsocks servers found in import file."))
+		return nil
+	}
+
+	var reachable []importedServer
+	for _, s := range servers {
+		if !skipCheck {
+			dialer := net.Dialer{Timeout: 3 * time.Second}
+			conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(s.Server, strconv.Itoa(s.Port)))
+			if err != nil {
+				fmt.Printf(tr("Skipping %s (%s:%d): unreachable\n"), s.Name, s.Server, s.Port)
+				continue
+			}
+			conn.Close()
+		}
+		reachable = append(reachable, s)
+	}
+
+	added, adopted := 0, 0
+	err = c.recordManager.Update(func(records []ProxyRecord) ([]ProxyRecord, error) {
+		names := make(map[string]bool)
+		for _, r := range records {
+			names[r.Name] = true
+		}
+		for _, s := range reachable {
+			found := false
+			for i, r := range records {
+				if r.IP == s.Server && r.Type == "instance" {
+					records[i].Port, records[i].Method, records[i].Password = s.Port, s.Method, s.Password
+					found = true
+					adopted++
+					break
+				}
+			}
+			if found {
+				continue
+			}
+			name := s.Name
+			if name == "" {
+				name = "imported-" + strings.ReplaceAll(s.Server, ".", "-")
+			}
+			for base, i := name, 2; names[name]; i++ {
+				name = fmt.Sprintf("%s-%d", base, i)
+			}
+			names[name] = true
+			records = append(records, ProxyRecord{
+				Name:     name,
+				Provider: ProviderExternal,
+				IP:       s.Server,
+				Type:     "instance",
+				Status:   StatusActive,
+				Protocol: ProtocolShadowsocks,
+				Project:  c.config.GCP.ProjectID,
+				Port:     s.Port,
+				Method:   s.Method,
+				Password: s.Password,
+			})
+			added++
+		}
+		return records, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error saving records: %v", err)
+	}
+	fmt.Printf(tr("Imported %d servers, updated %d existing records.\n"), added, adopted)
+	return nil
+}
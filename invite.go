@@ -0,0 +1,407 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Invite 邀請碼，可以在 subscription server 兌換成 AccessKey
+type Invite struct {
+	Code      string    `json:"code"`
+	Scope     []string  `json:"scope,omitempty"` // 可使用的 proxy 名稱，空代表全部
+	Quota     int       `json:"quota"`           // 可兌換次數
+	Redeemed  int       `json:"redeemed"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Revoked   bool      `json:"revoked,omitempty"`
+}
+
+// AccessKey 兌換後取得的存取金鑰，權限與到期時間繼承自邀請碼
+type AccessKey struct {
+	Key        string    `json:"key"`
+	InviteCode string    `json:"invite_code"`
+	Scope      []string  `json:"scope,omitempty"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+type inviteData struct {
+	Invites []Invite    `json:"invites"`
+	Keys    []AccessKey `json:"keys"`
+}
+
+type InviteManager struct {
+	filePath string
+	mu       sync.Mutex
+}
+
+func NewInviteManager(filePath string) *InviteManager {
+	return &InviteManager{filePath: filePath}
+}
+
+// lock 同時鎖住程序內與檔案，避免 CLI 與 serve 同時修改
+func (m *InviteManager) lock() (func(), error) {
+	m.mu.Lock()
+	unlock, err := lockFile(m.filePath + ".lock")
+	if err != nil {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("failed to lock invites: %w", err)
+	}
+	return func() {
+		unlock()
+		m.mu.Unlock()
+	}, nil
+}
+
+func (m *InviteManager) load() (*inviteData, error) {
+	data, err := os.ReadFile(m.filePath)
+	if os.IsNotExist(err) {
+		return &inviteData{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read invites: %w", err)
+	}
+	var d inviteData
+	if err := json.Unmarshal(data, &d); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal invites: %w", err)
+	}
+	return &d, nil
+}
+
+func (m *InviteManager) save(d *inviteData) error {
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal invites: %w", err)
+	}
+	if err := writeFileAtomic(m.filePath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write invites: %w", err)
+	}
+	return nil
+}
+
+// Snapshot 回傳所有邀請碼與存取金鑰，用於備份
+func (m *InviteManager) Snapshot() (*inviteData, error) {
+	unlock, err := m.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return m.load()
+}
+
+// Restore 從備份還原邀請碼與存取金鑰，replace 為 false 時只加入還沒有的項目，回傳加入的邀請碼數
+func (m *InviteManager) Restore(backup *inviteData, replace bool) (int, error) {
+	unlock, err := m.lock()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	if replace {
+		return len(backup.Invites), m.save(backup)
+	}
+	d, err := m.load()
+	if err != nil {
+		return 0, err
+	}
+	added := 0
+	for _, inv := range backup.Invites {
+		if !slices.ContainsFunc(d.Invites, func(i Invite) bool { return i.Code == inv.Code }) {
+			d.Invites = append(d.Invites, inv)
+			added++
+		}
+	}
+	for _, key := range backup.Keys {
+		if !slices.ContainsFunc(d.Keys, func(k AccessKey) bool { return k.Key == key.Key }) {
+			d.Keys = append(d.Keys, key)
+		}
+	}
+	return added, m.save(d)
+}
+
+func (m *InviteManager) Create(scope []string, quota int, ttl time.Duration) (Invite, error) {
+	unlock, err := m.lock()
+	if err != nil {
+		return Invite{}, err
+	}
+	defer unlock()
+	d, err := m.load()
+	if err != nil {
+		return Invite{}, err
+	}
+	code, err := randomToken(8)
+	if err != nil {
+		return Invite{}, err
+	}
+	invite := Invite{Code: code, Scope: scope, Quota: quota, ExpiresAt: now().Add(ttl)}
+	d.Invites = append(d.Invites, invite)
+	return invite, m.save(d)
+}
+
+func (m *InviteManager) List() ([]Invite, error) {
+	unlock, err := m.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	d, err := m.load()
+	if err != nil {
+		return nil, err
+	}
+	return d.Invites, nil
+}
+
+// Revoke 作廢邀請碼並刪除由它兌換出的所有 AccessKey
+func (m *InviteManager) Revoke(code string) error {
+	unlock, err := m.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	d, err := m.load()
+	if err != nil {
+		return err
+	}
+	found := false
+	for i := range d.Invites {
+		if d.Invites[i].Code == code {
+			d.Invites[i].Revoked = true
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("invite not found: %s", code)
+	}
+	keys := d.Keys[:0]
+	for _, k := range d.Keys {
+		if k.InviteCode != code {
+			keys = append(keys, k)
+		}
+	}
+	d.Keys = keys
+	return m.save(d)
+}
+
+// RevokeAll 作廢所有仍然有效的邀請碼並刪除所有 AccessKey，回傳作廢的邀請碼與刪除的金鑰數量
+func (m *InviteManager) RevokeAll() (int, int, error) {
+	unlock, err := m.lock()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer unlock()
+	d, err := m.load()
+	if err != nil {
+		return 0, 0, err
+	}
+	invites := 0
+	for i := range d.Invites {
+		if !d.Invites[i].Revoked && now().Before(d.Invites[i].ExpiresAt) {
+			d.Invites[i].Revoked = true
+			invites++
+		}
+	}
+	keys := len(d.Keys)
+	if invites == 0 && keys == 0 {
+		return 0, 0, nil
+	}
+	d.Keys = nil
+	return invites, keys, m.save(d)
+}
+
+// RenameScope 把邀請碼與存取金鑰範圍中的 proxy 名稱改成新名稱
+func (m *InviteManager) RenameScope(oldName, newName string) error {
+	unlock, err := m.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	d, err := m.load()
+	if err != nil {
+		return err
+	}
+	for _, invite := range d.Invites {
+		renameInScope(invite.Scope, oldName, newName)
+	}
+	for _, key := range d.Keys {
+		renameInScope(key.Scope, oldName, newName)
+	}
+	return m.save(d)
+}
+
+func renameInScope(scope []string, oldName, newName string) {
+	for i, name := range scope {
+		if name == oldName {
+			scope[i] = newName
+		}
+	}
+}
+
+func (m *InviteManager) Redeem(code string) (AccessKey, error) {
+	unlock, err := m.lock()
+	if err != nil {
+		return AccessKey{}, err
+	}
+	defer unlock()
+	d, err := m.load()
+	if err != nil {
+		return AccessKey{}, err
+	}
+	for i := range d.Invites {
+		invite := &d.Invites[i]
+		if invite.Code != code {
+			continue
+		}
+		if invite.Revoked || now().After(invite.ExpiresAt) {
+			return AccessKey{}, fmt.Errorf("invite expired: %s", code)
+		}
+		if invite.Redeemed >= invite.Quota {
+			return AccessKey{}, fmt.Errorf("invite quota exhausted: %s", code)
+		}
+		token, err := randomToken(16)
+		if err != nil {
+			return AccessKey{}, err
+		}
+		key := AccessKey{Key: token, InviteCode: code, Scope: invite.Scope, ExpiresAt: invite.ExpiresAt}
+		invite.Redeemed++
+		d.Keys = append(d.Keys, key)
+		return key, m.save(d)
+	}
+	return AccessKey{}, fmt.Errorf("invite not found: %s", code)
+}
+
+// Lookup 驗證 AccessKey 是否存在且未過期
+func (m *InviteManager) Lookup(key string) (AccessKey, error) {
+	unlock, err := m.lock()
+	if err != nil {
+		return AccessKey{}, err
+	}
+	defer unlock()
+	d, err := m.load()
+	if err != nil {
+		return AccessKey{}, err
+	}
+	for _, k := range d.Keys {
+		if k.Key == key {
+			if now().After(k.ExpiresAt) {
+				return AccessKey{}, fmt.Errorf("access key expired")
+			}
+			return k, nil
+		}
+	}
+	return AccessKey{}, fmt.Errorf("access key not found")
+}
+
+// Allows 判斷 AccessKey 是否可以使用某個 proxy
+func (k AccessKey) Allows(name string) bool {
+	if len(k.Scope) == 0 {
+		return true
+	}
+	for _, s := range k.Scope {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (c *Commander) InviteCreate(scope []string, quota int, ttl time.Duration) error {
+	invite, err := c.invites.Create(scope, quota, ttl)
+	if err != nil {
+		return fmt.Errorf("error creating invite: %v", err)
+	}
+	fmt.Printf(tr("Invite code: %s (quota: %d, expires: %s)\n"), invite.Code, invite.Quota, invite.ExpiresAt.Format(time.RFC3339))
+	return nil
+}
+
+func (c *Commander) InviteList() error {
+	invites, err := c.invites.List()
+	if err != nil {
+		return fmt.Errorf("error loading invites: %v", err)
+	}
+	if len(invites) == 0 {
+		fmt.Println(tr("No invites found."))
+		return nil
+	}
+	for _, i := range invites {
+		scope := "all"
+		if len(i.Scope) > 0 {
+			scope = strings.Join(i.Scope, ",")
+		}
+		state := "active"
+		if i.Revoked {
+			state = "revoked"
+		} else if now().After(i.ExpiresAt) {
+			state = "expired"
+		}
+		fmt.Printf(tr("Code: %s, Scope: %s, Redeemed: %d/%d, Expires: %s, State: %s\n"), i.Code, scope, i.Redeemed, i.Quota, i.ExpiresAt.Format(time.RFC3339), state)
+	}
+	return nil
+}
+
+func (c *Commander) InviteRevoke(code string) error {
+	if err := c.invites.Revoke(code); err != nil {
+		return fmt.Errorf("error revoking invite: %v", err)
+	}
+	fmt.Printf(tr("Invite %s revoked.\n"), code)
+	return nil
+}
+
+// caDir 不為空時以 HTTPS 提供服務，並要求用戶端出示這個目錄的 CA 簽發的憑證
+// expireInterval 大於 0 時定期移除到期的 proxy 使用者，reapInterval 大於 0 時定期刪除到期的 proxy
+func (c *Commander) Serve(ctx context.Context, addr string, authSchemes []string, caDir string, expireInterval, reapInterval time.Duration) error {
+	auth, err := NewAuthenticator(authSchemes)
+	if err != nil {
+		return err
+	}
+	if expireInterval > 0 {
+		go c.expireUsersEvery(ctx, expireInterval)
+	}
+	if reapInterval > 0 {
+		go c.reapEvery(ctx, reapInterval)
+	}
+	var tlsConfig *tls.Config
+	if caDir != "" {
+		if tlsConfig, err = mtlsConfig(caDir); err != nil {
+			return err
+		}
+	}
+	return NewSubscriptionServer(c.recordManager, c.invites, auth, c.logger).ListenAndServe(addr, tlsConfig)
+}
+
+// InviteLink 產生有期限的簽章訂閱網址，期限不會超過 access key 本身的有效期限
+func (c *Commander) InviteLink(key, baseURL string, ttl time.Duration) error {
+	accessKey, err := c.invites.Lookup(key)
+	if err != nil {
+		return err
+	}
+	signer, err := NewURLSignerFromEnv()
+	if err != nil {
+		return err
+	}
+	expires := now().Add(ttl)
+	if accessKey.ExpiresAt.Before(expires) {
+		expires = accessKey.ExpiresAt
+	}
+	link, err := signer.Sign(strings.TrimSuffix(baseURL, "/")+"/subscription?key="+url.QueryEscape(key), expires)
+	if err != nil {
+		return err
+	}
+	fmt.Println(link)
+	fmt.Printf(tr("Expires: %s\n"), expires.Format(time.RFC3339))
+	return nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IPChecker 查詢對外 IP，allowlist、GeoIP 與連線測試都透過它取得 IP，不要各自呼叫外部服務
+type IPChecker interface {
+	// PublicIP 回傳本機對外的 IP
+	PublicIP(ctx context.Context) (string, error)
+	// ExitIP 回傳透過 proxy 連線時對外看到的 IP
+	ExitIP(ctx context.Context, record ProxyRecord) (string, error)
+}
+
+var defaultIPServices = []string{
+	"https://api.ipify.org",
+	"https://ifconfig.me/ip",
+	"https://icanhazip.com",
+	"https://checkip.amazonaws.com",
+}
+
+// CachingIPChecker 依序嘗試多個服務，結果快取 ttl，並限制兩次實際查詢之間至少間隔 minInterval
+type CachingIPChecker struct {
+	services    []string
+	client      *http.Client
+	ttl         time.Duration
+	minInterval time.Duration
+	sshUser     string
+	sshKeyPath  string
+
+	mu        sync.Mutex
+	cache     map[string]cachedIP
+	lastQuery time.Time
+}
+
+type cachedIP struct {
+	ip      string
+	fetched time.Time
+}
+
+func NewCachingIPChecker(sshUser, sshKeyPath string) *CachingIPChecker {
+	return &CachingIPChecker{
+		services:    defaultIPServices,
+		client:      &http.Client{Timeout: 5 * time.Second},
+		ttl:         5 * time.Minute,
+		minInterval: time.Second,
+		sshUser:     sshUser,
+		sshKeyPath:  sshKeyPath,
+		cache:       make(map[string]cachedIP),
+	}
+}
+
+func (c *CachingIPChecker) PublicIP(ctx context.Context) (string, error) {
+	return c.cached("local", func() (string, error) {
+		var lastErr error
+		for _, service := range c.services {
+			ip, err := c.fetch(ctx, service)
+			if err == nil {
+				return ip, nil
+			}
+			lastErr = err
+		}
+		return "", fmt.Errorf("all IP services failed: %v", lastErr)
+	})
+}
+
+// ExitIP 從 proxy 主機上查詢對外 IP
+func (c *CachingIPChecker) ExitIP(ctx context.Context, record ProxyRecord) (string, error) {
+	user, keyPath := record.SSHUser, record.SSHKeyPath
+	if user == "" {
+		user = c.sshUser
+	}
+	if keyPath == "" {
+		keyPath = c.sshKeyPath
+	}
+	return c.cached("proxy:"+record.Name, func() (string, error) {
+		var lastErr error
+		for _, service := range c.services {
+			args := append(record.DeployTarget().sshOptions(keyPath), fmt.Sprintf("%s@%s", user, record.IP), "curl", "-s", "--max-time", "5", service)
+			cmd := exec.CommandContext(ctx, "ssh", args...)
+			out, err := cmd.Output()
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			ip, err := parseIP(string(out))
+			if err == nil {
+				return ip, nil
+			}
+			lastErr = err
+		}
+		return "", fmt.Errorf("failed to get exit IP of %s: %v", record.Name, lastErr)
+	})
+}
+
+func (c *CachingIPChecker) cached(key string, fetch func() (string, error)) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.cache[key]; ok && time.Since(entry.fetched) < c.ttl {
+		return entry.ip, nil
+	}
+	if wait := c.minInterval - time.Since(c.lastQuery); wait > 0 {
+		time.Sleep(wait)
+	}
+	c.lastQuery = time.Now()
+	ip, err := fetch()
+	if err != nil {
+		return "", err
+	}
+	c.cache[key] = cachedIP{ip: ip, fetched: time.Now()}
+	return ip, nil
+}
+
+func (c *CachingIPChecker) fetch(ctx context.Context, service string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, service, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", userAgent())
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s returned %s", service, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+	if err != nil {
+		return "", err
+	}
+	return parseIP(string(body))
+}
+
+func parseIP(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if net.ParseIP(s) == nil {
+		return "", fmt.Errorf("invalid IP in response: %q", s)
+	}
+	return s, nil
+}
+
+// IP 顯示本機對外 IP，指定 name 時同時顯示該 proxy 的出口 IP
+func (c *Commander) IP(ctx context.Context, name string) error {
+	ip, err := c.ipChecker.PublicIP(ctx)
+	if err != nil {
+		return fmt.Errorf("error checking public IP: %v", err)
+	}
+	fmt.Printf(tr("Public IP: %s\n"), ip)
+	if name == "" {
+		return nil
+	}
+
+	records, err := c.recordManager.Load()
+	if err != nil {
+		return fmt.Errorf("error loading records: %v", err)
+	}
+	for _, r := range records {
+		if r.Name == name && r.Type == "instance" {
+			exit, err := c.ipChecker.ExitIP(ctx, r)
+			if err != nil {
+				return fmt.Errorf("error checking exit IP: %v", err)
+			}
+			fmt.Printf(tr("Exit IP of %s: %s\n"), name, exit)
+			return nil
+		}
+	}
+	fmt.Printf(tr("Proxy not found: %s\n"), name)
+	return nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+)
+
+// 日誌記錄的雲端操作
+const (
+	JournalInsertInstance = "instance.insert"
+	JournalDeleteInstance = "instance.delete"
+	JournalDeleteDisk     = "disk.delete"
+)
+
+// staleJournalAge 其他電腦留下的項目無法確認程序是否還在執行，超過這個時間才重新處理
+const staleJournalAge = 24 * time.Hour
+
+// JournalEntry 一項進行中的雲端操作，Proxy 為空表示沒有對應的紀錄，例如 bake 的暫時 instance
+type JournalEntry struct {
+	ID       string    `json:"id"`
+	Op       string    `json:"op"`
+	Project  string    `json:"project,omitempty"`
+	Proxy    string    `json:"proxy,omitempty"`
+	Zone     string    `json:"zone"`
+	Resource string    `json:"resource"`
+	Host     string    `json:"host"`
+	PID      int       `json:"pid"`
+	Started  time.Time `json:"started"`
+}
+
+// Journal 在雲端操作之前寫入、完成後移除，程序中途被中斷時留下的項目在下次執行時重新處理，
+// 避免留下沒有紀錄、持續計費的資源。nil 的 Journal 不記錄任何操作，dry run 時使用
+// 所有 profile 共用同一個檔案，每個項目記錄所屬的 GCP project，只處理目前 project 的項目
+type Journal struct {
+	path    string
+	project string
+}
+
+// Begin 記錄即將進行的操作，回傳的函式在操作完成後移除這個項目
+func (j *Journal) Begin(op, proxy, zone, resource string) (func(), error) {
+	if j == nil {
+		return func() {}, nil
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	entry := JournalEntry{ID: hex.EncodeToString(id), Op: op, Project: j.project, Proxy: proxy, Zone: zone, Resource: resource, PID: os.Getpid(), Started: time.Now()}
+	entry.Host, _ = os.Hostname()
+	err := j.update(func(entries []JournalEntry) []JournalEntry {
+		return append(entries, entry)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// 移除失敗時項目會在下次執行時重新處理，處理方式不會重複修改雲端資源
+	return func() { j.remove(entry.ID) }, nil
+}
+
+func (j *Journal) remove(id string) error {
+	return j.update(func(entries []JournalEntry) []JournalEntry {
+		return slices.DeleteFunc(entries, func(e JournalEntry) bool { return e.ID == id })
+	})
+}
+
+// Stale 回傳目前 project 中執行的程序已經結束的項目
+// 其他 project 的項目在錯誤的 project 查不到資源，會被誤判為已完成，留給該 project 的 profile 處理。
+// 舊版沒有記錄 project 的項目維持原本的處理方式
+func (j *Journal) Stale() ([]JournalEntry, error) {
+	if j == nil {
+		return nil, nil
+	}
+	entries, err := j.load()
+	if err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	var stale []JournalEntry
+	for _, e := range entries {
+		if e.Project != "" && e.Project != j.project {
+			continue
+		}
+		if e.Host == host && e.PID != os.Getpid() && !processAlive(e.PID) ||
+			e.Host != host && time.Since(e.Started) > staleJournalAge {
+			stale = append(stale, e)
+		}
+	}
+	return stale, nil
+}
+
+func (j *Journal) load() ([]JournalEntry, error) {
+	data, err := os.ReadFile(j.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read journal: %w", err)
+	}
+	var entries []JournalEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse journal %s: %w", j.path, err)
+	}
+	return entries, nil
+}
+
+func (j *Journal) update(fn func([]JournalEntry) []JournalEntry) error {
+	unlock, err := lockFile(j.path + ".lock")
+	if err != nil {
+		return fmt.Errorf("failed to lock journal: %w", err)
+	}
+	defer unlock()
+	entries, err := j.load()
+	if err != nil {
+		return err
+	}
+	entries = fn(entries)
+	if len(entries) == 0 {
+		if err := os.Remove(j.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to write journal: %w", err)
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal journal: %w", err)
+	}
+	return writeFileAtomic(j.path, data, 0600)
+}
+
+// recoverJournal 重新處理上次被中斷的操作，處理成功的項目從日誌移除，失敗的留到下次
+func (c *Commander) recoverJournal(ctx context.Context) {
+	entries, err := c.journal.Stale()
+	if err != nil {
+		c.logger.Printf("Error reading journal: %v", err)
+		return
+	}
+	for _, e := range entries {
+		if err := c.recoverEntry(ctx, e); err != nil {
+			c.logger.Printf("Error recovering %s %s: %v", e.Op, e.Resource, err)
+			fmt.Fprintf(os.Stderr, tr("Warning: failed to recover the interrupted %s of %s: %v\n"), e.Op, e.Resource, err)
+			continue
+		}
+		if err := c.journal.remove(e.ID); err != nil {
+			c.logger.Printf("Error updating journal: %v", err)
+		}
+	}
+}
+
+// recoverEntry 依操作的種類完成或清除中斷的操作
+// 建立到一半的 instance 有紀錄時補上紀錄讓 resume 繼續部署，沒有紀錄時刪除；刪除到一半的資源再刪除一次
+func (c *Commander) recoverEntry(ctx context.Context, e JournalEntry) error {
+	switch e.Op {
+	case JournalInsertInstance:
+		info, err := c.provider.GetInstanceInfo(ctx, e.Zone, e.Resource)
+		if errors.Is(err, ErrInstanceNotFound) {
+			return c.markCreateFailed(e.Proxy)
+		}
+		if err != nil {
+			return err
+		}
+		record, found, err := c.findJournalRecord(e.Proxy)
+		if err != nil {
+			return err
+		}
+		if !found {
+			fmt.Fprintf(os.Stderr, tr("Deleting instance %s left behind by an interrupted operation\n"), e.Resource)
+			if err := c.provider.DeleteInstance(ctx, e.Zone, e.Resource); err != nil && !errors.Is(err, ErrInstanceNotFound) {
+				return err
+			}
+			if info.DiskID == "" {
+				return nil
+			}
+			if err := c.provider.DeleteDisk(ctx, e.Zone, info.DiskID); err != nil && !errors.Is(err, ErrDiskNotFound) {
+				return err
+			}
+			return nil
+		}
+		if status := record.Lifecycle(); (status == StatusCreating || status == StatusFailed) && record.IP == "" {
+			_, err := c.recordManager.SetStatus(record.Name, StatusProvisioning, func(r *ProxyRecord) {
+				r.InstanceID, r.IP, r.DiskID = e.Resource, info.IP, info.DiskID
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, tr("Recovered proxy %s from an interrupted create, run `auto_proxy resume --name %s` to deploy it\n"), record.Name, record.Name)
+		}
+		return nil
+	case JournalDeleteInstance:
+		if err := c.provider.DeleteInstance(ctx, e.Zone, e.Resource); err != nil && !errors.Is(err, ErrInstanceNotFound) {
+			return err
+		}
+		return nil
+	case JournalDeleteDisk:
+		if err := c.provider.DeleteDisk(ctx, e.Zone, e.Resource); err != nil && !errors.Is(err, ErrDiskNotFound) {
+			return err
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown journal operation %q", e.Op)
+}
+
+// markCreateFailed 中斷時還沒有建立 instance 的紀錄標示為 failed
+func (c *Commander) markCreateFailed(name string) error {
+	record, found, err := c.findJournalRecord(name)
+	if err != nil || !found || record.Lifecycle() != StatusCreating {
+		return err
+	}
+	_, err = c.recordManager.SetStatus(name, StatusFailed, nil)
+	return err
+}
+
+// findJournalRecord 日誌項目沒有對應的紀錄時 name 為空
+func (c *Commander) findJournalRecord(name string) (ProxyRecord, bool, error) {
+	if name == "" {
+		return ProxyRecord{}, false, nil
+	}
+	record, found, err := c.recordManager.FindByName(name)
+	if err != nil {
+		return ProxyRecord{}, false, fmt.Errorf("error loading records: %v", err)
+	}
+	return record, found, nil
+}
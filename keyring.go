@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/zalando/go-keyring"
+	"golang.org/x/term"
+	"google.golang.org/api/option"
+)
+
+// keyringEnvSecrets 可以改存在 OS keyring 的環境變數，環境變數沒有設定時從 keyring 讀取
+var keyringEnvSecrets = []string{
+	"AUTO_PROXY_PASSPHRASE",
+	"AUTO_PROXY_SERVE_TOKEN",
+	"AUTO_PROXY_SERVE_PASSWORD",
+	"AUTO_PROXY_SERVE_SIGNING_KEY",
+}
+
+// serveSecrets state rekey --rotate-tokens 更換的 serve 密鑰
+var serveSecrets = []string{
+	"AUTO_PROXY_SERVE_TOKEN",
+	"AUTO_PROXY_SERVE_PASSWORD",
+	"AUTO_PROXY_SERVE_SIGNING_KEY",
+}
+
+// gcp.credentials 為 keyring 或 keyring:<name> 時服務帳戶金鑰的 JSON 存在 OS keyring，
+// 以 secret set gcp-credentials[:<name>] --from-file 匯入
+const (
+	keyringCredentials        = "keyring"
+	keyringCredentialsAccount = "gcp-credentials"
+)
+
+// keyringRefPrefix proxy 密碼存在 OS keyring 時 PasswordRef 的前綴
+const keyringRefPrefix = "keyring:"
+
+// secretEnv 讀取環境變數，沒有設定時改從 OS keyring 讀取，都沒有時回傳空字串
+func secretEnv(name string) string {
+	if value := os.Getenv(name); value != "" {
+		registerSecret(value)
+		return value
+	}
+	value, err := keyring.Get(keyringService, name)
+	if err != nil {
+		if !errors.Is(err, keyring.ErrNotFound) {
+			debugf("OS keyring unavailable, cannot read %s: %v", name, err)
+		}
+		return ""
+	}
+	registerSecret(value)
+	return value
+}
+
+// credentialsAccount 回傳 gcp.credentials 指向 keyring 時的帳號，不是 keyring 時 ok 為 false
+func credentialsAccount(credentials string) (account string, ok bool) {
+	if credentials == keyringCredentials {
+		return keyringCredentialsAccount, true
+	}
+	if name, found := strings.CutPrefix(credentials, keyringRefPrefix); found && name != "" {
+		return keyringCredentialsAccount + ":" + name, true
+	}
+	return "", false
+}
+
+// gcpCredentialsOption 依 gcp.credentials 回傳連線 GCP 的 client option
+func gcpCredentialsOption(credentials string) (option.ClientOption, error) {
+	if _, ok := credentialsAccount(credentials); !ok {
+		return option.WithCredentialsFile(credentials), nil
+	}
+	data, err := credentialsJSON(credentials)
+	if err != nil {
+		return nil, err
+	}
+	return option.WithCredentialsJSON(data), nil
+}
+
+// credentialsJSON 讀取 gcp.credentials 指向的服務帳戶金鑰內容，檔案或 OS keyring 都可以
+func credentialsJSON(credentials string) ([]byte, error) {
+	account, ok := credentialsAccount(credentials)
+	if !ok {
+		data, err := os.ReadFile(credentials)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read GCP credentials: %w", err)
+		}
+		return data, nil
+	}
+	data, err := keyring.Get(keyringService, account)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return nil, fmt.Errorf("no GCP credentials in the OS keyring, run `auto_proxy secret set %s --from-file <key.json>`", account)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read GCP credentials from the OS keyring: %w", err)
+	}
+	return []byte(data), nil
+}
+
+// keyringStore 以 OS keyring 存放 proxy 密碼，參照為 keyring:proxy/<project>/<name>
+// 只有這台電腦讀得到，其他電腦共用紀錄時應該使用 Secret Manager 或 Vault
+type keyringStore struct {
+	project string
+}
+
+func (k *keyringStore) Put(ctx context.Context, name, value string) (string, error) {
+	account := "proxy/" + k.project + "/" + name
+	if err := keyring.Set(keyringService, account, value); err != nil {
+		return "", fmt.Errorf("failed to store %s in the OS keyring: %w", name, err)
+	}
+	return keyringRefPrefix + account, nil
+}
+
+func (k *keyringStore) Get(ctx context.Context, ref string) (string, error) {
+	value, err := keyring.Get(keyringService, strings.TrimPrefix(ref, keyringRefPrefix))
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s from the OS keyring: %w", ref, err)
+	}
+	return value, nil
+}
+
+func (k *keyringStore) Delete(ctx context.Context, ref string) error {
+	err := keyring.Delete(keyringService, strings.TrimPrefix(ref, keyringRefPrefix))
+	if err != nil && !errors.Is(err, keyring.ErrNotFound) {
+		return fmt.Errorf("failed to delete %s from the OS keyring: %w", ref, err)
+	}
+	return nil
+}
+
+// checkSecretName 檢查 secret 指令的名稱，名稱就是 keyring 中的帳號，credentials 表示服務帳戶金鑰
+func checkSecretName(name string) (credentials bool, err error) {
+	if slices.Contains(keyringEnvSecrets, name) {
+		return false, nil
+	}
+	if name == keyringCredentialsAccount || strings.HasPrefix(name, keyringCredentialsAccount+":") {
+		return true, nil
+	}
+	return false, fmt.Errorf("unknown secret %s (supported: %s, %s[:<name>])", name, strings.Join(keyringEnvSecrets, ", "), keyringCredentialsAccount)
+}
+
+// SecretSet 把 name 的值存到 OS keyring，fromFile 不為空時讀取檔案內容，否則從終端機或標準輸入讀取
+func SecretSet(name, fromFile string) error {
+	credentials, err := checkSecretName(name)
+	if err != nil {
+		return err
+	}
+	if credentials && fromFile == "" {
+		return fmt.Errorf("use --from-file with the service account key file")
+	}
+	var value string
+	switch {
+	case fromFile != "":
+		data, err := os.ReadFile(fromFile)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", fromFile, err)
+		}
+		value = strings.TrimSpace(string(data))
+	case term.IsTerminal(int(os.Stdin.Fd())):
+		fmt.Fprintf(os.Stderr, tr("Value of %s: "), name)
+		data, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return fmt.Errorf("failed to read the value: %v", err)
+		}
+		value = string(data)
+	default:
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("failed to read the value from stdin: %v", err)
+		}
+		value = strings.TrimRight(line, "\r\n")
+	}
+	if value == "" {
+		return fmt.Errorf("empty value for %s", name)
+	}
+	if credentials && !json.Valid([]byte(value)) {
+		return fmt.Errorf("%s is not a service account key file", fromFile)
+	}
+	if err := keyring.Set(keyringService, name, value); err != nil {
+		return fmt.Errorf("failed to store %s in the OS keyring: %w", name, err)
+	}
+	fmt.Printf(tr("%s stored in the OS keyring.\n"), name)
+	if credentials {
+		setting := strings.Replace(name, keyringCredentialsAccount, keyringCredentials, 1)
+		fmt.Printf(tr("Run `auto_proxy config set gcp.credentials %s` to use it, then delete %s.\n"), setting, fromFile)
+	}
+	return nil
+}
+
+// SecretDelete 從 OS keyring 刪除 name
+func SecretDelete(name string) error {
+	if _, err := checkSecretName(name); err != nil {
+		return err
+	}
+	if err := keyring.Delete(keyringService, name); err != nil {
+		if errors.Is(err, keyring.ErrNotFound) {
+			return fmt.Errorf("%s is not stored in the OS keyring", name)
+		}
+		return fmt.Errorf("failed to delete %s from the OS keyring: %w", name, err)
+	}
+	fmt.Printf(tr("%s deleted from the OS keyring.\n"), name)
+	return nil
+}
+
+// SecretList 列出可以存在 OS keyring 的值目前從哪裡讀取，不顯示值
+// 服務帳戶金鑰只列出目前設定使用的帳號
+func SecretList(credentials string) error {
+	names := slices.Clone(keyringEnvSecrets)
+	if account, ok := credentialsAccount(credentials); ok {
+		names = append(names, account)
+	}
+	for _, name := range names {
+		source := tr("not set")
+		if os.Getenv(name) != "" {
+			source = tr("environment")
+		} else if _, err := keyring.Get(keyringService, name); err == nil {
+			source = tr("OS keyring")
+		}
+		fmt.Printf("%-30s %s\n", name, source)
+	}
+	return nil
+}
+
+// rotatedSecret 更換後的 serve 密鑰
+type rotatedSecret struct {
+	Name  string
+	Value string
+}
+
+// rotateServeSecrets 為存在 OS keyring 的 serve 密鑰產生新的隨機值，環境變數優先時不更換
+func rotateServeSecrets() ([]rotatedSecret, error) {
+	var rotated []rotatedSecret
+	for _, name := range serveSecrets {
+		if os.Getenv(name) != "" {
+			continue
+		}
+		if _, err := keyring.Get(keyringService, name); err != nil {
+			continue
+		}
+		value, err := randomToken(24)
+		if err != nil {
+			return rotated, err
+		}
+		if err := keyring.Set(keyringService, name, value); err != nil {
+			return rotated, fmt.Errorf("failed to store %s in the OS keyring: %w", name, err)
+		}
+		rotated = append(rotated, rotatedSecret{Name: name, Value: value})
+	}
+	return rotated, nil
+}
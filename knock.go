@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// knockLength knock 序列的連接埠數量
+const knockLength = 3
+
+// knockOpenSeconds knock 之後 SSH 對來源開放的時間，已經建立的連線在關閉後仍然有效
+const knockOpenSeconds = 3600
+
+// knockFile 記錄主機上目前設定的 knock 序列，探測部署狀態時比對
+const knockFile = "/etc/auto-proxy/knock"
+
+// randomKnockPorts 在 --randomize 的範圍內挑選不重複的 knock 序列，避開 used 中的連接埠
+func randomKnockPorts(used ...int) []int {
+	ports := make([]int, 0, knockLength)
+	for len(ports) < knockLength {
+		port := randomPortMin + rand.Intn(randomPortMax-randomPortMin+1)
+		if !slices.Contains(ports, port) && !slices.Contains(used, port) {
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
+
+// knockMarker 回傳寫入 knockFile 的內容，沒有 knock 序列時為空字串
+func knockMarker(ports []int) string {
+	if len(ports) == 0 {
+		return ""
+	}
+	values := make([]string, len(ports))
+	for i, port := range ports {
+		values[i] = strconv.Itoa(port)
+	}
+	return strings.Join(values, ",") + "\n"
+}
+
+// knock 依序對連接埠送出 TCP SYN，knockd 從網卡看到封包就算數，不需要等連線建立
+func knock(ip string, ports []int) {
+	for _, port := range ports {
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip, strconv.Itoa(port)), 300*time.Millisecond)
+		if err == nil {
+			conn.Close()
+		}
+	}
+}
+
+// knockTasks 產生安裝 knockd 的 tasks，需要在 UFW tasks 之前，UFW 關閉 SSH 前先讓部署的來源保持開放
+func knockTasks(ports []int, sshPort int) string {
+	if len(ports) == 0 {
+		return ""
+	}
+	if sshPort == 0 {
+		sshPort = defaultSSHPort
+	}
+	marker := knockMarker(ports)
+	var b strings.Builder
+	b.WriteString("    - name: Configure SSH port knocking\n      block:\n")
+	b.WriteString("        - name: Install knockd\n          apt:\n            name: knockd\n            state: present\n")
+	config := fmt.Sprintf(`[options]
+    UseSyslog
+[openSSH]
+    sequence = %s
+    seq_timeout = 10
+    tcpflags = syn
+    start_command = /usr/sbin/ufw allow from %%IP%% to any port %d proto tcp
+    cmd_timeout = %d
+    stop_command = /usr/sbin/ufw delete allow from %%IP%% to any port %d proto tcp`, strings.TrimSuffix(marker, "\n"), sshPort, knockOpenSeconds, sshPort)
+	fmt.Fprintf(&b, "        - name: Configure knockd\n          copy:\n            content: |\n%s\n            dest: /etc/knockd.conf\n          notify: Restart knockd\n", indent(config, 14))
+	// knockd 預設監聽 eth0，GCE 的網卡名稱不同，改用預設路由的網卡
+	b.WriteString(`        - name: Listen for knocks on the default interface
+          shell: |
+            iface=$(ip route show default | awk '{print $5; exit}')
+            printf 'START_KNOCKD=1\nKNOCKD_OPTS="-i %s"\n' "$iface" > /etc/default/knockd.new
+            if cmp -s /etc/default/knockd.new /etc/default/knockd; then rm /etc/default/knockd.new; else mv /etc/default/knockd.new /etc/default/knockd; echo changed; fi
+          register: knockd_defaults
+          changed_when: "'changed' in knockd_defaults.stdout"
+          notify: Restart knockd
+        - name: Find the deploying source
+          shell: echo "${SSH_CLIENT%% *}"
+          become: no
+          register: knock_source
+          changed_when: false
+`)
+	// 第一次部署時 knockd 還沒有執行，之前的 knock 沒有作用，以相同的規則讓這次部署的來源保持開放
+	b.WriteString("        - name: Keep SSH open for this deployment\n          shell: |\n")
+	fmt.Fprintf(&b, "            ufw allow from {{ knock_source.stdout }} to any port %d proto tcp\n", sshPort)
+	fmt.Fprintf(&b, "            systemd-run --on-active=%d /usr/sbin/ufw delete allow from {{ knock_source.stdout }} to any port %d proto tcp\n", knockOpenSeconds, sshPort)
+	b.WriteString("          changed_when: false\n")
+	b.WriteString("        - name: Ensure knockd is enabled and started\n          systemd:\n            name: knockd\n            enabled: yes\n            state: started\n")
+	fmt.Fprintf(&b, "        - name: Create auto_proxy state directory\n          file:\n            path: %s\n            state: directory\n            mode: '0755'\n", path.Dir(knockFile))
+	fmt.Fprintf(&b, "        - name: Record knock sequence\n          copy:\n            content: |\n%s\n            dest: %s\n", indent(strings.TrimSuffix(marker, "\n"), 14), knockFile)
+	b.WriteString("      tags: [config]\n")
+	return b.String()
+}
+
+// knockHandler 重新啟動 knockd，沒有 knock 序列時為空字串
+func knockHandler(ports []int) string {
+	if len(ports) == 0 {
+		return ""
+	}
+	return `    - name: Restart knockd
+      systemd:
+        name: knockd
+        state: restarted
+`
+}
+
+// SSH 以部署使用的帳號與金鑰連線到 proxy，有 knock 序列時先 knock 開啟 SSH，command 為空時開啟互動的 shell
+func (c *Commander) SSH(name string, command []string) error {
+	record, found, err := c.recordManager.FindByName(name)
+	if err != nil {
+		return fmt.Errorf("error loading records: %v", err)
+	}
+	if !found {
+		return fmt.Errorf("proxy not found: %s", name)
+	}
+	if record.IP == "" {
+		return fmt.Errorf("proxy %s has no IP address yet", name)
+	}
+	target := record.DeployTarget()
+	user, keyPath := target.SSHUser, target.SSHKeyPath
+	if user == "" {
+		user = c.config.SSH.User
+	}
+	if keyPath == "" {
+		keyPath = c.config.SSH.KeyPath
+	}
+	if len(target.KnockPorts) > 0 {
+		c.logger.Printf("Knocking on %s before connecting", name)
+		knock(target.IP, target.KnockPorts)
+	}
+	cmd := exec.Command("ssh", append(append(target.sshOptions(keyPath), user+"@"+target.IP), command...)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ssh to %s failed: %v", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// lastCreate 上一次 create 精靈的選擇，下一次作為選單的預設值，create --last 時直接沿用
+type lastCreate struct {
+	Platform    string `json:"platform"`
+	Region      string `json:"region"`
+	Zone        string `json:"zone"`
+	MachineType string `json:"machine_type"`
+	Protocol    string `json:"protocol"`
+	OS          string `json:"os,omitempty"`    // 全新安裝的作業系統
+	Image       string `json:"image,omitempty"` // 預先安裝好 proxy 的映像檔
+}
+
+// lastCreatePath 與設定檔放在同一個目錄
+func lastCreatePath() (string, error) {
+	path, err := configPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(path), "last_create.json"), nil
+}
+
+// loadLastCreate 讀取上一次的選擇，還沒有建立過時回傳 nil
+func loadLastCreate() (*lastCreate, error) {
+	path, err := lastCreatePath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read last create selections: %v", err)
+	}
+	var last lastCreate
+	if err := json.Unmarshal(data, &last); err != nil {
+		return nil, fmt.Errorf("failed to parse last create selections: %v", err)
+	}
+	return &last, nil
+}
+
+func (l *lastCreate) save() error {
+	path, err := lastCreatePath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create config directory: %v", err)
+	}
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal last create selections: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to save last create selections: %v", err)
+	}
+	return nil
+}
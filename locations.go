@@ -0,0 +1,113 @@
+package main
+
+// locationNames 各語言的 region 顯示名稱，key 為 region 代碼，所有 provider 共用
+// 沒有目前語言的名稱時使用英文，仍然沒有時直接顯示 region 代碼
+var locationNames = map[string]map[string]string{
+	LangEnglish: {
+		"africa-south1":           "Johannesburg",
+		"asia-east1":              "Taiwan",
+		"asia-east2":              "Hong Kong",
+		"asia-northeast1":         "Tokyo",
+		"asia-northeast2":         "Osaka",
+		"asia-northeast3":         "Seoul",
+		"asia-south1":             "Mumbai",
+		"asia-south2":             "Delhi",
+		"asia-southeast1":         "Singapore",
+		"asia-southeast2":         "Jakarta",
+		"australia-southeast1":    "Sydney",
+		"australia-southeast2":    "Melbourne",
+		"europe-central2":         "Warsaw",
+		"europe-north1":           "Finland",
+		"europe-north2":           "Stockholm",
+		"europe-southwest1":       "Madrid",
+		"europe-west1":            "Belgium",
+		"europe-west10":           "Berlin",
+		"europe-west12":           "Turin",
+		"europe-west2":            "London",
+		"europe-west3":            "Frankfurt",
+		"europe-west4":            "Netherlands",
+		"europe-west6":            "Zurich",
+		"europe-west8":            "Milan",
+		"europe-west9":            "Paris",
+		"me-central1":             "Doha",
+		"me-central2":             "Dammam",
+		"me-west1":                "Tel Aviv",
+		"northamerica-northeast1": "Montréal",
+		"northamerica-northeast2": "Toronto",
+		"northamerica-south1":     "Mexico",
+		"southamerica-east1":      "São Paulo",
+		"southamerica-west1":      "Santiago",
+		"us-central1":             "Iowa",
+		"us-east1":                "South Carolina",
+		"us-east4":                "Northern Virginia",
+		"us-east5":                "Columbus",
+		"us-south1":               "Dallas",
+		"us-west1":                "Oregon",
+		"us-west2":                "Los Angeles",
+		"us-west3":                "Salt Lake City",
+		"us-west4":                "Las Vegas",
+	},
+	LangTraditionalChinese: {
+		"africa-south1":           "約翰尼斯堡",
+		"asia-east1":              "台灣",
+		"asia-east2":              "香港",
+		"asia-northeast1":         "東京",
+		"asia-northeast2":         "大阪",
+		"asia-northeast3":         "首爾",
+		"asia-south1":             "孟買",
+		"asia-south2":             "德里",
+		"asia-southeast1":         "新加坡",
+		"asia-southeast2":         "雅加達",
+		"australia-southeast1":    "雪梨",
+		"australia-southeast2":    "墨爾本",
+		"europe-central2":         "華沙",
+		"europe-north1":           "芬蘭",
+		"europe-north2":           "斯德哥爾摩",
+		"europe-southwest1":       "馬德里",
+		"europe-west1":            "比利時",
+		"europe-west10":           "柏林",
+		"europe-west12":           "杜林",
+		"europe-west2":            "倫敦",
+		"europe-west3":            "法蘭克福",
+		"europe-west4":            "荷蘭",
+		"europe-west6":            "蘇黎世",
+		"europe-west8":            "米蘭",
+		"europe-west9":            "巴黎",
+		"me-central1":             "杜哈",
+		"me-central2":             "達曼",
+		"me-west1":                "特拉維夫",
+		"northamerica-northeast1": "蒙特婁",
+		"northamerica-northeast2": "多倫多",
+		"northamerica-south1":     "墨西哥",
+		"southamerica-east1":      "聖保羅",
+		"southamerica-west1":      "聖地牙哥",
+		"us-central1":             "愛荷華州",
+		"us-east1":                "南卡羅來納州",
+		"us-east4":                "北維吉尼亞州",
+		"us-east5":                "哥倫布",
+		"us-south1":               "達拉斯",
+		"us-west1":                "奧勒岡州",
+		"us-west2":                "洛杉磯",
+		"us-west3":                "鹽湖城",
+		"us-west4":                "拉斯維加斯",
+	},
+}
+
+// locationName 回傳 region 在目前語言的顯示名稱
+func locationName(region string) string {
+	if name, ok := locationNames[currentLang][region]; ok {
+		return name
+	}
+	if name, ok := locationNames[LangEnglish][region]; ok {
+		return name
+	}
+	return region
+}
+
+// DisplayLocation 回傳紀錄以目前語言顯示的地點，沒有 region 的紀錄 (例如匯入的伺服器) 使用記錄的名稱
+func (r ProxyRecord) DisplayLocation() string {
+	if r.Region == "" {
+		return r.Location
+	}
+	return locationName(r.Region)
+}
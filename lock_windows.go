@@ -0,0 +1,34 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// staleLockAge 超過這個時間的鎖檔視為失效
+const staleLockAge = 2 * time.Minute
+
+// lockFile 取得 path 的獨佔鎖，回傳的函式用來釋放
+// Windows 沒有 flock，改用 O_EXCL 建立鎖檔
+func lockFile(path string) (func(), error) {
+	for i := 0; i < 300; i++ {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		// 持有鎖的程序異常結束時鎖檔會留下，鎖只在讀寫紀錄的短時間內持有，太舊的鎖檔視為失效
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > staleLockAge {
+			os.Remove(path)
+			continue
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return nil, fmt.Errorf("timed out waiting for lock %s", path)
+}
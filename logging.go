@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// LogLevel 控制終端機上的進度訊息與記錄檔的詳細程度
+type LogLevel int
+
+const (
+	LevelQuiet   LogLevel = iota // 只輸出結果，錯誤只寫入記錄檔
+	LevelNormal                  // 部署階段與重試訊息
+	LevelVerbose                 // 加上 Ansible 的輸出與等待雲端操作的過程
+	LevelDebug                   // Ansible 以 -vvv 執行，並記錄每個雲端 API 呼叫
+)
+
+var logLevel = LevelNormal
+
+// setLogLevel 依 --quiet 與 -v 的次數設定詳細程度
+func setLogLevel(quiet bool, verbose int) error {
+	if quiet && verbose > 0 {
+		return fmt.Errorf("--quiet and --verbose cannot be used together")
+	}
+	switch {
+	case quiet:
+		logLevel = LevelQuiet
+	case verbose >= 2:
+		logLevel = LevelDebug
+	case verbose == 1:
+		logLevel = LevelVerbose
+	default:
+		logLevel = LevelNormal
+	}
+	return nil
+}
+
+// chatter 回傳指定等級的進度訊息應該寫到哪裡，等級不足時捨棄
+func chatter(level LogLevel) io.Writer {
+	if logLevel < level {
+		return io.Discard
+	}
+	return os.Stdout
+}
+
+// chatf 輸出進度訊息，quiet 時不輸出
+func chatf(format string, args ...any) {
+	if status != nil && status.active() {
+		status.Println(fmt.Sprintf(format, args...))
+		return
+	}
+	fmt.Fprintf(chatter(LevelNormal), format, args...)
+}
+
+// debugLogger 只在 -vv 時輸出，記錄雲端 API 呼叫等除錯細節
+var debugLogger = log.New(io.Discard, "Debug: ", log.LstdFlags)
+
+func debugf(format string, args ...any) {
+	debugLogger.Printf(format, args...)
+}
+
+// openLogger 開啟記錄檔，quiet 以外的等級同時輸出到 stderr，回傳的 close 需要在結束前呼叫
+func openLogger(path string) (*log.Logger, func(), error) {
+	var writers []io.Writer
+	closeFile := func() {}
+	if path != "" {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open log file: %v", err)
+		}
+		writers = append(writers, file)
+		closeFile = func() { file.Close() }
+	}
+	if logLevel >= LevelNormal {
+		writers = append(writers, os.Stderr)
+	}
+	// 記錄檔與 stderr 都不應該出現密碼，錯誤訊息可能帶有 Ansible 或 API 回應的內容
+	w := redactWriter{io.MultiWriter(writers...)}
+	if logLevel >= LevelDebug {
+		debugLogger.SetOutput(w)
+	}
+	return log.New(w, "Proxy: ", log.LstdFlags), closeFile, nil
+}
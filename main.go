@@ -2,36 +2,191 @@ package main
 
 import (
 	"context"
-	"flag"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/AlecAivazis/survey/v2"
-	"github.com/joho/godotenv"
 )
 
 type Commander struct {
 	provider      CloudProvider
 	deployer      ProxyDeployer
 	recordManager *RecordManager
+	probeTargets  *ProbeTargets
+	invites       *InviteManager
+	ipChecker     IPChecker
+	reporter      Reporter
+	metadata      *InstanceMetadataConfig
+	config        *Config
+	output        string
+	dryRun        bool
+	showSecrets   bool            // 建立結果的 json 與 yaml 輸出包含密碼
+	secretKeys    *secretKeyStore // 使用 AUTO_PROXY_PASSPHRASE 時為 nil
+	audit         *AuditLog
+	journal       *Journal // dry run 時為 nil
 	logger        *log.Logger
 }
 
-func NewCommander(provider CloudProvider, deployer ProxyDeployer, recordManager *RecordManager, logger *log.Logger) *Commander {
+func NewCommander(provider CloudProvider, deployer ProxyDeployer, recordManager *RecordManager, probeTargets *ProbeTargets, invites *InviteManager, ipChecker IPChecker, reporter Reporter, metadata *InstanceMetadataConfig, logger *log.Logger) *Commander {
 	return &Commander{
 		provider:      provider,
 		deployer:      deployer,
 		recordManager: recordManager,
+		probeTargets:  probeTargets,
+		invites:       invites,
+		ipChecker:     ipChecker,
+		reporter:      reporter,
+		metadata:      metadata,
 		logger:        logger,
 	}
 }
 
-func (c *Commander) Create(ctx context.Context) error {
+// createPlan 建立 proxy 時在精靈中選好的設定
+type createPlan struct {
+	Platform    string // 由 create 精靈選擇時才有值，此時會記住這次的選擇
+	Region      string
+	Location    string
+	Zone        string
+	MachineType string
+	Image       string
+	Arch        string
+	Prebaked    bool
+	SSHUser     string
+	SSHKeyPath  string
+	SSHPort     int
+	PrivateOnly bool
+	JumpHost    string
+	IAP         bool
+	FastBoot    bool
+	Randomize   bool
+	Knock       bool
+	RateLimit   int
+	TTL         time.Duration
+	EndOfDay    bool
+	AllowedIPs  []string
+	Notes       []string
+	Tags        map[string]string
+}
+
+// CreateOptions create 指令的參數
+type CreateOptions struct {
+	// Name 為空時以 zone 加上隨機後綴命名
+	Name       string
+	Count      int
+	Parallel   int
+	SSHUser    string
+	SSHKeyPath string
+	SSHPort    int // 建立時把 sshd 移到這個連接埠，0 表示使用設定檔的 ssh.port
+	OS         string
+	// Private 只配置內部 IP，需要透過 JumpHost 或 IAP 才能部署
+	Private  bool
+	JumpHost string
+	IAP      bool
+	// Fast 使用 minimal 映像檔與預先設定的 apt，縮短建立到可以使用的時間
+	Fast bool
+	// Spread 把多台 proxy 隨機分散到 region 內的各個 zone，Jitter 錯開每台的建立時間，讓 IP 落在不同網段
+	Spread bool
+	Jitter time.Duration
+	Notes  []string
+	// Tags key=value，同時設定為 instance 的 label
+	Tags []string
+	// Latency 選擇 region 前先探測各 region 的延遲，依延遲排序並顯示在地點名稱旁
+	Latency bool
+	// Last 沿用上一次精靈的選擇，不顯示任何選單
+	Last bool
+	// Randomize 每台 proxy 隨機挑選連接埠與加密方式，取代設定檔的預設值
+	Randomize bool
+	// AllowMyIP proxy 的連接埠只開放給目前的對外 IP，之後以 allow-ip 修改
+	AllowMyIP bool
+	// Knock SSH 預設關閉，只在 knock 隨機產生的連接埠序列後開放，建立後不能取消
+	Knock bool
+	// RateLimit 每個來源每分鐘的新連線數上限，0 表示使用設定檔的 shadowsocks.rate_limit
+	RateLimit int
+	// TTL 大於 0 時 proxy 在建立後這段時間到期，由 reap 刪除
+	TTL time.Duration
+	// EndOfDay proxy 在設定時區的當天結束時到期，由 reap 刪除
+	EndOfDay bool
+	// MaxCost 大於 0 時預估的月費超過這個金額就不建立
+	MaxCost float64
+	// Yes 略過精靈最後的確認
+	Yes bool
+}
+
+func (c *Commander) Create(ctx context.Context, opts CreateOptions) error {
+	if (opts.Spread || opts.Jitter > 0) && opts.Count < 2 {
+		return fmt.Errorf("--spread and --jitter only apply when --count is greater than 1")
+	}
+	if opts.JumpHost != "" && opts.IAP {
+		return fmt.Errorf("--jump-host and --iap cannot be used together")
+	}
+	if opts.Private && opts.JumpHost == "" && !opts.IAP {
+		return fmt.Errorf("private proxies need --jump-host or --iap to be reachable for deployment")
+	}
+	// knock 從本機直接送到 proxy 的 IP，經過跳板機或 IAP 時送不到
+	if opts.Knock && (opts.Private || opts.JumpHost != "" || opts.IAP) {
+		return fmt.Errorf("--knock cannot be used with --private, --jump-host or --iap")
+	}
+	if opts.Last && opts.Latency {
+		return fmt.Errorf("--last and --latency cannot be used together")
+	}
+	if _, err := parseTags(opts.Tags); err != nil {
+		return err
+	}
+	if opts.Name != "" {
+		if opts.Count > 1 {
+			return fmt.Errorf("--name cannot be used with --count")
+		}
+		if err := validateProxyName(opts.Name); err != nil {
+			return err
+		}
+		records, err := c.recordManager.Load()
+		if err != nil {
+			return fmt.Errorf("error loading records: %v", err)
+		}
+		for _, r := range records {
+			if r.Type != "instance" {
+				continue
+			}
+			if r.Name == opts.Name {
+				return fmt.Errorf("proxy %s already exists", opts.Name)
+			}
+			if r.InstanceID == opts.Name {
+				return fmt.Errorf("instance %s is already used by proxy %s", opts.Name, r.Name)
+			}
+		}
+	}
+	if err := c.preflight(); err != nil {
+		return err
+	}
+	if err := c.checkCredentials(ctx); err != nil {
+		return err
+	}
+	// 上一次的選擇優先於設定檔的預設值
+	defaults := c.config.Defaults
+	last, err := loadLastCreate()
+	if err != nil {
+		c.logger.Printf("Warning: %v", err)
+	}
+	if opts.Last && last == nil {
+		return fmt.Errorf("no previous create to repeat, run create without --last first")
+	}
 	platforms := []string{"GCP"}
 	var selectedPlatform string
-	survey.AskOne(&survey.Select{Message: "Choose a cloud platform:", Options: platforms}, &selectedPlatform)
+	if last != nil {
+		defaults.Region, defaults.Zone, defaults.MachineType = last.Region, last.Zone, last.MachineType
+		selectedPlatform = last.Platform
+	}
+	if !opts.Last {
+		survey.AskOne(&survey.Select{Message: tr("Choose a cloud platform:"), Options: platforms, Default: selectDefault(platforms, selectedPlatform)}, &selectedPlatform)
+	}
 
 	regions, err := c.provider.ListRegions(ctx)
 	if err != nil {
@@ -39,275 +194,1168 @@ func (c *Commander) Create(ctx context.Context) error {
 	}
 
 	var selectedRegion, selectedLocation string
-	var locations []string
-	// 依照不同的 platform 回傳不同的 location 列表
-	switch strings.ToUpper(selectedPlatform) {
-	case "GCP":
-		locations = regionToLocations(regions, gcp_locations)
-	default:
+	if strings.ToUpper(selectedPlatform) != "GCP" {
 		return fmt.Errorf("invalid platform: %s", selectedPlatform)
 	}
-	survey.AskOne(&survey.Select{Message: "Choose a region:", Options: locations}, &selectedLocation)
-	reverseMap := make(map[string]string)
-	for k, v := range gcp_locations {
-		reverseMap[v] = k
+	if opts.Last {
+		if !slices.Contains(regions, defaults.Region) {
+			return fmt.Errorf("region %s from the last create is no longer available", defaults.Region)
+		}
+		selectedRegion, selectedLocation = defaults.Region, locationName(defaults.Region)
+	} else {
+		locations := regionToLocations(regions)
+		if opts.Latency {
+			regions, locations = c.regionsByLatency(ctx, regions)
+		}
+		var defaultLocation string
+		if i := slices.Index(regions, defaults.Region); i >= 0 {
+			defaultLocation = locations[i]
+		}
+		// 選單顯示地點名稱，也可以輸入 region 代碼篩選
+		survey.AskOne(filterSelect(tr("Choose a region:"), locations, regions, selectDefault(locations, defaultLocation)), &selectedLocation)
+		for i, location := range locations {
+			if location == selectedLocation {
+				selectedRegion = regions[i]
+			}
+		}
 	}
-	selectedRegion = reverseMap[selectedLocation]
 
 	zones, err := c.provider.ListZones(ctx, selectedRegion)
 	if err != nil {
 		return fmt.Errorf("error listing zones: %v", err)
 	}
-	var selectedZone string
-	survey.AskOne(&survey.Select{Message: "Choose a zone:", Options: zones}, &selectedZone)
+	if len(zones) == 0 {
+		return fmt.Errorf("no zones found in region %s", selectedRegion)
+	}
+	// 分散建立時每台的 zone 稍後隨機決定，機器類型以第一個 zone 為準
+	selectedZone := zones[0]
+	switch {
+	case opts.Spread:
+	case opts.Last:
+		if !slices.Contains(zones, defaults.Zone) {
+			return fmt.Errorf("zone %s from the last create is no longer available", defaults.Zone)
+		}
+		selectedZone = defaults.Zone
+	default:
+		survey.AskOne(filterSelect(tr("Choose a zone:"), zones, nil, selectDefault(zones, defaults.Zone)), &selectedZone)
+	}
 
 	machineTypes, err := c.provider.ListMachineTypes(ctx, selectedZone)
 	if err != nil {
 		return fmt.Errorf("error listing machine types: %v", err)
 	}
-	recommended := c.provider.RecommendedType()
-	for i, mt := range machineTypes {
-		if mt == recommended {
-			machineTypes[i] = mt + " (recommended)"
+	var selectedType string
+	if opts.Last {
+		if !slices.Contains(machineTypes, defaults.MachineType) {
+			return fmt.Errorf("machine type %s from the last create is not available in %s", defaults.MachineType, selectedZone)
 		}
+		selectedType = defaults.MachineType
+	} else {
+		recommended := c.provider.RecommendedType()
+		// 價格只是參考，billing API 未啟用或沒有權限時仍然可以選擇
+		prices, err := c.provider.MachinePrices(ctx, selectedZone)
+		if err != nil {
+			c.logger.Printf("Warning: failed to load machine type prices: %v", err)
+		}
+		typeOptions, typeLookup := machineTypeOptions(machineTypes, recommended, prices)
+		var defaultType any
+		for label, mt := range typeLookup {
+			if mt == defaults.MachineType {
+				defaultType = label
+			}
+		}
+		var selectedLabel string
+		survey.AskOne(filterSelect(tr("Choose a machine type:"), typeOptions, nil, defaultType), &selectedLabel)
+		selectedType = typeLookup[selectedLabel]
 	}
-	var selectedType string
-	survey.AskOne(&survey.Select{Message: "Choose a machine type:", Options: machineTypes}, &selectedType)
-	if strings.HasSuffix(selectedType, " (recommended)") {
-		selectedType = recommended
+
+	plan := createPlan{
+		Platform:    selectedPlatform,
+		Region:      selectedRegion,
+		Location:    selectedLocation,
+		Zone:        selectedZone,
+		MachineType: selectedType,
+		SSHUser:     opts.SSHUser,
+		SSHKeyPath:  opts.SSHKeyPath,
+		PrivateOnly: opts.Private,
+		JumpHost:    opts.JumpHost,
+		IAP:         opts.IAP,
+		FastBoot:    opts.Fast,
+		Notes:       opts.Notes,
 	}
+	if opts.Last && opts.OS == "" && !opts.Fast {
+		if last.Image != "" {
+			plan.Image, plan.Prebaked = last.Image, true
+		} else {
+			opts.OS = last.OS
+		}
+	}
+	return c.createFromPlan(ctx, opts, plan, zones)
+}
 
-	name := "proxy-" + strings.ReplaceAll(selectedZone, "-", "")
-	instanceID, ip, err := c.provider.CreateInstance(ctx, name, selectedZone, selectedType)
+// createFromPlan 依選好的 region、zone 與機器類型決定映像檔並建立 proxy，zones 為 region 內所有 zone，分散建立時使用
+func (c *Commander) createFromPlan(ctx context.Context, opts CreateOptions, plan createPlan, zones []string) error {
+	defaults := c.config.Defaults
+	selectedZone := plan.Zone
+	tags, err := parseTags(opts.Tags)
 	if err != nil {
+		return err
+	}
+	plan.Tags = tags
+	plan.Randomize = opts.Randomize
+	plan.Knock = opts.Knock
+	plan.RateLimit = opts.RateLimit
+	if opts.TTL < 0 {
+		return fmt.Errorf("invalid TTL: %v", opts.TTL)
+	}
+	plan.TTL = opts.TTL
+	plan.EndOfDay = opts.EndOfDay
+	if plan.RateLimit == 0 {
+		plan.RateLimit = c.config.Shadowsocks.RateLimit
+	}
+	if plan.RateLimit < 0 {
+		return fmt.Errorf("invalid rate limit: %d", plan.RateLimit)
+	}
+	plan.SSHPort = opts.SSHPort
+	if plan.SSHPort == 0 {
+		plan.SSHPort = c.config.SSH.Port
+	}
+	if plan.SSHPort <= 0 || plan.SSHPort > 65535 {
+		return fmt.Errorf("invalid SSH port: %d", plan.SSHPort)
+	}
+	if !opts.Randomize && plan.SSHPort == c.config.Shadowsocks.Port {
+		return fmt.Errorf("SSH port %d is already used by the proxy", plan.SSHPort)
+	}
+	if opts.AllowMyIP {
+		source, err := c.currentSource(ctx)
+		if err != nil {
+			return err
+		}
+		plan.AllowedIPs = []string{source}
+	}
+	// 指定作業系統時使用全新安裝，否則可以選擇預先安裝好的映像檔
+	plan.Arch = c.provider.MachineArch(plan.MachineType)
+	osName := opts.OS
+	if osName == "" && opts.Fast {
+		osName = fastBootOS
+	}
+	if osName == "" {
+		osName = defaults.OS
+	}
+	if osName != "" {
+		image, ok := resolveOSImage(osName, plan.Arch)
+		if !ok {
+			return fmt.Errorf("unsupported OS image: %s (supported: %s)", osName, strings.Join(supportedOSImages(), ", "))
+		}
+		plan.Image = image
+	} else if !plan.Prebaked {
+		selectedImage, err := c.chooseImage(ctx, plan.Arch)
+		if err != nil {
+			return err
+		}
+		plan.Image, plan.Prebaked = selectedImage, selectedImage != ""
+	}
+	count := max(opts.Count, 1)
+	if err := c.checkCost(ctx, plan, count, opts.MaxCost); err != nil {
+		return err
+	}
+	// 只有精靈需要確認，--last 與 recommend --apply 已經明確指定要建立
+	if plan.Platform != "" && !opts.Last && !opts.Yes && !c.dryRun && !c.machineOutput() {
+		confirmed := false
+		if err := survey.AskOne(&survey.Confirm{Message: fmt.Sprintf(tr("Create %d proxies?"), count), Default: true}, &confirmed); err != nil {
+			return fmt.Errorf("confirmation failed (use --yes to skip it): %v", err)
+		}
+		if !confirmed {
+			fmt.Println(tr("Cancelled."))
+			return nil
+		}
+	}
+	if plan.Platform != "" && !c.dryRun {
+		last := lastCreate{
+			Platform:    plan.Platform,
+			Region:      plan.Region,
+			Zone:        plan.Zone,
+			MachineType: plan.MachineType,
+			Protocol:    "shadowsocks",
+			OS:          osName,
+		}
+		if plan.Prebaked {
+			last.Image = plan.Image
+		} else if last.OS == "" {
+			// 在映像檔選單選擇全新安裝時使用預設的作業系統
+			last.OS = defaultOSImage
+		}
+		if err := last.save(); err != nil {
+			c.logger.Printf("Warning: %v", err)
+		}
+	}
+
+	if opts.Count > 1 {
+		records, err := c.recordManager.Load()
+		if err != nil {
+			return fmt.Errorf("error loading records: %v", err)
+		}
+		taken := takenNames(records)
+		existing := make(map[string]int)
+		for _, r := range records {
+			existing[r.Zone]++
+		}
+		jobZones := make([]string, opts.Count)
+		for i := range jobZones {
+			jobZones[i] = selectedZone
+		}
+		if opts.Spread {
+			jobZones = spreadZones(zones, existing, opts.Count)
+		}
+		// 再次執行 create --count 時從尚未使用的編號繼續，不會覆蓋既有的紀錄
+		return c.createMany(ctx, bulkJobs(plan, jobZones, taken), opts.Parallel, opts.Jitter)
+	}
+	name := opts.Name
+	if name == "" {
+		records, err := c.recordManager.Load()
+		if err != nil {
+			return fmt.Errorf("error loading records: %v", err)
+		}
+		name = proxyName(selectedZone, takenNames(records))
+	}
+	if err := c.provision(ctx, plan, name); err != nil {
+		return err
+	}
+	if c.machineOutput() {
+		return c.renderCreated([]string{name}, nil)
+	}
+	if !plan.Prebaked && osName == "" {
+		c.offerImageBuild(ctx, name)
+	}
+	return nil
+}
+
+// newProxyPassword 設定檔有指定密碼時沿用，否則每台 proxy 產生隨機密碼
+// 舊版的設定檔可能寫入了共用的預設密碼，同樣視為沒有指定
+func (c *Commander) newProxyPassword() (string, error) {
+	if password := c.config.Shadowsocks.Password; password != "" && password != shadowsocksPassword {
+		return password, nil
+	}
+	return generateProxyPassword()
+}
+
+// storePassword secrets.backend 為外部服務時把紀錄的密碼存進去，紀錄寫回時只保留參照
+func (c *Commander) storePassword(ctx context.Context, record *ProxyRecord) error {
+	if c.recordManager.refs == nil || record.Password == "" || record.PasswordRef != "" {
+		return nil
+	}
+	if backend := c.config.Secrets.Backend; c.dryRun && backend != "" && backend != SecretsRecords {
+		dryRunf("Would store the password of %s in %s\n", record.Name, backend)
+		return nil
+	}
+	ref, err := c.recordManager.refs.Store(ctx, record.InstanceID, record.Password)
+	if err != nil {
+		return fmt.Errorf("error storing the password of %s: %v", record.Name, err)
+	}
+	record.PasswordRef = ref
+	return nil
+}
+
+// storeUserPassword secrets.backend 為外部服務時把使用者的密碼存進去，紀錄中只保留參照
+// 名稱以底線分隔 instance ID 與使用者，proxy 名稱不含底線，不會與其他 proxy 的密碼重複
+func (c *Commander) storeUserPassword(ctx context.Context, record ProxyRecord, user *ProxyUser) error {
+	if c.recordManager.refs == nil || user.Password == "" || user.PasswordRef != "" {
+		return nil
+	}
+	if backend := c.config.Secrets.Backend; c.dryRun && backend != "" && backend != SecretsRecords {
+		dryRunf("Would store the password of user %s of %s in %s\n", user.Name, record.Name, backend)
+		return nil
+	}
+	ref, err := c.recordManager.refs.Store(ctx, record.InstanceID+"_"+user.Name, user.Password)
+	if err != nil {
+		return fmt.Errorf("error storing the password of user %s: %v", user.Name, err)
+	}
+	user.PasswordRef = ref
+	return nil
+}
+
+// releasePassword 刪除 proxy 與其使用者存在外部服務的密碼，失敗時只記錄，密碼留在服務中不影響其他 proxy
+func (c *Commander) releasePassword(ctx context.Context, deleted ProxyRecord) {
+	refs := []string{deleted.PasswordRef}
+	for _, user := range deleted.Users {
+		refs = append(refs, user.PasswordRef)
+	}
+	for _, ref := range refs {
+		c.releaseRef(ctx, deleted.Name, ref)
+	}
+}
+
+// releaseRef 刪除一個外部服務中的密碼，不是外部參照時不做任何事
+func (c *Commander) releaseRef(ctx context.Context, name, ref string) {
+	if !externalRef(ref) || c.recordManager.refs == nil {
+		return
+	}
+	if c.dryRun {
+		dryRunf("Would delete the stored password %s\n", ref)
+		return
+	}
+	if err := c.recordManager.refs.Delete(ctx, ref); err != nil {
+		c.logger.Printf("Error deleting the password of %s: %v", name, err)
+		fmt.Printf(tr("Failed to delete the stored password %s, delete it manually\n"), ref)
+	}
+}
+
+// provision 寫入 creating 紀錄、建立 instance 並部署 proxy，每個步驟更新紀錄的狀態
+func (c *Commander) provision(ctx context.Context, plan createPlan, name string) error {
+	started := time.Now()
+	report(c.reporter, name, "", StageCreateInstance, EventStarted, "")
+	port, method := c.config.Shadowsocks.Port, c.config.Shadowsocks.Method
+	if plan.Randomize {
+		port, method = randomEndpoint()
+		for port == plan.SSHPort {
+			port, method = randomEndpoint()
+		}
+	}
+	// 預設連接埠不記錄，舊紀錄與新紀錄的 0 都表示 22
+	sshPort := plan.SSHPort
+	if sshPort == defaultSSHPort {
+		sshPort = 0
+	}
+	var knockPorts []int
+	if plan.Knock {
+		knockPorts = randomKnockPorts(port, plan.SSHPort)
+	}
+	firewall, err := proxyFirewall(ProtocolShadowsocks, port, sshPort, nil, knockPorts, nil, plan.AllowedIPs, name)
+	if err != nil {
+		return err
+	}
+	password, err := c.newProxyPassword()
+	if err != nil {
+		return err
+	}
+	// 沒有權限管理防火牆時仍然建立，沿用專案既有的防火牆規則
+	if err := c.provider.EnsureFirewall(ctx, firewall); err != nil {
+		c.logger.Printf("Warning: %v", err)
+	}
+	own := map[string]string{metadataManagedKey: "true"}
+	if script := sshPortStartupScript(sshPort); script != "" {
+		own["startup-script"] = script
+	}
+	metadata := c.metadata.Merge(own)
+	if _, ok := metadata["user-data"]; plan.FastBoot && !ok {
+		metadata["user-data"] = fastBootUserData
+	}
+	billingID, err := randomToken(8)
+	if err != nil {
+		return err
+	}
+	spec := InstanceSpec{
+		Name:        name,
+		Zone:        plan.Zone,
+		MachineType: plan.MachineType,
+		Image:       plan.Image,
+		Arch:        plan.Arch,
+		Metadata:    metadata,
+		PrivateOnly: plan.PrivateOnly,
+		NetworkTags: []string{firewall.Tag()},
+		Labels:      map[string]string{managedLabel: "true", nameLabel: name, billingLabel: billingID},
+	}
+	for key, value := range plan.Tags {
+		spec.Labels[key] = value
+	}
+
+	// 建立 instance 前先寫入 creating 紀錄，中途失敗或中斷時 list 仍然看得到這台 proxy
+	record := ProxyRecord{
+		Name:        name,
+		BillingID:   billingID,
+		Provider:    "gcp",
+		Region:      plan.Region,
+		Zone:        plan.Zone,
+		InstanceID:  name,
+		Type:        "instance",
+		Location:    plan.Location,
+		Status:      StatusCreating,
+		CreatedAt:   now(),
+		Project:     c.config.GCP.ProjectID,
+		Protocol:    ProtocolShadowsocks,
+		MachineType: plan.MachineType,
+		Port:        port,
+		Method:      method,
+		Password:    password,
+		AllowedIPs:  plan.AllowedIPs,
+		SSHUser:     plan.SSHUser,
+		SSHKeyPath:  plan.SSHKeyPath,
+		SSHPort:     sshPort,
+		KnockPorts:  knockPorts,
+		RateLimit:   plan.RateLimit,
+		Image:       plan.Image,
+		Arch:        plan.Arch,
+		PrivateOnly: plan.PrivateOnly,
+		JumpHost:    plan.JumpHost,
+		IAP:         plan.IAP,
+		Notes:       plan.Notes,
+		Tags:        plan.Tags,
+	}
+	if plan.EndOfDay {
+		record.ExpiresAt = endOfDay(record.CreatedAt)
+	} else if plan.TTL > 0 {
+		record.ExpiresAt = record.CreatedAt.Add(plan.TTL)
+	}
+	if err := c.storePassword(ctx, &record); err != nil {
+		return err
+	}
+	if err := c.recordManager.Add(record); err != nil {
+		return fmt.Errorf("error saving records: %v", err)
+	}
+
+	// 建立失敗時 instance 仍可能已經存在，日誌項目留給下次執行時確認
+	done, err := c.journal.Begin(JournalInsertInstance, name, plan.Zone, name)
+	if err != nil {
+		return err
+	}
+	instanceID, info, err := c.provider.CreateInstance(ctx, spec)
+	if err != nil {
+		report(c.reporter, name, "", StageCreateInstance, EventFailed, err.Error())
+		c.setStatus(name, StatusFailed)
 		return fmt.Errorf("error creating instance: %v", err)
 	}
+	report(c.reporter, name, info.IP, StageCreateInstance, EventSucceeded, "")
 
-	if err := c.deployer.Deploy(ip); err != nil {
-		c.logger.Printf("Error deploying proxy %s: %v", name, err)
-		return fmt.Errorf("error deploying proxy: %v", err)
+	// 部署失敗時可以用 resume 重試
+	record, err = c.recordManager.SetStatus(name, StatusProvisioning, func(r *ProxyRecord) {
+		r.InstanceID, r.IP, r.DiskID = instanceID, info.IP, info.DiskID
+	})
+	if err != nil {
+		return fmt.Errorf("error saving records: %v", err)
 	}
+	done()
 
+	// 使用預先安裝好的映像檔時不需要再跑 Ansible
+	if plan.Prebaked {
+		err = c.activatePrebaked(ctx, record)
+	} else {
+		err = c.deploy(record)
+	}
+	if err == nil && !c.machineOutput() {
+		fmt.Printf(tr("Proxy %s ready in %v\n"), name, time.Since(started).Round(time.Second))
+		if !record.ExpiresAt.IsZero() {
+			fmt.Printf(tr("It expires at %s and will be deleted by `auto_proxy reap`.\n"), record.ExpiresAt.Format(time.RFC3339))
+		}
+	}
+	return err
+}
+
+// Resume 重新執行部署失敗的 proxy 的部署步驟
+func (c *Commander) Resume(ctx context.Context, name string) error {
+	if err := c.preflight(); err != nil {
+		return err
+	}
 	records, err := c.recordManager.Load()
 	if err != nil {
 		return fmt.Errorf("error loading records: %v", err)
 	}
-	records = append(records,
-		ProxyRecord{
-			Name:       name,
-			Provider:   "gcp",
-			Region:     selectedRegion,
-			Zone:       selectedZone,
-			InstanceID: instanceID,
-			IP:         ip,
-			Type:       "instance",
-			Location:   selectedLocation,
-		})
-	if err := c.recordManager.Save(records); err != nil {
+	for _, r := range records {
+		if r.Name != name || r.Type != "instance" {
+			continue
+		}
+		switch status := r.Lifecycle(); {
+		case r.Ready():
+			fmt.Printf(tr("Proxy %s is already deployed.\n"), name)
+			return nil
+		case status != StatusProvisioning && status != StatusFailed:
+			return fmt.Errorf("proxy %s is %s and cannot be resumed", name, status)
+		case r.IP == "" && r.DiskID == "":
+			// 建立 instance 就失敗了，沒有可以部署的機器
+			return fmt.Errorf("the instance of proxy %s was never created, delete it and create a new one", name)
+		}
+		record, err := c.recordManager.SetStatus(name, StatusProvisioning, nil)
+		if err != nil {
+			return fmt.Errorf("error saving records: %v", err)
+		}
+		return c.deploy(record)
+	}
+	fmt.Printf(tr("Proxy not found: %s\n"), name)
+	return nil
+}
+
+func (c *Commander) deploy(record ProxyRecord) error {
+	name, ip := record.Name, record.IP
+	target, err := c.deployTarget(record)
+	if err != nil {
+		return fmt.Errorf("error preparing SSH tunnel: %v", err)
+	}
+	target.Completed = record.Checkpoints
+	if err := c.deployer.Deploy(target); err != nil {
+		c.logger.Printf("Error deploying proxy %s: %v", name, err)
+		c.setStatus(name, StatusFailed)
+		return fmt.Errorf("error deploying proxy: %v (run `auto_proxy resume --name %s` to retry)", err, name)
+	}
+
+	// 連不上 proxy 埠通常是雲端防火牆沒有開放，部署本身已經成功，只提出警告並標示為 unhealthy
+	healthy := true
+	if record.PrivateOnly {
+		report(c.reporter, name, ip, StageVerify, EventProgress, "proxy has no public IP, skipping port check")
+	} else {
+		report(c.reporter, name, ip, StageVerify, EventStarted, "")
+		if err := c.checkHealth(context.Background(), record); err != nil {
+			report(c.reporter, name, ip, StageVerify, EventFailed, fmt.Sprintf("proxy port not reachable, check the firewall rules: %v", err))
+			healthy = false
+		} else {
+			report(c.reporter, name, ip, StageVerify, EventSucceeded, "")
+		}
+	}
+
+	return c.markDeployed(name, ip, healthy)
+}
+
+// setStatus 修改狀態並只記錄失敗，用在已經要回傳其他錯誤的地方
+func (c *Commander) setStatus(name, status string) {
+	if _, err := c.recordManager.SetStatus(name, status, nil); err != nil {
+		c.logger.Printf("Error setting status of %s to %s: %v", name, status, err)
+	}
+}
+
+// deployTarget 回傳部署目標，使用 IAP 的 proxy 由 provider 提供 SSH 通道
+func (c *Commander) deployTarget(record ProxyRecord) (DeployTarget, error) {
+	target := record.DeployTarget()
+	if record.IAP {
+		command, err := c.provider.TunnelCommand(record.Zone, record.InstanceID)
+		if err != nil {
+			return target, err
+		}
+		target.SSHProxy = "ProxyCommand=" + command
+	}
+	return target, nil
+}
+
+// markDeployed 部署完成後依健康檢查的結果標示為 active 或 unhealthy
+func (c *Commander) markDeployed(name, ip string, healthy bool) error {
+	status := StatusActive
+	if !healthy {
+		status = StatusUnhealthy
+	}
+	record, err := c.recordManager.SetStatus(name, status, nil)
+	if err != nil {
 		return fmt.Errorf("error saving records: %v", err)
 	}
+	c.runHook(HookPostCreate, record)
 
-	fmt.Printf("Shadowsocks proxy created at: %s:8388\n - Protocol: Shadowsocks\n - Password: s;980303\n - Encryption: aes-256-gcm\n", ip)
+	if !c.machineOutput() {
+		port, method, password := record.Endpoint()
+		printAbove(tr("Shadowsocks proxy created at: %s:%d\n - Protocol: Shadowsocks\n - Password: %s\n - Encryption: %s\n"), ip, port, password, method)
+		printAbove(tr(" - Share URI: %s\n"), record.ShareURI())
+	}
 	return nil
 }
 
-func (c *Commander) Delete(ctx context.Context, name string) error {
+// renderCreated 輸出建立結果，errs 記錄建立失敗的 proxy
+func (c *Commander) renderCreated(names []string, errs map[string]error) error {
 	records, err := c.recordManager.Load()
 	if err != nil {
 		return fmt.Errorf("error loading records: %v", err)
 	}
+	views := make([]createdView, 0, len(names))
+	for _, name := range names {
+		view := createdView{proxyView: proxyView{Name: name, Type: "instance", Status: StatusFailed}}
+		for _, r := range records {
+			if r.Name == name && r.Type == "instance" {
+				view = createdView{proxyView: newProxyView(r)}
+				// 記錄與 CI 的輸出常被保存下來，密碼只在明確要求時輸出，之後可以用 connect 取得
+				if c.showSecrets {
+					_, _, view.Password = r.Endpoint()
+					view.ShareURI = r.ShareURI()
+				}
+				break
+			}
+		}
+		if err := errs[name]; err != nil {
+			view.Error = err.Error()
+		}
+		views = append(views, view)
+	}
+	return c.render(views, func(w io.Writer) {
+		fmt.Fprintln(w, "NAME\tSTATUS\tIP\tPORT\tMETHOD\tERROR")
+		for _, v := range views {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", v.Name, v.Status, v.IP, v.Port, v.Method, v.Error)
+		}
+	})
+}
 
-	var instanceRecord *ProxyRecord
-	for i, r := range records {
-		if r.Name == name && r.Type == "instance" {
-			instanceRecord = &records[i]
-			break
+// Delete 刪除 proxy 與雲端資源，受備註保護的 proxy 需要 force 才能刪除
+// 沒有指定名稱時列出所有 proxy 讓使用者勾選，刪除前列出會刪除的資源並確認，yes 時略過確認
+func (c *Commander) Delete(ctx context.Context, name string, force, yes bool) error {
+	names := []string{name}
+	if name == "" {
+		var err error
+		names, err = c.chooseProxies(tr("Choose proxies to delete:"))
+		if err != nil {
+			return err
+		}
+		if len(names) == 0 {
+			fmt.Println(tr("No proxies selected."))
+			return nil
 		}
 	}
+	records, err := c.recordManager.Load()
+	if err != nil {
+		return fmt.Errorf("error loading records: %v", err)
+	}
+	var matched []ProxyRecord
+	for _, r := range records {
+		if r.Type == "instance" && slices.Contains(names, r.Name) {
+			matched = append(matched, r)
+		}
+	}
+	if len(matched) == 0 {
+		fmt.Printf(tr("Proxy not found: %s\n"), name)
+		return nil
+	}
+	confirmed, err := confirmDelete(matched, yes)
+	if err != nil || !confirmed {
+		return err
+	}
+	if name != "" {
+		return c.deleteProxy(ctx, name, force)
+	}
+	return c.deleteMany(ctx, names, force)
+}
 
-	if instanceRecord == nil {
-		fmt.Printf("Proxy not found: %s\n", name)
+// DeleteFilter 批次刪除的條件，條件之間為 AND，All 表示刪除所有 proxy
+type DeleteFilter struct {
+	All       bool
+	Region    string
+	OlderThan time.Duration
+	Tags      []string
+}
+
+func (f DeleteFilter) empty() bool {
+	return !f.All && f.Region == "" && f.OlderThan == 0 && len(f.Tags) == 0
+}
+
+// DeleteMatching 刪除所有符合條件的 proxy，刪除前列出清單並確認一次，yes 時略過確認
+func (c *Commander) DeleteMatching(ctx context.Context, filter DeleteFilter, force, yes bool) error {
+	records, err := c.recordManager.Load()
+	if err != nil {
+		return fmt.Errorf("error loading records: %v", err)
+	}
+	var matched []ProxyRecord
+	unknownAge := 0
+	for _, r := range records {
+		if r.Type != "instance" {
+			continue
+		}
+		if (filter.Region != "" && r.Region != filter.Region) || !tagFilter(filter.Tags).match(r.Tags) {
+			continue
+		}
+		if filter.OlderThan > 0 {
+			// 沒有建立時間的紀錄無法判斷年齡，不列入刪除
+			if r.CreatedAt.IsZero() {
+				unknownAge++
+				continue
+			}
+			if now().Sub(r.CreatedAt) < filter.OlderThan {
+				continue
+			}
+		}
+		matched = append(matched, r)
+	}
+	if unknownAge > 0 {
+		fmt.Printf(tr("Skipped %d proxies without a creation time.\n"), unknownAge)
+	}
+	if len(matched) == 0 {
+		fmt.Println(tr("No proxies match."))
 		return nil
 	}
 
-	// 獲取實例信息
-	info, err := c.provider.GetInstanceInfo(ctx, instanceRecord.Zone, instanceRecord.InstanceID)
+	confirmed, err := confirmDelete(matched, yes)
+	if err != nil || !confirmed {
+		return err
+	}
+	names := make([]string, 0, len(matched))
+	for _, r := range matched {
+		names = append(names, r.Name)
+	}
+	return c.deleteMany(ctx, names, force)
+}
+
+// confirmDelete 列出每台 proxy 會被刪除的雲端資源與紀錄，確認後回傳 true，yes 時只列出不詢問
+func confirmDelete(records []ProxyRecord, yes bool) (bool, error) {
+	fmt.Println(tr("The following will be deleted:"))
+	for _, r := range records {
+		if !r.Managed() {
+			// 匯入的外部伺服器只移除紀錄
+			fmt.Printf(tr(" - %s (%s, %s): record only, the external server is not touched\n"), r.Name, r.DisplayLocation(), r.IP)
+			continue
+		}
+		fmt.Printf(tr(" - %s (%s, %s): instance %s in %s, its boot disk and the record\n"), r.Name, r.DisplayLocation(), r.IP, r.InstanceID, r.Zone)
+	}
+	if yes {
+		return true, nil
+	}
+	confirmed := false
+	if err := survey.AskOne(&survey.Confirm{Message: fmt.Sprintf(tr("Delete %d proxies?"), len(records))}, &confirmed); err != nil {
+		return false, fmt.Errorf("confirmation failed (use --yes to skip it): %v", err)
+	}
+	if !confirmed {
+		fmt.Println(tr("Cancelled."))
+	}
+	return confirmed, nil
+}
+
+// chooseProxies 以多選選單列出 proxy，回傳選取的名稱
+func (c *Commander) chooseProxies(message string) ([]string, error) {
+	records, err := c.recordManager.Load()
 	if err != nil {
-		c.logger.Printf("Failed to get instance info for %s: %v", instanceRecord.InstanceID, err)
-	} else {
-		fmt.Printf("Found boot disk: %s for instance %s\n", info.DiskID, instanceRecord.InstanceID)
+		return nil, fmt.Errorf("error loading records: %v", err)
+	}
+	var options []string
+	names := make(map[string]string)
+	for _, r := range records {
+		if r.Type != "instance" {
+			continue
+		}
+		label := fmt.Sprintf("%s (%s, %s)", r.Name, r.DisplayLocation(), r.IP)
+		options = append(options, label)
+		names[label] = r.Name
+	}
+	if len(options) == 0 {
+		return nil, fmt.Errorf("no proxies found")
+	}
+	var selected []string
+	if err := survey.AskOne(&survey.MultiSelect{Message: message, Options: options}, &selected); err != nil {
+		return nil, err
 	}
+	chosen := make([]string, 0, len(selected))
+	for _, label := range selected {
+		chosen = append(chosen, names[label])
+	}
+	return chosen, nil
+}
 
-	// 刪除 Instance
-	if err := c.provider.DeleteInstance(ctx, instanceRecord.Zone, instanceRecord.InstanceID); err != nil {
-		c.logger.Printf("Error deleting instance %s: %v", instanceRecord.InstanceID, err)
-		fmt.Printf("Failed to delete instance %s\n", instanceRecord.InstanceID)
+// deleteMany 依序刪除多台 proxy，單台失敗時繼續刪除其他的
+func (c *Commander) deleteMany(ctx context.Context, names []string, force bool) error {
+	var failed []string
+	for _, name := range names {
+		if err := c.deleteProxy(ctx, name, force); err != nil {
+			fmt.Printf(tr("Failed to delete %s: %v\n"), name, err)
+			failed = append(failed, name)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to delete %d of %d proxies: %s", len(failed), len(names), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func (c *Commander) deleteProxy(ctx context.Context, name string, force bool) error {
+	instanceRecord, found, err := c.recordManager.FindByName(name)
+	if err != nil {
+		return fmt.Errorf("error loading records: %v", err)
+	}
+	if !found {
+		fmt.Printf(tr("Proxy not found: %s\n"), name)
 		return nil
 	}
 
-	for i, r := range records {
-		if r.Name == name && r.Type == "instance" {
-			records = append(records[:i], records[i+1:]...)
-			break
+	deleted := instanceRecord
+	printNotes(deleted)
+	if note := deleted.ProtectedBy(); note != "" && !force {
+		return fmt.Errorf("proxy %s is protected by note %q, use --force to delete it anyway", name, note)
+	}
+
+	// 匯入的外部伺服器不是由 auto_proxy 建立，只移除紀錄
+	if !instanceRecord.Managed() {
+		return c.forget(name)
+	}
+
+	// 建立時已經記錄開機磁碟，舊紀錄與建立到一半的紀錄才向雲端查詢
+	info := InstanceInfo{DiskID: instanceRecord.DiskID}
+	if info.DiskID == "" {
+		info, err = c.provider.GetInstanceInfo(ctx, instanceRecord.Zone, instanceRecord.InstanceID)
+		if errors.Is(err, ErrInstanceNotFound) && !instanceRecord.Ready() {
+			// instance 沒有建立成功，只移除紀錄
+			return c.dropRecord(deleted)
+		}
+		if err != nil {
+			c.logger.Printf("Failed to get instance info for %s: %v", instanceRecord.InstanceID, err)
+		} else {
+			fmt.Printf(tr("Found boot disk: %s for instance %s\n"), info.DiskID, instanceRecord.InstanceID)
 		}
 	}
 
+	// 刪除到一半中斷時紀錄會停在 deleting，可以再執行 delete
+	if _, err := c.recordManager.SetStatus(name, StatusDeleting, nil); err != nil {
+		return fmt.Errorf("error saving records: %v", err)
+	}
+
+	// 刪除 Instance，已經不存在時視為刪除完成，例如上次刪除到一半被中斷
+	done, err := c.journal.Begin(JournalDeleteInstance, name, instanceRecord.Zone, instanceRecord.InstanceID)
+	if err != nil {
+		return err
+	}
+	err = withSpinner(fmt.Sprintf(tr("[%s] Deleting instance"), name), func() error {
+		return c.provider.DeleteInstance(ctx, instanceRecord.Zone, instanceRecord.InstanceID)
+	})
+	// 失敗時紀錄停在 deleting，可以再執行 delete，不需要日誌項目
+	done()
+	if errors.Is(err, ErrInstanceNotFound) {
+		err = nil
+	}
+	if err != nil {
+		c.logger.Printf("Error deleting instance %s: %v", instanceRecord.InstanceID, err)
+		return fmt.Errorf("error deleting instance %s: %v", instanceRecord.InstanceID, err)
+	}
+
 	// 刪除磁碟
+	var diskRecord *ProxyRecord
 	if info.DiskID != "" {
-		diskRecord := ProxyRecord{
-			Name:       name,
-			Provider:   instanceRecord.Provider,
-			Region:     instanceRecord.Region,
-			Zone:       instanceRecord.Zone,
-			InstanceID: info.DiskID,
-			Type:       "disk",
-			Location:   instanceRecord.Location,
+		done, err := c.journal.Begin(JournalDeleteDisk, name, instanceRecord.Zone, info.DiskID)
+		if err != nil {
+			return err
 		}
-
-		if err := c.provider.DeleteDisk(ctx, instanceRecord.Zone, info.DiskID); err != nil {
+		err = withSpinner(fmt.Sprintf(tr("[%s] Deleting disk"), name), func() error {
+			return c.provider.DeleteDisk(ctx, instanceRecord.Zone, info.DiskID)
+		})
+		if errors.Is(err, ErrDiskNotFound) {
+			err = nil
+		}
+		// 刪除失敗時改以 disk 紀錄追蹤，不需要日誌項目
+		done()
+		if err != nil {
 			c.logger.Printf("Error deleting disk %s: %v", info.DiskID, err)
-			fmt.Printf("Failed to delete disk %s\n", info.DiskID)
+			fmt.Printf(tr("Failed to delete disk %s\n"), info.DiskID)
 			// 如果刪除失敗，則添加到紀錄
-			records = append(records, diskRecord)
+			diskRecord = &ProxyRecord{
+				Name:       name,
+				Provider:   instanceRecord.Provider,
+				Region:     instanceRecord.Region,
+				Zone:       instanceRecord.Zone,
+				InstanceID: info.DiskID,
+				Type:       "disk",
+				Location:   instanceRecord.Location,
+			}
 		}
 	}
 
-	if err := c.recordManager.Save(records); err != nil {
+	// 雲端操作期間紀錄可能被其他程序修改，在同一次寫入中以 disk 紀錄取代 instance 紀錄
+	err = c.recordManager.Update(func(records []ProxyRecord) ([]ProxyRecord, error) {
+		records = slices.DeleteFunc(records, func(r ProxyRecord) bool { return r.Name == name && r.Type == "instance" })
+		if diskRecord != nil {
+			records = append(records, *diskRecord)
+		}
+		return records, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error saving records: %v", err)
+	}
+	c.releaseFirewall(ctx, deleted)
+	c.releasePassword(ctx, deleted)
+	c.runHook(HookPostDelete, deleted)
+
+	fmt.Printf(tr("Proxy %s deleted.\n"), name)
+	return nil
+}
+
+// dropRecord 移除 instance 沒有建立成功的紀錄
+func (c *Commander) dropRecord(record ProxyRecord) error {
+	if err := c.recordManager.Remove(record.Name); err != nil && !errors.Is(err, ErrRecordNotFound) {
 		return fmt.Errorf("error saving records: %v", err)
 	}
+	fmt.Printf(tr("Proxy %s had no instance, record removed.\n"), record.Name)
+	return nil
+}
 
-	fmt.Printf("Proxy %s deleted.\n", name)
+func (c *Commander) forget(name string) error {
+	if err := c.recordManager.Remove(name); err != nil && !errors.Is(err, ErrRecordNotFound) {
+		return fmt.Errorf("error saving records: %v", err)
+	}
+	fmt.Printf(tr("Record of external proxy %s removed.\n"), name)
 	return nil
 }
 
-func (c *Commander) List() error {
+// ListOptions list 的篩選條件，條件之間為 AND，空白表示不篩選
+type ListOptions struct {
+	Region   string // region 前綴，例如 asia 或 asia-east1
+	Provider string
+	Protocol string
+	Status   string
+	Tags     []string // key=value 或 key
+	Sort     string   // listSortKeys 之一
+}
+
+// listSortKeys list --sort 支援的欄位，依建立時間排序時最新的在前
+var listSortKeys = []string{"name", "region", "status", "ip", "created"}
+
+func (c *Commander) List(opts ListOptions) error {
+	if opts.Sort != "" && !slices.Contains(listSortKeys, opts.Sort) {
+		return fmt.Errorf("unsupported sort key: %s (supported: %s)", opts.Sort, strings.Join(listSortKeys, ", "))
+	}
 	records, err := c.recordManager.Load()
 	if err != nil {
 		return fmt.Errorf("error loading records: %v", err)
 	}
-	if len(records) == 0 {
-		fmt.Println("No proxies found.")
+	views := make([]proxyView, 0, len(records))
+	for _, r := range records {
+		v := newProxyView(r)
+		if !strings.HasPrefix(v.Region, opts.Region) ||
+			(opts.Provider != "" && !strings.EqualFold(v.Provider, opts.Provider)) ||
+			(opts.Protocol != "" && !strings.EqualFold(v.Protocol, opts.Protocol)) ||
+			(opts.Status != "" && !strings.EqualFold(v.Status, opts.Status)) ||
+			!tagFilter(opts.Tags).match(r.Tags) {
+			continue
+		}
+		views = append(views, v)
+	}
+	if len(views) == 0 && !c.machineOutput() {
+		fmt.Println(tr("No proxies found."))
 		return nil
 	}
-	for _, r := range records {
-		fmt.Printf("Name: %s, IP: %s, Region: %s, Location: %s\n", r.Name, r.IP, r.Region, r.Location)
+	if opts.Sort != "" {
+		sort.SliceStable(views, func(i, j int) bool {
+			a, b := views[i], views[j]
+			switch opts.Sort {
+			case "region":
+				return a.Region < b.Region
+			case "status":
+				return a.Status < b.Status
+			case "ip":
+				return a.IP < b.IP
+			case "created":
+				// 沒有建立時間的舊紀錄排在最後
+				return a.CreatedAt != nil && (b.CreatedAt == nil || a.CreatedAt.After(*b.CreatedAt))
+			}
+			return a.Name < b.Name
+		})
 	}
-	return nil
+	return c.renderProxies(views)
 }
 
-func checkEnv() error {
-	// check .env is exists, if not exists create .env
-	if _, err := os.Stat(".env"); os.IsNotExist(err) {
-		fmt.Println("No .env file found, creating an example .env file")
-		file, err := os.Create(".env")
-		if err != nil {
-			return fmt.Errorf("failed to create .env file: %v", err)
+// renderProxies 以 list 的欄位輸出 proxy
+func (c *Commander) renderProxies(views []proxyView) error {
+	return c.render(views, func(w io.Writer) {
+		fmt.Fprintln(w, "NAME\tSTATUS\tIP\tREGION\tLOCATION\tTAGS")
+		for _, v := range views {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", v.Name, v.Status, v.IP, v.Region, v.Location, formatTags(v.Tags))
 		}
+	})
+}
 
-		file.WriteString(`# Google Cloud credentials path
-GOOGLE_APPLICATION_CREDENTIALS=""
+// Best 依延遲排序現有的 proxy，regions 為 true 時改為排序可建立的 region
+func (c *Commander) Best(ctx context.Context, regions bool) error {
+	groups := make(map[string][]string)
+	labels := make(map[string]string)
+	if regions {
+		names, err := c.provider.ListRegions(ctx)
+		if err != nil {
+			return fmt.Errorf("error listing regions: %v", err)
+		}
+		for _, r := range names {
+			groups[r] = c.probeTargets.ForRegion(r)
+			labels[r] = locationName(r)
+		}
+	} else {
+		records, err := c.recordManager.Load()
+		if err != nil {
+			return fmt.Errorf("error loading records: %v", err)
+		}
+		for _, r := range records {
+			if r.Type != "instance" {
+				continue
+			}
+			groups[r.Name] = c.probeTargets.ForProxy(r)
+			labels[r.Name] = r.DisplayLocation()
+		}
+	}
+	if len(groups) == 0 {
+		fmt.Println(tr("No proxies found."))
+		return nil
+	}
 
-# Google project id
-GOOGLE_PROJECT_ID=""
+	results := NewProber(3*time.Second).ProbeAll(ctx, groups)
+	for _, r := range results {
+		if r.Err != nil {
+			fmt.Printf(tr("%s (%s): unreachable\n"), r.Key, labels[r.Key])
+			continue
+		}
+		fmt.Printf("%s (%s): %v\n", r.Key, labels[r.Key], r.Latency.Round(time.Millisecond))
+	}
+	return nil
+}
 
-# Ansible ssh config
-ANSIBLE_SSH_USER=""
-ANSIBLE_SSH_KEY_PATH=""
-		`)
-		defer file.Close()
+// regionsByLatency 併發探測各 region 的延遲，回傳依延遲排序的 region 與附上延遲的顯示名稱
+func (c *Commander) regionsByLatency(ctx context.Context, regions []string) ([]string, []string) {
+	groups := make(map[string][]string, len(regions))
+	for _, r := range regions {
+		groups[r] = c.probeTargets.ForRegion(r)
 	}
-	if err := godotenv.Load(); err != nil {
-		log.New(os.Stdout, "Proxy: ", log.LstdFlags).Printf("Error loading .env file: %v", err)
+	chatf(tr("Measuring latency to %d regions...\n"), len(regions))
+	results := NewProber(3*time.Second).ProbeAll(ctx, groups)
+	sorted := make([]string, 0, len(results))
+	labels := make([]string, 0, len(results))
+	for _, r := range results {
+		sorted = append(sorted, r.Key)
+		if r.Err != nil {
+			labels = append(labels, fmt.Sprintf(tr("%s (unreachable)"), locationName(r.Key)))
+			continue
+		}
+		labels = append(labels, fmt.Sprintf("%s (%v)", locationName(r.Key), r.Latency.Round(time.Millisecond)))
 	}
-	return nil
+	return sorted, labels
 }
 
 func main() {
-	logger := log.New(os.Stdout, "Proxy: ", log.LstdFlags)
-
-	if err := checkEnv(); err != nil {
-		logger.Printf("Error checking environment: %v", err)
+	logger := log.New(redactWriter{os.Stdout}, "Proxy: ", log.LstdFlags)
+	app := &cliApp{logger: logger}
+	root := app.binaryCommand()
+	root.SetArgs(normalizeLegacyFlags(root, os.Args[1:]))
+	err := root.Execute()
+	app.close()
+	if err != nil {
 		os.Exit(1)
 	}
+}
 
-	credsPath := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
-	if credsPath == "" {
-		logger.Println("GOOGLE_APPLICATION_CREDENTIALS not set in .env")
-		os.Exit(1)
-	}
+// commanderOptions 由全域參數決定的 Commander 設定
+type commanderOptions struct {
+	Output  string
+	MaxWait time.Duration // 單一雲端操作花在重試與等待的總時間上限，0 表示不限制
+	DryRun  bool          // 只印出會執行的雲端操作與部署內容
+	DataDir string        // 紀錄與邀請檔所在的目錄
 
-	projectId := os.Getenv("GOOGLE_PROJECT_ID")
-	if projectId == "" {
-		logger.Println("GOOGLE_PROJECT_ID not set in .env")
-		os.Exit(1)
-	}
+	// ShowSecrets json 與 yaml 輸出的建立結果包含密碼與分享連結
+	ShowSecrets bool
+}
 
-	provider, err := NewGCPProvider(projectId, credsPath)
+// openRecordManager 開啟設定檔指定的紀錄儲存方式與加密金鑰，storage 若實作 io.Closer 需由呼叫端關閉
+func openRecordManager(cfg *Config, dataDir string, dryRun bool) (*RecordManager, *secretKeyStore, RecordStorage, error) {
+	// 第一次切換到 sqlite 時會匯入既有的 proxy_records.json
+	storage, err := newRecordStorage(cfg, filepath.Join(dataDir, "proxy_records"))
 	if err != nil {
-		logger.Printf("Error initializing GCP: %v", err)
-		os.Exit(1)
+		return nil, nil, nil, err
 	}
-
-	sshUser := os.Getenv("ANSIBLE_SSH_USER")
-	if sshUser == "" {
-		logger.Println("ANSIBLE_SSH_USER not set in .env")
-		os.Exit(1)
+	// 沒有指定密碼短語時以 auto_proxy 保存的隨機金鑰加密，第一次執行時產生，dry run 不產生
+	passphrase := secretEnv("AUTO_PROXY_PASSPHRASE")
+	var secretKeys *secretKeyStore
+	if passphrase == "" {
+		secretKeys = newSecretKeyStore(dataDir)
+		if passphrase, err = secretKeys.Load(); err != nil {
+			return nil, nil, nil, err
+		}
+		if passphrase == "" && !dryRun {
+			if passphrase, err = generateSecretKey(); err != nil {
+				return nil, nil, nil, err
+			}
+			if err := secretKeys.Save(passphrase); err != nil {
+				return nil, nil, nil, err
+			}
+		}
 	}
-	sshKeyPath := os.Getenv("ANSIBLE_SSH_KEY_PATH")
-	if sshKeyPath == "" {
-		logger.Println("ANSIBLE_SSH_KEY_PATH not set in .env")
-		os.Exit(1)
+	recordManager := NewRecordManager(storage, NewSecretBox(passphrase))
+	recordManager.dryRun = dryRun
+	if recordManager.refs, err = newSecretResolver(cfg); err != nil {
+		return nil, nil, nil, err
 	}
+	return recordManager, secretKeys, storage, nil
+}
 
-	deployer := NewAnsibleProxyDeployer(sshUser, sshKeyPath)
-	recordManager := NewRecordManager("proxy_records.json")
-	commander := NewCommander(provider, deployer, recordManager, logger)
-
-	createCmd := flag.NewFlagSet("create", flag.ExitOnError)
-	deleteCmd := flag.NewFlagSet("delete", flag.ExitOnError)
-	listCmd := flag.NewFlagSet("list", flag.ExitOnError)
-	deleteName := deleteCmd.String("name", "", "Name of the proxy to delete")
+// newCommanderFromConfig 依設定檔建立 Commander，回傳的 cleanup 需要在結束前呼叫
+func newCommanderFromConfig(logger *log.Logger, cfg *Config, opts commanderOptions) (*Commander, func(), error) {
+	output := opts.Output
+	if err := checkOutputFormat(output); err != nil {
+		return nil, nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, nil, err
+	}
+	if err := loadTimezone(cfg.Timezone); err != nil {
+		return nil, nil, err
+	}
 
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: auto_proxy [create|delete|list]")
-		return
+	provider, err := NewGCPProvider(cfg.GCP.ProjectID, cfg.GCP.Credentials)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error initializing GCP: %v", err)
 	}
+	provider.SetMaxWait(opts.MaxWait)
 
-	ctx := context.Background()
-	switch os.Args[1] {
-	case "create":
-		createCmd.Parse(os.Args[2:])
-		if err := commander.Create(ctx); err != nil {
-			fmt.Println(err)
-		}
-	case "delete":
-		deleteCmd.Parse(os.Args[2:])
-		if *deleteName == "" {
-			fmt.Println("Error: Proxy name is required. Usage: auto_proxy delete -name <proxy-name>")
-			return
+	sshUser, sshKeyPath := cfg.SSH.User, cfg.SSH.KeyPath
+	recordManager, secretKeys, storage, err := openRecordManager(cfg, opts.DataDir, opts.DryRun)
+	if err != nil {
+		return nil, nil, err
+	}
+	// 沒有選擇 profile 時，還沒有記錄專案的舊紀錄屬於 gcp 的專案
+	recordManager.scope = projectScope(cfg.GCP.ProjectID, cfg.profile == "")
+	// 輸出給程式解析時，部署進度改寫到 stderr 以免混進結果
+	var progress io.Writer = os.Stdout
+	if output == OutputJSON || output == OutputYAML {
+		progress = os.Stderr
+	}
+	if logLevel < LevelNormal {
+		progress = io.Discard
+	}
+	base := NewReporter(cfg.Events, progress)
+	// 在終端機上以狀態列顯示進行中的階段與經過時間，取代逐行的進度訊息
+	if cfg.Events != "json" && progress == os.Stdout {
+		enableStatusLine()
+		if status != nil {
+			base = &SpinnerReporter{status: status}
 		}
-		if err := commander.Delete(ctx, *deleteName); err != nil {
-			fmt.Println(err)
+	}
+	reporter := NewCheckpointReporter(base, recordManager, logger)
+	var cloud CloudProvider
+	var deployer ProxyDeployer
+	ansible := NewAnsibleProxyDeployer(sshUser, sshKeyPath, reporter)
+	ansible.hardening = cfg.Hardening
+	if opts.DryRun {
+		cloud, deployer = wrapDryRun(provider, ansible)
+	} else if cloud, deployer, err = wrapChaos(provider, ansible); err != nil {
+		return nil, nil, fmt.Errorf("error enabling chaos mode: %v", err)
+	}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		cacheDir = os.TempDir()
+	}
+	cache := NewDiskCache(filepath.Join(cacheDir, "auto_proxy"), 24*time.Hour, logger)
+	cloud = NewCachingProvider(cloud, cache, "gcp-"+cfg.GCP.ProjectID)
+
+	probeTargets, err := LoadProbeTargets(cfg.ProbeTargetsFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error loading probe targets: %v", err)
+	}
+	invites := NewInviteManager(filepath.Join(opts.DataDir, "invites.json"))
+	ipChecker := NewCachingIPChecker(sshUser, sshKeyPath)
+	metadata, err := LoadInstanceMetadataConfig(cfg.MetadataFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error loading instance metadata config: %v", err)
+	}
+	commander := NewCommander(cloud, deployer, recordManager, probeTargets, invites, ipChecker, reporter, metadata, logger)
+	commander.output = output
+	commander.config = cfg
+	commander.dryRun = opts.DryRun
+	commander.showSecrets = opts.ShowSecrets
+	commander.secretKeys = secretKeys
+	commander.audit = &AuditLog{path: filepath.Join(opts.DataDir, "audit.log")}
+	if !opts.DryRun {
+		commander.journal = &Journal{path: filepath.Join(opts.DataDir, "journal.json"), project: cfg.GCP.ProjectID}
+	}
+	cleanup := func() {
+		cache.Wait()
+		if closer, ok := storage.(io.Closer); ok {
+			closer.Close()
 		}
-	case "list":
-		listCmd.Parse(os.Args[2:])
-		if err := commander.List(); err != nil {
-			fmt.Println(err)
+	}
+	return commander, cleanup, nil
+}
+
+// selectDefault 回傳選單的預設選項，設定檔中的值不在選項內時不預先選擇
+func selectDefault(options []string, value string) any {
+	for _, option := range options {
+		if option == value {
+			return value
 		}
-	default:
-		fmt.Println("Unknown command:", os.Args[1])
-		fmt.Println("Usage: auto_proxy [create|delete|list]")
 	}
+	return nil
 }
 
-func regionToLocations(regions []string, mapping map[string]string) []string {
-	locations := make([]string, 0)
+// regionToLocations 回傳各個 region 在目前語言的顯示名稱，順序與 regions 相同
+func regionToLocations(regions []string) []string {
+	locations := make([]string, 0, len(regions))
 	for _, r := range regions {
-		if location, ok := mapping[r]; ok {
-			locations = append(locations, location)
-		} else {
-			locations = append(locations, r)
-		}
+		locations = append(locations, locationName(r))
 	}
 	return locations
 }
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// InstanceMetadataConfig 使用者額外要加在 instance 上的 metadata，例如公司的安全代理程式安裝腳本
+type InstanceMetadataConfig struct {
+	Metadata          map[string]string `json:"metadata"`
+	StartupScript     string            `json:"startup_script"`
+	StartupScriptFile string            `json:"startup_script_file"`
+}
+
+// auto_proxy 自己使用的 metadata key，使用者設定不能覆寫
+const metadataManagedKey = "auto-proxy-managed"
+
+func LoadInstanceMetadataConfig(filePath string) (*InstanceMetadataConfig, error) {
+	config := &InstanceMetadataConfig{}
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read instance metadata config: %w", err)
+	}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal instance metadata config: %w", err)
+	}
+	if config.StartupScriptFile != "" {
+		script, err := os.ReadFile(config.StartupScriptFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read startup script: %w", err)
+		}
+		config.StartupScript = strings.TrimSpace(config.StartupScript + "\n" + string(script))
+	}
+	return config, nil
+}
+
+// Merge 將使用者的 metadata 與 auto_proxy 自己的 metadata 合併，保留給 auto_proxy 的 key 以 own 為準
+func (m *InstanceMetadataConfig) Merge(own map[string]string) map[string]string {
+	merged := make(map[string]string)
+	if m != nil {
+		for k, v := range m.Metadata {
+			merged[k] = v
+		}
+		if m.StartupScript != "" {
+			merged["startup-script"] = m.StartupScript
+		}
+	}
+	for k, v := range own {
+		if existing, ok := merged[k]; ok && k == "startup-script" {
+			merged[k] = v + "\n" + existing
+			continue
+		}
+		merged[k] = v
+	}
+	return merged
+}
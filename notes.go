@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// 以這些字開頭的備註表示 proxy 不能隨意刪除，例如 "shared-with-team: CI 使用中"
+var protectedNotes = []string{"do-not-delete", "shared-with-team", "protected"}
+
+// ProtectedBy 回傳讓紀錄受保護的備註，沒有時回傳空字串
+func (r ProxyRecord) ProtectedBy() string {
+	for _, note := range r.Notes {
+		lower := strings.ToLower(strings.TrimSpace(note))
+		for _, keyword := range protectedNotes {
+			if strings.HasPrefix(lower, keyword) {
+				return note
+			}
+		}
+	}
+	return ""
+}
+
+// printNotes 醒目地印出紀錄的備註，刪除前提醒操作者
+func printNotes(r ProxyRecord) {
+	if len(r.Notes) == 0 {
+		return
+	}
+	fmt.Printf(tr("!!! Notes on proxy %s:\n"), r.Name)
+	for _, note := range r.Notes {
+		fmt.Printf("!!!   - %s\n", note)
+	}
+}
+
+// Note 新增或移除 proxy 的備註，兩者皆為空時列出目前的備註
+func (c *Commander) Note(name string, add, remove []string) error {
+	record, err := c.recordManager.UpdateRecord(name, func(r *ProxyRecord) error {
+		for _, note := range add {
+			if !slices.Contains(r.Notes, note) {
+				r.Notes = append(r.Notes, note)
+			}
+		}
+		r.Notes = slices.DeleteFunc(r.Notes, func(note string) bool {
+			return slices.Contains(remove, note)
+		})
+		return nil
+	})
+	if errors.Is(err, ErrRecordNotFound) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("error saving records: %v", err)
+	}
+	if len(record.Notes) == 0 {
+		fmt.Printf(tr("Proxy %s has no notes.\n"), name)
+		return nil
+	}
+	for _, note := range record.Notes {
+		fmt.Println(note)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 輸出格式，table 給人看，json 與 yaml 的欄位名稱固定，供腳本使用
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+	OutputYAML  = "yaml"
+)
+
+func checkOutputFormat(format string) error {
+	switch format {
+	case OutputTable, OutputJSON, OutputYAML:
+		return nil
+	}
+	return fmt.Errorf("unsupported output format: %s (supported: table, json, yaml)", format)
+}
+
+// machineOutput 回傳是否輸出給程式解析，此時進度訊息改寫到 stderr
+func (c *Commander) machineOutput() bool {
+	return c.output == OutputJSON || c.output == OutputYAML
+}
+
+// render 依輸出格式印出 v，table 格式由 table 負責寫出各欄
+func (c *Commander) render(v any, table func(w io.Writer)) error {
+	switch c.output {
+	case OutputJSON:
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	case OutputYAML:
+		encoder := yaml.NewEncoder(os.Stdout)
+		encoder.SetIndent(2)
+		defer encoder.Close()
+		return encoder.Encode(v)
+	default:
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		table(w)
+		return w.Flush()
+	}
+}
+
+// proxyView 對外輸出的 proxy 欄位，不含密碼等機密
+type proxyView struct {
+	Name        string            `json:"name" yaml:"name"`
+	Type        string            `json:"type" yaml:"type"`
+	Status      string            `json:"status" yaml:"status"`
+	Provider    string            `json:"provider" yaml:"provider"`
+	Protocol    string            `json:"protocol,omitempty" yaml:"protocol,omitempty"`
+	Region      string            `json:"region,omitempty" yaml:"region,omitempty"`
+	Zone        string            `json:"zone,omitempty" yaml:"zone,omitempty"`
+	Location    string            `json:"location,omitempty" yaml:"location,omitempty"`
+	IP          string            `json:"ip,omitempty" yaml:"ip,omitempty"`
+	Port        int               `json:"port,omitempty" yaml:"port,omitempty"`
+	Method      string            `json:"method,omitempty" yaml:"method,omitempty"`
+	MachineType string            `json:"machine_type,omitempty" yaml:"machine_type,omitempty"`
+	Arch        string            `json:"arch,omitempty" yaml:"arch,omitempty"`
+	CreatedAt   *time.Time        `json:"created_at,omitempty" yaml:"created_at,omitempty"`
+	ExpiresAt   *time.Time        `json:"expires_at,omitempty" yaml:"expires_at,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Project     string            `json:"project,omitempty" yaml:"project,omitempty"`
+	Notes       []string          `json:"notes,omitempty" yaml:"notes,omitempty"`
+}
+
+func newProxyView(r ProxyRecord) proxyView {
+	status := r.Lifecycle()
+	view := proxyView{
+		Name:        r.Name,
+		Type:        r.Type,
+		Status:      status,
+		Provider:    r.Provider,
+		Protocol:    r.ProxyProtocol(),
+		Region:      r.Region,
+		Zone:        r.Zone,
+		Location:    r.DisplayLocation(),
+		IP:          r.IP,
+		MachineType: r.MachineType,
+		Arch:        r.Arch,
+		Tags:        r.Tags,
+		Project:     r.Project,
+		Notes:       r.Notes,
+	}
+	if !r.CreatedAt.IsZero() {
+		view.CreatedAt = &r.CreatedAt
+	}
+	if !r.ExpiresAt.IsZero() {
+		view.ExpiresAt = &r.ExpiresAt
+	}
+	if r.Type == "instance" {
+		view.Port, view.Method, _ = r.Endpoint()
+	}
+	return view
+}
+
+// createdView create 的結果，--show-secrets 時包含連線需要的密碼
+type createdView struct {
+	proxyView `yaml:",inline"`
+	Password  string `json:"password,omitempty" yaml:"password,omitempty"`
+	ShareURI  string `json:"share_uri,omitempty" yaml:"share_uri,omitempty"`
+	Error     string `json:"error,omitempty" yaml:"error,omitempty"`
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/AlecAivazis/survey/v2"
+)
+
+// pickerPageSize 可篩選選單一次顯示的選項數
+const pickerPageSize = 15
+
+// filterSelect 建立可以輸入文字篩選的選單，keywords[i] 為 options[i] 額外可以比對的文字，例如 region 代碼
+func filterSelect(message string, options, keywords []string, def any) *survey.Select {
+	return &survey.Select{
+		Message:  message,
+		Options:  options,
+		Default:  def,
+		PageSize: pickerPageSize,
+		Help:     tr("Type to filter, e.g. \"asia\" or \"e2 med\""),
+		Filter: func(filter, value string, index int) bool {
+			target := value
+			if index < len(keywords) {
+				target += " " + keywords[index]
+			}
+			return fuzzyMatch(filter, target)
+		},
+	}
+}
+
+// fuzzyMatch 以空白分隔的每個詞都依序出現在 target 中 (不需要相鄰) 時視為符合，不分大小寫
+func fuzzyMatch(filter, target string) bool {
+	target = strings.ToLower(target)
+	for _, term := range strings.Fields(strings.ToLower(filter)) {
+		if !isSubsequence(term, target) {
+			return false
+		}
+	}
+	return true
+}
+
+func isSubsequence(term, target string) bool {
+	runes := []rune(term)
+	i := 0
+	for _, r := range target {
+		if i < len(runes) && r == runes[i] {
+			i++
+		}
+	}
+	return i == len(runes)
+}
+
+// machineFamily 回傳機器類型的系列，例如 e2-medium 屬於 e2
+func machineFamily(machineType string) string {
+	family, _, _ := strings.Cut(machineType, "-")
+	return family
+}
+
+// machineTypeOptions 依系列排序機器類型並在選項前標示系列，有價格時附在選項後，回傳選項與選項對應的機器類型
+func machineTypeOptions(machineTypes []string, recommended string, prices map[string]MachinePrice) ([]string, map[string]string) {
+	sorted := append([]string(nil), machineTypes...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		fi, fj := machineFamily(sorted[i]), machineFamily(sorted[j])
+		if fi != fj {
+			// 推薦的系列排在最前面
+			if fi == machineFamily(recommended) || fj == machineFamily(recommended) {
+				return fi == machineFamily(recommended)
+			}
+			return fi < fj
+		}
+		return sorted[i] < sorted[j]
+	})
+	options := make([]string, 0, len(sorted))
+	lookup := make(map[string]string, len(sorted))
+	for _, mt := range sorted {
+		label := fmt.Sprintf("[%s] %s", machineFamily(mt), mt)
+		if price, ok := prices[mt]; ok {
+			label += "  ~" + price.String()
+		}
+		if mt == recommended {
+			label += " " + tr("(recommended)")
+		}
+		options = append(options, label)
+		lookup[label] = mt
+	}
+	return options, lookup
+}
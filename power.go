@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// Stop 停止 proxy 的 instance，停止期間只計算磁碟的費用，紀錄與伺服器上的設定都保留
+func (c *Commander) Stop(ctx context.Context, name string) error {
+	record, err := c.powerProxy(name)
+	if err != nil {
+		return err
+	}
+	if !record.Ready() {
+		return fmt.Errorf("proxy %s is %s, only deployed proxies can be stopped", name, record.Lifecycle())
+	}
+	fmt.Printf(tr("Stopping proxy %s...\n"), name)
+	if err := c.provider.StopInstance(ctx, record.Zone, record.InstanceID); err != nil {
+		return fmt.Errorf("error stopping instance: %v", err)
+	}
+	if _, err := c.recordManager.SetStatus(name, StatusStopped, nil); err != nil {
+		return fmt.Errorf("error saving records: %v", err)
+	}
+	c.logger.Printf("Proxy %s stopped", name)
+	fmt.Printf(tr("Proxy %s stopped, start it again with `auto_proxy start`.\n"), name)
+	return nil
+}
+
+// Start 啟動停止的 proxy，外部 IP 改變時更新紀錄並提醒更新客戶端
+func (c *Commander) Start(ctx context.Context, name string) error {
+	record, err := c.powerProxy(name)
+	if err != nil {
+		return err
+	}
+	if record.Lifecycle() != StatusStopped {
+		return fmt.Errorf("proxy %s is %s, not stopped", name, record.Lifecycle())
+	}
+	fmt.Printf(tr("Starting proxy %s...\n"), name)
+	if err := c.provider.StartInstance(ctx, record.Zone, record.InstanceID); err != nil {
+		return fmt.Errorf("error starting instance: %v", err)
+	}
+	info, err := c.provider.GetInstanceInfo(ctx, record.Zone, record.InstanceID)
+	if err != nil {
+		return fmt.Errorf("error getting instance info: %v", err)
+	}
+	previousIP := record.IP
+	record.IP = info.IP
+	// 開機後服務自動啟動，不需要重新部署
+	status := StatusActive
+	if !record.PrivateOnly {
+		if err := c.checkHealth(ctx, record); err != nil {
+			c.logger.Printf("Health check failed for %s: %v", name, err)
+			status = StatusUnhealthy
+		}
+	}
+	if _, err := c.recordManager.SetStatus(name, status, func(r *ProxyRecord) {
+		r.IP = info.IP
+	}); err != nil {
+		return fmt.Errorf("error saving records: %v", err)
+	}
+	c.logger.Printf("Proxy %s started at %s", name, info.IP)
+	if status == StatusUnhealthy {
+		fmt.Printf(tr("Warning: proxy %s did not pass the health check after starting.\n"), name)
+	}
+	if info.IP != previousIP {
+		fmt.Printf(tr("Proxy %s started with a new IP %s (was %s), update your clients:\n\n"), name, info.IP, previousIP)
+		return c.Connect(name, false)
+	}
+	fmt.Printf(tr("Proxy %s started at %s.\n"), name, info.IP)
+	return nil
+}
+
+// powerProxy 回傳可以停止或啟動的 proxy 紀錄，匯入的外部伺服器不由 auto_proxy 管理電源
+func (c *Commander) powerProxy(name string) (ProxyRecord, error) {
+	record, found, err := c.recordManager.FindByName(name)
+	if err != nil {
+		return record, fmt.Errorf("error loading records: %v", err)
+	}
+	if !found {
+		return record, fmt.Errorf("proxy not found: %s", name)
+	}
+	if !record.Managed() {
+		return record, fmt.Errorf("proxy %s is an external server, stop it with its own provider", name)
+	}
+	return record, nil
+}
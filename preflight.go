@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/cloudresourcemanager/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// PreflightChecker 由需要外部工具的 deployer 實作，在開始部署前檢查環境
+type PreflightChecker interface {
+	Preflight() error
+}
+
+// 最低版本需求
+var (
+	minAnsibleVersion = [2]int{2, 9}
+	minOpenSSHVersion = [2]int{7, 6}
+)
+
+var (
+	ansibleVersionRe = regexp.MustCompile(`ansible-playbook (?:\[core )?(\d+)\.(\d+)`)
+	opensshVersionRe = regexp.MustCompile(`OpenSSH_(?:for_Windows_)?(\d+)\.(\d+)`)
+)
+
+func (d *AnsibleProxyDeployer) Preflight() error {
+	out, err := exec.Command("ansible-playbook", "--version").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ansible-playbook not found or not runnable, please install Ansible %d.%d or later: %v", minAnsibleVersion[0], minAnsibleVersion[1], err)
+	}
+	ansible, err := parseVersion(ansibleVersionRe, string(out))
+	if err != nil {
+		return fmt.Errorf("failed to detect ansible-playbook version: %v", err)
+	}
+	if versionLess(ansible, minAnsibleVersion) {
+		return fmt.Errorf("ansible-playbook %d.%d is too old, %d.%d or later is required", ansible[0], ansible[1], minAnsibleVersion[0], minAnsibleVersion[1])
+	}
+
+	// ansible-core 2.10 之後 ufw 模組移到 community.general collection
+	if !versionLess(ansible, [2]int{2, 10}) {
+		out, err := exec.Command("ansible-galaxy", "collection", "list", "community.general").CombinedOutput()
+		if err != nil || !strings.Contains(string(out), "community.general") {
+			return fmt.Errorf("ansible collection community.general is required for the ufw module, install it with `ansible-galaxy collection install community.general`")
+		}
+	}
+
+	// ssh -V 將版本輸出到 stderr
+	out, err = exec.Command("ssh", "-V").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ssh not found or not runnable, please install OpenSSH %d.%d or later: %v", minOpenSSHVersion[0], minOpenSSHVersion[1], err)
+	}
+	ssh, err := parseVersion(opensshVersionRe, string(out))
+	if err != nil {
+		return fmt.Errorf("failed to detect ssh version: %v", err)
+	}
+	if versionLess(ssh, minOpenSSHVersion) {
+		return fmt.Errorf("OpenSSH %d.%d is too old, %d.%d or later is required", ssh[0], ssh[1], minOpenSSHVersion[0], minOpenSSHVersion[1])
+	}
+	return nil
+}
+
+// preflight 在部署相關的指令開始前檢查 deployer 需要的外部工具
+func (c *Commander) preflight() error {
+	checker, ok := c.deployer.(PreflightChecker)
+	if !ok {
+		return nil
+	}
+	if err := checker.Preflight(); err != nil {
+		return fmt.Errorf("preflight check failed: %v", err)
+	}
+	return nil
+}
+
+// requiredPermissions 建立與管理 proxy 至少需要的 IAM 權限
+var requiredPermissions = []string{
+	"compute.instances.create",
+	"compute.instances.delete",
+	"compute.instances.get",
+	"compute.instances.list",
+	"compute.instances.setLabels",
+	"compute.instances.setMetadata",
+	"compute.instances.setTags",
+}
+
+// serviceAccountKey 服務帳戶金鑰檔中檢查用到的欄位
+type serviceAccountKey struct {
+	Type        string `json:"type"`
+	ProjectID   string `json:"project_id"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+}
+
+// checkCredentials 建立 proxy 前確認服務帳戶金鑰可以解析、屬於設定的專案並且有需要的權限，
+// 不要等到選完 region 與機器類型才遇到 403；使用 ADC 時沒有金鑰檔，只檢查權限
+func (c *Commander) checkCredentials(ctx context.Context) error {
+	gcp := c.config.GCP
+	account := "the application default credentials"
+	if gcp.Credentials != "" {
+		key, err := checkCredentialsFile(gcp)
+		if err != nil {
+			return fmt.Errorf("credential check failed: %v", err)
+		}
+		if key.ClientEmail != "" {
+			account = key.ClientEmail
+		}
+	}
+	missing, err := c.provider.MissingPermissions(ctx, requiredPermissions)
+	if err != nil {
+		// 專案沒有啟用 Resource Manager API 時無法檢查，交給之後的 Compute API 回報
+		if resourceManagerDisabled(err) {
+			c.logger.Printf("Warning: skipping the permission check: %v", err)
+			return nil
+		}
+		return fmt.Errorf("credential check failed: %s cannot access project %s: %v", account, gcp.ProjectID, err)
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("credential check failed: %s is missing these permissions in project %s: %s, grant it a role such as roles/compute.instanceAdmin.v1", account, gcp.ProjectID, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// checkCredentialsFile 檢查 gcp.credentials 是服務帳戶金鑰並且屬於 gcp.project_id，
+// gcloud 的使用者憑證檔 (authorized_user) 沒有所屬專案，只檢查格式
+func checkCredentialsFile(gcp GCPConfig) (serviceAccountKey, error) {
+	var key serviceAccountKey
+	data, err := credentialsJSON(gcp.Credentials)
+	if err != nil {
+		return key, err
+	}
+	if err := json.Unmarshal(data, &key); err != nil {
+		return key, fmt.Errorf("%s is not valid JSON: %v", gcp.Credentials, err)
+	}
+	if key.Type == "authorized_user" {
+		return key, nil
+	}
+	if key.Type != "service_account" || key.ClientEmail == "" || key.PrivateKey == "" {
+		return key, fmt.Errorf("%s is not a service account key file", gcp.Credentials)
+	}
+	if key.ProjectID != gcp.ProjectID {
+		return key, fmt.Errorf("the service account key belongs to project %s but gcp.project_id is %s, fix it with `auto_proxy config set gcp.project_id %s` or use a key of project %s", key.ProjectID, gcp.ProjectID, key.ProjectID, gcp.ProjectID)
+	}
+	return key, nil
+}
+
+// MissingPermissions 以 Resource Manager 的 testIamPermissions 檢查專案中的權限，不會建立任何資源
+func (g *GCPProvider) MissingPermissions(ctx context.Context, permissions []string) ([]string, error) {
+	crm, err := cloudresourcemanager.NewService(ctx, g.clientOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create resource manager client: %v", err)
+	}
+	debugf("cloudresourcemanager.projects.testIamPermissions %s", g.project)
+	resp, err := crm.Projects.TestIamPermissions("projects/"+g.project, &cloudresourcemanager.TestIamPermissionsRequest{Permissions: permissions}).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	var missing []string
+	for _, permission := range permissions {
+		if !slices.Contains(resp.Permissions, permission) {
+			missing = append(missing, permission)
+		}
+	}
+	return missing, nil
+}
+
+// resourceManagerDisabled 專案沒有啟用 Cloud Resource Manager API
+func resourceManagerDisabled(err error) bool {
+	gerr, ok := err.(*googleapi.Error)
+	if !ok || gerr.Code != http.StatusForbidden {
+		return false
+	}
+	for _, item := range gerr.Errors {
+		if item.Reason == "accessNotConfigured" {
+			return true
+		}
+	}
+	return strings.Contains(gerr.Message, "SERVICE_DISABLED") || strings.Contains(gerr.Message, "has not been used")
+}
+
+func parseVersion(re *regexp.Regexp, output string) ([2]int, error) {
+	m := re.FindStringSubmatch(output)
+	if m == nil {
+		return [2]int{}, fmt.Errorf("unrecognized version output: %s", strings.TrimSpace(output))
+	}
+	major, _ := strconv.Atoi(m[1])
+	minor, _ := strconv.Atoi(m[2])
+	return [2]int{major, minor}, nil
+}
+
+func versionLess(a, b [2]int) bool {
+	if a[0] != b[0] {
+		return a[0] < b[0]
+	}
+	return a[1] < b[1]
+}
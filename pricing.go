@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"google.golang.org/api/cloudbilling/v1"
+	"google.golang.org/api/compute/v1"
+)
+
+// computeBillingService Compute Engine 在 Cloud Billing catalog 中的 service ID
+const computeBillingService = "services/6F81-5844-456A"
+
+// hoursPerMonth 月費以每月 730 小時估算，與 GCP 的價格計算機相同
+const hoursPerMonth = 730
+
+// Monthly 回傳以 730 小時估算的月費
+func (p MachinePrice) Monthly() float64 {
+	return p.Hourly * hoursPerMonth
+}
+
+// String 例如 $0.0084/h, $6.11/mo
+func (p MachinePrice) String() string {
+	symbol := p.Currency + " "
+	if p.Currency == "USD" || p.Currency == "" {
+		symbol = "$"
+	}
+	return fmt.Sprintf("%s%.4f/h, %s%.2f/mo", symbol, p.Hourly, symbol, p.Monthly())
+}
+
+// billingFamilies 機器系列在 billing catalog SKU 說明中的前綴，SKU 依 CPU 與記憶體分開計價
+var billingFamilies = map[string]string{
+	"e2":  "E2 Instance",
+	"n1":  "N1 Predefined Instance",
+	"n2":  "N2 Instance",
+	"n2d": "N2D AMD Instance",
+	"n4":  "N4 Instance",
+	"t2d": "T2D AMD Instance",
+	"t2a": "T2A Arm Instance",
+	"c2":  "Compute optimized",
+	"c2d": "C2D AMD Instance",
+	"c3":  "C3 Instance",
+	"c3d": "C3D Instance",
+	"c4":  "C4 Instance",
+	"c4a": "C4A Arm Instance",
+}
+
+// sharedCoreVCPUs 共用核心的機器類型以 vCPU 的比例計價
+var sharedCoreVCPUs = map[string]float64{
+	"e2-micro":  0.25,
+	"e2-small":  0.5,
+	"e2-medium": 1,
+}
+
+// skuRate 回傳 SKU 的單價，分級計價時使用最後一級
+func skuRate(sku *cloudbilling.Sku) (float64, string, bool) {
+	if len(sku.PricingInfo) == 0 || sku.PricingInfo[0].PricingExpression == nil {
+		return 0, "", false
+	}
+	rates := sku.PricingInfo[0].PricingExpression.TieredRates
+	if len(rates) == 0 || rates[len(rates)-1].UnitPrice == nil {
+		return 0, "", false
+	}
+	price := rates[len(rates)-1].UnitPrice
+	return float64(price.Units) + float64(price.Nanos)/1e9, price.CurrencyCode, true
+}
+
+// MachinePrices 依 billing catalog 中 CPU 與記憶體的隨選單價估算 zone 內各機器類型的價格
+// 不包含磁碟、外部 IP 與網路流量，沒有對應 SKU 的機器類型不會出現在結果中
+func (g *GCPProvider) MachinePrices(ctx context.Context, zone string) (map[string]MachinePrice, error) {
+	region := zone[:strings.LastIndex(zone, "-")]
+	billing, err := cloudbilling.NewService(ctx, g.clientOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create billing client: %v", err)
+	}
+	type rate struct {
+		core, ram float64
+		currency  string
+	}
+	rates := make(map[string]*rate)
+	debugf("cloudbilling.services.skus.list %s", computeBillingService)
+	err = billing.Services.Skus.List(computeBillingService).Pages(ctx, func(page *cloudbilling.ListSkusResponse) error {
+		for _, sku := range page.Skus {
+			if sku.Category == nil || sku.Category.UsageType != "OnDemand" || !slices.Contains(sku.ServiceRegions, region) {
+				continue
+			}
+			if strings.Contains(sku.Description, "Custom") || strings.Contains(sku.Description, "Sole Tenancy") {
+				continue
+			}
+			for family, prefix := range billingFamilies {
+				if !strings.HasPrefix(sku.Description, prefix+" ") {
+					continue
+				}
+				price, currency, ok := skuRate(sku)
+				if !ok {
+					continue
+				}
+				r := rates[family]
+				if r == nil {
+					r = &rate{}
+					rates[family] = r
+				}
+				r.currency = currency
+				rest := strings.TrimPrefix(sku.Description, prefix+" ")
+				switch {
+				case strings.HasPrefix(rest, "Core"):
+					r.core = price
+				case strings.HasPrefix(rest, "Ram"):
+					r.ram = price
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list billing SKUs: %v", err)
+	}
+
+	prices := make(map[string]MachinePrice)
+	err = g.service.MachineTypes.List(g.project, zone).Pages(ctx, func(page *compute.MachineTypeList) error {
+		for _, mt := range page.Items {
+			r := rates[machineFamily(mt.Name)]
+			if r == nil || r.core == 0 || r.ram == 0 {
+				continue
+			}
+			vcpus := float64(mt.GuestCpus)
+			if shared, ok := sharedCoreVCPUs[mt.Name]; ok {
+				vcpus = shared
+			}
+			hourly := vcpus*r.core + float64(mt.MemoryMb)/1024*r.ram
+			prices[mt.Name] = MachinePrice{Hourly: hourly, Currency: r.currency}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list machine types: %v", err)
+	}
+	return prices, nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProbeTargets 使用者自訂的延遲探測目標，依 proxy > region > default 的順序套用
+type ProbeTargets struct {
+	Default []string            `json:"default"`
+	Regions map[string][]string `json:"regions"`
+	Proxies map[string][]string `json:"proxies"`
+}
+
+// defaultRegionTarget 沒有設定時用來代表 region 的端點
+const defaultRegionTarget = "https://%s-docker.pkg.dev"
+
+func LoadProbeTargets(filePath string) (*ProbeTargets, error) {
+	targets := &ProbeTargets{Regions: map[string][]string{}, Proxies: map[string][]string{}}
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return targets, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read probe targets: %w", err)
+	}
+	if err := json.Unmarshal(data, targets); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal probe targets: %w", err)
+	}
+	return targets, nil
+}
+
+// ForRegion 回傳某個 region 要探測的目標
+func (t *ProbeTargets) ForRegion(region string) []string {
+	if targets, ok := t.Regions[region]; ok && len(targets) > 0 {
+		return targets
+	}
+	if len(t.Default) > 0 {
+		return t.Default
+	}
+	return []string{fmt.Sprintf(defaultRegionTarget, region)}
+}
+
+// ForProxy 回傳某個 proxy 要探測的目標，沒有設定時直接探測 proxy 本身
+func (t *ProbeTargets) ForProxy(record ProxyRecord) []string {
+	if targets, ok := t.Proxies[record.Name]; ok && len(targets) > 0 {
+		return targets
+	}
+	if targets, ok := t.Regions[record.Region]; ok && len(targets) > 0 {
+		return targets
+	}
+	port, _, _ := record.Endpoint()
+	return []string{net.JoinHostPort(record.IP, strconv.Itoa(port))}
+}
+
+type ProbeResult struct {
+	Key     string
+	Latency time.Duration
+	Err     error
+}
+
+type Prober struct {
+	timeout time.Duration
+}
+
+func NewProber(timeout time.Duration) *Prober {
+	return &Prober{timeout: timeout}
+}
+
+// Probe 對每個目標做 TCP 連線並回傳平均延遲
+func (p *Prober) Probe(ctx context.Context, targets []string) (time.Duration, error) {
+	var total time.Duration
+	var ok int
+	var lastErr error
+	for _, target := range targets {
+		addr, err := probeAddress(target)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		dialer := net.Dialer{Timeout: p.timeout}
+		start := time.Now()
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		total += time.Since(start)
+		conn.Close()
+		ok++
+	}
+	if ok == 0 {
+		return 0, fmt.Errorf("all probe targets failed: %v", lastErr)
+	}
+	return total / time.Duration(ok), nil
+}
+
+// ProbeAll 併發探測多組目標，結果依延遲排序，失敗的排在最後
+func (p *Prober) ProbeAll(ctx context.Context, groups map[string][]string) []ProbeResult {
+	results := make([]ProbeResult, 0, len(groups))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for key, targets := range groups {
+		wg.Add(1)
+		go func(key string, targets []string) {
+			defer wg.Done()
+			latency, err := p.Probe(ctx, targets)
+			mu.Lock()
+			results = append(results, ProbeResult{Key: key, Latency: latency, Err: err})
+			mu.Unlock()
+		}(key, targets)
+	}
+	wg.Wait()
+	sort.Slice(results, func(i, j int) bool {
+		if (results[i].Err == nil) != (results[j].Err == nil) {
+			return results[i].Err == nil
+		}
+		if results[i].Latency != results[j].Latency {
+			return results[i].Latency < results[j].Latency
+		}
+		return results[i].Key < results[j].Key
+	})
+	return results
+}
+
+// probeAddress 將 URL 或 host[:port] 轉成可以撥號的 host:port
+func probeAddress(target string) (string, error) {
+	if strings.Contains(target, "://") {
+		u, err := url.Parse(target)
+		if err != nil {
+			return "", fmt.Errorf("invalid probe target %s: %v", target, err)
+		}
+		port := u.Port()
+		if port == "" {
+			port = "443"
+			if u.Scheme == "http" {
+				port = "80"
+			}
+		}
+		return net.JoinHostPort(u.Hostname(), port), nil
+	}
+	if _, _, err := net.SplitHostPort(target); err == nil {
+		return target, nil
+	}
+	return net.JoinHostPort(target, "443"), nil
+}
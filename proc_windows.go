@@ -0,0 +1,15 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// processAlive 回傳 pid 的程序是否還在執行，Windows 上 FindProcess 會開啟程序，程序不存在時失敗
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
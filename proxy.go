@@ -2,54 +2,169 @@ package main
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math/rand"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
+// Shadowsocks 連線參數，與 playbook 中的設定一致
+// shadowsocksPassword 是舊版所有 proxy 共用的密碼，只用於沒有記錄密碼的舊紀錄，新的 proxy 各自產生隨機密碼
+const (
+	shadowsocksPort     = 8388
+	shadowsocksPassword = "s;980303"
+	shadowsocksMethod   = "aes-256-gcm"
+)
+
+// randomMethods --randomize 挑選的 AEAD 加密方式，shadowsocks-libev 與常見的客戶端都支援
+var randomMethods = []string{"aes-128-gcm", "aes-256-gcm", "chacha20-ietf-poly1305"}
+
+// --randomize 挑選連接埠的範圍，避開常用服務的連接埠與 Linux 的 ephemeral port
+const (
+	randomPortMin = 10000
+	randomPortMax = 32767
+)
+
+// randomEndpoint 隨機挑選連接埠與加密方式，讓每台 proxy 的特徵不同
+func randomEndpoint() (port int, method string) {
+	return randomPortMin + rand.Intn(randomPortMax-randomPortMin+1), randomMethods[rand.Intn(len(randomMethods))]
+}
+
 type ProxyDeployer interface {
-	Deploy(ip string) error
+	Deploy(target DeployTarget) error
+}
+
+// DeployTarget 部署的目標主機，SSHUser 與 SSHKeyPath 為空時使用 deployer 的預設值
+type DeployTarget struct {
+	IP         string
+	SSHUser    string
+	SSHKeyPath string
+	SSHPort    int    // 0 表示 22
+	Arch       string // 空值視為 amd64
+	// Port、Method、Password 寫入伺服器的 Shadowsocks 設定
+	Port      int
+	Method    string
+	Password  string
+	Completed []Stage
+	// AllowedIPs 不為空時 UFW 只對這些來源開放 proxy 的連接埠
+	AllowedIPs []string
+	// Users 多使用者模式下的其他使用者，各自以獨立的服務提供
+	Users []ProxyUser
+	// KnockPorts 不為空時連線前先依序 knock，SSH 才會開放
+	KnockPorts []int
+	// FirewallRules UFW 額外開放的自訂規則
+	FirewallRules []FirewallRule
+	// RateLimit proxy 與使用者連接埠每個來源每分鐘的新連線數上限，0 表示不限制
+	RateLimit int
+	// SSHProxy 透過跳板機或 IAP 連線時的 ssh 選項，例如 ProxyJump=user@bastion
+	SSHProxy string
+}
+
+// sshOptions 回傳連線到目標時共用的 ssh 參數
+func (t DeployTarget) sshOptions(keyPath string) []string {
+	opts := []string{"-i", keyPath, "-o", "StrictHostKeyChecking=no", "-o", "UserKnownHostsFile=/dev/null"}
+	if t.SSHPort != 0 {
+		opts = append(opts, "-p", strconv.Itoa(t.SSHPort))
+	}
+	if t.SSHProxy != "" {
+		opts = append(opts, "-o", t.SSHProxy)
+	}
+	return opts
+}
+
+// ansibleSSHArgs 回傳給 ansible_ssh_common_args 使用的字串
+func (t DeployTarget) ansibleSSHArgs() string {
+	args := "-o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null"
+	if t.SSHProxy != "" {
+		key, value, _ := strings.Cut(t.SSHProxy, "=")
+		args += fmt.Sprintf(" -o %s=%q", key, value)
+	}
+	return args
+}
+
+// done 回傳上一次部署是否已經完成 stage
+func (t DeployTarget) done(stage Stage) bool {
+	for _, s := range t.Completed {
+		if s == stage {
+			return true
+		}
+	}
+	return false
 }
 
 type AnsibleProxyDeployer struct {
-	user string
-	keyPath  string
+	user      string
+	keyPath   string
+	reporter  Reporter
+	hardening HardeningConfig
 }
 
-func NewAnsibleProxyDeployer(user, keyPath string) *AnsibleProxyDeployer {
-	return &AnsibleProxyDeployer{user: user, keyPath: keyPath}
+func NewAnsibleProxyDeployer(user, keyPath string, reporter Reporter) *AnsibleProxyDeployer {
+	return &AnsibleProxyDeployer{user: user, keyPath: keyPath, reporter: reporter}
 }
 
-func (d *AnsibleProxyDeployer) Deploy(ip string) error {
-	sshUser := os.Getenv("ANSIBLE_SSH_USER")
-	if sshUser == "" {
-		return fmt.Errorf("ANSIBLE_SSH_USER not set in .env")
+// credentials 回傳目標的 SSH 使用者與金鑰，未指定時使用預設值
+func (d *AnsibleProxyDeployer) credentials(target DeployTarget) (string, string) {
+	user, keyPath := target.SSHUser, target.SSHKeyPath
+	if user == "" {
+		user = d.user
 	}
-	sshKeyPath := os.Getenv("ANSIBLE_SSH_KEY_PATH")
-	if sshKeyPath == "" {
-		return fmt.Errorf("ANSIBLE_SSH_KEY_PATH not set in .env")
+	if keyPath == "" {
+		keyPath = d.keyPath
 	}
+	return user, keyPath
+}
 
-	invetory := fmt.Sprintf("[proxy_server]\n%s ansible_user=%s ansible_ssh_private_key_file=%s", ip, d.user, d.keyPath)
-	if err := os.WriteFile("inventory.ini", []byte(invetory), 0645); err != nil {
-		return err
+// inventory 產生部署目標的 Ansible inventory
+func (d *AnsibleProxyDeployer) inventory(target DeployTarget) string {
+	user, keyPath := d.credentials(target)
+	inventory := fmt.Sprintf("[proxy_server]\n%s ansible_user=%s ansible_ssh_private_key_file=%s", target.IP, user, keyPath)
+	if target.SSHPort != 0 {
+		inventory += fmt.Sprintf(" ansible_port=%d", target.SSHPort)
 	}
-	defer os.Remove("inventory.ini")
-	playbook := `
-- name: Deploy Shadowsocks Proxy Server on Ubuntu
+	return inventory
+}
+
+// playbook 產生安裝與設定 Shadowsocks 的 playbook
+func (d *AnsibleProxyDeployer) playbook(target DeployTarget) (string, error) {
+	firewall, err := target.firewall()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`
+- name: Deploy Shadowsocks Proxy Server
   hosts: proxy_server
   become: yes
-  vars:
-    ansible_ssh_common_args: '-o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null'
+  gather_subset: ['!all', 'platform']
   tasks:
-    - name: Update apt cache
-      apt:
-        update_cache: yes
-    - name: Install Shadowsocks-libev
+    - name: Verify target architecture
+      assert:
+        that: ansible_architecture in arch_aliases[proxy_arch]
+        fail_msg: "Expected {{ proxy_arch }} host but found {{ ansible_architecture }}"
+      vars:
+        arch_aliases:
+          amd64: [x86_64, amd64]
+          arm64: [aarch64, arm64]
+    - name: Preseed apt config
+      copy:
+        content: |
+%s
+        dest: /etc/apt/apt.conf.d/90auto-proxy
+    - name: Install packages
       apt:
-        name: shadowsocks-libev
+        name: [shadowsocks-libev, ufw]
         state: present
+        update_cache: yes
+        cache_valid_time: 3600
     - name: Create Shadowsocks config directory
       file:
         path: /etc/shadowsocks-libev
@@ -58,61 +173,163 @@ func (d *AnsibleProxyDeployer) Deploy(ip string) error {
     - name: Configure Shadowsocks
       copy:
         content: |
-          {
-              "server": "0.0.0.0",
-              "server_port": 8388,
-              "password": "s;980303",
-              "timeout": 300,
-              "method": "aes-256-gcm",
-              "fast_open": true
-          }
+%s
         dest: /etc/shadowsocks-libev/config.json
       notify: Restart Shadowsocks
+      tags: [config]
     - name: Ensure Shadowsocks service is enabled and started
       systemd:
         name: shadowsocks-libev
         enabled: yes
         state: started
-    - name: Install and configure UFW
-      block:
-        - name: Install UFW
-          apt:
-            name: ufw
-            state: present
-        - name: Allow SSH
-          ufw:
-            rule: allow
-            port: 22
-        - name: Allow Shadowsocks port
-          ufw:
-            rule: allow
-            port: 8388
-        - name: Enable UFW
-          ufw:
-            state: enabled
-  handlers:
+      tags: [config]
+%s%s%s%s%s%s  handlers:
     - name: Restart Shadowsocks
       systemd:
         name: shadowsocks-libev
         state: restarted
-`
-	if err := os.WriteFile("playbook.yml", []byte(playbook), 0644); err != nil {
-		return nil
+%s%s%s%s`, indent(aptPreseed, 10), indent(shadowsocksConfigJSON(target.Port, "{{ shadowsocks_password | to_json }}", target.Method), 10), userTasks(target.Users, target.Method), knockTasks(target.KnockPorts, target.SSHPort), firewall.ufwTasks(), rateLimitTasks(target.RateLimit, target.proxyPorts()), sshPortTasks(target.SSHPort), d.hardening.tasks(), sshdHandler, d.hardening.handlers(), knockHandler(target.KnockPorts), rateLimitHandler(target.RateLimit)), nil
+}
+
+// secretVars 產生以檔案傳給 ansible-playbook 的 extra vars，密碼不會出現在 playbook 與指令列
+func secretVars(target DeployTarget) ([]byte, error) {
+	users := make(map[string]string, len(target.Users))
+	for _, user := range target.Users {
+		users[user.Name] = user.Password
+		registerSecret(user.Password)
+	}
+	registerSecret(target.Password)
+	data, err := json.Marshal(map[string]any{"shadowsocks_password": target.Password, "shadowsocks_users": users})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal secret vars: %v", err)
+	}
+	return data, nil
+}
+
+func (d *AnsibleProxyDeployer) Deploy(target DeployTarget) error {
+	ip := target.IP
+	user, keyPath := d.credentials(target)
+
+	// 每次部署使用獨立的暫存目錄，讓多台可以同時部署
+	workDir, err := os.MkdirTemp("", "auto_proxy-")
+	if err != nil {
+		return fmt.Errorf("failed to create work dir: %v", err)
+	}
+	defer os.RemoveAll(workDir)
+	inventoryPath := filepath.Join(workDir, "inventory.ini")
+	playbookPath := filepath.Join(workDir, "playbook.yml")
+	varsPath := filepath.Join(workDir, "secrets.json")
+
+	if err := os.WriteFile(inventoryPath, []byte(d.inventory(target)), 0600); err != nil {
+		return err
+	}
+	playbook, err := d.playbook(target)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(playbookPath, []byte(playbook), 0600); err != nil {
+		return err
+	}
+	// Shadowsocks 密碼只寫在這個檔案，只有自己可以讀取
+	vars, err := secretVars(target)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(varsPath, vars, 0600); err != nil {
+		return err
 	}
-	defer os.Remove("playbook.yml")
 
-	fmt.Println("Waiting for SSH to be ready...")
+	report(d.reporter, "", ip, StageWaitSSH, EventStarted, "")
+	sshReady := false
 	for i := 0; i < 30; i++ {
-		cmd := exec.Command("ssh", "-i", d.keyPath, "-o", "StrictHostKeyChecking=no", fmt.Sprintf("%s@%s", d.user, ip), "exit")
+		// knockd 對來源開放 SSH 一段時間，之後探測與 playbook 的連線都在這段時間內
+		if len(target.KnockPorts) > 0 {
+			knock(ip, target.KnockPorts)
+		}
+		cmd := exec.Command("ssh", append(target.sshOptions(keyPath), fmt.Sprintf("%s@%s", user, ip), "exit")...)
 		if err := cmd.Run(); err == nil {
+			sshReady = true
 			break
 		}
-		fmt.Printf("SSH not ready, retrying in 2 seconds (%d/30)...\n", i+1)
+		report(d.reporter, "", ip, StageWaitSSH, EventProgress, fmt.Sprintf("SSH not ready, retrying in 2 seconds (%d/30)...", i+1))
 		time.Sleep(2 * time.Second)
 	}
+	if !sshReady {
+		report(d.reporter, "", ip, StageWaitSSH, EventFailed, "SSH not ready after 30 attempts")
+		return fmt.Errorf("ssh to %s not ready after 30 attempts", ip)
+	}
+	report(d.reporter, "", ip, StageWaitSSH, EventSucceeded, "")
 
-	fmt.Println("Starting Ansible playbook execution...")
-	cmd := exec.Command("ansible-playbook", "-i", "inventory.ini", "playbook.yml", "-v", "-e", "ansible_ssh_common_args='-o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null'")
+	// 上次中斷前已經完成安裝的話，直接從下一步繼續
+	if target.done(StageProvision) {
+		report(d.reporter, "", ip, StageProvision, EventSucceeded, "completed in a previous run, skipping playbook")
+		return nil
+	}
+
+	// 已經正確設定時跳過，只有設定不同時只重新套用設定
+	var tags []string
+	switch d.probeState(target, user, keyPath) {
+	case stateConfigured:
+		report(d.reporter, "", ip, StageProvision, EventSucceeded, "already configured, skipping playbook")
+		return nil
+	case stateDrifted:
+		report(d.reporter, "", ip, StageProvision, EventProgress, "proxy installed but config differs, reconciling config only")
+		tags = []string{"config"}
+	}
+
+	report(d.reporter, "", ip, StageProvision, EventStarted, "")
+	arch := target.Arch
+	if arch == "" {
+		arch = ArchAMD64
+	}
+	// 連線層級的錯誤（網路不穩）重試，playbook 本身是冪等的，重跑會從未完成的步驟接續
+	for attempt := 0; attempt < maxPlaybookAttempts; attempt++ {
+		if attempt > 0 {
+			wait := time.Duration(5<<uint(attempt-1)) * time.Second
+			report(d.reporter, "", ip, StageProvision, EventProgress, fmt.Sprintf("connection lost, retrying playbook in %v (%d/%d)...", wait, attempt+1, maxPlaybookAttempts))
+			time.Sleep(wait)
+		}
+		err = d.runPlaybook(target, inventoryPath, playbookPath, varsPath, arch, tags)
+		if err == nil || !isTransportError(err) {
+			break
+		}
+	}
+	if err != nil {
+		report(d.reporter, "", ip, StageProvision, EventFailed, err.Error())
+		return err
+	}
+	report(d.reporter, "", ip, StageProvision, EventSucceeded, "")
+	return nil
+}
+
+// playbookArgs 回傳 ansible-playbook 的參數
+func playbookArgs(target DeployTarget, inventoryPath, playbookPath, varsPath, arch string, tags []string) ([]string, error) {
+	// 以 JSON 傳入 extra vars，ProxyCommand 中的空白與引號才不會被拆開
+	extraVars, err := json.Marshal(map[string]string{"ansible_ssh_common_args": target.ansibleSSHArgs(), "proxy_arch": arch})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal extra vars: %v", err)
+	}
+	verbosity := "-v"
+	if logLevel >= LevelDebug {
+		verbosity = "-vvv"
+	}
+	args := []string{"-i", inventoryPath, playbookPath, verbosity, "-e", string(extraVars), "-e", "@" + varsPath}
+	if len(tags) > 0 {
+		args = append(args, "--tags", strings.Join(tags, ","))
+	}
+	return args, nil
+}
+
+// runPlaybook 執行 playbook，套件由 apt 依主機架構安裝，proxy_arch 用來確認主機架構符合預期
+func (d *AnsibleProxyDeployer) runPlaybook(target DeployTarget, inventoryPath, playbookPath, varsPath, arch string, tags []string) error {
+	ip := target.IP
+	args, err := playbookArgs(target, inventoryPath, playbookPath, varsPath, arch, tags)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command("ansible-playbook", args...)
+	// pipelining 減少每個 task 的 SSH 往返次數
+	cmd.Env = append(os.Environ(), "ANSIBLE_PIPELINING=True")
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
 		return fmt.Errorf("failed to get stdout pipe: %v", err)
@@ -126,24 +343,121 @@ func (d *AnsibleProxyDeployer) Deploy(ip string) error {
 		return fmt.Errorf("failed to start ansible-playbook: %v", err)
 	}
 
+	var wg sync.WaitGroup
+	wg.Add(2)
 	go func() {
+		defer wg.Done()
 		scanner := bufio.NewScanner(stdout)
 		for scanner.Scan() {
-			fmt.Println(scanner.Text())
+			// Ansible 的輸出只在 --verbose 時顯示，-vvv 會印出 task 的參數，密碼在回報前遮蔽
+			if logLevel >= LevelVerbose {
+				report(d.reporter, "", ip, StageProvision, EventProgress, redact(scanner.Text()))
+			}
 		}
 	}()
 
 	go func() {
+		defer wg.Done()
 		scanner := bufio.NewScanner(stderr)
 		for scanner.Scan() {
-			fmt.Println("ERROR: ", scanner.Text())
+			report(d.reporter, "", ip, StageProvision, EventProgress, "ERROR: "+redact(scanner.Text()))
 		}
 	}()
 
+	wg.Wait()
 	if err := cmd.Wait(); err != nil {
-		return fmt.Errorf("ansible-playbook failed: %v", err)
+		return fmt.Errorf("ansible-playbook failed: %w", err)
 	}
-
-	fmt.Println("Ansible playbook execution completed successfully.")
 	return nil
-}
\ No newline at end of file
+}
+
+// maxPlaybookAttempts 連線中斷時 playbook 最多執行的次數
+const maxPlaybookAttempts = 3
+
+// ansible-playbook 在主機無法連線時回傳 4，ssh 連線失敗時回傳 255
+func isTransportError(err error) bool {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+	code := exitErr.ExitCode()
+	return code == 4 || code == 255
+}
+
+// firewall 回傳主機 UFW 使用的 template，scope 只影響雲端防火牆的網路標記
+func (t DeployTarget) firewall() (FirewallTemplate, error) {
+	return proxyFirewall(ProtocolShadowsocks, t.Port, t.SSHPort, userPorts(t.Users), t.KnockPorts, t.FirewallRules, t.AllowedIPs, "")
+}
+
+// proxyPorts 回傳 proxy 與所有使用者的連接埠
+func (t DeployTarget) proxyPorts() []int {
+	return append([]int{t.Port}, userPorts(t.Users)...)
+}
+
+// rateLimitMarker 回傳主機上 rateLimitFile 應有的內容，copy 模組寫入時帶結尾換行
+func (t DeployTarget) rateLimitMarker() string {
+	if rules := rateLimitRules(t.RateLimit, t.proxyPorts()); rules != "" {
+		return rules + "\n"
+	}
+	return ""
+}
+
+// shadowsocksConfig 產生伺服器上 /etc/shadowsocks-libev/config.json 的內容
+func (t DeployTarget) shadowsocksConfig() string {
+	return shadowsocksConfigJSON(t.Port, strconv.Quote(t.Password), t.Method)
+}
+
+// shadowsocksConfigJSON password 為已經編碼的 JSON 字串，playbook 中以 Jinja 變數代入
+func shadowsocksConfigJSON(port int, password, method string) string {
+	return fmt.Sprintf(`{
+    "server": "0.0.0.0",
+    "server_port": %d,
+    "password": %s,
+    "timeout": 300,
+    "method": %q,
+    "fast_open": true
+}`, port, password, method)
+}
+
+// indent 將每一行縮排 n 個空白，用於嵌入 YAML block
+func indent(s string, n int) string {
+	pad := strings.Repeat(" ", n)
+	return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+}
+
+type provisionState int
+
+const (
+	stateUnknown provisionState = iota
+	stateDrifted
+	stateConfigured
+)
+
+// probeState 透過 SSH 檢查服務是否運作中以及設定檔的 hash
+func (d *AnsibleProxyDeployer) probeState(target DeployTarget, user, keyPath string) provisionState {
+	script := "systemctl is-active --quiet shadowsocks-libev && sudo ufw status | grep -q 'Status: active' && sha256sum /etc/shadowsocks-libev/config.json && (cat " + ufwSourcesFile + " 2>/dev/null || echo any; cat " + hardeningFile + " " + usersFile + " " + knockFile + " " + customRulesFile + " " + rateLimitFile + " 2>/dev/null) | sha256sum"
+	cmd := exec.Command("ssh", append(target.sshOptions(keyPath), fmt.Sprintf("%s@%s", user, target.IP), script)...)
+	out, err := cmd.Output()
+	if err != nil {
+		return stateUnknown
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 {
+		return stateUnknown
+	}
+	// copy 模組寫入 YAML block 的內容時會帶結尾換行
+	sum := sha256.Sum256([]byte(target.shadowsocksConfig() + "\n"))
+	if fields[0] != hex.EncodeToString(sum[:]) {
+		return stateDrifted
+	}
+	// 開放的來源、強化步驟或使用者不同時同樣只重新套用設定，這些 tasks 也帶有 config 標記
+	firewall, err := target.firewall()
+	if err != nil {
+		return stateUnknown
+	}
+	state := sha256.Sum256([]byte(firewall.ufwSources() + d.hardening.marker() + usersMarker(target.Users) + knockMarker(target.KnockPorts) + firewall.customMarker() + target.rateLimitMarker()))
+	if len(fields) < 3 || fields[2] != hex.EncodeToString(state[:]) {
+		return stateDrifted
+	}
+	return stateConfigured
+}
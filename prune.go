@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/AlecAivazis/survey/v2"
+)
+
+// Prune 移除 instance 已經不存在的紀錄，例如在 GCP console 上直接刪除的機器
+// 查詢失敗的紀錄一律保留，yes 時不詢問直接移除
+func (c *Commander) Prune(ctx context.Context, yes bool) error {
+	records, err := c.recordManager.Load()
+	if err != nil {
+		return fmt.Errorf("error loading records: %v", err)
+	}
+	var instances []CloudInstance
+	err = withSpinner(tr("Listing cloud instances"), func() error {
+		instances, err = c.provider.ListInstances(ctx, managedLabel)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error listing instances: %v", err)
+	}
+	var dead []driftView
+	for _, d := range c.detectDrift(ctx, records, instances) {
+		if d.Drift == DriftMissing {
+			dead = append(dead, d)
+		}
+	}
+	if len(dead) == 0 {
+		fmt.Println(tr("No dead records found."))
+		return nil
+	}
+
+	fmt.Println(tr("The following records have no instance in the cloud:"))
+	for _, d := range dead {
+		fmt.Printf(" - %s (%s, %s)\n", d.Name, d.Zone, d.RecordedIP)
+	}
+	if c.dryRun {
+		dryRunf("Would remove %d records\n", len(dead))
+		return nil
+	}
+	if !yes {
+		confirmed := false
+		if err := survey.AskOne(&survey.Confirm{Message: fmt.Sprintf(tr("Remove %d records?"), len(dead))}, &confirmed); err != nil {
+			return fmt.Errorf("confirmation failed (use --yes to skip it): %v", err)
+		}
+		if !confirmed {
+			fmt.Println(tr("Cancelled."))
+			return nil
+		}
+	}
+
+	removed := 0
+	err = c.recordManager.Update(func(records []ProxyRecord) ([]ProxyRecord, error) {
+		removed = 0
+		kept := records[:0]
+		for _, r := range records {
+			if r.Type == "instance" && isDead(dead, r) {
+				removed++
+				continue
+			}
+			kept = append(kept, r)
+		}
+		return kept, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error saving records: %v", err)
+	}
+	fmt.Printf(tr("Removed %d records.\n"), removed)
+	return nil
+}
+
+// isDead 紀錄是否為 dead 中的一項，以 zone 與 instance 比對，期間被改名的紀錄也能對上
+func isDead(dead []driftView, r ProxyRecord) bool {
+	for _, d := range dead {
+		if d.Zone == r.Zone && d.InstanceID == r.InstanceID {
+			return true
+		}
+	}
+	return false
+}
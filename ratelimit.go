@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// 連線數限制以獨立的 nftables table 實作，不修改 UFW 的規則
+// 同一個 hook 上任何一個 chain drop 封包就會丟棄，與 UFW 同時生效
+const (
+	rateLimitFile    = "/etc/auto-proxy/rate-limit.nft"
+	rateLimitService = "auto-proxy-rate-limit"
+	rateLimitTable   = "auto_proxy_rate_limit"
+)
+
+// rateLimitRules 產生限制每個來源每分鐘新連線數的 nftables 規則，limit 為 0 時為空字串
+// 先建立再刪除 table，重新載入時不會留下舊的規則
+func rateLimitRules(limit int, ports []int) string {
+	if limit <= 0 {
+		return ""
+	}
+	values := make([]string, len(ports))
+	for i, port := range ports {
+		values[i] = strconv.Itoa(port)
+	}
+	match := fmt.Sprintf("ct state new meta l4proto { tcp, udp } th dport { %s }", strings.Join(values, ", "))
+	return fmt.Sprintf(`table inet %[1]s
+delete table inet %[1]s
+table inet %[1]s {
+    set sources4 {
+        type ipv4_addr
+        flags dynamic, timeout
+        timeout 5m
+    }
+    set sources6 {
+        type ipv6_addr
+        flags dynamic, timeout
+        timeout 5m
+    }
+    chain input {
+        type filter hook input priority -10; policy accept;
+        %[2]s add @sources4 { ip saddr limit rate over %[3]d/minute } drop
+        %[2]s add @sources6 { ip6 saddr limit rate over %[3]d/minute } drop
+    }
+}`, rateLimitTable, match, limit)
+}
+
+// rateLimitTasks 產生 playbook 中設定連線數限制的 tasks，停用時移除之前安裝的服務
+func rateLimitTasks(limit int, ports []int) string {
+	if limit <= 0 {
+		return fmt.Sprintf(`    - name: Remove connection rate limit
+      shell: |
+        if [ -f /etc/systemd/system/%[1]s.service ]; then
+          systemctl disable --now %[1]s
+          rm -f /etc/systemd/system/%[1]s.service %[2]s
+          systemctl daemon-reload
+          echo removed
+        fi
+      register: rate_limit_removed
+      changed_when: "'removed' in rate_limit_removed.stdout"
+      tags: [config]
+`, rateLimitService, rateLimitFile)
+	}
+	unit := fmt.Sprintf(`[Unit]
+Description=auto_proxy connection rate limit
+After=network.target
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=/usr/sbin/nft -f %s
+ExecStop=/usr/sbin/nft delete table inet %s
+
+[Install]
+WantedBy=multi-user.target`, rateLimitFile, rateLimitTable)
+	return fmt.Sprintf(`    - name: Configure connection rate limit
+      block:
+        - name: Install nftables
+          apt:
+            name: nftables
+            state: present
+        - name: Create auto_proxy state directory
+          file:
+            path: %s
+            state: directory
+            mode: '0755'
+        - name: Write rate limit rules
+          copy:
+            content: |
+%s
+            dest: %s
+            validate: /usr/sbin/nft -c -f %%s
+          notify: Reload rate limit
+        - name: Install rate limit service
+          copy:
+            content: |
+%s
+            dest: /etc/systemd/system/%s.service
+          notify: Reload rate limit
+        - name: Ensure rate limit service is enabled and started
+          systemd:
+            name: %s
+            daemon_reload: yes
+            enabled: yes
+            state: started
+      tags: [config]
+`, path.Dir(rateLimitFile), indent(rateLimitRules(limit, ports), 14), rateLimitFile, indent(unit, 14), rateLimitService, rateLimitService)
+}
+
+// rateLimitHandler 重新載入連線數限制，沒有啟用時為空字串
+func rateLimitHandler(limit int) string {
+	if limit <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(`    - name: Reload rate limit
+      systemd:
+        name: %s
+        daemon_reload: yes
+        state: restarted
+`, rateLimitService)
+}
+
+// SetRateLimit 修改 proxy 連接埠每個來源每分鐘的新連線數上限並重新部署，0 表示不限制
+func (c *Commander) SetRateLimit(ctx context.Context, name string, limit int) error {
+	if limit < 0 {
+		return fmt.Errorf("rate limit must not be negative")
+	}
+	previous, err := c.firewallProxy(name)
+	if err != nil {
+		return err
+	}
+	if previous.RateLimit == limit {
+		fmt.Printf(tr("Rate limit of proxy %s unchanged.\n"), name)
+		return nil
+	}
+	record := previous
+	record.RateLimit = limit
+	if err := c.applyFirewall(ctx, previous, record); err != nil {
+		return err
+	}
+	if _, err := c.recordManager.UpdateRecord(name, func(r *ProxyRecord) error {
+		r.RateLimit = limit
+		return nil
+	}); err != nil {
+		return fmt.Errorf("error saving records: %v", err)
+	}
+	c.logger.Printf("Rate limit of proxy %s set to %d", name, limit)
+	if limit == 0 {
+		fmt.Printf(tr("Proxy %s no longer limits new connections.\n"), name)
+		return nil
+	}
+	fmt.Printf(tr("Rate limit of proxy %s set to %d/minute per source.\n"), name, limit)
+	return nil
+}
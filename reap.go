@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Reap 刪除 create --ttl 設定的期限已到的 proxy，受備註保護的 proxy 只提出警告
+func (c *Commander) Reap(ctx context.Context) error {
+	records, err := c.recordManager.Load()
+	if err != nil {
+		return fmt.Errorf("error loading records: %v", err)
+	}
+	at := now()
+	var names []string
+	for _, r := range records {
+		if r.Type != "instance" || !r.Expired(at) {
+			continue
+		}
+		if note := r.ProtectedBy(); note != "" {
+			fmt.Printf(tr("Proxy %s expired but is protected by note %q, delete it with --force.\n"), r.Name, note)
+			continue
+		}
+		names = append(names, r.Name)
+	}
+	if len(names) == 0 {
+		fmt.Println(tr("No expired proxies."))
+		return nil
+	}
+	for _, name := range names {
+		c.logger.Printf("Proxy %s expired, deleting it", name)
+	}
+	return c.deleteMany(ctx, names, false)
+}
+
+// reapEvery 由 serve 在背景執行，定期刪除到期的 proxy 直到 ctx 結束
+func (c *Commander) reapEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.Reap(ctx); err != nil {
+				c.logger.Printf("Failed to reap expired proxies: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// RecommendOptions recommend 指令的參數
+type RecommendOptions struct {
+	Candidates int // 依延遲取前幾個 region 比較費用
+	// LatencySlack 比最快的 region 慢不超過此值的 region 視為一樣快，改以免費方案與價格決定
+	LatencySlack time.Duration
+	Apply        bool
+	Create       CreateOptions // --apply 時建立 proxy 的參數
+}
+
+// regionCandidate recommend 比較的一個 region，價格未知時 Hourly 為 0
+type regionCandidate struct {
+	Region      string  `json:"region" yaml:"region"`
+	Location    string  `json:"location" yaml:"location"`
+	Zone        string  `json:"zone" yaml:"zone"`
+	MachineType string  `json:"machine_type" yaml:"machine_type"`
+	LatencyMs   int64   `json:"latency_ms" yaml:"latency_ms"`
+	Hourly      float64 `json:"hourly,omitempty" yaml:"hourly,omitempty"`
+	Monthly     float64 `json:"monthly,omitempty" yaml:"monthly,omitempty"`
+	Currency    string  `json:"currency,omitempty" yaml:"currency,omitempty"`
+	FreeTier    bool    `json:"free_tier" yaml:"free_tier"`
+	Recommended bool    `json:"recommended" yaml:"recommended"`
+
+	latency time.Duration
+	price   *MachinePrice
+}
+
+// Recommend 測量到各 region 的延遲，在夠快的 region 中優先選擇免費方案，其次選擇最便宜的，apply 時直接建立
+func (c *Commander) Recommend(ctx context.Context, opts RecommendOptions) error {
+	if opts.Candidates < 1 {
+		return fmt.Errorf("--candidates must be at least 1")
+	}
+	regions, err := c.provider.ListRegions(ctx)
+	if err != nil {
+		return fmt.Errorf("error listing regions: %v", err)
+	}
+	groups := make(map[string][]string, len(regions))
+	for _, r := range regions {
+		groups[r] = c.probeTargets.ForRegion(r)
+	}
+	chatf(tr("Measuring latency to %d regions...\n"), len(regions))
+	results := NewProber(3*time.Second).ProbeAll(ctx, groups)
+
+	machineType := c.config.Defaults.MachineType
+	if machineType == "" {
+		machineType = c.provider.RecommendedType()
+	}
+	var candidates []regionCandidate
+	for _, r := range results {
+		if r.Err != nil || len(candidates) >= opts.Candidates {
+			break
+		}
+		zones, err := c.provider.ListZones(ctx, r.Key)
+		if err != nil {
+			return fmt.Errorf("error listing zones: %v", err)
+		}
+		if len(zones) == 0 {
+			continue
+		}
+		candidate := regionCandidate{
+			Region:      r.Key,
+			Location:    locationName(r.Key),
+			Zone:        zones[0],
+			MachineType: machineType,
+			LatencyMs:   r.Latency.Milliseconds(),
+			FreeTier:    c.provider.FreeTier(r.Key, machineType),
+			latency:     r.Latency,
+		}
+		// 價格只是參考，billing API 無法使用時只依延遲與免費方案比較
+		prices, err := c.provider.MachinePrices(ctx, candidate.Zone)
+		if err != nil {
+			c.logger.Printf("Warning: failed to load machine type prices: %v", err)
+		}
+		if price, ok := prices[machineType]; ok {
+			candidate.price = &price
+			candidate.Hourly, candidate.Monthly, candidate.Currency = price.Hourly, price.Monthly(), price.Currency
+		}
+		candidates = append(candidates, candidate)
+	}
+	if len(candidates) == 0 {
+		return fmt.Errorf("no reachable regions found")
+	}
+	best := pickCandidate(candidates, opts.LatencySlack)
+	candidates[best].Recommended = true
+
+	chosen := candidates[best]
+	if !opts.Apply {
+		err := c.render(candidates, func(w io.Writer) {
+			fmt.Fprintln(w, " \tREGION\tLOCATION\tZONE\tTYPE\tLATENCY\tPRICE\tFREE TIER")
+			for _, cand := range candidates {
+				mark, price, free := "", "-", ""
+				if cand.Recommended {
+					mark = "*"
+				}
+				if cand.price != nil {
+					price = cand.price.String()
+				}
+				if cand.FreeTier {
+					free = tr("yes")
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%v\t%s\t%s\n", mark, cand.Region, cand.Location, cand.Zone, cand.MachineType, cand.latency.Round(time.Millisecond), price, free)
+			}
+		})
+		if err == nil && !c.machineOutput() {
+			fmt.Printf(tr("\nRecommended: %s (%s), zone %s, %s\nRun with --apply to create it.\n"), chosen.Location, chosen.Region, chosen.Zone, chosen.MachineType)
+		}
+		return err
+	}
+
+	chatf(tr("Creating a proxy in %s (%s), zone %s, %s\n"), chosen.Location, chosen.Region, chosen.Zone, chosen.MachineType)
+	if err := c.preflight(); err != nil {
+		return err
+	}
+	if err := c.checkCredentials(ctx); err != nil {
+		return err
+	}
+	zones, err := c.provider.ListZones(ctx, chosen.Region)
+	if err != nil {
+		return fmt.Errorf("error listing zones: %v", err)
+	}
+	create := opts.Create
+	// 沒有指定作業系統時使用預設映像檔，不詢問預先安裝好的映像檔
+	if create.OS == "" && c.config.Defaults.OS == "" && !create.Fast {
+		create.OS = defaultOSImage
+	}
+	plan := createPlan{
+		Region:      chosen.Region,
+		Location:    chosen.Location,
+		Zone:        chosen.Zone,
+		MachineType: chosen.MachineType,
+		SSHUser:     create.SSHUser,
+		SSHKeyPath:  create.SSHKeyPath,
+		FastBoot:    create.Fast,
+		Notes:       create.Notes,
+	}
+	return c.createFromPlan(ctx, create, plan, zones)
+}
+
+// pickCandidate 在延遲不超過最快者加 slack 的 region 中，依免費方案、價格、延遲的順序選出最佳者
+// candidates 已依延遲排序
+func pickCandidate(candidates []regionCandidate, slack time.Duration) int {
+	limit := candidates[0].latency + slack
+	eligible := make([]int, 0, len(candidates))
+	for i, cand := range candidates {
+		if cand.latency <= limit {
+			eligible = append(eligible, i)
+		}
+	}
+	sort.SliceStable(eligible, func(i, j int) bool {
+		a, b := candidates[eligible[i]], candidates[eligible[j]]
+		if a.FreeTier != b.FreeTier {
+			return a.FreeTier
+		}
+		if (a.price != nil) != (b.price != nil) {
+			return a.price != nil
+		}
+		if a.price != nil && a.price.Hourly != b.price.Hourly {
+			return a.price.Hourly < b.price.Hourly
+		}
+		return a.latency < b.latency
+	})
+	return eligible[0]
+}
@@ -1,9 +1,14 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 )
 
 type ProxyRecord struct {
@@ -12,41 +17,477 @@ type ProxyRecord struct {
 	Region     string `json:"region"`
 	Zone       string `json:"zone"`
 	InstanceID string `json:"instance_id"`
-	IP         string `json:"ip"`
-	Type       string `json:"type"`
-	Location   string `json:"location"`
+	// BillingID 建立時產生並寫在 instance label 上的隨機 ID，帳單資料以此歸屬到這台 proxy，
+	// rename 與之後重複使用同一個名稱都不影響。舊紀錄沒有這個欄位
+	BillingID string `json:"billing_id,omitempty"`
+	IP        string `json:"ip"`
+	Type      string `json:"type"`
+	Location  string `json:"location"`
+	Status    string `json:"status,omitempty"`
+	// CreatedAt 建立 instance 的時間，舊紀錄與匯入的伺服器沒有這個欄位
+	CreatedAt time.Time `json:"created_at,omitempty"`
+	// ExpiresAt 由 create --ttl 設定，到期後由 reap 或 serve --reap-interval 刪除，零值表示不會到期
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+	// Protocol、MachineType 與 DiskID 在建立時記錄，舊紀錄沒有這些欄位
+	Protocol    string `json:"protocol,omitempty"`
+	MachineType string `json:"machine_type,omitempty"`
+	DiskID      string `json:"disk_id,omitempty"`
+	Port        int    `json:"port,omitempty"`
+	Method      string `json:"method,omitempty"`
+	Password    string `json:"password,omitempty"`
+	// PasswordRef 密碼不存在紀錄中時的來源：env:NAME、gcpsm:<secret> 或 vault:<path>，設定時優先於 Password
+	// 外部服務的密碼在讀取紀錄時填入 Password，寫回時清除
+	PasswordRef string            `json:"password_ref,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	// AllowedIPs 不為空時 proxy 的連接埠只開放給這些 CIDR，由 allow-ip 管理
+	AllowedIPs []string `json:"allowed_ips,omitempty"`
+	// FirewallRules 以 firewall 指令加入的規則，與 proxy 本身的連接埠一起套用到雲端防火牆與 UFW
+	FirewallRules []FirewallRule `json:"firewall_rules,omitempty"`
+	// RateLimit 每個來源每分鐘可以建立的新連線數，超過時主機丟棄封包，0 表示不限制
+	RateLimit int `json:"rate_limit,omitempty"`
+	// Project 建立時使用的雲端專案，RecordManager 只處理目前 profile 的專案的紀錄，舊紀錄為空值
+	Project    string `json:"project,omitempty"`
+	SSHUser    string `json:"ssh_user,omitempty"`
+	SSHKeyPath string `json:"ssh_key_path,omitempty"`
+	SSHPort    int    `json:"ssh_port,omitempty"` // 建立時移動 sshd 的連接埠，0 表示 22
+	// KnockPorts 不為空時 SSH 只在依序 knock 這些連接埠後開放，由 create --knock 產生
+	KnockPorts []int  `json:"knock_ports,omitempty"`
+	Image      string `json:"image,omitempty"`
+	Arch       string `json:"arch,omitempty"`
+	// 只有內部 IP 的 proxy 透過跳板機或 IAP 部署
+	PrivateOnly bool   `json:"private_only,omitempty"`
+	JumpHost    string `json:"jump_host,omitempty"`
+	IAP         bool   `json:"iap,omitempty"`
+	// Notes 留給之後的自己或同事的備註，do-not-delete 等備註會讓 delete 需要 --force
+	Notes []string `json:"notes,omitempty"`
+	// Checkpoints 已完成的部署階段，中斷後 resume 只執行剩下的步驟
+	Checkpoints []Stage `json:"checkpoints,omitempty"`
+	// Users 多使用者模式下的其他使用者，各自有 Shadowsocks 連接埠與密碼，由 user 指令管理
+	Users []ProxyUser `json:"users,omitempty"`
 }
 
+// ProxyUser 同一台 proxy 上的一個使用者，以獨立的 shadowsocks-libev 服務提供，
+// 移除使用者時只需停止這個服務，不影響其他人的連線
+type ProxyUser struct {
+	Name     string `json:"name"`
+	Port     int    `json:"port"`
+	Password string `json:"password"`
+	// PasswordRef secrets.backend 為外部服務時密碼的參照，與 ProxyRecord.PasswordRef 相同，讀取時填入 Password
+	PasswordRef string `json:"password_ref,omitempty"`
+	// ExpiresAt 到期後由 user expire 或 serve --expire-interval 從伺服器移除，零值表示不會到期
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+// Expired 回傳使用者是否已經到期
+func (u ProxyUser) Expired(at time.Time) bool {
+	return !u.ExpiresAt.IsZero() && !at.Before(u.ExpiresAt)
+}
+
+// Expired 回傳 proxy 是否已經到期
+func (r ProxyRecord) Expired(at time.Time) bool {
+	return !r.ExpiresAt.IsZero() && !at.Before(r.ExpiresAt)
+}
+
+// DeployTarget 回傳部署這台 proxy 需要的連線資訊，未記錄的欄位由 deployer 使用預設值
+func (r ProxyRecord) DeployTarget() DeployTarget {
+	target := DeployTarget{IP: r.IP, SSHUser: r.SSHUser, SSHKeyPath: r.SSHKeyPath, SSHPort: r.SSHPort, Arch: r.Arch}
+	target.Port, target.Method, target.Password = r.Endpoint()
+	target.AllowedIPs = r.AllowedIPs
+	target.Users = r.Users
+	target.KnockPorts = r.KnockPorts
+	target.FirewallRules = r.FirewallRules
+	target.RateLimit = r.RateLimit
+	if r.JumpHost != "" {
+		target.SSHProxy = "ProxyJump=" + r.JumpHost
+	}
+	return target
+}
+
+// Firewall 回傳 proxy 的防火牆 template，限制來源的 proxy 有自己的網路標記
+func (r ProxyRecord) Firewall() (FirewallTemplate, error) {
+	port, _, _ := r.Endpoint()
+	return proxyFirewall(r.ProxyProtocol(), port, r.SSHPort, userPorts(r.Users), r.KnockPorts, r.FirewallRules, r.AllowedIPs, r.InstanceID)
+}
+
+// Managed 回傳紀錄是否由 auto_proxy 在雲端建立，匯入的外部伺服器只有紀錄
+func (r ProxyRecord) Managed() bool {
+	return r.Provider != ProviderExternal
+}
+
+// ProxyProtocol 回傳 proxy 使用的協定，沒有記錄協定的舊 instance 都是 Shadowsocks
+func (r ProxyRecord) ProxyProtocol() string {
+	if r.Protocol == "" && r.Type == "instance" {
+		return ProtocolShadowsocks
+	}
+	return r.Protocol
+}
+
+// Endpoint 回傳連線參數，未記錄的欄位使用部署時的預設值
+func (r ProxyRecord) Endpoint() (port int, method, password string) {
+	port, method, password = r.Port, r.Method, r.Password
+	if name, ok := strings.CutPrefix(r.PasswordRef, "env:"); ok {
+		password = os.Getenv(name)
+	}
+	if port == 0 {
+		port = shadowsocksPort
+	}
+	if method == "" {
+		method = shadowsocksMethod
+	}
+	if password == "" {
+		password = shadowsocksPassword
+	}
+	return port, method, password
+}
+
+// ProtocolShadowsocks 目前唯一部署的協定
+const ProtocolShadowsocks = "shadowsocks"
+
+// 紀錄的生命週期狀態，只能依 statusTransitions 轉換
+// 舊紀錄沒有 status 欄位視為 active，pending 是舊版的 provisioning
+const (
+	StatusCreating     = "creating"     // 正在建立 instance
+	StatusProvisioning = "provisioning" // instance 已建立，正在部署或等待 resume
+	StatusActive       = "active"
+	StatusUnhealthy    = "unhealthy" // 部署完成但連不上 proxy 埠
+	StatusDeleting     = "deleting"  // 刪除到一半，可以再執行 delete
+	StatusFailed       = "failed"    // 建立或部署失敗
+	StatusStopped      = "stopped"   // instance 由 stop 停止，start 後恢復
+	StatusPending      = "pending"
+)
+
+// statusTransitions 每個狀態可以轉換到的狀態
+var statusTransitions = map[string][]string{
+	StatusCreating:     {StatusProvisioning, StatusFailed, StatusDeleting},
+	StatusProvisioning: {StatusActive, StatusUnhealthy, StatusFailed, StatusDeleting},
+	StatusActive:       {StatusUnhealthy, StatusStopped, StatusDeleting},
+	StatusUnhealthy:    {StatusActive, StatusStopped, StatusDeleting},
+	StatusStopped:      {StatusActive, StatusUnhealthy, StatusDeleting},
+	StatusFailed:       {StatusProvisioning, StatusDeleting},
+	StatusDeleting:     {StatusFailed},
+}
+
+func canTransition(from, to string) bool {
+	return from == to || slices.Contains(statusTransitions[from], to)
+}
+
+// Lifecycle 回傳紀錄的狀態，舊紀錄的空白與 pending 換成對應的狀態
+func (r ProxyRecord) Lifecycle() string {
+	switch r.Status {
+	case "":
+		return StatusActive
+	case StatusPending:
+		return StatusProvisioning
+	}
+	return r.Status
+}
+
+// Ready 已經部署完成，可以連線、匯出與分享
+func (r ProxyRecord) Ready() bool {
+	status := r.Lifecycle()
+	return status == StatusActive || status == StatusUnhealthy
+}
+
+// ProviderExternal 從其他管理工具匯入、不是由 auto_proxy 建立的伺服器
+const ProviderExternal = "external"
+
+// RecordManager 的紀錄可能同時被 CLI 與 serve 常駐程序修改，
+// 所有「讀取-修改-寫入」都應該透過 Add、Remove、UpdateRecord 或 Update 在鎖內完成
+// 同一個程序內的 goroutine 以 mu 互斥，不同程序之間以 storage 的檔案鎖互斥
 type RecordManager struct {
-	filePath string
+	mu      sync.Mutex
+	storage RecordStorage
+	secrets *SecretBox
+	// refs 讀取存在外部服務的密碼，nil 時遇到外部參照的紀錄會回傳錯誤
+	refs *secretResolver
+	// dryRun 時修改只保留在記憶體中，不寫回紀錄檔
+	dryRun  bool
+	pending []ProxyRecord
+	// scope 不為 nil 時 Load 與 Update 只看得到符合的紀錄，其他專案的紀錄原樣保留，避免跨專案刪除
+	scope func(ProxyRecord) bool
+}
+
+// projectScope 只包含 project 的紀錄，legacy 時也包含還沒有記錄專案的舊紀錄
+func projectScope(project string, legacy bool) func(ProxyRecord) bool {
+	return func(r ProxyRecord) bool {
+		return r.Project == project || (r.Project == "" && legacy)
+	}
 }
 
-func NewRecordManager(filePath string) *RecordManager {
-	return &RecordManager{filePath: filePath}
+func NewRecordManager(storage RecordStorage, secrets *SecretBox) *RecordManager {
+	return &RecordManager{storage: storage, secrets: secrets}
 }
 
+// Load 讀取目前專案的紀錄
 func (r *RecordManager) Load() ([]ProxyRecord, error) {
-	data, err := os.ReadFile(r.filePath)
-	if os.IsNotExist(err) {
-		return []ProxyRecord{}, nil
+	records, err := r.LoadAll()
+	if err != nil || r.scope == nil {
+		return records, err
 	}
+	return slices.DeleteFunc(records, func(rec ProxyRecord) bool { return !r.scope(rec) }), nil
+}
+
+// LoadAll 讀取所有專案的紀錄
+func (r *RecordManager) LoadAll() ([]ProxyRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.loadAll()
+}
+
+func (r *RecordManager) loadAll() ([]ProxyRecord, error) {
+	if r.pending != nil {
+		return append([]ProxyRecord(nil), r.pending...), nil
+	}
+	records, err := r.storage.Read()
 	if err != nil {
-		return nil, fmt.Errorf("failed to read records: %w", err)
+		return nil, err
 	}
-	var records []ProxyRecord
-	if err := json.Unmarshal(data, &records); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal records: %w", err)
+	for i := range records {
+		if records[i].Password, err = r.secrets.Open(records[i].Password); err != nil {
+			return nil, fmt.Errorf("failed to decrypt record %s: %w", records[i].Name, err)
+		}
+		for j := range records[i].Users {
+			user := &records[i].Users[j]
+			if user.Password, err = r.secrets.Open(user.Password); err != nil {
+				return nil, fmt.Errorf("failed to decrypt user %s of record %s: %w", user.Name, records[i].Name, err)
+			}
+			if !externalRef(user.PasswordRef) {
+				continue
+			}
+			if r.refs == nil {
+				return nil, fmt.Errorf("user %s of record %s keeps its password in %s, which cannot be read here", user.Name, records[i].Name, user.PasswordRef)
+			}
+			if user.Password, err = r.refs.Resolve(context.Background(), user.PasswordRef); err != nil {
+				return nil, fmt.Errorf("failed to read the password of user %s of record %s: %w", user.Name, records[i].Name, err)
+			}
+		}
+		// 外部服務的密碼只在記憶體中，Save 時不會寫回紀錄
+		if ref := records[i].PasswordRef; externalRef(ref) {
+			if r.refs == nil {
+				return nil, fmt.Errorf("record %s keeps its password in %s, which cannot be read here", records[i].Name, ref)
+			}
+			if records[i].Password, err = r.refs.Resolve(context.Background(), ref); err != nil {
+				return nil, fmt.Errorf("failed to read the password of record %s: %w", records[i].Name, err)
+			}
+		}
+		registerSecret(records[i].Password)
+		for _, user := range records[i].Users {
+			registerSecret(user.Password)
+		}
 	}
 	return records, nil
 }
 
 func (r *RecordManager) Save(records []ProxyRecord) error {
-	data, err := json.MarshalIndent(records, "", "  ")
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.save(records)
+}
+
+func (r *RecordManager) save(records []ProxyRecord) error {
+	if r.dryRun {
+		r.pending = append([]ProxyRecord{}, records...)
+		return nil
+	}
+	// 加密寫入的副本，呼叫端拿到的紀錄維持明文
+	sealed := append([]ProxyRecord(nil), records...)
+	for i := range sealed {
+		if len(sealed[i].Users) > 0 {
+			sealed[i].Users = slices.Clone(sealed[i].Users)
+			for j := range sealed[i].Users {
+				user := &sealed[i].Users[j]
+				if externalRef(user.PasswordRef) {
+					user.Password = ""
+					continue
+				}
+				password, err := r.secrets.Seal(user.Password)
+				if err != nil {
+					return fmt.Errorf("failed to encrypt user %s of record %s: %w", user.Name, sealed[i].Name, err)
+				}
+				user.Password = password
+			}
+		}
+		if externalRef(sealed[i].PasswordRef) {
+			sealed[i].Password = ""
+			continue
+		}
+		password, err := r.secrets.Seal(sealed[i].Password)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt record %s: %w", sealed[i].Name, err)
+		}
+		sealed[i].Password = password
+	}
+	return r.storage.Write(sealed)
+}
+
+// maxConflictRetries 遠端儲存被其他機器修改時，Update 重新讀取並套用修改的次數上限
+const maxConflictRetries = 5
+
+// ErrRecordNotFound 目前專案沒有這個名稱的 proxy 紀錄
+var ErrRecordNotFound = errors.New("proxy not found")
+
+// ErrRecordExists 目前專案已經有同名的紀錄
+var ErrRecordExists = errors.New("record already exists")
+
+// FindByName 回傳目前專案中名稱為 name 的 proxy 紀錄
+func (r *RecordManager) FindByName(name string) (ProxyRecord, bool, error) {
+	records, err := r.Load()
+	if err != nil {
+		return ProxyRecord{}, false, err
+	}
+	for _, record := range records {
+		if record.Name == name && record.Type == "instance" {
+			return record, true, nil
+		}
+	}
+	return ProxyRecord{}, false, nil
+}
+
+// Add 新增一筆紀錄，同名同種類的紀錄已經存在時回傳 ErrRecordExists
+func (r *RecordManager) Add(record ProxyRecord) error {
+	return r.Update(func(records []ProxyRecord) ([]ProxyRecord, error) {
+		for _, rec := range records {
+			if rec.Name == record.Name && rec.Type == record.Type {
+				return nil, fmt.Errorf("%s %s: %w", record.Type, record.Name, ErrRecordExists)
+			}
+		}
+		return append(records, record), nil
+	})
+}
+
+// Remove 移除名稱為 name 的 proxy 紀錄，找不到時回傳 ErrRecordNotFound
+func (r *RecordManager) Remove(name string) error {
+	return r.Update(func(records []ProxyRecord) ([]ProxyRecord, error) {
+		for i, rec := range records {
+			if rec.Name == name && rec.Type == "instance" {
+				return append(records[:i], records[i+1:]...), nil
+			}
+		}
+		return nil, fmt.Errorf("%w: %s", ErrRecordNotFound, name)
+	})
+}
+
+// UpdateRecord 在鎖內以 fn 修改名稱為 name 的 proxy 紀錄，fn 回傳錯誤時不寫回，回傳修改後的紀錄
+func (r *RecordManager) UpdateRecord(name string, fn func(*ProxyRecord) error) (ProxyRecord, error) {
+	var record ProxyRecord
+	err := r.Update(func(records []ProxyRecord) ([]ProxyRecord, error) {
+		for i := range records {
+			if records[i].Name != name || records[i].Type != "instance" {
+				continue
+			}
+			if err := fn(&records[i]); err != nil {
+				return nil, err
+			}
+			record = records[i]
+			return records, nil
+		}
+		return nil, fmt.Errorf("%w: %s", ErrRecordNotFound, name)
+	})
+	return record, err
+}
+
+// SetStatus 依 statusTransitions 修改 proxy 的狀態，update 不為 nil 時一併修改其他欄位，回傳修改後的紀錄
+func (r *RecordManager) SetStatus(name, status string, update func(*ProxyRecord)) (ProxyRecord, error) {
+	return r.UpdateRecord(name, func(record *ProxyRecord) error {
+		if from := record.Lifecycle(); !canTransition(from, status) {
+			return fmt.Errorf("proxy %s cannot change from %s to %s", name, from, status)
+		}
+		record.Status = status
+		if update != nil {
+			update(record)
+		}
+		return nil
+	})
+}
+
+// Update 在持有鎖的情況下讀取紀錄、交給 fn 修改後寫回
+// 遠端儲存 (gcs) 只能以樂觀鎖偵測其他機器的修改，衝突時以最新的紀錄重新呼叫 fn
+func (r *RecordManager) Update(fn func([]ProxyRecord) ([]ProxyRecord, error)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	unlock, err := r.storage.Lock()
+	if err != nil {
+		return fmt.Errorf("failed to lock records: %w", err)
+	}
+	defer unlock()
+
+	for attempt := 1; ; attempt++ {
+		all, err := r.loadAll()
+		if err != nil {
+			return err
+		}
+		// fn 只拿到目前專案的紀錄，寫回時接上其他專案的紀錄
+		records := make([]ProxyRecord, 0, len(all))
+		var others []ProxyRecord
+		for _, rec := range all {
+			if r.scope == nil || r.scope(rec) {
+				records = append(records, rec)
+			} else {
+				others = append(others, rec)
+			}
+		}
+		records, err = fn(records)
+		if err != nil {
+			return err
+		}
+		err = r.save(append(records, others...))
+		if errors.Is(err, ErrStorageConflict) && attempt < maxConflictRetries {
+			debugf("records changed concurrently, retrying update (attempt %d)", attempt+1)
+			continue
+		}
+		return err
+	}
+}
+
+// History 回傳紀錄的變更歷史，不含密碼，只有保留歷史的儲存方式 (sqlite) 支援
+func (r *RecordManager) History(name string) ([]RecordChange, error) {
+	h, ok := r.storage.(historyStorage)
+	if !ok {
+		return nil, fmt.Errorf("record history needs the sqlite storage, run `auto_proxy config set storage sqlite` to switch")
+	}
+	changes, err := h.History(name)
+	if err != nil {
+		return nil, err
+	}
+	for i := range changes {
+		changes[i].Record.Password = ""
+		for j := range changes[i].Record.Users {
+			changes[i].Record.Users[j].Password = ""
+		}
+	}
+	return changes, nil
+}
+
+// Rekey 在鎖內以目前的金鑰解密所有紀錄，再用 secrets 重新加密寫回，回傳重新加密的欄位數
+func (r *RecordManager) Rekey(secrets *SecretBox) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	unlock, err := r.storage.Lock()
 	if err != nil {
-		return fmt.Errorf("failed to marshal records: %w", err)
+		return 0, fmt.Errorf("failed to lock records: %w", err)
+	}
+	defer unlock()
+
+	records, err := r.loadAll()
+	if err != nil {
+		return 0, err
+	}
+	previous := r.secrets
+	r.secrets = secrets
+	if err := r.save(records); err != nil {
+		r.secrets = previous
+		return 0, err
 	}
-	if err := os.WriteFile(r.filePath, data, 0644); err != nil {
-		return fmt.Errorf("failed to write records: %w", err)
+	count := 0
+	for _, record := range records {
+		if record.Password != "" && !externalRef(record.PasswordRef) {
+			count++
+		}
+		for _, user := range record.Users {
+			if !externalRef(user.PasswordRef) {
+				count++
+			}
+		}
 	}
-	return nil
+	return count, nil
 }
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// redactedText 取代記錄檔與 Ansible 輸出中機密的文字
+const redactedText = "[REDACTED]"
+
+// minSecretLength 太短的值可能出現在一般文字中，不列入遮蔽
+const minSecretLength = 6
+
+// knownSecrets 程式執行期間讀取或產生的密碼、金鑰與 token，寫入記錄前從文字中遮蔽
+var knownSecrets struct {
+	sync.RWMutex
+	values []string
+}
+
+// registerSecret 記錄之後要從記錄檔與輸出中遮蔽的值
+func registerSecret(values ...string) {
+	knownSecrets.Lock()
+	defer knownSecrets.Unlock()
+	for _, value := range values {
+		if len(value) < minSecretLength || slices.Contains(knownSecrets.values, value) {
+			continue
+		}
+		knownSecrets.values = append(knownSecrets.values, value)
+	}
+	// 較長的值先取代，包含另一個機密的值才不會只被遮蔽一部分
+	slices.SortFunc(knownSecrets.values, func(a, b string) int { return len(b) - len(a) })
+}
+
+// redact 把已記錄的機密換成 redactedText
+func redact(s string) string {
+	knownSecrets.RLock()
+	defer knownSecrets.RUnlock()
+	for _, value := range knownSecrets.values {
+		s = strings.ReplaceAll(s, value, redactedText)
+	}
+	return s
+}
+
+// redactWriter 寫入前遮蔽機密，log.Logger 每次寫入一整行，機密不會被拆到兩次寫入
+type redactWriter struct {
+	w io.Writer
+}
+
+func (r redactWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.w, redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+)
+
+// nameLabel instance 上記錄 proxy 名稱的 label，instance 本身的名稱建立後無法修改
+const nameLabel = "auto-proxy-name"
+
+// billingLabel instance 上記錄 ProxyRecord.BillingID 的 label，cost 以此加總帳單資料
+const billingLabel = "auto-proxy-id"
+
+// proxyNamePattern 與 GCP 的資源名稱及 label 值相同的限制
+var proxyNamePattern = regexp.MustCompile(`^[a-z]([-a-z0-9]{0,61}[a-z0-9])?$`)
+
+// validateProxyName 檢查名稱是否符合 GCP 的命名規則
+func validateProxyName(name string) error {
+	if !proxyNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid name %q: use at most 63 lowercase letters, digits and hyphens, starting with a letter and not ending with a hyphen", name)
+	}
+	return nil
+}
+
+// Rename 修改 proxy 的名稱，instance 名稱不變，由 provider 在 label 上記錄新名稱
+func (c *Commander) Rename(ctx context.Context, oldName, newName string) error {
+	if err := validateProxyName(newName); err != nil {
+		return err
+	}
+	var record ProxyRecord
+	err := c.recordManager.Update(func(records []ProxyRecord) ([]ProxyRecord, error) {
+		index := -1
+		for i, r := range records {
+			if r.Type != "instance" {
+				continue
+			}
+			if r.Name == newName {
+				return nil, fmt.Errorf("proxy %s already exists", newName)
+			}
+			if r.Name == oldName {
+				index = i
+			}
+		}
+		if index < 0 {
+			return nil, fmt.Errorf("proxy not found: %s", oldName)
+		}
+		records[index].Name = newName
+		record = records[index]
+		return records, nil
+	})
+	if err != nil {
+		return err
+	}
+	if c.dryRun {
+		dryRunf("Would rename %s to %s in invite scopes\n", oldName, newName)
+	} else if err := c.invites.RenameScope(oldName, newName); err != nil {
+		c.logger.Printf("Error renaming %s in invite scopes: %v", oldName, err)
+	}
+
+	// label 只是方便在主控台辨識，更新失敗不影響本機的紀錄
+	if record.Managed() {
+		if err := c.provider.SetInstanceLabels(ctx, record.Zone, record.InstanceID, map[string]string{nameLabel: newName}); err != nil {
+			c.logger.Printf("Error labeling instance %s: %v", record.InstanceID, err)
+			fmt.Printf(tr("Warning: failed to update the instance label: %v\n"), err)
+		}
+	}
+	fmt.Printf(tr("Proxy %s renamed to %s.\n"), oldName, newName)
+	return nil
+}
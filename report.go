@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// Stage 部署流程中的階段
+type Stage string
+
+const (
+	StageCreateInstance Stage = "create_instance"
+	StageWaitSSH        Stage = "wait_ssh"
+	StageProvision      Stage = "provision"
+	StageVerify         Stage = "verify"
+)
+
+// 事件狀態
+const (
+	EventStarted   = "started"
+	EventProgress  = "progress"
+	EventSucceeded = "succeeded"
+	EventFailed    = "failed"
+)
+
+// StageEvent 部署進度事件，JSON 格式提供給外部包裝程式使用
+type StageEvent struct {
+	Time    time.Time `json:"time"`
+	Proxy   string    `json:"proxy,omitempty"`
+	IP      string    `json:"ip,omitempty"`
+	Stage   Stage     `json:"stage"`
+	Status  string    `json:"status"`
+	Message string    `json:"message,omitempty"`
+}
+
+// Reporter 接收部署進度事件
+type Reporter interface {
+	Report(event StageEvent)
+}
+
+// NewReporter 依 format 建立 reporter，"json" 輸出每行一個事件，其他則輸出給人看的文字
+func NewReporter(format string, w io.Writer) Reporter {
+	if format == "json" {
+		return &JSONReporter{encoder: json.NewEncoder(w)}
+	}
+	return &TextReporter{w: w}
+}
+
+type TextReporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (r *TextReporter) Report(e StageEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	prefix := e.Proxy
+	if prefix == "" {
+		prefix = e.IP
+	}
+	switch e.Status {
+	case EventStarted:
+		fmt.Fprintf(r.w, "[%s] %s: started\n", prefix, e.Stage)
+	case EventProgress:
+		fmt.Fprintf(r.w, "[%s] %s\n", prefix, e.Message)
+	case EventSucceeded:
+		fmt.Fprintf(r.w, "[%s] %s: done\n", prefix, e.Stage)
+	case EventFailed:
+		fmt.Fprintf(r.w, "[%s] %s: failed: %s\n", prefix, e.Stage, e.Message)
+	}
+}
+
+type JSONReporter struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+func (r *JSONReporter) Report(e StageEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.encoder.Encode(e)
+}
+
+// report 建立事件並送給 reporter
+func report(r Reporter, proxy, ip string, stage Stage, status, message string) {
+	r.Report(StageEvent{Time: now(), Proxy: proxy, IP: ip, Stage: stage, Status: status, Message: message})
+}
+
+// CheckpointReporter 將成功完成的階段記錄到 proxy 紀錄中，作為斷線後續傳的檢查點
+type CheckpointReporter struct {
+	Reporter
+	recordManager *RecordManager
+	logger        *log.Logger
+}
+
+func NewCheckpointReporter(next Reporter, recordManager *RecordManager, logger *log.Logger) *CheckpointReporter {
+	return &CheckpointReporter{Reporter: next, recordManager: recordManager, logger: logger}
+}
+
+func (r *CheckpointReporter) Report(e StageEvent) {
+	r.Reporter.Report(e)
+	if e.Status != EventSucceeded {
+		return
+	}
+	err := r.recordManager.Update(func(records []ProxyRecord) ([]ProxyRecord, error) {
+		for i, rec := range records {
+			if rec.Type != "instance" || (rec.Name != e.Proxy && (e.IP == "" || rec.IP != e.IP)) {
+				continue
+			}
+			for _, s := range rec.Checkpoints {
+				if s == e.Stage {
+					return records, nil
+				}
+			}
+			records[i].Checkpoints = append(records[i].Checkpoints, e.Stage)
+			break
+		}
+		return records, nil
+	})
+	if err != nil {
+		r.logger.Printf("Error saving checkpoint %s for %s: %v", e.Stage, e.Proxy+e.IP, err)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Rollout 將目前的部署設定重新套用到所有 proxy
+// 先部署 canary 台並驗證，通過後再處理其餘的，任何一台失敗就停止
+func (c *Commander) Rollout(ctx context.Context, canary int) error {
+	if err := c.preflight(); err != nil {
+		return err
+	}
+	records, err := c.recordManager.Load()
+	if err != nil {
+		return fmt.Errorf("error loading records: %v", err)
+	}
+	var targets []ProxyRecord
+	for _, r := range records {
+		if r.Type == "instance" && r.Managed() && r.Ready() {
+			targets = append(targets, r)
+		}
+	}
+	if len(targets) == 0 {
+		fmt.Println(tr("No proxies found."))
+		return nil
+	}
+	if canary < 0 || canary > len(targets) {
+		canary = len(targets)
+	}
+
+	fmt.Printf(tr("Rolling out to %d canary proxies...\n"), canary)
+	if err := c.rolloutBatch(ctx, targets[:canary]); err != nil {
+		return fmt.Errorf("canary failed, rollout halted: %v", err)
+	}
+	if canary == len(targets) {
+		fmt.Println(tr("Rollout completed."))
+		return nil
+	}
+
+	fmt.Printf(tr("Canary passed, rolling out to remaining %d proxies...\n"), len(targets)-canary)
+	if err := c.rolloutBatch(ctx, targets[canary:]); err != nil {
+		return fmt.Errorf("rollout halted: %v", err)
+	}
+	fmt.Println(tr("Rollout completed."))
+	return nil
+}
+
+func (c *Commander) rolloutBatch(ctx context.Context, records []ProxyRecord) error {
+	for _, r := range records {
+		fmt.Printf(tr("Deploying to %s (%s)...\n"), r.Name, r.IP)
+		target, err := c.deployTarget(r)
+		if err != nil {
+			return fmt.Errorf("deploy %s: %v", r.Name, err)
+		}
+		if err := c.deployer.Deploy(target); err != nil {
+			c.logger.Printf("Error deploying proxy %s: %v", r.Name, err)
+			c.setStatus(r.Name, StatusUnhealthy)
+			return fmt.Errorf("deploy %s: %v", r.Name, err)
+		}
+		if r.PrivateOnly {
+			fmt.Printf(tr("Proxy %s has no public IP, skipping health check.\n"), r.Name)
+			continue
+		}
+		if err := c.checkHealth(ctx, r); err != nil {
+			c.logger.Printf("Health check failed for %s: %v", r.Name, err)
+			c.setStatus(r.Name, StatusUnhealthy)
+			return fmt.Errorf("health check %s: %v", r.Name, err)
+		}
+		c.setStatus(r.Name, StatusActive)
+		fmt.Printf(tr("Proxy %s is healthy.\n"), r.Name)
+	}
+	return nil
+}
+
+// checkHealth 確認 proxy 可以連線，dry run 時沒有實際部署所以略過
+func (c *Commander) checkHealth(ctx context.Context, record ProxyRecord) error {
+	if c.dryRun {
+		return nil
+	}
+	return checkProxyHealth(ctx, record)
+}
+
+// checkProxyHealth 確認 proxy 的服務埠可以連線
+func checkProxyHealth(ctx context.Context, record ProxyRecord) error {
+	port, _, _ := record.Endpoint()
+	var lastErr error
+	for i := 0; i < 5; i++ {
+		dialer := net.Dialer{Timeout: 3 * time.Second}
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(record.IP, strconv.Itoa(port)))
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		lastErr = err
+		time.Sleep(2 * time.Second)
+	}
+	return lastErr
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// Rotate 為 proxy 產生新的密碼並部署到伺服器，完成後印出新的客戶端設定
+// 新密碼先寫入紀錄，部署失敗時伺服器可能仍使用舊密碼，之後以 rollout 重新套用
+func (c *Commander) Rotate(ctx context.Context, name string) error {
+	record, found, err := c.recordManager.FindByName(name)
+	if err != nil {
+		return fmt.Errorf("error loading records: %v", err)
+	}
+	if !found {
+		return fmt.Errorf("proxy not found: %s", name)
+	}
+	if !record.Managed() {
+		return fmt.Errorf("proxy %s was imported, rotate its credentials with the tool that manages it", name)
+	}
+	if !record.Ready() {
+		return fmt.Errorf("proxy %s is not deployed yet, run `auto_proxy resume --name %s` first", name, name)
+	}
+	if protocol := record.ProxyProtocol(); protocol != ProtocolShadowsocks {
+		return fmt.Errorf("rotating credentials of %s proxies is not supported", protocol)
+	}
+	if record.PasswordRef != "" && !externalRef(record.PasswordRef) {
+		return fmt.Errorf("the password of proxy %s comes from %s, change it there and run `auto_proxy rollout`", name, record.PasswordRef)
+	}
+	if err := c.preflight(); err != nil {
+		return err
+	}
+
+	password, err := generateProxyPassword()
+	if err != nil {
+		return err
+	}
+	// 存在外部服務的密碼新增一個版本，紀錄中的參照不變
+	if externalRef(record.PasswordRef) && c.dryRun {
+		dryRunf("Would store the new password in %s\n", record.PasswordRef)
+	} else if externalRef(record.PasswordRef) {
+		if err := c.recordManager.refs.Replace(ctx, record.PasswordRef, password); err != nil {
+			return fmt.Errorf("error storing the new password: %v", err)
+		}
+	}
+	record, err = c.recordManager.UpdateRecord(name, func(r *ProxyRecord) error {
+		r.Password = password
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error saving records: %v", err)
+	}
+	c.logger.Printf("Rotating credentials of proxy %s", name)
+
+	fmt.Printf(tr("Deploying new credentials to %s (%s)...\n"), name, record.IP)
+	target, err := c.deployTarget(record)
+	if err != nil {
+		return err
+	}
+	if err := c.deployer.Deploy(target); err != nil {
+		c.logger.Printf("Error deploying new credentials to %s: %v", name, err)
+		c.setStatus(name, StatusUnhealthy)
+		return fmt.Errorf("error deploying new credentials, run `auto_proxy rollout` to retry: %v", err)
+	}
+	healthy := true
+	if !record.PrivateOnly {
+		if err := c.checkHealth(ctx, record); err != nil {
+			c.logger.Printf("Health check failed for %s: %v", name, err)
+			healthy = false
+		}
+	}
+	if healthy {
+		c.setStatus(name, StatusActive)
+	} else {
+		c.setStatus(name, StatusUnhealthy)
+		fmt.Printf(tr("Warning: proxy %s did not pass the health check after rotating credentials.\n"), name)
+	}
+
+	fmt.Printf(tr("Credentials of proxy %s rotated, update your clients:\n\n"), name)
+	return c.Connect(name, false)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ruleCommentPattern 說明會放進 playbook 的 task 名稱，只允許不需要跳脫的字元
+var ruleCommentPattern = regexp.MustCompile(`^[A-Za-z0-9 ._-]{0,40}$`)
+
+// parsePortSpec 解析 port 或 port/protocol，沒有指定協定時為 tcp
+func parsePortSpec(s string) (int, string, error) {
+	portText, protocol, found := strings.Cut(s, "/")
+	if !found {
+		protocol = "tcp"
+	}
+	protocol = strings.ToLower(protocol)
+	if protocol != "tcp" && protocol != "udp" {
+		return 0, "", fmt.Errorf("invalid protocol %q: use tcp or udp", protocol)
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil || port <= 0 || port > 65535 {
+		return 0, "", fmt.Errorf("invalid port: %s", portText)
+	}
+	return port, protocol, nil
+}
+
+// firewallRuleView 對外輸出的規則，Custom 表示由 firewall 指令加入
+type firewallRuleView struct {
+	FirewallRule `yaml:",inline"`
+	Custom       bool `json:"custom" yaml:"custom"`
+}
+
+// FirewallRules 列出 proxy 在雲端防火牆與 UFW 開放的所有規則
+func (c *Commander) FirewallRules(name string) error {
+	record, err := c.firewallProxy(name)
+	if err != nil {
+		return err
+	}
+	template, err := record.Firewall()
+	if err != nil {
+		return err
+	}
+	views := make([]firewallRuleView, len(template.Rules))
+	custom := len(template.Rules) - len(template.CustomRules)
+	for i, rule := range template.Rules {
+		views[i] = firewallRuleView{FirewallRule: rule, Custom: i >= custom}
+	}
+	return c.render(views, func(w io.Writer) {
+		fmt.Fprintln(w, "PORT\tPROTOCOL\tACTION\tSOURCES\tCOMMENT\tCUSTOM")
+		for _, v := range views {
+			sources := "any"
+			if len(v.Sources) > 0 {
+				sources = strings.Join(v.Sources, ",")
+			}
+			flag := ""
+			if v.Custom {
+				flag = "yes"
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", v.Port, v.Protocol, v.action(), sources, v.Comment, flag)
+		}
+	})
+}
+
+// AddFirewallRule 在 proxy 上開放一個自訂的連接埠，套用到雲端防火牆與主機後寫入紀錄
+func (c *Commander) AddFirewallRule(ctx context.Context, name string, rule FirewallRule) error {
+	if !ruleCommentPattern.MatchString(rule.Comment) {
+		return fmt.Errorf("invalid comment %q: use up to 40 letters, digits, spaces, dots, underscores and hyphens", rule.Comment)
+	}
+	if rule.Comment == "" {
+		rule.Comment = "Custom"
+	}
+	for i, raw := range rule.Sources {
+		source, err := parseSource(raw)
+		if err != nil {
+			return err
+		}
+		rule.Sources[i] = source
+	}
+	previous, err := c.firewallProxy(name)
+	if err != nil {
+		return err
+	}
+	template, err := previous.Firewall()
+	if err != nil {
+		return err
+	}
+	for _, existing := range template.Rules {
+		if existing.Port != rule.Port || existing.Protocol != rule.Protocol {
+			continue
+		}
+		if slices.ContainsFunc(previous.FirewallRules, func(r FirewallRule) bool { return r.Port == rule.Port && r.Protocol == rule.Protocol }) {
+			return fmt.Errorf("proxy %s already has a custom rule for %d/%s, remove it first", name, rule.Port, rule.Protocol)
+		}
+		return fmt.Errorf("port %d/%s is already used by proxy %s (%s)", rule.Port, rule.Protocol, name, existing.Comment)
+	}
+	record := previous
+	record.FirewallRules = append(slices.Clone(previous.FirewallRules), rule)
+	if err := c.applyFirewall(ctx, previous, record); err != nil {
+		return err
+	}
+	if _, err := c.recordManager.UpdateRecord(name, func(r *ProxyRecord) error {
+		r.FirewallRules = record.FirewallRules
+		return nil
+	}); err != nil {
+		return fmt.Errorf("error saving records: %v", err)
+	}
+	c.logger.Printf("Firewall rule %d/%s added to proxy %s", rule.Port, rule.Protocol, name)
+	fmt.Printf(tr("Opened %d/%s on proxy %s.\n"), rule.Port, rule.Protocol, name)
+	return nil
+}
+
+// RemoveFirewallRule 關閉以 firewall add 開放的連接埠，proxy 本身的連接埠不能移除
+func (c *Commander) RemoveFirewallRule(ctx context.Context, name string, port int, protocol string) error {
+	previous, err := c.firewallProxy(name)
+	if err != nil {
+		return err
+	}
+	record := previous
+	record.FirewallRules = slices.DeleteFunc(slices.Clone(previous.FirewallRules), func(r FirewallRule) bool { return r.Port == port && r.Protocol == protocol })
+	if len(record.FirewallRules) == len(previous.FirewallRules) {
+		return fmt.Errorf("proxy %s has no custom rule for %d/%s", name, port, protocol)
+	}
+	if err := c.applyFirewall(ctx, previous, record); err != nil {
+		return err
+	}
+	if _, err := c.recordManager.UpdateRecord(name, func(r *ProxyRecord) error {
+		r.FirewallRules = record.FirewallRules
+		return nil
+	}); err != nil {
+		return fmt.Errorf("error saving records: %v", err)
+	}
+	c.logger.Printf("Firewall rule %d/%s removed from proxy %s", port, protocol, name)
+	fmt.Printf(tr("Closed %d/%s on proxy %s.\n"), port, protocol, name)
+	return nil
+}
+
+// firewallProxy 回傳可以修改防火牆規則的 proxy 紀錄
+func (c *Commander) firewallProxy(name string) (ProxyRecord, error) {
+	record, found, err := c.recordManager.FindByName(name)
+	if err != nil {
+		return record, fmt.Errorf("error loading records: %v", err)
+	}
+	if !found {
+		return record, fmt.Errorf("proxy not found: %s", name)
+	}
+	if !record.Ready() {
+		return record, fmt.Errorf("proxy %s is not deployed yet, run `auto_proxy resume --name %s` first", name, name)
+	}
+	return record, nil
+}
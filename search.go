@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Search 列出名稱、IP、region、地點或 tag 包含 query 的 proxy，不分大小寫
+func (c *Commander) Search(query string) error {
+	records, err := c.recordManager.Load()
+	if err != nil {
+		return fmt.Errorf("error loading records: %v", err)
+	}
+	query = strings.ToLower(strings.TrimSpace(query))
+	views := make([]proxyView, 0)
+	for _, r := range records {
+		if r.Type == "instance" && recordMatches(r, query) {
+			views = append(views, newProxyView(r))
+		}
+	}
+	if len(views) == 0 && !c.machineOutput() {
+		fmt.Printf(tr("No proxies match %q.\n"), query)
+		return nil
+	}
+	return c.renderProxies(views)
+}
+
+// recordMatches query 已轉為小寫，tag 以 key、value 或 key=value 比對
+func recordMatches(r ProxyRecord, query string) bool {
+	fields := []string{r.Name, r.IP, r.Region, r.Zone, r.Location, r.DisplayLocation()}
+	for key, value := range r.Tags {
+		fields = append(fields, key+"="+value)
+	}
+	for _, f := range fields {
+		if strings.Contains(strings.ToLower(f), query) {
+			return true
+		}
+	}
+	return false
+}
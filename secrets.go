@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/zalando/go-keyring"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+// 加密後的欄位格式為 enc:v1:<salt>:<nonce+密文>，沒有前綴的值視為舊的明文紀錄
+const secretPrefix = "enc:v1:"
+
+// SecretBox 以密碼短語衍生的金鑰加解密紀錄中的機密欄位，nil 表示不加密
+type SecretBox struct {
+	passphrase string
+	mu         sync.Mutex
+	keys       map[string]*[32]byte // 依 salt 快取衍生出的金鑰，scrypt 很慢
+	salt       []byte               // 這次執行加密時使用的 salt
+}
+
+func NewSecretBox(passphrase string) *SecretBox {
+	if passphrase == "" {
+		return nil
+	}
+	registerSecret(passphrase)
+	return &SecretBox{passphrase: passphrase, keys: make(map[string]*[32]byte)}
+}
+
+func (b *SecretBox) key(salt []byte) (*[32]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if key, ok := b.keys[string(salt)]; ok {
+		return key, nil
+	}
+	derived, err := scrypt.Key([]byte(b.passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	key := new([32]byte)
+	copy(key[:], derived)
+	b.keys[string(salt)] = key
+	return key, nil
+}
+
+func (b *SecretBox) Seal(plain string) (string, error) {
+	if b == nil || plain == "" {
+		return plain, nil
+	}
+	b.mu.Lock()
+	if b.salt == nil {
+		b.salt = make([]byte, 16)
+		if _, err := rand.Read(b.salt); err != nil {
+			b.mu.Unlock()
+			return "", fmt.Errorf("failed to generate salt: %w", err)
+		}
+	}
+	salt := b.salt
+	b.mu.Unlock()
+
+	key, err := b.key(salt)
+	if err != nil {
+		return "", err
+	}
+	var nonce [24]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := secretbox.Seal(nonce[:], []byte(plain), &nonce, key)
+	return secretPrefix + base64.RawStdEncoding.EncodeToString(salt) + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+func (b *SecretBox) Open(value string) (string, error) {
+	rest, ok := strings.CutPrefix(value, secretPrefix)
+	if !ok {
+		return value, nil
+	}
+	if b == nil {
+		return "", fmt.Errorf("records contain encrypted secrets, set AUTO_PROXY_PASSPHRASE")
+	}
+	saltText, sealedText, ok := strings.Cut(rest, ":")
+	if !ok {
+		return "", fmt.Errorf("malformed encrypted secret")
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(saltText)
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted secret: %w", err)
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(sealedText)
+	if err != nil || len(sealed) < 24 {
+		return "", fmt.Errorf("malformed encrypted secret")
+	}
+	key, err := b.key(salt)
+	if err != nil {
+		return "", err
+	}
+	var nonce [24]byte
+	copy(nonce[:], sealed[:24])
+	plain, ok := secretbox.Open(nil, sealed[24:], &nonce, key)
+	if !ok {
+		return "", fmt.Errorf("failed to decrypt secret: wrong passphrase or corrupted record")
+	}
+	return string(plain), nil
+}
+
+// secretKeyStore 保存沒有設定 AUTO_PROXY_PASSPHRASE 時加密紀錄使用的隨機金鑰
+// 金鑰存在 OS keyring，資料目錄的 secret.key 只記錄金鑰在 keyring 中；
+// 無法使用 keyring 時 (例如沒有桌面環境的伺服器) 金鑰直接存在 secret.key
+type secretKeyStore struct {
+	path    string
+	account string // keyring 中以資料目錄區分不同的金鑰
+}
+
+const (
+	keyringService = "auto_proxy"
+	keyringMarker  = "keyring"
+)
+
+func newSecretKeyStore(dataDir string) *secretKeyStore {
+	return &secretKeyStore{path: filepath.Join(dataDir, "secret.key"), account: dataDir}
+}
+
+// Load 讀取金鑰，還沒有產生過金鑰時回傳空字串
+func (s *secretKeyStore) Load() (string, error) {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret key: %w", err)
+	}
+	value := strings.TrimSpace(string(data))
+	if value != keyringMarker {
+		return value, nil
+	}
+	key, err := keyring.Get(keyringService, s.account)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the secret key from the OS keyring, set AUTO_PROXY_PASSPHRASE if records were encrypted with a passphrase: %w", err)
+	}
+	return key, nil
+}
+
+// Save 優先存到 OS keyring，keyring 無法使用時存在 secret.key
+func (s *secretKeyStore) Save(key string) error {
+	content := key
+	if err := keyring.Set(keyringService, s.account, key); err != nil {
+		debugf("OS keyring unavailable, storing the secret key in %s: %v", s.path, err)
+	} else {
+		content = keyringMarker
+	}
+	if err := writeFileAtomic(s.path, []byte(content+"\n"), 0600); err != nil {
+		return fmt.Errorf("failed to save secret key: %w", err)
+	}
+	return nil
+}
+
+// Location 回傳金鑰實際存放的位置，顯示給使用者
+func (s *secretKeyStore) Location() string {
+	data, err := os.ReadFile(s.path)
+	if err == nil && strings.TrimSpace(string(data)) == keyringMarker {
+		return "OS keyring"
+	}
+	return s.path
+}
+
+// generateProxyPassword 產生 proxy 的隨機密碼，只使用 URL 安全的字元，分享連結與設定檔都不需要跳脫
+func generateProxyPassword() (string, error) {
+	key := make([]byte, 18)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("failed to generate password: %w", err)
+	}
+	password := base64.RawURLEncoding.EncodeToString(key)
+	registerSecret(password)
+	return password, nil
+}
+
+// generateSecretKey 產生隨機金鑰，以 SecretBox 的密碼短語使用
+func generateSecretKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("failed to generate secret key: %w", err)
+	}
+	return base64.RawStdEncoding.EncodeToString(key), nil
+}
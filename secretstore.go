@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	secretmanager "google.golang.org/api/secretmanager/v1"
+)
+
+// 新建 proxy 的密碼存放位置，records 表示存在紀錄中 (以 SecretBox 加密)
+const (
+	SecretsRecords       = "records"
+	SecretsKeyring       = "keyring"
+	SecretsSecretManager = "gcp-secret-manager"
+	SecretsVault         = "vault"
+)
+
+// PasswordRef 的前綴，決定由哪個 SecretStore 讀取
+const (
+	secretManagerRefPrefix = "gcpsm:"
+	vaultRefPrefix         = "vault:"
+)
+
+// secretTimeout 單次存取外部密碼服務的時間上限
+const secretTimeout = 30 * time.Second
+
+// SecretStore 存放 proxy 密碼的外部服務，紀錄中只保留 Put 回傳的參照
+type SecretStore interface {
+	// Put 以 secretName 的名稱存入密碼，已經存在時新增一個版本，回傳的參照不變
+	Put(ctx context.Context, name, value string) (ref string, err error)
+	Get(ctx context.Context, ref string) (string, error)
+	Delete(ctx context.Context, ref string) error
+}
+
+// externalRef 回傳 PasswordRef 是否指向外部的 SecretStore，env: 由 Endpoint 直接讀取
+func externalRef(ref string) bool {
+	return refBackend(ref) != ""
+}
+
+// refBackend 回傳讀取參照的 backend，不是外部參照時回傳空字串
+func refBackend(ref string) string {
+	switch {
+	case strings.HasPrefix(ref, keyringRefPrefix):
+		return SecretsKeyring
+	case strings.HasPrefix(ref, secretManagerRefPrefix):
+		return SecretsSecretManager
+	case strings.HasPrefix(ref, vaultRefPrefix):
+		return SecretsVault
+	}
+	return ""
+}
+
+// secretName 外部服務中 proxy 密碼的名稱，以 instance ID 命名，rename 之後名稱不變，
+// 之後以舊名稱建立的 proxy 也不會寫到同一個 secret
+func secretName(instanceID string) string {
+	return "auto-proxy-" + instanceID
+}
+
+// refSecretName 回傳參照中的 secret 名稱，三種參照的最後一段都是 Put 時的名稱
+func refSecretName(ref string) string {
+	return ref[strings.LastIndex(ref, "/")+1:]
+}
+
+// secretResolver 依設定建立 SecretStore 並讀取紀錄中的參照，同一次執行內快取讀到的密碼
+// 參照依前綴決定服務，修改 secrets.backend 後既有的紀錄仍然可以讀取
+type secretResolver struct {
+	cfg *Config
+
+	mu     sync.Mutex
+	stores map[string]SecretStore
+	cache  map[string]string
+}
+
+func newSecretResolver(cfg *Config) (*secretResolver, error) {
+	switch cfg.Secrets.Backend {
+	case "", SecretsRecords, SecretsKeyring, SecretsSecretManager, SecretsVault:
+	default:
+		return nil, fmt.Errorf("unsupported secrets.backend: %s (supported: %s, %s, %s, %s)", cfg.Secrets.Backend, SecretsRecords, SecretsKeyring, SecretsSecretManager, SecretsVault)
+	}
+	return &secretResolver{cfg: cfg, stores: make(map[string]SecretStore), cache: make(map[string]string)}, nil
+}
+
+// store 回傳處理 backend 的 SecretStore，第一次使用時才建立連線
+func (s *secretResolver) store(backend string) (SecretStore, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if store, ok := s.stores[backend]; ok {
+		return store, nil
+	}
+	var store SecretStore
+	var err error
+	switch backend {
+	case SecretsKeyring:
+		store = &keyringStore{project: s.cfg.GCP.ProjectID}
+	case SecretsSecretManager:
+		var opts []option.ClientOption
+		if s.cfg.GCP.Credentials != "" {
+			credentials, err := gcpCredentialsOption(s.cfg.GCP.Credentials)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, credentials)
+		}
+		store, err = openSecretManagerStore(s.cfg.GCP.ProjectID, opts...)
+	case SecretsVault:
+		store, err = openVaultStore(s.cfg.Secrets.VaultMount)
+	default:
+		return nil, fmt.Errorf("unsupported secrets backend: %s", backend)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.stores[backend] = store
+	return store, nil
+}
+
+func (s *secretResolver) storeForRef(ref string) (SecretStore, error) {
+	backend := refBackend(ref)
+	if backend == "" {
+		return nil, fmt.Errorf("unsupported password reference: %s", ref)
+	}
+	return s.store(backend)
+}
+
+// Resolve 讀取參照指向的密碼
+func (s *secretResolver) Resolve(ctx context.Context, ref string) (string, error) {
+	s.mu.Lock()
+	value, ok := s.cache[ref]
+	s.mu.Unlock()
+	if ok {
+		return value, nil
+	}
+	store, err := s.storeForRef(ref)
+	if err != nil {
+		return "", err
+	}
+	if value, err = store.Get(ctx, ref); err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	s.cache[ref] = value
+	s.mu.Unlock()
+	return value, nil
+}
+
+// Store 把 instance 的密碼存到 secrets.backend，backend 為 records 時回傳空的參照
+func (s *secretResolver) Store(ctx context.Context, instanceID, value string) (string, error) {
+	if s.cfg.Secrets.Backend == "" || s.cfg.Secrets.Backend == SecretsRecords {
+		return "", nil
+	}
+	return s.put(ctx, s.cfg.Secrets.Backend, secretName(instanceID), value)
+}
+
+// Replace 以新的密碼取代參照指向的密碼，沿用參照中的名稱，參照不變
+func (s *secretResolver) Replace(ctx context.Context, ref, value string) error {
+	updated, err := s.put(ctx, refBackend(ref), refSecretName(ref), value)
+	if err != nil {
+		return err
+	}
+	if updated != ref {
+		return fmt.Errorf("password was stored as %s instead of %s", updated, ref)
+	}
+	return nil
+}
+
+func (s *secretResolver) put(ctx context.Context, backend, name, value string) (string, error) {
+	store, err := s.store(backend)
+	if err != nil {
+		return "", err
+	}
+	ref, err := store.Put(ctx, name, value)
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	s.cache[ref] = value
+	s.mu.Unlock()
+	return ref, nil
+}
+
+// Delete 刪除參照指向的密碼
+func (s *secretResolver) Delete(ctx context.Context, ref string) error {
+	store, err := s.storeForRef(ref)
+	if err != nil {
+		return err
+	}
+	if err := store.Delete(ctx, ref); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	delete(s.cache, ref)
+	s.mu.Unlock()
+	return nil
+}
+
+// secretManagerStore 以 GCP Secret Manager 存放密碼，參照為 gcpsm:projects/<project>/secrets/<name>
+type secretManagerStore struct {
+	service *secretmanager.Service
+	project string
+}
+
+func openSecretManagerStore(project string, opts ...option.ClientOption) (*secretManagerStore, error) {
+	if project == "" {
+		return nil, fmt.Errorf("gcp.project_id is required for %s", SecretsSecretManager)
+	}
+	opts = append(opts, option.WithUserAgent(userAgent()))
+	service, err := secretmanager.NewService(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Secret Manager client: %v", err)
+	}
+	return &secretManagerStore{service: service, project: project}, nil
+}
+
+func (s *secretManagerStore) Put(ctx context.Context, name, value string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, secretTimeout)
+	defer cancel()
+	parent := "projects/" + s.project
+	secret := &secretmanager.Secret{
+		Replication: &secretmanager.Replication{Automatic: &secretmanager.Automatic{}},
+		Labels:      map[string]string{managedLabel: "true"},
+	}
+	debugf("secretmanager.projects.secrets.create %s/secrets/%s", parent, name)
+	_, err := s.service.Projects.Secrets.Create(parent, secret).SecretId(name).Context(ctx).Do()
+	if gerr, ok := err.(*googleapi.Error); ok && gerr.Code == http.StatusConflict {
+		err = nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to create secret %s: %w", name, err)
+	}
+	secretPath := parent + "/secrets/" + name
+	request := &secretmanager.AddSecretVersionRequest{Payload: &secretmanager.SecretPayload{Data: base64.StdEncoding.EncodeToString([]byte(value))}}
+	debugf("secretmanager.projects.secrets.addVersion %s", secretPath)
+	if _, err := s.service.Projects.Secrets.AddVersion(secretPath, request).Context(ctx).Do(); err != nil {
+		return "", fmt.Errorf("failed to store secret %s: %w", name, err)
+	}
+	return secretManagerRefPrefix + secretPath, nil
+}
+
+func (s *secretManagerStore) Get(ctx context.Context, ref string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, secretTimeout)
+	defer cancel()
+	secretPath := strings.TrimPrefix(ref, secretManagerRefPrefix)
+	debugf("secretmanager.projects.secrets.versions.access %s/versions/latest", secretPath)
+	resp, err := s.service.Projects.Secrets.Versions.Access(secretPath + "/versions/latest").Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret %s: %w", secretPath, err)
+	}
+	data, err := base64.StdEncoding.DecodeString(resp.Payload.Data)
+	if err != nil {
+		return "", fmt.Errorf("malformed secret %s: %w", secretPath, err)
+	}
+	return string(data), nil
+}
+
+func (s *secretManagerStore) Delete(ctx context.Context, ref string) error {
+	ctx, cancel := context.WithTimeout(ctx, secretTimeout)
+	defer cancel()
+	secretPath := strings.TrimPrefix(ref, secretManagerRefPrefix)
+	debugf("secretmanager.projects.secrets.delete %s", secretPath)
+	_, err := s.service.Projects.Secrets.Delete(secretPath).Context(ctx).Do()
+	if gerr, ok := err.(*googleapi.Error); ok && gerr.Code == http.StatusNotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete secret %s: %w", secretPath, err)
+	}
+	return nil
+}
+
+// vaultStore 以 HashiCorp Vault 的 KV v2 存放密碼，位址與 token 取自 VAULT_ADDR 與 VAULT_TOKEN
+// 參照為 vault:<mount>/<name>，密碼存在 password 欄位
+type vaultStore struct {
+	addr   string
+	token  string
+	mount  string
+	client *http.Client
+}
+
+func openVaultStore(mount string) (*vaultStore, error) {
+	addr, token := os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN")
+	if addr == "" || token == "" {
+		return nil, fmt.Errorf("VAULT_ADDR and VAULT_TOKEN are required for %s", SecretsVault)
+	}
+	registerSecret(token)
+	if mount == "" {
+		mount = "secret"
+	}
+	return &vaultStore{addr: strings.TrimSuffix(addr, "/"), token: token, mount: strings.Trim(mount, "/"), client: &http.Client{Timeout: secretTimeout}}, nil
+}
+
+// apiPath 把參照轉成 KV v2 API 的路徑，kind 為 data 或 metadata
+// 掛載點可能有多層 (例如 kv/team)，以設定的掛載點切開參照，不屬於這個掛載點的參照無法讀取
+func (v *vaultStore) apiPath(ref, kind string) (string, error) {
+	key, ok := strings.CutPrefix(ref, vaultRefPrefix+v.mount+"/")
+	if !ok {
+		return "", fmt.Errorf("Vault reference %s is not under the configured mount %s, set secrets.vault_mount to its mount", ref, v.mount)
+	}
+	if key == "" {
+		return "", fmt.Errorf("malformed Vault reference: %s", ref)
+	}
+	return fmt.Sprintf("%s/v1/%s/%s/%s", v.addr, v.mount, kind, key), nil
+}
+
+func (v *vaultStore) do(ctx context.Context, method, url string, body any, out any) (int, error) {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return 0, err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	req.Header.Set("Content-Type", "application/json")
+	debugf("vault %s %s", method, url)
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var failure struct {
+			Errors []string `json:"errors"`
+		}
+		json.NewDecoder(resp.Body).Decode(&failure)
+		return resp.StatusCode, fmt.Errorf("vault returned %s: %s", resp.Status, strings.Join(failure.Errors, "; "))
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp.StatusCode, fmt.Errorf("malformed Vault response: %w", err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+func (v *vaultStore) Put(ctx context.Context, name, value string) (string, error) {
+	ref := vaultRefPrefix + v.mount + "/" + name
+	url, err := v.apiPath(ref, "data")
+	if err != nil {
+		return "", err
+	}
+	body := map[string]any{"data": map[string]string{"password": value}}
+	if _, err := v.do(ctx, http.MethodPost, url, body, nil); err != nil {
+		return "", fmt.Errorf("failed to store secret %s: %w", name, err)
+	}
+	return ref, nil
+}
+
+func (v *vaultStore) Get(ctx context.Context, ref string) (string, error) {
+	url, err := v.apiPath(ref, "data")
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		Data struct {
+			Data map[string]string `json:"data"`
+		} `json:"data"`
+	}
+	if _, err := v.do(ctx, http.MethodGet, url, nil, &resp); err != nil {
+		return "", fmt.Errorf("failed to read secret %s: %w", ref, err)
+	}
+	password, ok := resp.Data.Data["password"]
+	if !ok {
+		return "", fmt.Errorf("secret %s has no password field", ref)
+	}
+	return password, nil
+}
+
+// Delete 刪除所有版本與 metadata
+func (v *vaultStore) Delete(ctx context.Context, ref string) error {
+	url, err := v.apiPath(ref, "metadata")
+	if err != nil {
+		return err
+	}
+	status, err := v.do(ctx, http.MethodDelete, url, nil, nil)
+	if status == http.StatusNotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete secret %s: %w", ref, err)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// SubscriptionServer 提供邀請碼兌換與訂閱內容，讓使用者不需要主帳號也能取得 proxy
+type SubscriptionServer struct {
+	recordManager *RecordManager
+	invites       *InviteManager
+	auth          Authenticator
+	logger        *log.Logger
+}
+
+func NewSubscriptionServer(recordManager *RecordManager, invites *InviteManager, auth Authenticator, logger *log.Logger) *SubscriptionServer {
+	return &SubscriptionServer{recordManager: recordManager, invites: invites, auth: auth, logger: logger}
+}
+
+type subscriptionEntry struct {
+	Name     string `json:"name"`
+	Server   string `json:"server"`
+	Port     int    `json:"port"`
+	Method   string `json:"method"`
+	Password string `json:"password"`
+	Location string `json:"location"`
+}
+
+func (s *SubscriptionServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/redeem", s.handleRedeem)
+	mux.HandleFunc("/subscription", s.handleSubscription)
+	return s.authenticate(mux)
+}
+
+// authenticate 所有端點都要先通過設定的驗證方式，access key 只決定可以看到哪些 proxy
+func (s *SubscriptionServer) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := s.auth.Authenticate(r); err != nil {
+			if _, ok := r.Header["Authorization"]; !ok {
+				w.Header().Set("WWW-Authenticate", `Basic realm="auto_proxy"`)
+			}
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ListenAndServe tlsConfig 不為 nil 時以 HTTPS 提供服務，憑證與用戶端驗證都由 tlsConfig 決定
+func (s *SubscriptionServer) ListenAndServe(addr string, tlsConfig *tls.Config) error {
+	if tlsConfig == nil {
+		s.logger.Printf("Subscription server listening on %s", addr)
+		return http.ListenAndServe(addr, s.Handler())
+	}
+	s.logger.Printf("Subscription server listening on %s with mutual TLS", addr)
+	server := &http.Server{Addr: addr, Handler: s.Handler(), TLSConfig: tlsConfig}
+	return server.ListenAndServeTLS("", "")
+}
+
+func (s *SubscriptionServer) handleRedeem(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	key, err := s.invites.Redeem(r.FormValue("code"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	writeJSON(w, key)
+}
+
+func (s *SubscriptionServer) handleSubscription(w http.ResponseWriter, r *http.Request) {
+	key, err := s.invites.Lookup(r.URL.Query().Get("key"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	records, err := s.recordManager.Load()
+	if err != nil {
+		s.logger.Printf("Error loading records: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	entries := make([]subscriptionEntry, 0)
+	for _, rec := range records {
+		if rec.Type != "instance" || !rec.Ready() || !key.Allows(rec.Name) {
+			continue
+		}
+		port, method, password := rec.Endpoint()
+		entries = append(entries, subscriptionEntry{
+			Name:     rec.Name,
+			Server:   rec.IP,
+			Port:     port,
+			Method:   method,
+			Password: password,
+			Location: rec.DisplayLocation(),
+		})
+	}
+	writeJSON(w, entries)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, fmt.Sprintf("failed to encode response: %v", err), http.StatusInternalServerError)
+	}
+}
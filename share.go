@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// ShareURI 回傳 ss://base64(method:password@host:port)#name 格式的分享連結，大多數 Shadowsocks 客戶端都能直接貼上
+func (r ProxyRecord) ShareURI() string {
+	port, method, password := r.Endpoint()
+	return shareURI(r.Name, r.IP, port, method, password)
+}
+
+// UserShareURI 回傳多使用者模式下一個使用者的分享連結，名稱為 <proxy>-<user>
+func (r ProxyRecord) UserShareURI(user ProxyUser) string {
+	_, method, _ := r.Endpoint()
+	return shareURI(r.Name+"-"+user.Name, r.IP, user.Port, method, user.Password)
+}
+
+func shareURI(name, host string, port int, method, password string) string {
+	userinfo := method + ":" + password + "@" + host + ":" + strconv.Itoa(port)
+	return "ss://" + base64.StdEncoding.EncodeToString([]byte(userinfo)) + "#" + url.PathEscape(name)
+}
+
+// Share 印出 proxy 的 ss:// 分享連結，name 為空時印出所有可用的 proxy；qr 為 true 時附上 QR code 供手機掃描
+func (c *Commander) Share(name string, qr bool) error {
+	records, err := c.recordManager.Load()
+	if err != nil {
+		return fmt.Errorf("error loading records: %v", err)
+	}
+	found := false
+	for _, r := range records {
+		if r.Type != "instance" || (name != "" && r.Name != name) {
+			continue
+		}
+		if !r.Ready() || r.IP == "" {
+			if name != "" {
+				return fmt.Errorf("proxy %s is not deployed yet, run `auto_proxy resume --name %s` first", name, name)
+			}
+			continue
+		}
+		found = true
+		if !qr {
+			fmt.Println(r.ShareURI())
+			continue
+		}
+		code, err := terminalQR(r.ShareURI())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\n%s\n%s\n", r.Name, code, r.ShareURI())
+	}
+	if !found {
+		if name != "" {
+			return fmt.Errorf("proxy not found: %s", name)
+		}
+		return fmt.Errorf("no active proxies to share")
+	}
+	return nil
+}
+
+// terminalQR 以半格字元把 QR code 畫在終端機上，一個字元代表上下兩個模組
+func terminalQR(content string) (string, error) {
+	code, err := qrcode.New(content, qrcode.Medium)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate QR code: %v", err)
+	}
+	// 深色背景的終端機較常見，反轉後掃描時才是白底黑碼
+	return code.ToSmallString(true), nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// statusLine 終端機最下面一行持續更新的狀態，顯示進行中的工作與經過時間
+// 其他進度訊息透過 Println 印在狀態列上方，避免和狀態列混在同一行
+type statusLine struct {
+	mu    sync.Mutex
+	w     io.Writer
+	width int
+	tasks map[string]statusTask
+	frame int
+	stop  chan struct{}
+}
+
+type statusTask struct {
+	label   string
+	started time.Time
+}
+
+// status 只在 stdout 是終端機且為一般詳細程度時啟用，否則為 nil，進度改以逐行文字輸出
+var status *statusLine
+
+// enableStatusLine 依目前的輸出環境決定是否使用狀態列
+func enableStatusLine() {
+	fd := int(os.Stdout.Fd())
+	if logLevel != LevelNormal || !term.IsTerminal(fd) {
+		return
+	}
+	width, _, err := term.GetSize(fd)
+	if err != nil || width < 20 {
+		width = 80
+	}
+	status = &statusLine{w: os.Stdout, width: width, tasks: make(map[string]statusTask)}
+}
+
+// Start 加入一個進行中的工作，第一個工作開始時啟動更新狀態列的 goroutine
+func (s *statusLine) Start(key, label string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tasks[key] = statusTask{label: label, started: time.Now()}
+	if s.stop == nil {
+		s.stop = make(chan struct{})
+		go s.run(s.stop)
+	}
+	s.draw()
+}
+
+// Done 結束工作並在狀態列上方印出結果，message 中的 %v 為經過時間，沒有工作時停止更新
+func (s *statusLine) Done(key, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	task, ok := s.tasks[key]
+	if !ok {
+		// 例如 resume 時沒有回報開始的階段
+		task.started = time.Now()
+	}
+	delete(s.tasks, key)
+	s.clear()
+	fmt.Fprintf(s.w, message+"\n", time.Since(task.started).Round(time.Second))
+	if len(s.tasks) == 0 {
+		if s.stop != nil {
+			close(s.stop)
+			s.stop = nil
+		}
+		return
+	}
+	s.draw()
+}
+
+// Println 在狀態列上方印出一行訊息
+func (s *statusLine) Println(text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clear()
+	fmt.Fprintln(s.w, strings.TrimSuffix(text, "\n"))
+	s.draw()
+}
+
+// active 回傳狀態列目前是否有進行中的工作
+func (s *statusLine) active() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.tasks) > 0
+}
+
+func (s *statusLine) run(stop chan struct{}) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			s.frame++
+			s.draw()
+			s.mu.Unlock()
+		}
+	}
+}
+
+func (s *statusLine) clear() {
+	fmt.Fprint(s.w, "\r\033[K")
+}
+
+// draw 重畫狀態列，多個工作同時進行時依開始時間排列，超過終端機寬度時截斷
+func (s *statusLine) draw() {
+	if len(s.tasks) == 0 {
+		s.clear()
+		return
+	}
+	tasks := make([]statusTask, 0, len(s.tasks))
+	for _, t := range s.tasks {
+		tasks = append(tasks, t)
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].started.Before(tasks[j].started) })
+	parts := make([]string, 0, len(tasks))
+	for _, t := range tasks {
+		parts = append(parts, fmt.Sprintf("%s %v", t.label, time.Since(t.started).Round(time.Second)))
+	}
+	line := []rune(spinnerFrames[s.frame%len(spinnerFrames)] + " " + strings.Join(parts, " · "))
+	if len(line) > s.width-1 {
+		line = append(line[:s.width-2], '…')
+	}
+	s.clear()
+	fmt.Fprint(s.w, string(line))
+}
+
+// SpinnerReporter 以狀態列顯示進行中的部署階段，階段結束時印出一行結果與耗時
+type SpinnerReporter struct {
+	status *statusLine
+}
+
+func (r *SpinnerReporter) Report(e StageEvent) {
+	prefix := e.Proxy
+	if prefix == "" {
+		prefix = e.IP
+	}
+	key := prefix + "/" + string(e.Stage)
+	switch e.Status {
+	case EventStarted:
+		r.status.Start(key, fmt.Sprintf("[%s] %s", prefix, e.Stage))
+	case EventProgress:
+		r.status.Println(fmt.Sprintf("[%s] %s", prefix, e.Message))
+	case EventSucceeded:
+		r.status.Done(key, fmt.Sprintf("[%s] %s: done (%%v)", prefix, e.Stage))
+	case EventFailed:
+		r.status.Done(key, fmt.Sprintf("[%s] %s: failed after %%v: %s", prefix, e.Stage, strings.ReplaceAll(e.Message, "%", "%%")))
+	}
+}
+
+// withSpinner 執行 fn 的期間在狀態列顯示 label，沒有狀態列時直接執行
+func withSpinner(label string, fn func() error) error {
+	if status == nil {
+		return fn()
+	}
+	key := fmt.Sprintf("%s/%d", label, time.Now().UnixNano())
+	status.Start(key, label)
+	escaped := strings.ReplaceAll(label, "%", "%%")
+	err := fn()
+	if err != nil {
+		status.Done(key, escaped+": "+tr("failed after %v"))
+		return err
+	}
+	status.Done(key, escaped+": "+tr("done (%v)"))
+	return nil
+}
+
+// printAbove 輸出結果訊息，狀態列有進行中的工作時印在狀態列上方
+func printAbove(format string, args ...any) {
+	if status != nil && status.active() {
+		status.Println(fmt.Sprintf(format, args...))
+		return
+	}
+	fmt.Printf(format, args...)
+}
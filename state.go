@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/AlecAivazis/survey/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// stateSchemaVersion 備份檔的格式版本，格式不相容地改變時遞增
+const stateSchemaVersion = 1
+
+// stateBackup state export 輸出的備份，包含設定、紀錄與邀請碼，紀錄中的密碼為明文
+type stateBackup struct {
+	SchemaVersion int            `json:"schema_version"`
+	AppVersion    string         `json:"app_version"`
+	ExportedAt    time.Time      `json:"exported_at"`
+	Config        map[string]any `json:"config"`
+	Records       []ProxyRecord  `json:"records"`
+	Invites       *inviteData    `json:"invites,omitempty"`
+}
+
+// StateExport 將設定、紀錄與邀請碼以 JSON 輸出到 w，用於搬移到另一台電腦
+func (c *Commander) StateExport(w io.Writer) error {
+	// 使用設定檔原本的內容，不要把資料目錄展開後的路徑寫進備份
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	tree, err := cfg.tree()
+	if err != nil {
+		return fmt.Errorf("failed to encode config: %v", err)
+	}
+	// 備份包含所有 profile 的紀錄
+	records, err := c.recordManager.LoadAll()
+	if err != nil {
+		return fmt.Errorf("error loading records: %v", err)
+	}
+	invites, err := c.invites.Snapshot()
+	if err != nil {
+		return err
+	}
+	backup := stateBackup{
+		SchemaVersion: stateSchemaVersion,
+		AppVersion:    version,
+		ExportedAt:    time.Now().UTC(),
+		Config:        tree,
+		Records:       records,
+		Invites:       invites,
+	}
+	data, err := json.MarshalIndent(backup, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal backup: %v", err)
+	}
+	if _, err := w.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, tr("Warning: the backup contains proxy passwords in plain text, keep it safe."))
+	return nil
+}
+
+// readStateBackup 讀取備份檔，path 為 - 時從標準輸入讀取
+func readStateBackup(path string) (*stateBackup, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup: %v", err)
+	}
+	var backup stateBackup
+	if err := json.Unmarshal(data, &backup); err != nil {
+		return nil, fmt.Errorf("failed to parse backup: %v", err)
+	}
+	if backup.SchemaVersion < 1 {
+		return nil, fmt.Errorf("%s is not an auto_proxy state backup", path)
+	}
+	if backup.SchemaVersion > stateSchemaVersion {
+		return nil, fmt.Errorf("backup schema version %d is newer than supported (%d), upgrade auto_proxy first", backup.SchemaVersion, stateSchemaVersion)
+	}
+	return &backup, nil
+}
+
+// StateImport 從 state export 的備份還原，不需要雲端連線
+// 預設保留既有的設定檔與同名紀錄，replace 時以備份的內容取代
+func StateImport(path string, replace, dryRun bool) error {
+	backup, err := readStateBackup(path)
+	if err != nil {
+		return err
+	}
+	cfgPath, err := configPath()
+	if err != nil {
+		return err
+	}
+	_, statErr := os.Stat(cfgPath)
+	writeConfig := replace || errors.Is(statErr, os.ErrNotExist)
+	cfg, err := LoadConfig(cfgPath)
+	if err != nil && !writeConfig {
+		return err
+	}
+	if writeConfig && backup.Config != nil {
+		data, err := yaml.Marshal(backup.Config)
+		if err != nil {
+			return fmt.Errorf("failed to encode config: %v", err)
+		}
+		if cfg, err = decodeConfig(data); err != nil {
+			return fmt.Errorf("failed to parse config in backup: %v", err)
+		}
+		if dryRun {
+			dryRunf("Would write %s\n", cfgPath)
+		} else {
+			if err := cfg.Save(cfgPath); err != nil {
+				return err
+			}
+			fmt.Printf(tr("Restored config to %s, check that gcp.credentials and ssh.key_path exist on this machine.\n"), cfgPath)
+		}
+	} else if backup.Config != nil {
+		fmt.Printf(tr("Kept the existing config %s, use --replace to overwrite it.\n"), cfgPath)
+	}
+
+	dataDir, err := cfg.prepareDataDir(!dryRun)
+	if err != nil {
+		return err
+	}
+	recordManager, _, storage, err := openRecordManager(cfg, dataDir, dryRun)
+	if err != nil {
+		return err
+	}
+	if closer, ok := storage.(io.Closer); ok {
+		defer closer.Close()
+	}
+	added, skipped := 0, 0
+	err = recordManager.Update(func(records []ProxyRecord) ([]ProxyRecord, error) {
+		added, skipped = 0, 0
+		if replace {
+			added = len(backup.Records)
+			return append([]ProxyRecord(nil), backup.Records...), nil
+		}
+		existing := make(map[string]bool, len(records))
+		for _, r := range records {
+			existing[r.Type+"/"+r.Name] = true
+		}
+		for _, r := range backup.Records {
+			if existing[r.Type+"/"+r.Name] {
+				skipped++
+				continue
+			}
+			records = append(records, r)
+			added++
+		}
+		return records, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error saving records: %v", err)
+	}
+	if skipped > 0 {
+		fmt.Printf(tr("Restored %d records, skipped %d that already exist (use --replace to overwrite).\n"), added, skipped)
+	} else {
+		fmt.Printf(tr("Restored %d records.\n"), added)
+	}
+
+	if backup.Invites != nil {
+		invites := NewInviteManager(filepath.Join(dataDir, "invites.json"))
+		if dryRun {
+			dryRunf("Would restore %d invites\n", len(backup.Invites.Invites))
+			return nil
+		}
+		count, err := invites.Restore(backup.Invites, replace)
+		if err != nil {
+			return err
+		}
+		fmt.Printf(tr("Restored %d invites.\n"), count)
+	}
+	return nil
+}
+
+// StateRekey 以新的密碼短語重新加密紀錄中的密碼，用於密碼短語外洩（例如筆電遺失）時
+// 新密碼短語可以由 AUTO_PROXY_NEW_PASSPHRASE 提供，否則互動輸入；
+// 金鑰由 auto_proxy 保存時直接產生新的隨機金鑰。rotateTokens 時一併更換 serve 的密鑰並作廢邀請碼
+func (c *Commander) StateRekey(rotateTokens bool) error {
+	passphrase := os.Getenv("AUTO_PROXY_NEW_PASSPHRASE")
+	if passphrase == "" && c.secretKeys != nil {
+		var err error
+		if passphrase, err = generateSecretKey(); err != nil {
+			return err
+		}
+	}
+	if passphrase == "" {
+		var confirm string
+		if err := survey.AskOne(&survey.Password{Message: tr("New passphrase:")}, &passphrase); err != nil {
+			return fmt.Errorf("failed to read the new passphrase: %v", err)
+		}
+		if err := survey.AskOne(&survey.Password{Message: tr("Confirm new passphrase:")}, &confirm); err != nil {
+			return fmt.Errorf("failed to read the new passphrase: %v", err)
+		}
+		if passphrase != confirm {
+			return fmt.Errorf("passphrases do not match")
+		}
+	}
+	if passphrase == "" {
+		return fmt.Errorf("new passphrase cannot be empty")
+	}
+	if c.secretKeys != nil {
+		if err := c.rekeyStored(passphrase); err != nil {
+			return err
+		}
+	} else {
+		count, err := c.recordManager.Rekey(NewSecretBox(passphrase))
+		if err != nil {
+			c.logger.Printf("Error rekeying records: %v", err)
+			return fmt.Errorf("error rekeying records: %v", err)
+		}
+		fmt.Printf(tr("Re-encrypted %d secrets. Update AUTO_PROXY_PASSPHRASE to the new passphrase.\n"), count)
+	}
+	if !rotateTokens {
+		return nil
+	}
+	return c.rotateTokens()
+}
+
+// rotateTokens 更換存在 OS keyring 的 serve 密鑰，並作廢所有邀請碼與存取金鑰，
+// 外洩的電腦上留下的 token、簽章網址與訂閱連結都隨即失效。環境變數提供的密鑰只能提示使用者自行更換
+func (c *Commander) rotateTokens() error {
+	if c.dryRun {
+		dryRunf("Would replace the serve secrets stored in the OS keyring and revoke all invites and access keys\n")
+		return nil
+	}
+	rotated, err := rotateServeSecrets()
+	if err != nil {
+		return err
+	}
+	for _, secret := range rotated {
+		switch secret.Name {
+		case "AUTO_PROXY_SERVE_SIGNING_KEY":
+			fmt.Printf(tr("Replaced %s, links signed with the old key no longer work.\n"), secret.Name)
+		default:
+			fmt.Printf(tr("Replaced %s, give clients the new value: %s\n"), secret.Name, secret.Value)
+		}
+	}
+	for _, name := range serveSecrets {
+		if os.Getenv(name) != "" {
+			fmt.Printf(tr("%s comes from the environment, replace it there.\n"), name)
+		}
+	}
+	invites, keys, err := c.invites.RevokeAll()
+	if err != nil {
+		return fmt.Errorf("error revoking invites: %v", err)
+	}
+	fmt.Printf(tr("Revoked %d invites and %d access keys, create new invites for your users.\n"), invites, keys)
+	if len(rotated) > 0 {
+		fmt.Println(tr("Restart serve to use the new secrets."))
+	}
+	return nil
+}
+
+// rekeyStored 先保存新的金鑰再重新加密，重新加密失敗時還原舊的金鑰，
+// 避免紀錄與保存的金鑰不一致而無法解密
+func (c *Commander) rekeyStored(key string) error {
+	previous, err := c.secretKeys.Load()
+	if err != nil {
+		return err
+	}
+	if c.dryRun {
+		dryRunf("Would re-encrypt secrets with a new key stored in %s\n", c.secretKeys.Location())
+		return nil
+	}
+	if err := c.secretKeys.Save(key); err != nil {
+		return err
+	}
+	count, err := c.recordManager.Rekey(NewSecretBox(key))
+	if err != nil {
+		c.logger.Printf("Error rekeying records: %v", err)
+		if previous != "" {
+			if err := c.secretKeys.Save(previous); err != nil {
+				c.logger.Printf("Error restoring the previous secret key: %v", err)
+			}
+		}
+		return fmt.Errorf("error rekeying records: %v", err)
+	}
+	fmt.Printf(tr("Re-encrypted %d secrets with a new key stored in %s.\n"), count, c.secretKeys.Location())
+	return nil
+}
+
+// StateHistory 列出紀錄的變更歷史，name 為空時列出所有紀錄
+func (c *Commander) StateHistory(name string) error {
+	changes, err := c.recordManager.History(name)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 && !c.machineOutput() {
+		fmt.Println(tr("No history found."))
+		return nil
+	}
+	return c.render(changes, func(w io.Writer) {
+		fmt.Fprintln(w, "TIME\tACTION\tNAME\tTYPE\tSTATUS\tIP")
+		for _, ch := range changes {
+			r := ch.Record
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", ch.Time.In(appLocation).Format(time.RFC3339), ch.Action, r.Name, r.Type, r.Status, r.IP)
+		}
+	})
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// status 指令回報的狀態，雲端狀態以外的情況
+const (
+	LiveStatusNotFound = "NOT FOUND"
+	LiveStatusExternal = "EXTERNAL"
+	LiveStatusUnknown  = "UNKNOWN"
+)
+
+// statusView 紀錄與雲端實際狀態的比對結果
+type statusView struct {
+	Name        string `json:"name" yaml:"name"`
+	Status      string `json:"status" yaml:"status"`
+	Lifecycle   string `json:"lifecycle" yaml:"lifecycle"`
+	MachineType string `json:"machine_type,omitempty" yaml:"machine_type,omitempty"`
+	RecordedIP  string `json:"recorded_ip" yaml:"recorded_ip"`
+	CurrentIP   string `json:"current_ip,omitempty" yaml:"current_ip,omitempty"`
+	IPChanged   bool   `json:"ip_changed" yaml:"ip_changed"`
+	Error       string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// Status 向雲端查詢每台 proxy 目前的狀態與外部 IP，並標示 IP 和紀錄不一致的 proxy
+func (c *Commander) Status(ctx context.Context, name string) error {
+	records, err := c.recordManager.Load()
+	if err != nil {
+		return fmt.Errorf("error loading records: %v", err)
+	}
+	var targets []ProxyRecord
+	for _, r := range records {
+		if r.Type == "instance" && (name == "" || r.Name == name) {
+			targets = append(targets, r)
+		}
+	}
+	if name != "" && len(targets) == 0 {
+		return fmt.Errorf("proxy not found: %s", name)
+	}
+
+	views := make([]statusView, len(targets))
+	var wg sync.WaitGroup
+	for i, r := range targets {
+		wg.Add(1)
+		go func(i int, r ProxyRecord) {
+			defer wg.Done()
+			views[i] = c.liveStatus(ctx, r)
+		}(i, r)
+	}
+	wg.Wait()
+
+	return c.render(views, func(w io.Writer) {
+		fmt.Fprintln(w, "NAME\tSTATUS\tLIFECYCLE\tMACHINE TYPE\tRECORDED IP\tCURRENT IP\tNOTE")
+		for _, v := range views {
+			note := v.Error
+			if v.IPChanged {
+				note = tr("IP changed")
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", v.Name, v.Status, v.Lifecycle, v.MachineType, v.RecordedIP, v.CurrentIP, note)
+		}
+	})
+}
+
+func (c *Commander) liveStatus(ctx context.Context, r ProxyRecord) statusView {
+	view := statusView{Name: r.Name, Lifecycle: r.Lifecycle(), MachineType: r.MachineType, RecordedIP: r.IP}
+	if !r.Managed() {
+		view.Status = LiveStatusExternal
+		return view
+	}
+	info, err := c.provider.GetInstanceInfo(ctx, r.Zone, r.InstanceID)
+	if errors.Is(err, ErrInstanceNotFound) {
+		view.Status = LiveStatusNotFound
+		return view
+	}
+	if err != nil {
+		c.logger.Printf("Error getting status of %s: %v", r.Name, err)
+		view.Status, view.Error = LiveStatusUnknown, err.Error()
+		return view
+	}
+	view.Status, view.CurrentIP = info.Status, info.IP
+	// 停止的機器沒有外部 IP，不算 IP 變更
+	view.IPChanged = info.IP != "" && info.IP != r.IP
+	return view
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"google.golang.org/api/option"
+	_ "modernc.org/sqlite"
+)
+
+// 紀錄的儲存方式，設定檔的 storage 選擇其中之一
+const (
+	StorageJSON   = "json"
+	StorageSQLite = "sqlite"
+	StorageGCS    = "gcs"
+)
+
+// RecordStorage 負責讀寫紀錄，收到與回傳的密碼都是加密後的值，加解密由 RecordManager 處理
+type RecordStorage interface {
+	Read() ([]ProxyRecord, error)
+	Write(records []ProxyRecord) error
+	// Lock 取得跨程序的寫入鎖，讓「讀取-修改-寫入」不會和其他程序交錯
+	Lock() (func(), error)
+}
+
+// RecordChange 紀錄的一次變更，Record 為變更後的內容，刪除時為變更前的內容
+type RecordChange struct {
+	Time   time.Time   `json:"time" yaml:"time"`
+	Action string      `json:"action" yaml:"action"`
+	Record ProxyRecord `json:"record" yaml:"record"`
+}
+
+// 變更的種類
+const (
+	ChangeCreated = "created"
+	ChangeUpdated = "updated"
+	ChangeDeleted = "deleted"
+)
+
+// historyStorage 會保留變更歷史的儲存方式
+type historyStorage interface {
+	History(name string) ([]RecordChange, error)
+}
+
+// newRecordStorage 依設定檔的 storage 開啟儲存方式，path 為本機紀錄檔的路徑，不含副檔名
+func newRecordStorage(cfg *Config, path string) (RecordStorage, error) {
+	switch cfg.Storage {
+	case "", StorageJSON:
+		return &jsonStorage{path: path + ".json"}, nil
+	case StorageSQLite:
+		return openSQLiteStorage(path+".db", path+".json")
+	case StorageGCS:
+		var opts []option.ClientOption
+		if cfg.GCP.Credentials != "" {
+			credentials, err := gcpCredentialsOption(cfg.GCP.Credentials)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, credentials)
+		}
+		return openGCSStorage(cfg.StorageBucket, path+".gcs.lock", opts...)
+	}
+	return nil, fmt.Errorf("unsupported storage: %s (supported: %s, %s, %s)", cfg.Storage, StorageJSON, StorageSQLite, StorageGCS)
+}
+
+// jsonStorage 將所有紀錄寫在一個 JSON 檔案
+type jsonStorage struct {
+	path string
+}
+
+func (s *jsonStorage) Read() ([]ProxyRecord, error) {
+	return readRecordsFile(s.path)
+}
+
+func readRecordsFile(path string) ([]ProxyRecord, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return []ProxyRecord{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read records: %w", err)
+	}
+	var records []ProxyRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal records: %w", err)
+	}
+	return records, nil
+}
+
+func (s *jsonStorage) Write(records []ProxyRecord) error {
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal records: %w", err)
+	}
+	if err := writeFileAtomic(s.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write records: %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic 先寫到同一個目錄的暫存檔並 fsync，再改名取代原檔，
+// 寫到一半中斷或同時讀取時只會看到完整的舊檔或新檔
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *jsonStorage) Lock() (func(), error) {
+	return lockFile(s.path + ".lock")
+}
+
+// sqliteStorage 將紀錄存在 SQLite，常用欄位獨立成欄方便查詢，完整內容存在 data，
+// 每次寫入時比較前後差異，把新增、修改與刪除記到 record_history
+type sqliteStorage struct {
+	db   *sql.DB
+	path string
+}
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS records (
+	position   INTEGER PRIMARY KEY,
+	name       TEXT NOT NULL,
+	type       TEXT NOT NULL,
+	provider   TEXT NOT NULL,
+	region     TEXT NOT NULL,
+	zone       TEXT NOT NULL,
+	ip         TEXT NOT NULL,
+	status     TEXT NOT NULL,
+	created_at TEXT NOT NULL,
+	data       TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS records_name ON records (name);
+CREATE TABLE IF NOT EXISTS record_history (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	changed_at TEXT NOT NULL,
+	action     TEXT NOT NULL,
+	name       TEXT NOT NULL,
+	type       TEXT NOT NULL,
+	data       TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS record_history_name ON record_history (name);
+`
+
+// openSQLiteStorage 開啟資料庫，資料庫是新建立的且 legacyJSON 存在時匯入既有的紀錄
+func openSQLiteStorage(path, legacyJSON string) (*sqliteStorage, error) {
+	_, statErr := os.Stat(path)
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open records database: %w", err)
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize records database: %w", err)
+	}
+	s := &sqliteStorage{db: db, path: path}
+	if err := s.scrubHistory(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to scrub record history: %w", err)
+	}
+	if os.IsNotExist(statErr) {
+		records, err := readRecordsFile(legacyJSON)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		if len(records) > 0 {
+			if err := s.Write(records); err != nil {
+				db.Close()
+				return nil, fmt.Errorf("failed to import %s: %w", legacyJSON, err)
+			}
+		}
+	}
+	return s, nil
+}
+
+func (s *sqliteStorage) Read() ([]ProxyRecord, error) {
+	rows, err := s.db.Query(`SELECT data FROM records ORDER BY position`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read records: %w", err)
+	}
+	defer rows.Close()
+	records := []ProxyRecord{}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to read records: %w", err)
+		}
+		var record ProxyRecord
+		if err := json.Unmarshal([]byte(data), &record); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal record: %w", err)
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// Write 在一個交易內以 records 取代所有紀錄並記錄差異
+func (s *sqliteStorage) Write(records []ProxyRecord) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to write records: %w", err)
+	}
+	defer tx.Rollback()
+
+	// 比較與記錄都使用去掉密碼的內容，每次寫入都會以新的 nonce 重新加密密碼，比較密文永遠不相等
+	previous := make(map[string]string)
+	rows, err := tx.Query(`SELECT name, type, data FROM records`)
+	if err != nil {
+		return fmt.Errorf("failed to write records: %w", err)
+	}
+	for rows.Next() {
+		var name, typ, data string
+		if err := rows.Scan(&name, &typ, &data); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to write records: %w", err)
+		}
+		var r ProxyRecord
+		if err := json.Unmarshal([]byte(data), &r); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to unmarshal record: %w", err)
+		}
+		if previous[typ+"/"+name], err = historyData(r); err != nil {
+			rows.Close()
+			return err
+		}
+	}
+	rows.Close()
+
+	if _, err := tx.Exec(`DELETE FROM records`); err != nil {
+		return fmt.Errorf("failed to write records: %w", err)
+	}
+	changedAt := now().UTC().Format(time.RFC3339Nano)
+	logChange := func(action, name, typ, data string) error {
+		_, err := tx.Exec(`INSERT INTO record_history (changed_at, action, name, type, data) VALUES (?, ?, ?, ?, ?)`, changedAt, action, name, typ, data)
+		return err
+	}
+	for i, r := range records {
+		encoded, err := json.Marshal(r)
+		if err != nil {
+			return fmt.Errorf("failed to marshal record %s: %w", r.Name, err)
+		}
+		data := string(encoded)
+		createdAt := ""
+		if !r.CreatedAt.IsZero() {
+			createdAt = r.CreatedAt.UTC().Format(time.RFC3339)
+		}
+		_, err = tx.Exec(`INSERT INTO records (position, name, type, provider, region, zone, ip, status, created_at, data) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			i, r.Name, r.Type, r.Provider, r.Region, r.Zone, r.IP, r.Status, createdAt, data)
+		if err != nil {
+			return fmt.Errorf("failed to write record %s: %w", r.Name, err)
+		}
+		stripped, err := historyData(r)
+		if err != nil {
+			return err
+		}
+		key := r.Type + "/" + r.Name
+		old, existed := previous[key]
+		delete(previous, key)
+		switch {
+		case !existed:
+			err = logChange(ChangeCreated, r.Name, r.Type, stripped)
+		case old != stripped:
+			err = logChange(ChangeUpdated, r.Name, r.Type, stripped)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to record history: %w", err)
+		}
+	}
+	for _, data := range previous {
+		var r ProxyRecord
+		if err := json.Unmarshal([]byte(data), &r); err != nil {
+			return fmt.Errorf("failed to unmarshal record: %w", err)
+		}
+		if err := logChange(ChangeDeleted, r.Name, r.Type, data); err != nil {
+			return fmt.Errorf("failed to record history: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to write records: %w", err)
+	}
+	return nil
+}
+
+// historyData 回傳寫入歷史的紀錄內容，不保留密碼，rekey 之後歷史中也不會留下舊金鑰加密的密碼
+func historyData(r ProxyRecord) (string, error) {
+	r.Password = ""
+	r.Users = slices.Clone(r.Users)
+	for i := range r.Users {
+		r.Users[i].Password = ""
+	}
+	encoded, err := json.Marshal(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal record %s: %w", r.Name, err)
+	}
+	return string(encoded), nil
+}
+
+// scrubHistory 移除舊版寫入歷史的密碼
+func (s *sqliteStorage) scrubHistory() error {
+	rows, err := s.db.Query(`SELECT id, data FROM record_history WHERE data LIKE '%"password":"_%'`)
+	if err != nil {
+		return err
+	}
+	scrubbed := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var data string
+		if err := rows.Scan(&id, &data); err != nil {
+			rows.Close()
+			return err
+		}
+		var r ProxyRecord
+		if err := json.Unmarshal([]byte(data), &r); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to unmarshal record: %w", err)
+		}
+		stripped, err := historyData(r)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		if stripped != data {
+			scrubbed[id] = stripped
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for id, data := range scrubbed {
+		if _, err := s.db.Exec(`UPDATE record_history SET data = ? WHERE id = ?`, data, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Lock SQLite 的交易只保護單次寫入，讀取-修改-寫入仍然需要檔案鎖
+func (s *sqliteStorage) Lock() (func(), error) {
+	return lockFile(s.path + ".lock")
+}
+
+// History 回傳某個名稱的變更歷史，name 為空時回傳全部，舊的在前
+func (s *sqliteStorage) History(name string) ([]RecordChange, error) {
+	rows, err := s.db.Query(`SELECT changed_at, action, data FROM record_history WHERE ? = '' OR name = ? ORDER BY id`, name, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	defer rows.Close()
+	var changes []RecordChange
+	for rows.Next() {
+		var changedAt, action, data string
+		if err := rows.Scan(&changedAt, &action, &data); err != nil {
+			return nil, fmt.Errorf("failed to read history: %w", err)
+		}
+		change := RecordChange{Action: action}
+		change.Time, _ = time.Parse(time.RFC3339Nano, changedAt)
+		if err := json.Unmarshal([]byte(data), &change.Record); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal record: %w", err)
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
+
+func (s *sqliteStorage) Close() error {
+	return s.db.Close()
+}
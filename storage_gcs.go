@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	storage "google.golang.org/api/storage/v1"
+)
+
+// ErrStorageConflict 寫入前紀錄已經被其他機器修改，RecordManager.Update 會重新讀取後再試
+var ErrStorageConflict = errors.New("records were changed by another machine")
+
+// gcsTimeout 單次讀寫 bucket 的時間上限
+const gcsTimeout = 30 * time.Second
+
+// gcsStorage 將紀錄存成 GCS bucket 中的一個物件，讓多台電腦管理同一批 proxy
+// 寫入時以讀取時的 generation 為前置條件 (樂觀鎖)，期間被其他機器修改時回傳 ErrStorageConflict
+type gcsStorage struct {
+	service  *storage.Service
+	bucket   string
+	object   string
+	lockPath string // 同一台電腦上的程序仍以檔案鎖排隊，減少不必要的衝突
+
+	mu         sync.Mutex
+	generation int64 // 最後一次讀取到的 generation，0 表示物件還不存在
+}
+
+// openGCSStorage location 為 bucket 名稱，可以加上路徑前綴，例如 my-bucket/team-a
+func openGCSStorage(location, lockPath string, opts ...option.ClientOption) (*gcsStorage, error) {
+	bucket, prefix, _ := strings.Cut(strings.TrimPrefix(location, "gs://"), "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("storage_bucket is required for %s storage", StorageGCS)
+	}
+	opts = append(opts, option.WithUserAgent(userAgent()))
+	service, err := storage.NewService(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage client: %v", err)
+	}
+	return &gcsStorage{
+		service:  service,
+		bucket:   bucket,
+		object:   path.Join(prefix, "proxy_records.json"),
+		lockPath: lockPath,
+	}, nil
+}
+
+func (s *gcsStorage) location() string {
+	return "gs://" + s.bucket + "/" + s.object
+}
+
+func (s *gcsStorage) Read() ([]ProxyRecord, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), gcsTimeout)
+	defer cancel()
+	debugf("storage.objects.get %s", s.location())
+	obj, err := s.service.Objects.Get(s.bucket, s.object).Context(ctx).Do()
+	if gerr, ok := err.(*googleapi.Error); ok && gerr.Code == http.StatusNotFound {
+		s.setGeneration(0)
+		return []ProxyRecord{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read records from %s: %w", s.location(), err)
+	}
+	// 指定 generation 下載，讀到的內容與記下的 generation 一定一致
+	resp, err := s.service.Objects.Get(s.bucket, s.object).Generation(obj.Generation).Context(ctx).Download()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read records from %s: %w", s.location(), err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read records from %s: %w", s.location(), err)
+	}
+	var records []ProxyRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal records: %w", err)
+	}
+	s.setGeneration(obj.Generation)
+	return records, nil
+}
+
+func (s *gcsStorage) Write(records []ProxyRecord) error {
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal records: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), gcsTimeout)
+	defer cancel()
+	s.mu.Lock()
+	generation := s.generation
+	s.mu.Unlock()
+	debugf("storage.objects.insert %s ifGenerationMatch=%d", s.location(), generation)
+	obj, err := s.service.Objects.Insert(s.bucket, &storage.Object{Name: s.object, ContentType: "application/json"}).
+		IfGenerationMatch(generation).
+		Media(bytes.NewReader(data)).
+		Context(ctx).
+		Do()
+	if gerr, ok := err.(*googleapi.Error); ok && gerr.Code == http.StatusPreconditionFailed {
+		return fmt.Errorf("failed to write records to %s: %w", s.location(), ErrStorageConflict)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write records to %s: %w", s.location(), err)
+	}
+	s.setGeneration(obj.Generation)
+	return nil
+}
+
+func (s *gcsStorage) setGeneration(generation int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.generation = generation
+}
+
+func (s *gcsStorage) Lock() (func(), error) {
+	return lockFile(s.lockPath)
+}
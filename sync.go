@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// managedLabel auto_proxy 建立的 instance 都帶有這個 label，sync 以此列出雲端上的 proxy
+// 較早建立的 instance 只有 metadataManagedKey，sync --fix 時補上
+const managedLabel = "auto-proxy-managed"
+
+// 紀錄與雲端不一致的種類
+const (
+	DriftMissing   = "missing"    // 紀錄中的 instance 已經不存在
+	DriftIPChanged = "ip-changed" // instance 的外部 IP 和紀錄不同
+	DriftUnlabeled = "unlabeled"  // instance 存在但缺少 managedLabel
+	DriftUnmanaged = "unmanaged"  // 帶有 managedLabel 但沒有紀錄的 instance
+)
+
+// SyncOptions fix 修正紀錄與 label，adopt 把沒有紀錄的 instance 加回紀錄
+type SyncOptions struct {
+	Fix   bool
+	Adopt bool
+}
+
+// driftView sync 找到的一項不一致
+type driftView struct {
+	Drift      string `json:"drift" yaml:"drift"`
+	Name       string `json:"name" yaml:"name"`
+	Zone       string `json:"zone" yaml:"zone"`
+	InstanceID string `json:"instance_id" yaml:"instance_id"`
+	RecordedIP string `json:"recorded_ip,omitempty" yaml:"recorded_ip,omitempty"`
+	CurrentIP  string `json:"current_ip,omitempty" yaml:"current_ip,omitempty"`
+	Action     string `json:"action,omitempty" yaml:"action,omitempty"`
+
+	labels map[string]string // unmanaged instance 的 label，adopt 時還原成 tag
+}
+
+// Sync 比對雲端上實際的 instance 與本機紀錄，列出不一致的項目，依 opts 修正
+func (c *Commander) Sync(ctx context.Context, opts SyncOptions) error {
+	records, err := c.recordManager.Load()
+	if err != nil {
+		return fmt.Errorf("error loading records: %v", err)
+	}
+	var instances []CloudInstance
+	err = withSpinner(tr("Listing cloud instances"), func() error {
+		instances, err = c.provider.ListInstances(ctx, managedLabel)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error listing instances: %v", err)
+	}
+	drifts := c.detectDrift(ctx, records, instances)
+	if opts.Fix || opts.Adopt {
+		if err := c.repairDrift(ctx, drifts, opts); err != nil {
+			return err
+		}
+	}
+
+	if len(drifts) == 0 && !c.machineOutput() {
+		fmt.Println(tr("Local records match the cloud."))
+		return nil
+	}
+	err = c.render(drifts, func(w io.Writer) {
+		fmt.Fprintln(w, "DRIFT\tNAME\tZONE\tRECORDED IP\tCURRENT IP\tACTION")
+		for _, d := range drifts {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", d.Drift, d.Name, d.Zone, d.RecordedIP, d.CurrentIP, d.Action)
+		}
+	})
+	if err == nil && !opts.Fix && !opts.Adopt && !c.machineOutput() {
+		fmt.Println(tr("Run `auto_proxy sync --fix` to update the records, and --adopt to add unmanaged instances."))
+	}
+	return err
+}
+
+// detectDrift 列出紀錄與雲端的差異，列表中找不到的紀錄再個別查詢，
+// 因為還沒有 managedLabel 的舊 instance 不會出現在列表中
+func (c *Commander) detectDrift(ctx context.Context, records []ProxyRecord, instances []CloudInstance) []driftView {
+	listed := make(map[string]CloudInstance, len(instances))
+	for _, inst := range instances {
+		listed[inst.Zone+"/"+inst.Name] = inst
+	}
+	var drifts []driftView
+	for _, r := range records {
+		if r.Type != "instance" || !r.Managed() {
+			continue
+		}
+		key := r.Zone + "/" + r.InstanceID
+		inst, ok := listed[key]
+		delete(listed, key)
+		if !ok {
+			info, err := c.provider.GetInstanceInfo(ctx, r.Zone, r.InstanceID)
+			if errors.Is(err, ErrInstanceNotFound) {
+				drifts = append(drifts, driftView{Drift: DriftMissing, Name: r.Name, Zone: r.Zone, InstanceID: r.InstanceID, RecordedIP: r.IP})
+				continue
+			}
+			if err != nil {
+				c.logger.Printf("Error getting instance %s: %v", r.InstanceID, err)
+				fmt.Printf(tr("Warning: failed to check %s: %v\n"), r.Name, err)
+				continue
+			}
+			drifts = append(drifts, driftView{Drift: DriftUnlabeled, Name: r.Name, Zone: r.Zone, InstanceID: r.InstanceID, RecordedIP: r.IP, CurrentIP: info.IP})
+			inst = CloudInstance{Name: r.InstanceID, Zone: r.Zone, IP: info.IP, Status: info.Status}
+		}
+		// 停止的機器沒有外部 IP，不算 IP 變更，start 時會更新紀錄
+		if r.Lifecycle() != StatusStopped && inst.IP != "" && inst.IP != r.IP {
+			drifts = append(drifts, driftView{Drift: DriftIPChanged, Name: r.Name, Zone: r.Zone, InstanceID: r.InstanceID, RecordedIP: r.IP, CurrentIP: inst.IP})
+		}
+	}
+
+	var unmanaged []driftView
+	for _, inst := range listed {
+		name := inst.Labels[nameLabel]
+		if name == "" {
+			name = inst.Name
+		}
+		unmanaged = append(unmanaged, driftView{Drift: DriftUnmanaged, Name: name, Zone: inst.Zone, InstanceID: inst.Name, CurrentIP: inst.IP, labels: inst.Labels})
+	}
+	sort.Slice(unmanaged, func(i, j int) bool { return unmanaged[i].Name < unmanaged[j].Name })
+	return append(drifts, unmanaged...)
+}
+
+// repairDrift 修正 drifts 並在每一項記下採取的動作
+// missing 移除紀錄、ip-changed 更新 IP、unlabeled 補上 label，adopt 時 unmanaged 加入 provisioning 紀錄，之後以 resume 重新部署
+func (c *Commander) repairDrift(ctx context.Context, drifts []driftView, opts SyncOptions) error {
+	for i := range drifts {
+		d := &drifts[i]
+		switch {
+		case d.Drift == DriftUnlabeled && opts.Fix:
+			if err := c.provider.SetInstanceLabels(ctx, d.Zone, d.InstanceID, map[string]string{managedLabel: "true", nameLabel: d.Name}); err != nil {
+				c.logger.Printf("Error labeling instance %s: %v", d.InstanceID, err)
+				d.Action = fmt.Sprintf(tr("failed: %v"), err)
+				continue
+			}
+			d.Action = tr("labeled")
+		case d.Drift == DriftMissing && opts.Fix:
+			d.Action = tr("record removed")
+		case d.Drift == DriftIPChanged && opts.Fix:
+			d.Action = tr("IP updated")
+		case d.Drift == DriftUnmanaged && opts.Adopt:
+			d.Action = tr("adopted as provisioning")
+		}
+	}
+	if c.dryRun {
+		for _, d := range drifts {
+			if d.Action != "" && d.Drift != DriftUnlabeled {
+				dryRunf("Would update record %s: %s\n", d.Name, d.Action)
+			}
+		}
+		return nil
+	}
+
+	endpoint := c.config.Shadowsocks
+	err := c.recordManager.Update(func(records []ProxyRecord) ([]ProxyRecord, error) {
+		for _, d := range drifts {
+			index, nameTaken := -1, false
+			for i, r := range records {
+				if r.Type == "instance" && r.Zone == d.Zone && r.InstanceID == d.InstanceID {
+					index = i
+				}
+				nameTaken = nameTaken || (r.Type == "instance" && r.Name == d.Name)
+			}
+			switch {
+			case d.Action == "" || d.Drift == DriftUnlabeled:
+			case d.Drift == DriftMissing && index >= 0:
+				records = append(records[:index], records[index+1:]...)
+			case d.Drift == DriftIPChanged && index >= 0:
+				records[index].IP = d.CurrentIP
+			case d.Drift == DriftUnmanaged && index < 0:
+				name := d.Name
+				if nameTaken {
+					name = d.InstanceID
+				}
+				region := d.Zone[:strings.LastIndex(d.Zone, "-")]
+				// resume 重新部署時改用新的密碼
+				password, err := c.newProxyPassword()
+				if err != nil {
+					return nil, err
+				}
+				adopted := ProxyRecord{
+					Name:       name,
+					Provider:   "gcp",
+					Region:     region,
+					Zone:       d.Zone,
+					InstanceID: d.InstanceID,
+					IP:         d.CurrentIP,
+					Type:       "instance",
+					Location:   locationName(region),
+					Status:     StatusProvisioning,
+					Protocol:   ProtocolShadowsocks,
+					Project:    c.config.GCP.ProjectID,
+					Port:       endpoint.Port,
+					Method:     endpoint.Method,
+					Password:   password,
+					Tags:       labelTags(d.labels),
+				}
+				if err := c.storePassword(ctx, &adopted); err != nil {
+					return nil, err
+				}
+				records = append(records, adopted)
+			}
+		}
+		return records, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error saving records: %v", err)
+	}
+	return nil
+}
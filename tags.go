@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// GCP label 的限制，tag 也會設定成 instance 的 label
+var (
+	tagKeyPattern   = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,62}$`)
+	tagValuePattern = regexp.MustCompile(`^[a-z0-9_-]{0,63}$`)
+)
+
+// reservedTagPrefix auto_proxy 自己使用的 label，例如 managedLabel 與 nameLabel
+const reservedTagPrefix = "auto-proxy-"
+
+// parseTags 解析 create --tag 的 key=value
+func parseTags(raw []string) (map[string]string, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	tags := make(map[string]string, len(raw))
+	for _, t := range raw {
+		key, value, ok := strings.Cut(t, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid tag %q: use key=value", t)
+		}
+		if !tagKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("invalid tag key %q: use lowercase letters, digits, underscores and hyphens, starting with a letter", key)
+		}
+		if !tagValuePattern.MatchString(value) {
+			return nil, fmt.Errorf("invalid tag value %q: use at most 63 lowercase letters, digits, underscores and hyphens", value)
+		}
+		if strings.HasPrefix(key, reservedTagPrefix) {
+			return nil, fmt.Errorf("tag keys starting with %s are reserved", reservedTagPrefix)
+		}
+		tags[key] = value
+	}
+	return tags, nil
+}
+
+// tagFilter list、delete 與 export 的 --tag 條件，key=value 比對值，只有 key 時有這個 tag 即符合
+type tagFilter []string
+
+func (f tagFilter) match(tags map[string]string) bool {
+	for _, t := range f {
+		key, value, hasValue := strings.Cut(t, "=")
+		v, ok := tags[key]
+		if !ok || (hasValue && v != value) {
+			return false
+		}
+	}
+	return true
+}
+
+// labelTags 從 instance 的 label 取出使用者的 tag，略過 auto_proxy 自己使用的 label
+func labelTags(labels map[string]string) map[string]string {
+	var tags map[string]string
+	for key, value := range labels {
+		if strings.HasPrefix(key, reservedTagPrefix) {
+			continue
+		}
+		if tags == nil {
+			tags = make(map[string]string)
+		}
+		tags[key] = value
+	}
+	return tags
+}
+
+// formatTags 以 key=value 依 key 排序，逗號分隔
+func formatTags(tags map[string]string) string {
+	parts := make([]string, 0, len(tags))
+	for key, value := range tags {
+		parts = append(parts, key+"="+value)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// appLocation 所有排程、TTL、報表與預算的日界線都以這個時區計算
+var appLocation = time.Local
+
+// loadTimezone 套用設定檔中的時區 (IANA 名稱，例如 Asia/Taipei)，未設定時使用系統時區
+func loadTimezone(name string) error {
+	if name == "" {
+		return nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return fmt.Errorf("invalid timezone %q: %v", name, err)
+	}
+	appLocation = loc
+	return nil
+}
+
+// now 回傳設定時區下的目前時間
+func now() time.Time {
+	return time.Now().In(appLocation)
+}
+
+// startOfDay 回傳 t 在設定時區下當天的 00:00
+func startOfDay(t time.Time) time.Time {
+	t = t.In(appLocation)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, appLocation)
+}
+
+// endOfDay 回傳 t 在設定時區下隔天的 00:00，作為「當天結束」的時間點
+func endOfDay(t time.Time) time.Time {
+	return startOfDay(t).AddDate(0, 0, 1)
+}
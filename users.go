@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// userNamePattern 使用者名稱同時是 systemd 服務與設定檔名稱的一部分，只允許小寫英數字與連字號
+var userNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,30}$`)
+
+// usersFile 記錄主機上目前設定的使用者與連接埠，每行 name:port，重新部署時據此移除不再存在的使用者
+const usersFile = "/etc/auto-proxy/users"
+
+// userPorts 回傳所有使用者的連接埠
+func userPorts(users []ProxyUser) []int {
+	ports := make([]int, len(users))
+	for i, user := range users {
+		ports[i] = user.Port
+	}
+	return ports
+}
+
+// usersMarker 回傳寫入 usersFile 的內容，沒有使用者時為空字串
+func usersMarker(users []ProxyUser) string {
+	var b strings.Builder
+	for _, user := range users {
+		fmt.Fprintf(&b, "%s:%d\n", user.Name, user.Port)
+	}
+	return b.String()
+}
+
+// userTasks 產生 playbook 中設定使用者的 tasks，每個使用者以 shadowsocks-libev-server@user-<name> 提供
+// 需要在 UFW tasks 之前，移除使用者時依 allowed-sources 中原本的來源刪除 UFW 規則
+func userTasks(users []ProxyUser, method string) string {
+	var b strings.Builder
+	b.WriteString("    - name: Configure Shadowsocks users\n      block:\n")
+	marker := usersMarker(users)
+	b.WriteString("        - name: Remove users no longer configured\n          shell: |\n")
+	fmt.Fprintf(&b, "            for entry in $(cat %s 2>/dev/null); do\n", usersFile)
+	fmt.Fprintf(&b, "              case \" %s \" in *\" $entry \"*) continue ;; esac\n", strings.Join(strings.Fields(marker), " "))
+	b.WriteString("              name=${entry%:*}\n              port=${entry#*:}\n")
+	b.WriteString("              systemctl disable --now \"shadowsocks-libev-server@user-$name\" || true\n")
+	b.WriteString("              rm -f \"/etc/shadowsocks-libev/user-$name.json\"\n")
+	fmt.Fprintf(&b, "              for source in $(cat %s 2>/dev/null || echo any); do\n", ufwSourcesFile)
+	b.WriteString("                ufw delete allow from \"$source\" to any port \"$port\" proto tcp || true\n")
+	b.WriteString("                ufw delete allow from \"$source\" to any port \"$port\" proto udp || true\n")
+	b.WriteString("              done\n            done\n")
+	fmt.Fprintf(&b, "        - name: Create auto_proxy state directory\n          file:\n            path: %s\n            state: directory\n            mode: '0755'\n", path.Dir(usersFile))
+	for i, user := range users {
+		config := shadowsocksConfigJSON(user.Port, fmt.Sprintf("{{ shadowsocks_users[%q] | to_json }}", user.Name), method)
+		fmt.Fprintf(&b, "        - name: Configure Shadowsocks user %s\n          copy:\n            content: |\n%s\n", user.Name, indent(config, 14))
+		fmt.Fprintf(&b, "            dest: /etc/shadowsocks-libev/user-%s.json\n          register: shadowsocks_user_%d\n", user.Name, i)
+		fmt.Fprintf(&b, "        - name: Start Shadowsocks user %s\n          systemd:\n            name: shadowsocks-libev-server@user-%s\n            enabled: yes\n", user.Name, user.Name)
+		fmt.Fprintf(&b, "            state: \"{{ 'restarted' if shadowsocks_user_%d.changed else 'started' }}\"\n", i)
+	}
+	b.WriteString("        - name: Record users\n          copy:\n")
+	if marker != "" {
+		b.WriteString("            content: |\n" + indent(strings.TrimSuffix(marker, "\n"), 14) + "\n")
+	} else {
+		b.WriteString("            content: \"\"\n")
+	}
+	b.WriteString("            dest: " + usersFile + "\n")
+	b.WriteString("      tags: [config]\n")
+	return b.String()
+}
+
+// userView 對外輸出的使用者欄位
+type userView struct {
+	Name      string    `json:"name" yaml:"name"`
+	Port      int       `json:"port" yaml:"port"`
+	ShareURI  string    `json:"share_uri" yaml:"share_uri"`
+	ExpiresAt time.Time `json:"expires_at,omitempty" yaml:"expires_at,omitempty"`
+}
+
+// Users 列出 proxy 的使用者與各自的分享連結
+func (c *Commander) Users(name string) error {
+	record, err := c.userProxy(name)
+	if err != nil {
+		return err
+	}
+	views := make([]userView, len(record.Users))
+	for i, user := range record.Users {
+		views[i] = userView{Name: user.Name, Port: user.Port, ShareURI: record.UserShareURI(user), ExpiresAt: user.ExpiresAt}
+	}
+	if len(views) == 0 && !c.machineOutput() {
+		fmt.Printf(tr("Proxy %s has no additional users.\n"), name)
+		return nil
+	}
+	return c.render(views, func(w io.Writer) {
+		fmt.Fprintln(w, "NAME\tPORT\tEXPIRES\tSHARE URI")
+		for _, v := range views {
+			expires := "never"
+			if !v.ExpiresAt.IsZero() {
+				expires = v.ExpiresAt.In(now().Location()).Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", v.Name, v.Port, expires, v.ShareURI)
+		}
+	})
+}
+
+// AddUser 在 proxy 上新增一個有獨立連接埠與密碼的使用者，套用到防火牆與主機後寫入紀錄並印出連線參數
+// ttl 大於 0 時使用者在 ttl 之後到期，由 ExpireUsers 移除
+func (c *Commander) AddUser(ctx context.Context, name, user string, ttl time.Duration) error {
+	if ttl < 0 {
+		return fmt.Errorf("--ttl must not be negative")
+	}
+	if !userNamePattern.MatchString(user) {
+		return fmt.Errorf("invalid user name %q: use up to 31 lowercase letters, digits and hyphens", user)
+	}
+	previous, err := c.userProxy(name)
+	if err != nil {
+		return err
+	}
+	if slices.ContainsFunc(previous.Users, func(u ProxyUser) bool { return u.Name == user }) {
+		return fmt.Errorf("proxy %s already has a user named %s", name, user)
+	}
+	port, err := nextUserPort(previous)
+	if err != nil {
+		return err
+	}
+	password, err := generateProxyPassword()
+	if err != nil {
+		return err
+	}
+	added := ProxyUser{Name: user, Port: port, Password: password}
+	if ttl > 0 {
+		added.ExpiresAt = now().Add(ttl)
+	}
+	if err := c.storeUserPassword(ctx, previous, &added); err != nil {
+		return err
+	}
+	record := previous
+	record.Users = append(slices.Clone(previous.Users), added)
+
+	fmt.Printf(tr("Adding user %s to proxy %s on port %d...\n"), user, name, port)
+	if err := c.applyFirewall(ctx, previous, record); err != nil {
+		c.releaseRef(ctx, name, added.PasswordRef)
+		return err
+	}
+	// 套用期間可能有其他指令變更使用者，只加入這個使用者
+	if _, err := c.recordManager.UpdateRecord(name, func(r *ProxyRecord) error {
+		if slices.ContainsFunc(r.Users, func(u ProxyUser) bool { return u.Name == user }) {
+			return fmt.Errorf("proxy %s already has a user named %s", name, user)
+		}
+		r.Users = append(r.Users, added)
+		return nil
+	}); err != nil {
+		return fmt.Errorf("error saving records: %v", err)
+	}
+	c.logger.Printf("User %s added to proxy %s on port %d", user, name, port)
+
+	_, method, _ := record.Endpoint()
+	view := connectView{Name: name + "-" + user, Protocol: ProtocolShadowsocks, Host: record.IP, Port: port, Password: password, Method: method, ShareURI: record.UserShareURI(added)}
+	if c.machineOutput() {
+		return c.render(view, nil)
+	}
+	fmt.Printf(tr("User %s added, share these parameters with them:\n\n"), user)
+	if err := writeConnectInfo(os.Stdout, view); err != nil {
+		return err
+	}
+	if !added.ExpiresAt.IsZero() {
+		fmt.Printf(tr("\nThe user expires at %s.\n"), added.ExpiresAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// RemoveUser 停止使用者的服務並關閉連接埠，其他使用者的連線不受影響
+func (c *Commander) RemoveUser(ctx context.Context, name, user string) error {
+	previous, err := c.userProxy(name)
+	if err != nil {
+		return err
+	}
+	index := slices.IndexFunc(previous.Users, func(u ProxyUser) bool { return u.Name == user })
+	if index < 0 {
+		return fmt.Errorf("proxy %s has no user named %s", name, user)
+	}
+	removed := previous.Users[index]
+	record := previous
+	record.Users = slices.Delete(slices.Clone(previous.Users), index, index+1)
+	if err := c.applyFirewall(ctx, previous, record); err != nil {
+		return err
+	}
+	// 套用期間可能有其他指令變更使用者，只移除這個使用者
+	if _, err := c.recordManager.UpdateRecord(name, func(r *ProxyRecord) error {
+		r.Users = slices.DeleteFunc(r.Users, func(u ProxyUser) bool { return u.Name == user })
+		return nil
+	}); err != nil {
+		return fmt.Errorf("error saving records: %v", err)
+	}
+	c.releaseRef(ctx, name, removed.PasswordRef)
+	c.logger.Printf("User %s removed from proxy %s", user, name)
+	fmt.Printf(tr("User %s removed from proxy %s.\n"), user, name)
+	return nil
+}
+
+// ExpireUsers 從伺服器移除已經到期的使用者並更新紀錄，name 為空時處理所有 proxy
+// 一個 proxy 失敗時繼續處理其他 proxy，最後回傳所有錯誤
+func (c *Commander) ExpireUsers(ctx context.Context, name string) error {
+	var records []ProxyRecord
+	if name != "" {
+		record, err := c.userProxy(name)
+		if err != nil {
+			return err
+		}
+		records = []ProxyRecord{record}
+	} else {
+		all, err := c.recordManager.Load()
+		if err != nil {
+			return fmt.Errorf("error loading records: %v", err)
+		}
+		for _, record := range all {
+			if record.Ready() && record.ProxyProtocol() == ProtocolShadowsocks {
+				records = append(records, record)
+			}
+		}
+	}
+	at := now()
+	var errs []error
+	removed := 0
+	for _, previous := range records {
+		record := previous
+		record.Users = slices.DeleteFunc(slices.Clone(previous.Users), func(u ProxyUser) bool { return u.Expired(at) })
+		if len(record.Users) == len(previous.Users) {
+			continue
+		}
+		if err := c.applyFirewall(ctx, previous, record); err != nil {
+			errs = append(errs, fmt.Errorf("proxy %s: %v", previous.Name, err))
+			continue
+		}
+		// 套用期間可能有其他指令變更使用者，只移除這次到期的使用者
+		if _, err := c.recordManager.UpdateRecord(previous.Name, func(r *ProxyRecord) error {
+			r.Users = slices.DeleteFunc(r.Users, func(u ProxyUser) bool { return u.Expired(at) })
+			return nil
+		}); err != nil {
+			errs = append(errs, fmt.Errorf("proxy %s: error saving records: %v", previous.Name, err))
+			continue
+		}
+		for _, user := range previous.Users {
+			if user.Expired(at) {
+				c.releaseRef(ctx, previous.Name, user.PasswordRef)
+				removed++
+				c.logger.Printf("Expired user %s removed from proxy %s", user.Name, previous.Name)
+				fmt.Printf(tr("Removed expired user %s from proxy %s.\n"), user.Name, previous.Name)
+			}
+		}
+	}
+	if removed == 0 && len(errs) == 0 {
+		fmt.Println(tr("No expired users."))
+	}
+	return errors.Join(errs...)
+}
+
+// expireUsersEvery 由 serve 在背景執行，定期移除到期的使用者直到 ctx 結束
+func (c *Commander) expireUsersEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.ExpireUsers(ctx, ""); err != nil {
+				c.logger.Printf("Failed to expire users: %v", err)
+			}
+		}
+	}
+}
+
+// userProxy 回傳可以管理使用者的 proxy 紀錄
+func (c *Commander) userProxy(name string) (ProxyRecord, error) {
+	if name == "" {
+		return ProxyRecord{}, fmt.Errorf(`required flag "name" not set`)
+	}
+	record, found, err := c.recordManager.FindByName(name)
+	if err != nil {
+		return record, fmt.Errorf("error loading records: %v", err)
+	}
+	if !found {
+		return record, fmt.Errorf("proxy not found: %s", name)
+	}
+	if !record.Ready() {
+		return record, fmt.Errorf("proxy %s is not deployed yet, run `auto_proxy resume --name %s` first", name, name)
+	}
+	if protocol := record.ProxyProtocol(); protocol != ProtocolShadowsocks {
+		return record, fmt.Errorf("users of %s proxies are not supported", protocol)
+	}
+	return record, nil
+}
+
+// nextUserPort 從 proxy 的連接埠往上找第一個沒有被使用的連接埠
+func nextUserPort(record ProxyRecord) (int, error) {
+	port, _, _ := record.Endpoint()
+	sshPort := record.SSHPort
+	if sshPort == 0 {
+		sshPort = defaultSSHPort
+	}
+	used := append(userPorts(record.Users), record.KnockPorts...)
+	for _, rule := range record.FirewallRules {
+		used = append(used, rule.Port)
+	}
+	for candidate := port + 1; candidate <= 65535; candidate++ {
+		if candidate != sshPort && !slices.Contains(used, candidate) {
+			return candidate, nil
+		}
+	}
+	return 0, fmt.Errorf("no free port above %d for another user", port)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/spf13/cobra"
+)
+
+// 建置時以 -ldflags 注入，例如
+//
+//	go build -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// 沒有注入時從 Go 記錄的 VCS 資訊補上 commit 與時間
+var (
+	version   = "0.0.0-dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// buildInfo 回傳版本、commit 與建置時間
+func buildInfo() (string, string, string) {
+	rev, date := commit, buildDate
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && rev == "":
+				rev = s.Value
+				if len(rev) > 12 {
+					rev = rev[:12]
+				}
+			case s.Key == "vcs.time" && date == "":
+				date = s.Value
+			}
+		}
+	}
+	if rev == "" {
+		rev = "unknown"
+	}
+	if date == "" {
+		date = "unknown"
+	}
+	return version, rev, date
+}
+
+// userAgent 附在雲端 API 與外部服務的請求上，回報問題時可以從記錄對應到版本
+func userAgent() string {
+	return "auto_proxy/" + version
+}
+
+func (a *cliApp) versionCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:         "version",
+		Short:       "Print the version, git commit and build date",
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{annotationStandalone: "true"},
+		Run: func(cmd *cobra.Command, args []string) {
+			v, rev, date := buildInfo()
+			fmt.Printf("auto_proxy %s\n", v)
+			fmt.Printf("commit: %s\n", rev)
+			fmt.Printf("built: %s\n", date)
+			fmt.Printf("go: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+		},
+	}
+}